@@ -0,0 +1,94 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+)
+
+/*
+How long an agent waits before asking again while every shard of its
+region is leased to other agents
+*/
+const agentWaitInterval = 5 * time.Second
+
+/*
+runAgent registers with the coordinator, then scans shards and streams
+their results back until the coordinator says the region is done
+*/
+func runAgent(addr, token, name, region string, opts ScanOptions) error {
+	transport, err := grpcSecurity.dialOption()
+	if err != nil {
+		return err
+	}
+	conn, err := grpc.Dial(addr,
+		transport,
+		grpc.WithDefaultCallOptions(grpc.CallContentSubtype(jsonCodecName)))
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	ctx := context.Background()
+	if token != "" {
+		ctx = metadata.AppendToOutgoingContext(ctx, tokenMetadataKey, "Bearer "+token)
+	}
+
+	id := new(AgentID)
+	if err := conn.Invoke(ctx, "/"+coordinatorService+"/Register", &AgentRegistration{Name: name, Region: region}, id); err != nil {
+		return err
+	}
+	log.Printf("Registered with %s as %s\n", addr, id.ID)
+
+	for {
+		shard := new(Shard)
+		if err := conn.Invoke(ctx, "/"+coordinatorService+"/NextShard", &ShardRequest{AgentID: id.ID}, shard); err != nil {
+			return err
+		}
+		switch {
+		case shard.Done:
+			log.Println("Coordinator has no more shards for this region")
+			return nil
+		case shard.Wait:
+			clock.Sleep(agentWaitInterval)
+			continue
+		}
+
+		if err := reportShard(ctx, conn, id.ID, shard, opts); err != nil {
+			return err
+		}
+	}
+}
+
+/*
+reportShard scans the shard's targets and streams each result as soon
+as it is ready
+*/
+func reportShard(ctx context.Context, conn *grpc.ClientConn, agentID string, shard *Shard, opts ScanOptions) error {
+	desc := &coordinatorServiceDesc.Streams[0]
+	stream, err := conn.NewStream(ctx, desc, "/"+coordinatorService+"/Report")
+	if err != nil {
+		return err
+	}
+
+	for _, target := range shard.Targets {
+		result := scanTarget(target, opts)
+		err := stream.SendMsg(newResultRecord(agentID, shard.ID, result))
+		if errors.Is(err, io.EOF) {
+			// The coordinator ended the stream, its status says why
+			return stream.RecvMsg(new(ReportAck))
+		}
+		if err != nil {
+			return err
+		}
+	}
+	if err := stream.CloseSend(); err != nil {
+		return err
+	}
+	return stream.RecvMsg(new(ReportAck))
+}
@@ -0,0 +1,183 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const anomalyRuleID = "MYSQL-HANDSHAKE-ANOMALY"
+
+/*
+Anomaly scores how much a handshake departs from what a genuine server
+of its version sends. Each inconsistency adds its weight to the score.
+*/
+type Anomaly struct {
+	Score   int
+	Reasons []string
+}
+
+func (a *Anomaly) add(weight int, reason string, args ...interface{}) {
+	a.Score += weight
+	a.Reasons = append(a.Reasons, fmt.Sprintf(reason, args...))
+}
+
+func init() {
+	registerCheck(Check{
+		ID:          anomalyRuleID,
+		Description: "Scores inconsistencies between the handshake fields and the advertised version",
+		Tier:        TierPassive,
+		Run:         checkHandshakeAnomaly,
+	})
+}
+
+/*
+serverVersion returns the version without the 5.5.5- prefix MariaDB
+adds for old clients
+*/
+func serverVersion(version string) string {
+	if strings.Contains(version, "MariaDB") {
+		return strings.TrimPrefix(version, "5.5.5-")
+	}
+	return version
+}
+
+/*
+serverRelease returns the major and minor version of the server,
+MariaDB's prefix skipped
+*/
+func serverRelease(version string) (major, minor int, ok bool) {
+	version = serverVersion(version)
+	parts := strings.SplitN(version, ".", 3)
+	if len(parts) < 2 {
+		return 0, 0, false
+	}
+	major, err := strconv.Atoi(parts[0])
+	if err != nil {
+		return 0, 0, false
+	}
+	digits := strings.IndexFunc(parts[1], func(r rune) bool { return r < '0' || r > '9' })
+	if digits == -1 {
+		digits = len(parts[1])
+	}
+	minor, err = strconv.Atoi(parts[1][:digits])
+	if err != nil {
+		return 0, 0, false
+	}
+	return major, minor, true
+}
+
+/*
+rawField returns the named field's bytes as received
+*/
+func (r *InitialHandshakePacket) rawField(name string) ([]byte, bool) {
+	for _, f := range r.fields {
+		if f.Name == name {
+			return r.raw[f.Offset : f.Offset+f.Length], true
+		}
+	}
+	return nil, false
+}
+
+/*
+handshakeAnomaly scores the handshake's inconsistencies
+*/
+func handshakeAnomaly(p *InitialHandshakePacket) Anomaly {
+	var a Anomaly
+	caps := p.CapabilitiesFlags
+	version := string(p.ServerVersion)
+	mariaDB := strings.Contains(version, "MariaDB")
+
+	if caps.Has(clientPluginAuth) && caps.Has(clientSecureConn) && int(p.AuthPluginDataLen) != len(p.AuthPluginData) {
+		a.add(3, "auth-plugin-data length is %d but %d bytes were sent", p.AuthPluginDataLen, len(p.AuthPluginData))
+	}
+	if caps.Has(clientPluginAuth) && len(p.AuthPluginName) == 0 {
+		a.add(2, "clientPluginAuth advertised without an auth plugin name")
+	}
+	if !caps.Has(clientProtocol41) {
+		for _, flag := range protocol41Only {
+			if caps.Has(flag) {
+				a.add(3, "%s advertised without clientProtocol41", flags[flag])
+			}
+		}
+	}
+
+	major, minor, ok := serverRelease(version)
+	if !ok {
+		a.add(1, "version %q doesn't parse", version)
+	}
+	// Every server from 5.7 on, and MariaDB from 5.5 on, advertises these
+	if ok && (major > 5 || major == 5 && (minor >= 7 || mariaDB && minor >= 5)) {
+		for _, flag := range []CapabilityFlag{clientProtocol41, clientSecureConn, clientPluginAuth} {
+			if !caps.Has(flag) {
+				a.add(2, "%s missing from version %s", flags[flag], version)
+			}
+		}
+	}
+	for _, m := range capabilityMismatches(p) {
+		a.add(2, "%s advertised by version %s, older than %s", flags[m.flag], version, m.release)
+	}
+	// MySQL 9.0 removed mysql_native_password, 8.4 only turned it off by default
+	if ok && major >= 9 && !mariaDB && string(p.AuthPluginName) == nativePasswordPlugin {
+		a.add(2, "%s offered by version %s, which removed it", nativePasswordPlugin, version)
+	}
+
+	// MariaDB keeps its own capabilities in the last 4 reserved bytes when it leaves clientLongPassword unset
+	if reserved, ok := p.rawField("reserved"); ok {
+		if mariaDB && !caps.Has(clientLongPassword) {
+			reserved = reserved[:6]
+		}
+		if bytes.Count(reserved, []byte{0}) != len(reserved) {
+			a.add(2, "reserved bytes are not zero (% x)", reserved)
+		}
+	}
+	for _, w := range p.Warnings {
+		if w.contradicts(version) {
+			a.add(2, "%s from version %s, which has the fix (%s)", w.Code, version, strings.Join(w.FixedIn, ", "))
+		}
+	}
+
+	if len(p.fields) > 0 {
+		last := p.fields[len(p.fields)-1]
+		if extra := len(p.raw) - (last.Offset + last.Length + 1); extra > 0 {
+			a.add(1, "%d unexpected bytes after the auth plugin name", extra)
+		}
+	}
+	return a
+}
+
+/*
+Anomaly returns the anomaly score of the decoded handshake
+*/
+func (r *ScanResult) Anomaly() Anomaly {
+	if r.Handshake == nil {
+		return Anomaly{}
+	}
+	return handshakeAnomaly(r.Handshake)
+}
+
+/*
+rankByAnomaly orders results with the most anomalous handshakes first,
+keeping the order of results that score the same
+*/
+func rankByAnomaly(results []*ScanResult) {
+	sort.SliceStable(results, func(i, j int) bool {
+		return results[i].Anomaly().Score > results[j].Anomaly().Score
+	})
+}
+
+func checkHandshakeAnomaly(ctx *CheckContext) []Finding {
+	a := handshakeAnomaly(ctx.Handshake)
+	if a.Score == 0 {
+		return nil
+	}
+
+	return []Finding{{
+		RuleID:   anomalyRuleID,
+		Severity: SeverityInfo,
+		Title:    "Handshake fields are inconsistent",
+		Detail:   fmt.Sprintf("score %d: %s", a.Score, strings.Join(a.Reasons, "; ")),
+	}}
+}
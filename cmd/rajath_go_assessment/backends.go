@@ -0,0 +1,130 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+/*
+Connection ids handed out by one server between two of our probes are
+expected to stay within this distance. A jump further than this, or
+backwards, means the probe was answered by another server.
+*/
+const maxConnectionIdGap = 1 << 16
+
+/*
+Backend is one distinct server seen behind a target
+*/
+type Backend struct {
+	Version        string
+	Capabilities   CapabilityFlag
+	CharacterSet   uint8
+	AuthPluginName string
+	CertSHA256     string
+	ConnectionIds  []uint32
+}
+
+/*
+fingerprint identifies the static properties of the backend
+*/
+func (b *Backend) fingerprint() string {
+	return fmt.Sprintf("%s|%d|%d|%s|%s", b.Version, b.Capabilities, b.CharacterSet, b.AuthPluginName, b.CertSHA256)
+}
+
+func (b *Backend) String() string {
+	ids := make([]string, len(b.ConnectionIds))
+	for i, id := range b.ConnectionIds {
+		ids[i] = fmt.Sprintf("%d", id)
+	}
+
+	s := fmt.Sprintf("version %s, capabilities 0x%08x, charset %d, plugin %s, connection ids %s",
+		b.Version, uint32(b.Capabilities), b.CharacterSet, b.AuthPluginName, strings.Join(ids, ","))
+	if b.CertSHA256 != "" {
+		s += ", cert sha256 " + b.CertSHA256
+	}
+	return s
+}
+
+/*
+accepts reports whether id continues this backend's connection id sequence
+*/
+func (b *Backend) accepts(id uint32) bool {
+	last := b.ConnectionIds[len(b.ConnectionIds)-1]
+	return id > last && id-last <= maxConnectionIdGap
+}
+
+/*
+enumerateBackends groups the successful probes, in the order they were
+made, by handshake fingerprint and connection id sequence
+*/
+func enumerateBackends(probes []ProbeSample) []*Backend {
+	var backends []*Backend
+
+	for _, p := range probes {
+		if p.Err != nil {
+			continue
+		}
+
+		candidate := &Backend{
+			Version:        string(p.Packet.ServerVersion),
+			Capabilities:   p.Packet.CapabilitiesFlags,
+			CharacterSet:   p.Packet.CharacterSet,
+			AuthPluginName: string(p.Packet.AuthPluginName),
+			CertSHA256:     p.CertSHA256,
+			ConnectionIds:  []uint32{p.Packet.ConnectionId},
+		}
+
+		var match *Backend
+		for _, b := range backends {
+			if b.fingerprint() == candidate.fingerprint() && b.accepts(p.Packet.ConnectionId) {
+				// Prefer the sequence the id follows most closely
+				if match == nil || b.ConnectionIds[len(b.ConnectionIds)-1] > match.ConnectionIds[len(match.ConnectionIds)-1] {
+					match = b
+				}
+			}
+		}
+
+		if match == nil {
+			backends = append(backends, candidate)
+			continue
+		}
+		match.ConnectionIds = append(match.ConnectionIds, p.Packet.ConnectionId)
+	}
+
+	return backends
+}
+
+/*
+Backends returns each distinct server seen across the repeated probes
+*/
+func (r *ScanResult) Backends() []*Backend {
+	return enumerateBackends(r.Probes)
+}
+
+func init() {
+	registerCheck(Check{
+		ID:          "MYSQL-MULTIPLE-BACKENDS",
+		Description: "Reports every distinct backend seen behind a target across repeated probes",
+		Tier:        TierPassive,
+		Run:         checkMultipleBackends,
+	})
+}
+
+func checkMultipleBackends(ctx *CheckContext) []Finding {
+	backends := enumerateBackends(ctx.Probes)
+	if len(backends) < 2 {
+		return nil
+	}
+
+	var details []string
+	for i, b := range backends {
+		details = append(details, fmt.Sprintf("backend %d: %s", i+1, b))
+	}
+
+	return []Finding{{
+		RuleID:   "MYSQL-MULTIPLE-BACKENDS",
+		Severity: SeverityInfo,
+		Title:    fmt.Sprintf("%d distinct backends answer on this address", len(backends)),
+		Detail:   strings.Join(details, "; "),
+	}}
+}
@@ -0,0 +1,140 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"sync"
+	"sync/atomic"
+)
+
+/*
+errByteCap is returned by connections of a target that used up its
+-max-bytes-per-target allowance
+*/
+type errByteCap struct {
+	limit int64
+}
+
+func (e errByteCap) Error() string {
+	return fmt.Sprintf("Byte cap of %d bytes reached for this target", e.limit)
+}
+
+/*
+Traffic counts the bytes exchanged with a target, or with all of them
+*/
+type Traffic struct {
+	sent     atomic.Int64
+	received atomic.Int64
+}
+
+func (t *Traffic) Sent() int64     { return t.sent.Load() }
+func (t *Traffic) Received() int64 { return t.received.Load() }
+func (t *Traffic) Total() int64    { return t.Sent() + t.Received() }
+
+func (t *Traffic) String() string {
+	return fmt.Sprintf("sent %d bytes, received %d bytes", t.Sent(), t.Received())
+}
+
+/*
+trafficMeter accounts for every byte the scanner sends to or receives
+from targets, and enforces the per-target cap. Counters of a target
+start over with each scan of it.
+*/
+type trafficMeter struct {
+	mu      sync.Mutex
+	limit   int64
+	targets map[string]*Traffic
+	total   Traffic
+}
+
+var traffic = &trafficMeter{targets: make(map[string]*Traffic)}
+
+/*
+start resets the target's counter at the start of a scan
+*/
+func (m *trafficMeter) start(target Target) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.targets[target.Label()] = &Traffic{}
+}
+
+/*
+of returns the target's counter
+*/
+func (m *trafficMeter) of(target Target) *Traffic {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	t, ok := m.targets[target.Label()]
+	if !ok {
+		t = &Traffic{}
+		m.targets[target.Label()] = t
+	}
+	return t
+}
+
+/*
+finish forgets the target's counter once its result holds it, so the
+meter doesn't grow with the number of targets scanned
+*/
+func (m *trafficMeter) finish(target Target) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.targets, target.Label())
+}
+
+/*
+wrap counts the connection's traffic against the target
+*/
+func (m *trafficMeter) wrap(target Target, conn net.Conn) net.Conn {
+	return &meteredConn{Conn: conn, meter: m, target: m.of(target)}
+}
+
+/*
+meteredConn counts bytes in both directions. A read that hits the cap
+fails, and a write that would go over it is not sent at all so no
+partial packet leaves the scanner.
+*/
+type meteredConn struct {
+	net.Conn
+	meter  *trafficMeter
+	target *Traffic
+}
+
+func (c *meteredConn) remaining() int64 {
+	if c.meter.limit <= 0 {
+		return -1
+	}
+	return c.meter.limit - c.target.Total()
+}
+
+func (c *meteredConn) Read(p []byte) (int, error) {
+	remaining := c.remaining()
+	if remaining == 0 || remaining < -1 {
+		return 0, errByteCap{limit: c.meter.limit}
+	}
+	capped := remaining > 0 && int64(len(p)) > remaining
+	if capped {
+		p = p[:remaining]
+	}
+
+	n, err := c.Conn.Read(p)
+	c.target.received.Add(int64(n))
+	c.meter.total.received.Add(int64(n))
+	if err == nil && capped && int64(n) == remaining {
+		// The read may have stopped short of the data, don't let it pass as whole
+		err = errByteCap{limit: c.meter.limit}
+	}
+	return n, err
+}
+
+func (c *meteredConn) Write(p []byte) (int, error) {
+	if remaining := c.remaining(); remaining >= 0 && int64(len(p)) > remaining {
+		return 0, errByteCap{limit: c.meter.limit}
+	}
+
+	n, err := c.Conn.Write(p)
+	c.target.sent.Add(int64(n))
+	c.meter.total.sent.Add(int64(n))
+	return n, err
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"sync"
+	"time"
+)
+
+/*
+bannerCache keeps what probing a target found, keyed by its address and
+protocol, so that scanning it again within ttl is served from memory
+instead of connecting. Failed scans aren't kept, they are retried.
+*/
+type bannerCache struct {
+	ttl time.Duration
+
+	mu      sync.Mutex
+	entries map[string]*ScanResult
+}
+
+func newBannerCache(ttl time.Duration) *bannerCache {
+	return &bannerCache{ttl: ttl, entries: make(map[string]*ScanResult)}
+}
+
+func bannerKey(target Target) string {
+	return target.Protocol + "://" + target.Address()
+}
+
+/*
+get returns a copy of the cached result for the target, marked with the
+time it was probed, or false when there is none or it is too old
+*/
+func (c *bannerCache) get(target Target) (*ScanResult, bool) {
+	if c == nil {
+		return nil, false
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	key := bannerKey(target)
+	cached, ok := c.entries[key]
+	if !ok {
+		return nil, false
+	}
+	if clock.Now().Sub(cached.CachedAt) >= c.ttl {
+		delete(c.entries, key)
+		return nil, false
+	}
+	return cached.copy(), true
+}
+
+/*
+put caches the result of probing a target, before anything is added to
+it for the current scan
+*/
+func (c *bannerCache) put(result *ScanResult) {
+	if c == nil || result.Failed() {
+		return
+	}
+	cached := result.copy()
+	cached.CachedAt = clock.Now()
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.entries[bannerKey(result.Target)] = cached
+}
+
+/*
+copy returns a copy of the result whose findings can be appended to
+without touching the original
+*/
+func (r *ScanResult) copy() *ScanResult {
+	c := *r
+	c.Findings = append([]Finding(nil), r.Findings...)
+	return &c
+}
@@ -0,0 +1,130 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"os"
+	"runtime"
+	"sort"
+)
+
+/*
+Manifest is what the capabilities subcommand prints: the probes, checks
+and output formats compiled into this binary, so automation can tell
+whether a deployed scanner runs the checks a policy requires
+*/
+type Manifest struct {
+	Tool          string           `json:"tool"`
+	Version       string           `json:"version"`
+	GoVersion     string           `json:"go_version"`
+	Platform      string           `json:"platform"`
+	Probes        []ManifestProbe  `json:"probes"`
+	Checks        []ManifestCheck  `json:"checks"`
+	Packs         []string         `json:"packs"`
+	OutputFormats []ManifestFormat `json:"output_formats"`
+	Transports    []string         `json:"transports"`
+	Languages     []string         `json:"languages"`
+}
+
+type ManifestProbe struct {
+	Name     string `json:"name"`
+	Protocol string `json:"protocol"`
+	// Protocol version the probe decodes, if the protocol has versions
+	Version     string `json:"version,omitempty"`
+	Description string `json:"description"`
+}
+
+type ManifestCheck struct {
+	ID          string `json:"id"`
+	Tier        string `json:"tier"`
+	Pack        string `json:"pack,omitempty"`
+	Description string `json:"description"`
+}
+
+type ManifestFormat struct {
+	Name string `json:"name"`
+	// Version of the format or of its schema, for those that have one
+	Version string `json:"version,omitempty"`
+	// Text formats -compat can pin
+	Compat []string `json:"compat,omitempty"`
+}
+
+/*
+Probes the scanner makes of a target before and besides its checks
+*/
+var manifestProbes = []ManifestProbe{
+	{"greeting", ProtocolMySQL, "10", "Decodes the initial handshake of the classic protocol"},
+	{"x-capabilities", ProtocolMySQLX, "", "Reads the capabilities of the X Protocol"},
+	{"xcom", ProtocolMySQL, "", "Tells group replication (XCom) ports, which don't greet, from closed ones"},
+	{"tls-certificate", ProtocolMySQL, "", "Upgrades to TLS when offered and fingerprints the server certificate (-tls-cert)"},
+	{"syn-proxy", "tcp", "", "Tells ports behind SYN proxies, which reset the connection on write, from tarpits"},
+}
+
+func newManifest() Manifest {
+	manifest := Manifest{
+		Tool:       toolName,
+		Version:    version,
+		GoVersion:  runtime.Version(),
+		Platform:   runtime.GOOS + "/" + runtime.GOARCH,
+		Probes:     manifestProbes,
+		Transports: []string{"tcp", "ssh", "socks5"},
+		Languages:  []string{defaultLang},
+	}
+	if namedPipes {
+		manifest.Transports = append(manifest.Transports, "named-pipe")
+	}
+
+	checksMu.Lock()
+	for _, check := range checks {
+		if check.custom {
+			continue
+		}
+		manifest.Checks = append(manifest.Checks, ManifestCheck{
+			ID:          check.ID,
+			Tier:        check.Tier.String(),
+			Pack:        check.Pack,
+			Description: check.Description,
+		})
+	}
+	checksMu.Unlock()
+	sort.Slice(manifest.Checks, func(i, j int) bool { return manifest.Checks[i].ID < manifest.Checks[j].ID })
+
+	for pack := range packs {
+		manifest.Packs = append(manifest.Packs, pack)
+	}
+	sort.Strings(manifest.Packs)
+
+	var compat []string
+	for format := range textFormats {
+		compat = append(compat, format)
+	}
+	sort.Strings(compat)
+	manifest.OutputFormats = []ManifestFormat{
+		{Name: OutputText, Compat: compat},
+		{Name: OutputJSON, Version: resultSchemaVersion},
+		{Name: OutputSARIF, Version: sarifVersion},
+		{Name: OutputJUnit},
+		{Name: OutputDissect},
+	}
+
+	for lang := range catalogs {
+		manifest.Languages = append(manifest.Languages, lang)
+	}
+	sort.Strings(manifest.Languages)
+	return manifest
+}
+
+/*
+runCapabilities prints the manifest as JSON
+*/
+func runCapabilities(args []string) int {
+	flags := flag.NewFlagSet("capabilities", flag.ExitOnError)
+	flags.Parse(args)
+
+	encoder := json.NewEncoder(os.Stdout)
+	encoder.SetIndent("", "  ")
+	if err := encoder.Encode(newManifest()); err != nil {
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"fmt"
+)
+
+/*
+Authentication plugins past their time, with how bad it is to still
+use them
+*/
+var legacyAuthPlugins = map[string]Severity{
+	// Pre-4.1 hashing, trivially cracked
+	"mysql_old_password": SeverityHigh,
+	// SHA1 based, deprecated in 8.0 and disabled by default in 8.4
+	"mysql_native_password": SeverityLow,
+}
+
+func init() {
+	registerCheck(Check{
+		ID:          "MYSQL-ACCOUNTS",
+		Description: "Audits mysql.user for wildcard hosts, empty passwords, legacy auth plugins and global ALL PRIVILEGES",
+		Tier:        TierActive,
+		Run:         checkAccounts,
+	})
+}
+
+/*
+checkAccounts reports one finding per account and problem. Locked
+accounts, like the mysql.sys ones, can't log in and are skipped.
+Without SELECT on mysql.user nothing is reported.
+*/
+func checkAccounts(ctx *CheckContext) []Finding {
+	session, err := ctx.Queries()
+	if err != nil {
+		return nil
+	}
+
+	accounts, err := queryAccounts(session)
+	if err != nil {
+		return nil
+	}
+
+	var findings []Finding
+	for _, account := range accounts {
+		if account.Locked {
+			continue
+		}
+
+		if account.WildcardHost() {
+			findings = append(findings, Finding{
+				RuleID:   "MYSQL-ACCOUNT-WILDCARD-HOST",
+				Severity: SeverityMedium,
+				Title:    "Account accepts connections from any matching host",
+				Detail:   fmt.Sprintf("%s uses a wildcard host", account),
+			})
+		}
+
+		if account.EmptyPassword && passwordPlugins[account.Plugin] {
+			findings = append(findings, Finding{
+				RuleID:   "MYSQL-ACCOUNT-EMPTY-PASSWORD",
+				Severity: SeverityHigh,
+				Title:    "Account has an empty password",
+				Detail:   fmt.Sprintf("%s authenticates with %s and no password", account, account.Plugin),
+			})
+		}
+
+		if severity, legacy := legacyAuthPlugins[account.Plugin]; legacy {
+			findings = append(findings, Finding{
+				RuleID:   "MYSQL-ACCOUNT-LEGACY-AUTH",
+				Severity: severity,
+				Title:    "Account uses a legacy authentication plugin",
+				Detail:   fmt.Sprintf("%s authenticates with %s", account, account.Plugin),
+			})
+		}
+
+		// The built-in superuser is expected to have everything
+		if account.AllPrivileges && !(account.User == "root" && account.Host == "localhost") {
+			severity := SeverityMedium
+			if account.WildcardHost() {
+				severity = SeverityHigh
+			}
+			findings = append(findings, Finding{
+				RuleID:   "MYSQL-ACCOUNT-ALL-PRIVILEGES",
+				Severity: severity,
+				Title:    "Account has every global privilege",
+				Detail:   fmt.Sprintf("%s was granted ALL PRIVILEGES ON *.*", account),
+			})
+		}
+	}
+	return findings
+}
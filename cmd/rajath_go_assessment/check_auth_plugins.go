@@ -0,0 +1,231 @@
+package main
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+func init() {
+	registerCheck(Check{
+		ID:          "MYSQL-AUTH-PLUGINS",
+		Description: "Starts logins as root and the scan user to see which authentication plugins the server switches them to",
+		Tier:        TierIntrusive,
+		Run:         checkAuthPlugins,
+	})
+}
+
+/*
+authPluginRule describes what it means for the server to ask for a
+client plugin. cleartext marks plugins the client answers with the
+password as typed.
+*/
+type authPluginRule struct {
+	ruleID    string
+	severity  Severity
+	title     string
+	cleartext bool
+}
+
+/*
+Client plugins the server asks for in an AuthSwitchRequest, by the
+server plugin behind them
+*/
+var authPluginRules = map[string]authPluginRule{
+	// authentication_ldap_sasl
+	"authentication_ldap_sasl_client": {"MYSQL-AUTH-LDAP-SASL", SeverityMedium, "Account authenticates against LDAP with SASL", false},
+	// authentication_pam and authentication_ldap_simple on MySQL Enterprise
+	"mysql_clear_password": {"MYSQL-AUTH-CLEARTEXT", SeverityMedium, "Account authenticates with a cleartext password (PAM or simple LDAP)", true},
+	// auth_pam on Percona Server and pam on MariaDB
+	"dialog": {"MYSQL-AUTH-PAM", SeverityMedium, "Account authenticates against PAM", true},
+	// auth_socket on MySQL and unix_socket on MariaDB
+	"auth_socket": {"MYSQL-AUTH-SOCKET", SeverityInfo, "Account authenticates by Unix socket peer credentials", false},
+	"unix_socket": {"MYSQL-AUTH-SOCKET", SeverityInfo, "Account authenticates by Unix socket peer credentials", false},
+	// authentication_kerberos on MySQL Enterprise
+	"authentication_kerberos_client": {"MYSQL-AUTH-KERBEROS", SeverityInfo, "Account authenticates with Kerberos", false},
+	// gssapi on MariaDB
+	"auth_gssapi_client": {"MYSQL-AUTH-KERBEROS", SeverityInfo, "Account authenticates with GSSAPI (Kerberos)", false},
+	// authentication_fido (8.0.27 to 8.3) and authentication_webauthn (8.2 and later) on MySQL Enterprise,
+	// mostly asked for as the second or third factor
+	"authentication_fido_client":     {"MYSQL-AUTH-FIDO", SeverityInfo, "Account authenticates with a FIDO device", false},
+	"authentication_webauthn_client": {"MYSQL-AUTH-FIDO", SeverityInfo, "Account authenticates with a WebAuthn (FIDO2) device", false},
+}
+
+/*
+kerberosPrincipal reads the service principal name and realm MySQL's
+authentication_kerberos sends the client to get a ticket for
+
+	int<2>       length of the SPN
+	string[len]  SPN
+	int<2>       length of the realm
+	string[len]  realm
+*/
+func kerberosPrincipal(data []byte) (spn, realm string, ok bool) {
+	field := func() (string, bool) {
+		if len(data) < 2 {
+			return "", false
+		}
+		n := int(binary.LittleEndian.Uint16(data))
+		if len(data) < 2+n {
+			return "", false
+		}
+		value := string(data[2 : 2+n])
+		data = data[2+n:]
+		return value, true
+	}
+	if spn, ok = field(); !ok {
+		return "", "", false
+	}
+	if realm, ok = field(); !ok {
+		return "", "", false
+	}
+	return spn, realm, true
+}
+
+/*
+switchedPlugin starts a login and returns the auth switch the client
+couldn't answer, or nil when the server didn't ask for an unknown plugin
+*/
+func switchedPlugin(ctx *CheckContext, user string) (*UnsupportedPluginError, error) {
+	session, err := ctx.Login(user, "")
+	if err == nil {
+		session.Close()
+		return nil, nil
+	}
+
+	var unsupported *UnsupportedPluginError
+	if errors.As(err, &unsupported) {
+		return unsupported, nil
+	}
+	var serverErr *ServerError
+	if errors.As(err, &serverErr) {
+		return nil, nil
+	}
+	return nil, err
+}
+
+/*
+fidoRelyingParty reads the relying party ID the FIDO and WebAuthn plugins
+send the client with their challenge, which WebAuthn precedes with a
+capability byte
+
+	int<1>              capabilities (WebAuthn only)
+	string<lenenc>      challenge
+	string<lenenc>      relying party ID
+*/
+func fidoRelyingParty(plugin string, data []byte) (string, bool) {
+	if plugin == "authentication_webauthn_client" {
+		if len(data) == 0 {
+			return "", false
+		}
+		data = data[1:]
+	}
+	_, n, _, err := readLenEncString(data)
+	if err != nil {
+		return "", false
+	}
+	party, _, _, err := readLenEncString(data[n:])
+	if err != nil || len(party) == 0 {
+		return "", false
+	}
+	return string(party), true
+}
+
+/*
+pluginDetail describes the data sent with the switch: the SASL mechanism
+for LDAP, the prompt for PAM, the service principal and realm for
+Kerberos, the relying party for FIDO
+*/
+func pluginDetail(user string, switched *UnsupportedPluginError) string {
+	return fmt.Sprintf("%q was switched to %s", user, switched.Plugin) + pluginDataDetail(switched.Plugin, switched.Data)
+}
+
+/*
+pluginDataDetail describes what the server sent the client along with
+asking for the plugin, empty when there is nothing to tell
+*/
+func pluginDataDetail(plugin string, data []byte) string {
+	var detail string
+	switch plugin {
+	case "authentication_ldap_sasl_client":
+		if len(data) > 0 {
+			detail += fmt.Sprintf(", SASL mechanism %s", data)
+		}
+	case "dialog":
+		// The first byte tells the client how to show the prompt
+		if len(data) > 1 {
+			detail += fmt.Sprintf(", prompt %q", strings.TrimSpace(string(data[1:])))
+		}
+	case "authentication_kerberos_client":
+		if spn, realm, ok := kerberosPrincipal(data); ok {
+			detail += fmt.Sprintf(", service principal %q in realm %q", spn, realm)
+		} else if len(data) > 0 {
+			detail += fmt.Sprintf(", plugin data %q", data)
+		}
+	case "authentication_fido_client", "authentication_webauthn_client":
+		if party, ok := fidoRelyingParty(plugin, data); ok {
+			detail += fmt.Sprintf(", relying party %q", party)
+		}
+	case "auth_gssapi_client":
+		// The principal name, then the GSSAPI mechanism after a NUL
+		if parts := strings.SplitN(string(data), "\x00", 2); parts[0] != "" {
+			detail += fmt.Sprintf(", service principal %q", parts[0])
+			if len(parts) == 2 && parts[1] != "" {
+				detail += fmt.Sprintf(", mechanism %s", parts[1])
+			}
+		}
+	}
+	return detail
+}
+
+/*
+cleartextOverPlaintext is reported when the server asks for the password
+as typed on the scanner's logins, which never use TLS. Any client not
+insisting on TLS would hand the password to whoever can see the traffic.
+*/
+func cleartextOverPlaintext(user string, switched *UnsupportedPluginError, tls bool) Finding {
+	detail := fmt.Sprintf("%q was switched to %s on a connection without TLS", user, switched.Plugin)
+	if tls {
+		detail += ", the server offers TLS but doesn't require it"
+	} else {
+		detail += ", the server doesn't offer TLS at all"
+	}
+	return Finding{
+		RuleID:   "MYSQL-CLEARTEXT-PASSWORD-PLAINTEXT",
+		Severity: SeverityHigh,
+		Title:    "Server asks for a clear text password over an unencrypted connection",
+		Detail:   detail,
+	}
+}
+
+func checkAuthPlugins(ctx *CheckContext) []Finding {
+	users := []string{"root"}
+	if ctx.Options.User != "" && ctx.Options.User != "root" {
+		users = append(users, ctx.Options.User)
+	}
+	tls := ctx.Handshake.CapabilitiesFlags.Has(clientSSL)
+
+	var findings []Finding
+	for _, user := range users {
+		switched, err := switchedPlugin(ctx, user)
+		if err != nil || switched == nil {
+			continue
+		}
+		rule, ok := authPluginRules[switched.Plugin]
+		if !ok {
+			continue
+		}
+
+		findings = append(findings, Finding{
+			RuleID:   rule.ruleID,
+			Severity: rule.severity,
+			Title:    rule.title,
+			Detail:   pluginDetail(user, switched),
+		})
+		if rule.cleartext {
+			findings = append(findings, cleartextOverPlaintext(user, switched, tls))
+		}
+	}
+	return findings
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+const bannerSpoofingRuleID = "MYSQL-BANNER-SPOOFED"
+
+/*
+capabilityRelease is the MySQL release that introduced a capability,
+which no older server advertises. Checked against MariaDB banners too
+when MariaDB has the capability with the same meaning since the same
+series, as it does the pre 5.6 ones.
+*/
+type capabilityRelease struct {
+	flag    CapabilityFlag
+	release string
+	mariaDB bool
+}
+
+var capabilityReleases = []capabilityRelease{
+	{clientPluginAuth, "5.5.7", true},
+	{clientConnectAttrs, "5.6.6", false},
+	{clientPluginAuthLenEncClientData, "5.6.6", false},
+	{clientCanHandleExpiredPasswords, "5.6.10", false},
+	{clientSessionTrack, "5.7.4", false},
+	{clientDeprecateEOF, "5.7.5", false},
+	{clientOptionalResultsetMetadata, "8.0.3", false},
+	{clientZstdCompressionAlgorithm, "8.0.18", false},
+	{clientQueryAttributes, "8.0.23", false},
+	{clientMultiFactorAuthentication, "8.0.27", false},
+}
+
+func init() {
+	registerCheck(Check{
+		ID:          bannerSpoofingRuleID,
+		Description: "Flags version banners older than the release that introduced a capability the server advertises",
+		Tier:        TierPassive,
+		Run:         checkBannerSpoofing,
+	})
+}
+
+/*
+olderRelease tells whether version is older than release. Banners
+without a patch number are only compared by series, giving them the
+benefit of the doubt.
+*/
+func olderRelease(version, release string) bool {
+	major, minor, _ := serverRelease(version)
+	releaseMajor, releaseMinor, _ := serverRelease(release)
+	if len(strings.FieldsFunc(version, func(r rune) bool { return r < '0' || r > '9' })) < 3 {
+		return major < releaseMajor || major == releaseMajor && minor < releaseMinor
+	}
+	return compareVersions(version, release) < 0
+}
+
+/*
+capabilityMismatches returns the capabilities the handshake advertises
+that its version predates, with the release that introduced each
+*/
+func capabilityMismatches(p *InitialHandshakePacket) []capabilityRelease {
+	version := string(p.ServerVersion)
+	mariaDB := strings.Contains(version, "MariaDB")
+	if mariaDB {
+		version = strings.TrimPrefix(version, "5.5.5-")
+	}
+	if _, _, ok := serverRelease(version); !ok {
+		return nil
+	}
+
+	var mismatches []capabilityRelease
+	for _, c := range capabilityReleases {
+		if p.CapabilitiesFlags.Has(c.flag) && (c.mariaDB || !mariaDB) && olderRelease(version, c.release) {
+			mismatches = append(mismatches, c)
+		}
+	}
+	return mismatches
+}
+
+/*
+impliedRelease returns the newest release among the mismatches, the
+oldest the server can really be
+*/
+func impliedRelease(mismatches []capabilityRelease) string {
+	newest := mismatches[0].release
+	for _, m := range mismatches[1:] {
+		if compareVersions(m.release, newest) > 0 {
+			newest = m.release
+		}
+	}
+	return newest
+}
+
+func checkBannerSpoofing(ctx *CheckContext) []Finding {
+	mismatches := capabilityMismatches(ctx.Handshake)
+	if len(mismatches) == 0 {
+		return nil
+	}
+
+	var reasons []string
+	for _, m := range mismatches {
+		reasons = append(reasons, fmt.Sprintf("%s since %s", flags[m.flag], m.release))
+	}
+	return []Finding{{
+		RuleID:   bannerSpoofingRuleID,
+		Severity: SeverityInfo,
+		Title:    "Version banner is older than the capabilities advertised, it is likely spoofed",
+		Detail: fmt.Sprintf("version %q advertises %s, so the server is at least %s",
+			strings.TrimSpace(string(ctx.Handshake.ServerVersion)), strings.Join(reasons, ", "), impliedRelease(mismatches)),
+	}}
+}
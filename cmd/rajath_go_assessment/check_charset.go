@@ -0,0 +1,50 @@
+package main
+
+import (
+	"fmt"
+)
+
+func init() {
+	registerCheck(Check{
+		ID:          "MYSQL-CHARSET",
+		Description: "Compares the handshake character set with character_set_server and collation_server",
+		Tier:        TierActive,
+		Run:         checkCharset,
+	})
+}
+
+func checkCharset(ctx *CheckContext) []Finding {
+	session, err := ctx.Queries()
+	if err != nil {
+		return nil
+	}
+
+	_, rows, err := session.Query("SELECT @@character_set_server, @@collation_server")
+	if err != nil || len(rows) != 1 || len(rows[0]) != 2 {
+		return nil
+	}
+	charset, collation := string(rows[0][0]), string(rows[0][1])
+
+	var findings []Finding
+
+	handshake := collationName(ctx.Handshake.CharacterSet)
+	if _, known := collations[ctx.Handshake.CharacterSet]; known && !sameCollation(handshake, collation) {
+		findings = append(findings, Finding{
+			RuleID:   "MYSQL-CHARSET-MISMATCH",
+			Severity: SeverityInfo,
+			Title:    "Handshake character set differs from the server default",
+			Detail:   fmt.Sprintf("handshake %s (%d), collation_server %s", handshake, ctx.Handshake.CharacterSet, collation),
+		})
+	}
+
+	if charset == "latin1" {
+		findings = append(findings, Finding{
+			RuleID:   "MYSQL-CHARSET-LATIN1",
+			Severity: SeverityInfo,
+			Title:    "Server still uses the legacy latin1 default character set",
+			Detail:   fmt.Sprintf("character_set_server %s, collation_server %s", charset, collation),
+		})
+	}
+
+	return findings
+}
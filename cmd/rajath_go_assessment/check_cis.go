@@ -0,0 +1,158 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+/*
+cisControl is a CIS MySQL Benchmark recommendation that can be verified
+from a single server variable
+*/
+type cisControl struct {
+	ID       string
+	Title    string
+	Variable string
+	Severity Severity
+	// Reports whether the variable's value complies
+	Pass func(value string) bool
+}
+
+/*
+The handshake-and-variables-verifiable subset of the CIS Oracle MySQL
+8.0 Benchmark. Control numbers follow the benchmark; controls that need
+file system or OS access are left out.
+*/
+var cisControls = []cisControl{
+	{"4.4", "Ensure 'local_infile' is disabled", "local_infile", SeverityMedium, equalsFold("OFF")},
+	{"4.6", "Ensure symbolic links are disabled", "have_symlink", SeverityLow, equalsFold("DISABLED")},
+	{"4.8", "Ensure 'secure_file_priv' is configured", "secure_file_priv", SeverityMedium, not(isEmpty)},
+	{"4.9", "Ensure 'sql_mode' contains 'STRICT_ALL_TABLES'", "sql_mode", SeverityLow, containsMode("STRICT_ALL_TABLES")},
+	{"6.1", "Ensure 'log_error' is configured", "log_error", SeverityLow, not(isEmpty)},
+	{"6.3", "Ensure 'log_error_verbosity' is set to '2'", "log_error_verbosity", SeverityLow, atLeast(2)},
+	{"7.1", "Ensure 'default_authentication_plugin' is set to a secure option", "default_authentication_plugin", SeverityMedium, equalsFold("caching_sha2_password")},
+	{"7.4", "Ensure 'default_password_lifetime' is less than or equal to '365'", "default_password_lifetime", SeverityLow, between(1, 365)},
+	cisSecureTransport,
+	{"8.4", "Ensure 'tls_version' is set to TLSv1.2 or higher", "tls_version", SeverityMedium, modernTLS},
+}
+
+/*
+Also checked from the handshake, which tells whether TLS is offered at all
+*/
+var cisSecureTransport = cisControl{"8.1", "Ensure 'require_secure_transport' is set to 'ON' and/or 'have_ssl' is set to 'YES'",
+	"require_secure_transport", SeverityHigh, equalsFold("ON")}
+
+func equalsFold(want string) func(string) bool {
+	return func(value string) bool { return strings.EqualFold(value, want) }
+}
+
+func isEmpty(value string) bool {
+	return strings.TrimSpace(value) == ""
+}
+
+func not(pass func(string) bool) func(string) bool {
+	return func(value string) bool { return !pass(value) }
+}
+
+func containsMode(mode string) func(string) bool {
+	return func(value string) bool {
+		for _, m := range strings.Split(value, ",") {
+			if strings.EqualFold(m, mode) {
+				return true
+			}
+		}
+		return false
+	}
+}
+
+func atLeast(min int) func(string) bool {
+	return func(value string) bool {
+		n, err := strconv.Atoi(value)
+		return err == nil && n >= min
+	}
+}
+
+func between(min, max int) func(string) bool {
+	return func(value string) bool {
+		n, err := strconv.Atoi(value)
+		return err == nil && n >= min && n <= max
+	}
+}
+
+func modernTLS(value string) bool {
+	if isEmpty(value) {
+		return false
+	}
+	for _, version := range strings.Split(value, ",") {
+		switch strings.TrimSpace(version) {
+		case "TLSv1", "TLSv1.1":
+			return false
+		}
+	}
+	return true
+}
+
+func init() {
+	registerCheck(Check{
+		ID:          "CIS",
+		Description: "Verifies the handshake and server variable controls of the CIS MySQL Benchmark",
+		Tier:        TierActive,
+		Pack:        PackCIS,
+		Run:         checkCIS,
+	})
+}
+
+func cisFinding(control cisControl, detail string) Finding {
+	return Finding{
+		RuleID:   "CIS-" + control.ID,
+		Severity: control.Severity,
+		Title:    control.Title,
+		Detail:   detail,
+	}
+}
+
+/*
+checkCIS reports every failed control. The TLS control is checked from
+the handshake even without credentials, the others need a session.
+Variables the server doesn't have, like those of removed features,
+count as compliant.
+*/
+func checkCIS(ctx *CheckContext) []Finding {
+	var findings []Finding
+
+	noTLS := !ctx.Handshake.CapabilitiesFlags.Has(clientSSL)
+	if noTLS {
+		findings = append(findings, cisFinding(cisSecureTransport, "server does not offer TLS in its handshake"))
+	}
+
+	session, err := ctx.Queries()
+	if err != nil {
+		return findings
+	}
+
+	names := make([]string, len(cisControls))
+	for i, control := range cisControls {
+		names[i] = "'" + control.Variable + "'"
+	}
+	_, rows, err := session.Query("SHOW GLOBAL VARIABLES WHERE Variable_name IN (" + strings.Join(names, ", ") + ")")
+	if err != nil {
+		return findings
+	}
+
+	variables := make(map[string]string)
+	for _, row := range rows {
+		if len(row) == 2 {
+			variables[string(row[0])] = string(row[1])
+		}
+	}
+
+	for _, control := range cisControls {
+		value, ok := variables[control.Variable]
+		if !ok || control.Pass(value) || (noTLS && control.ID == cisSecureTransport.ID) {
+			continue
+		}
+		findings = append(findings, cisFinding(control, fmt.Sprintf("%s = '%s'", control.Variable, value)))
+	}
+	return findings
+}
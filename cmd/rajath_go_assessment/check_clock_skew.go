@@ -0,0 +1,96 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+/*
+Skew beyond this, after allowing for the round trip, is reported
+*/
+const defaultMaxClockSkew = 5 * time.Second
+
+/*
+ClockSkew is how far the server clock is ahead of the scanner's (negative when behind)
+*/
+type ClockSkew struct {
+	Skew time.Duration
+	RTT  time.Duration
+}
+
+func (c *ClockSkew) String() string {
+	return fmt.Sprintf("%s (rtt %s)", c.Skew.Round(time.Millisecond), c.RTT.Round(time.Microsecond))
+}
+
+func init() {
+	registerCheck(Check{
+		ID:          "MYSQL-CLOCK-SKEW",
+		Description: "Compares the server clock with the scanner's, corrected for round trip time",
+		Tier:        TierActive,
+		Run:         checkClockSkew,
+	})
+}
+
+/*
+measureClockSkew asks the server for its time and compares it with the
+middle of the round trip. UTC_TIMESTAMP is used rather than NOW() so the
+session time zone doesn't get in the way. Servers before 5.6.4 have no
+fractional seconds and refuse UTC_TIMESTAMP(6), so they are asked again
+for whole seconds.
+*/
+func measureClockSkew(session QueryRunner) (*ClockSkew, error) {
+	sent := clock.Now()
+	_, rows, err := session.Query("SELECT UTC_TIMESTAMP(6)")
+	received := clock.Now()
+	var serverErr *ServerError
+	if errors.As(err, &serverErr) {
+		sent = clock.Now()
+		_, rows, err = session.Query("SELECT UTC_TIMESTAMP()")
+		received = clock.Now()
+	}
+	if err != nil {
+		return nil, err
+	}
+	if len(rows) != 1 || len(rows[0]) != 1 {
+		return nil, fmt.Errorf("Unexpected reply to UTC_TIMESTAMP()")
+	}
+
+	serverTime, err := time.Parse("2006-01-02 15:04:05.999999", string(rows[0][0]))
+	if err != nil {
+		return nil, err
+	}
+
+	rtt := received.Sub(sent)
+	midpoint := sent.Add(rtt / 2)
+	return &ClockSkew{Skew: serverTime.Sub(midpoint), RTT: rtt}, nil
+}
+
+func checkClockSkew(ctx *CheckContext) []Finding {
+	session, err := ctx.Queries()
+	if err != nil {
+		return nil
+	}
+
+	skew, err := measureClockSkew(session)
+	if err != nil {
+		return nil
+	}
+	ctx.Result.ClockSkew = skew
+
+	// The server read its clock somewhere within the round trip
+	magnitude := skew.Skew
+	if magnitude < 0 {
+		magnitude = -magnitude
+	}
+	if magnitude-skew.RTT/2 <= ctx.Options.MaxClockSkew {
+		return nil
+	}
+
+	return []Finding{{
+		RuleID:   "MYSQL-CLOCK-SKEW",
+		Severity: SeverityLow,
+		Title:    "Server clock is skewed",
+		Detail:   fmt.Sprintf("server clock differs by %s, allowed %s", skew, ctx.Options.MaxClockSkew),
+	}}
+}
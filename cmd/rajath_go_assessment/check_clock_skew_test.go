@@ -0,0 +1,29 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestClockSkewWithoutFractionalSeconds(t *testing.T) {
+	useFakes(t)
+	var queries []string
+	// A 5.5 server, which only knows whole seconds
+	old := queryFunc(func(sql string) ([]string, [][][]byte, error) {
+		queries = append(queries, sql)
+		if sql == "SELECT UTC_TIMESTAMP(6)" {
+			return nil, nil, &ServerError{Code: 1064, Message: "You have an error in your SQL syntax"}
+		}
+		return []string{"UTC_TIMESTAMP()"}, [][][]byte{{[]byte(fakeStart.UTC().Add(10 * time.Second).Format("2006-01-02 15:04:05"))}}, nil
+	})
+	skew, err := measureClockSkew(old)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(queries) != 2 {
+		t.Errorf("queried %q", queries)
+	}
+	if skew.Skew.Round(time.Second) != 10*time.Second {
+		t.Errorf("skew %s, want 10s", skew.Skew)
+	}
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"fmt"
+	"time"
+)
+
+func init() {
+	registerCheck(Check{
+		ID:          "MYSQL-CONNECTION-CHURN",
+		Description: "Estimates how many connections the server accepts from the connection id delta",
+		Tier:        TierActive,
+		Run:         checkConnectionChurn,
+	})
+}
+
+/*
+connectionChurn estimates the rate of connections made by other
+clients between the first and last sample. Connection ids are handed
+out sequentially by the server, so the delta minus our own connections
+is the number of connections made by everyone else.
+*/
+func connectionChurn(samples []HandshakeSample) (others uint32, perSecond float64, ok bool) {
+	if len(samples) < 2 {
+		return 0, 0, false
+	}
+
+	first, last := samples[0], samples[len(samples)-1]
+	elapsed := last.ReceivedAt.Sub(first.ReceivedAt).Seconds()
+	if elapsed <= 0 {
+		return 0, 0, false
+	}
+
+	// Unsigned subtraction keeps the delta correct when the id wraps around
+	delta := last.Packet.ConnectionId - first.Packet.ConnectionId
+	ours := uint32(len(samples) - 1)
+	if delta < ours {
+		// Ids went backwards or were reused, likely a restart or a load balancer
+		return 0, 0, false
+	}
+
+	others = delta - ours
+	return others, float64(others) / elapsed, true
+}
+
+func checkConnectionChurn(ctx *CheckContext) []Finding {
+	samples := ctx.Samples()
+	others, perSecond, ok := connectionChurn(samples)
+	if !ok {
+		return nil
+	}
+
+	first, last := samples[0], samples[len(samples)-1]
+	return []Finding{{
+		RuleID:   "MYSQL-CONNECTION-CHURN",
+		Severity: SeverityInfo,
+		Title:    "Estimated connection churn from connection id delta",
+		Detail: fmt.Sprintf("%d other connections in %s (%.2f/s), ids %d to %d",
+			others, last.ReceivedAt.Sub(first.ReceivedAt).Round(time.Millisecond), perSecond,
+			first.Packet.ConnectionId, last.Packet.ConnectionId),
+	}}
+}
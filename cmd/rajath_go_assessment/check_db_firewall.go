@@ -0,0 +1,104 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"syscall"
+	"time"
+)
+
+const dbFirewallRuleID = "MYSQL-DB-FIREWALL"
+
+/*
+Tag put on targets that look like a database firewall or proxy, whose
+handshake may be its own rather than the server's behind it
+*/
+const dbFirewallTag = "db_firewall"
+
+func init() {
+	registerCheck(Check{
+		ID:          dbFirewallRuleID,
+		Description: "Logs in with an account that can't exist to tell database firewalls, which drop the connection, from servers, which refuse it",
+		Tier:        TierIntrusive,
+		Run:         checkDBFirewall,
+	})
+}
+
+/*
+Errors a genuine server answers a login for an unknown account with:
+access denied, with or without a password, and the host being blocked,
+not allowed or out of connections
+*/
+var loginRefusals = map[uint16]bool{
+	1040: true,
+	1045: true,
+	1129: true,
+	1130: true,
+	1698: true,
+}
+
+/*
+probeFirewall starts a login with an account that can't exist and
+returns why the answer isn't a server's, empty when it is. Firewalls
+that only greet on the server's behalf often reset the connection, or
+go quiet, once they see the login; some answer with an error of their own.
+*/
+func probeFirewall(target Target) (string, error) {
+	conn, packet, _, err := openHandshake(target)
+	if err != nil {
+		return "", err
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(decodeTimeouts.total))
+
+	session := &Session{Target: target, Handshake: packet, User: randomCredential(), conn: conn}
+	err = session.authenticate([]string{randomCredential()})
+
+	var serverErr *ServerError
+	var netErr net.Error
+	switch {
+	case err == nil:
+		// Logged in as anyone, which the default credentials check reports
+		return "", nil
+	case errors.As(err, &serverErr):
+		if loginRefusals[serverErr.Code] {
+			return "", nil
+		}
+		return fmt.Sprintf("the login was answered with %s instead of access denied", serverErr), nil
+	case errors.Is(err, io.EOF), errors.Is(err, io.ErrUnexpectedEOF), errors.Is(err, syscall.ECONNRESET):
+		return "the connection was dropped after the login instead of refusing it with an error", nil
+	case errors.As(err, &netErr) && netErr.Timeout():
+		return fmt.Sprintf("nothing answered the login within %s", decodeTimeouts.total), nil
+	}
+	return "", err
+}
+
+/*
+FirewallSuspected reports whether the firewall probe fired for this
+result, which scanTarget tags it for
+*/
+func (r *ScanResult) FirewallSuspected() bool {
+	for _, f := range r.Findings {
+		if f.RuleID == dbFirewallRuleID {
+			return true
+		}
+	}
+	return false
+}
+
+func checkDBFirewall(ctx *CheckContext) []Finding {
+	reason, err := probeFirewall(ctx.Target)
+	if err != nil || reason == "" {
+		return nil
+	}
+
+	return []Finding{{
+		RuleID:   dbFirewallRuleID,
+		Severity: SeverityInfo,
+		Title:    "Endpoint is likely a database firewall or proxy, the handshake may not be the server's",
+		Detail:   fmt.Sprintf("%s; version %q and the other handshake fields may be the intermediary's", reason, strings.TrimSpace(string(ctx.Handshake.ServerVersion))),
+	}}
+}
@@ -0,0 +1,149 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+)
+
+/*
+Server error codes meaning further login attempts would lock someone out
+or are already being refused
+*/
+const (
+	erHostIsBlocked             = 1129
+	erAccountHasBeenLocked      = 3118
+	erAccountBlockedByPassLock  = 3955
+	erAccountBlockedByPassLock2 = 3957
+)
+
+var lockoutErrors = map[uint16]string{
+	erHostIsBlocked:             "host blocked after too many connection errors",
+	erAccountHasBeenLocked:      "account locked",
+	erAccountBlockedByPassLock:  "account temporarily locked after failed logins",
+	erAccountBlockedByPassLock2: "account temporarily locked after failed logins",
+}
+
+/*
+Credential is a user and password pair tried against a server
+*/
+type Credential struct {
+	User     string
+	Password string
+}
+
+func (c Credential) String() string {
+	if c.Password == "" {
+		return c.User + "/<blank>"
+	}
+	return c.User + "/" + c.Password
+}
+
+/*
+Notorious default credentials, kept short on purpose
+*/
+var defaultCredentials = []Credential{
+	{User: "root", Password: ""},
+	{User: "root", Password: "root"},
+	{User: "admin", Password: "admin"},
+}
+
+/*
+loadCredentials reads user:password pairs, one per line. Empty lines
+and lines starting with # are skipped, an empty password is allowed.
+*/
+func loadCredentials(path string) ([]Credential, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var creds []Credential
+	scanner := bufio.NewScanner(f)
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+		user, password, ok := strings.Cut(text, ":")
+		if !ok || user == "" {
+			return nil, fmt.Errorf("%s:%d: expected user:password", path, line)
+		}
+		creds = append(creds, Credential{User: user, Password: password})
+	}
+	return creds, scanner.Err()
+}
+
+func init() {
+	registerCheck(Check{
+		ID:          "MYSQL-DEFAULT-CREDENTIALS",
+		Description: "Attempts a short list of default credentials, stopping at any sign of lockout",
+		Tier:        TierIntrusive,
+		Run:         checkDefaultCredentials,
+	})
+}
+
+func checkDefaultCredentials(ctx *CheckContext) []Finding {
+	creds := ctx.Options.Credentials
+	if creds == nil {
+		creds = defaultCredentials
+	}
+
+	var accepted []Credential
+	attempts := 0
+	for _, cred := range creds {
+		if cred.User == "root" && cred.Password == "" {
+			// Covered by MYSQL-EMPTY-ROOT-PASSWORD
+			continue
+		}
+
+		if attempts > 0 {
+			clock.Sleep(ctx.Options.LoginDelay)
+		}
+		attempts++
+
+		ok, err := acceptsLogin(ctx, cred.User, cred.Password)
+		var serverErr *ServerError
+		if errors.As(err, &serverErr) {
+			if reason, locked := lockoutErrors[serverErr.Code]; locked {
+				return append(credentialFindings(accepted), Finding{
+					RuleID:   "MYSQL-DEFAULT-CREDENTIALS-ABORTED",
+					Severity: SeverityInfo,
+					Title:    "Stopped trying default credentials to avoid a lockout",
+					Detail:   fmt.Sprintf("%s after %d attempts: %s", reason, attempts, serverErr),
+				})
+			}
+		}
+		if err != nil {
+			// Connection level trouble, don't keep hammering the server
+			break
+		}
+		if ok {
+			accepted = append(accepted, cred)
+		}
+	}
+
+	if len(accepted) > 0 {
+		// With skip-grant-tables everything is accepted, which is reported on its own
+		if anyone, err := acceptsLogin(ctx, randomCredential(), randomCredential()); err == nil && anyone {
+			return nil
+		}
+	}
+	return credentialFindings(accepted)
+}
+
+func credentialFindings(accepted []Credential) []Finding {
+	var findings []Finding
+	for _, cred := range accepted {
+		findings = append(findings, Finding{
+			RuleID:   "MYSQL-DEFAULT-CREDENTIALS",
+			Severity: SeverityCritical,
+			Title:    "Server accepts default credentials",
+			Detail:   cred.String(),
+		})
+	}
+	return findings
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+)
+
+func init() {
+	registerCheck(Check{
+		ID:          "MYSQL-EMPTY-ROOT-PASSWORD",
+		Description: "Attempts to log in as root with an empty password",
+		Tier:        TierIntrusive,
+		Run:         checkEmptyRootPassword,
+	})
+}
+
+/*
+randomCredential returns a string no real account would use
+*/
+func randomCredential() string {
+	b := make([]byte, 8)
+	rand.Read(b)
+	return "scan_" + hex.EncodeToString(b)
+}
+
+/*
+acceptsLogin reports whether the server let us in. An expired password
+counts as accepted, since whoever knows it can log in and set a new one,
+a password the server asks another factor after doesn't. Errors that
+aren't an authentication failure from the server are returned.
+*/
+func acceptsLogin(ctx *CheckContext, user, password string) (bool, error) {
+	session, err := ctx.Login(user, password)
+	if err == nil {
+		session.Close()
+		return true, nil
+	}
+
+	var serverErr *ServerError
+	if errors.As(err, &serverErr) {
+		return passwordExpired(serverErr), nil
+	}
+	var factorErr *FactorRequiredError
+	if errors.As(err, &factorErr) {
+		return false, nil
+	}
+	return false, err
+}
+
+func checkEmptyRootPassword(ctx *CheckContext) []Finding {
+	ok, err := acceptsLogin(ctx, "root", "")
+	if err != nil || !ok {
+		return nil
+	}
+
+	/*
+		With skip-grant-tables every login succeeds, so try an account
+		that cannot exist to tell it apart from a root without password
+	*/
+	if anyone, err := acceptsLogin(ctx, randomCredential(), randomCredential()); err == nil && anyone {
+		return []Finding{{
+			RuleID:   "MYSQL-SKIP-GRANT-TABLES",
+			Severity: SeverityCritical,
+			Title:    "Server accepts any credentials",
+			Detail:   "a random user and password were accepted, the server is likely running with skip-grant-tables",
+		}}
+	}
+
+	return []Finding{{
+		RuleID:   "MYSQL-EMPTY-ROOT-PASSWORD",
+		Severity: SeverityCritical,
+		Title:    "root can log in with an empty password",
+	}}
+}
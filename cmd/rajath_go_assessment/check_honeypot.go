@@ -0,0 +1,214 @@
+package main
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+)
+
+const honeypotRuleID = "MYSQL-HONEYPOT-LIKELY"
+
+/*
+Version banners shipped as defaults by low interaction honeypots.
+Real servers rarely present them verbatim years after their release.
+*/
+var cannedVersions = map[string]string{
+	"5.5.43-0ubuntu0.14.04.1": "OpenCanary default banner",
+}
+
+/*
+Capabilities that only exist in the 4.1+ protocol, so a server
+advertising them without clientProtocol41 cannot be genuine
+*/
+var protocol41Only = []CapabilityFlag{
+	clientSecureConn,
+	clientPluginAuth,
+	clientConnectAttrs,
+	clientSessionTrack,
+	clientDeprecateEOF,
+}
+
+/*
+HoneypotFingerprint is the greeting of a known honeypot, given with
+-honeypot-fingerprints: the auth-plugin-data it always sends, hex
+encoded, and optionally its version banner
+*/
+type HoneypotFingerprint struct {
+	Name    string `json:"name"`
+	Salt    string `json:"salt"`
+	Version string `json:"version,omitempty"`
+
+	salt []byte
+}
+
+/*
+Fingerprints of the honeypots salts are matched against
+*/
+var honeypotFingerprints []HoneypotFingerprint
+
+/*
+loadHoneypotFingerprints reads a JSON array of fingerprints, such as
+
+	[{"name": "lab canary", "salt": "3f59264b2b346000...", "version": "5.5.43-0ubuntu0.14.04.1"}]
+*/
+func loadHoneypotFingerprints(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	var fingerprints []HoneypotFingerprint
+	if err := json.Unmarshal(data, &fingerprints); err != nil {
+		return fmt.Errorf("%s: %s", path, err.Error())
+	}
+	for i := range fingerprints {
+		f := &fingerprints[i]
+		if f.salt, err = hex.DecodeString(f.Salt); err != nil || len(f.salt) == 0 || f.Name == "" {
+			return fmt.Errorf("%s: fingerprint %d needs a name and a hex salt", path, i+1)
+		}
+	}
+	honeypotFingerprints = fingerprints
+	return nil
+}
+
+/*
+matchFingerprint returns the known honeypot the greeting is, if any
+*/
+func matchFingerprint(packet *InitialHandshakePacket) (HoneypotFingerprint, bool) {
+	s := salt(packet)
+	for _, f := range honeypotFingerprints {
+		if bytes.Equal(s, bytes.TrimRight(f.salt, "\x00")) && (f.Version == "" || f.Version == string(packet.ServerVersion)) {
+			return f, true
+		}
+	}
+	return HoneypotFingerprint{}, false
+}
+
+func init() {
+	registerCheck(Check{
+		ID:          honeypotRuleID,
+		Description: "Flags handshakes with anomalies typical of honeypots and emulated servers, and the greetings of known honeypots",
+		Tier:        TierPassive,
+		Run:         checkHoneypot,
+	})
+	// Comparing greetings takes more connections, which passive scans don't make
+	registerCheck(Check{
+		ID:          "MYSQL-HONEYPOT-REPEATED",
+		Description: "Flags servers greeting every connection with the same connection ID, as honeypots replaying one greeting do",
+		Tier:        TierActive,
+		Run:         checkHoneypotRepeated,
+	})
+}
+
+/*
+HoneypotLikely reports whether the honeypot heuristics fired for this result
+*/
+func (r *ScanResult) HoneypotLikely() bool {
+	for _, f := range r.Findings {
+		if f.RuleID == honeypotRuleID {
+			return true
+		}
+	}
+	return false
+}
+
+/*
+cannedSalt reports salts that are constant or a simple counting
+sequence, which no real random generator produces
+*/
+func cannedSalt(s []byte) bool {
+	if len(s) < 3 {
+		return false
+	}
+
+	step := int(s[1]) - int(s[0])
+	if step < -1 || step > 1 {
+		return false
+	}
+	for i := 2; i < len(s); i++ {
+		if int(s[i])-int(s[i-1]) != step {
+			return false
+		}
+	}
+	return true
+}
+
+/*
+repeatedConnectionID returns why the samples look replayed: every one
+has the connection ID of the first
+*/
+func repeatedConnectionID(samples []HandshakeSample) (string, bool) {
+	if len(samples) < 2 {
+		return "", false
+	}
+	first := samples[0].Packet
+	for _, sample := range samples[1:] {
+		if sample.Packet.ConnectionId != first.ConnectionId {
+			return "", false
+		}
+	}
+	return fmt.Sprintf("connection id %d repeated across %d connections", first.ConnectionId, len(samples)), true
+}
+
+/*
+honeypotReasons returns every anomaly found in a handshake
+*/
+func honeypotReasons(first *InitialHandshakePacket) []string {
+	var reasons []string
+
+	caps := first.CapabilitiesFlags
+	if !caps.Has(clientProtocol41) {
+		for _, flag := range protocol41Only {
+			if caps.Has(flag) {
+				reasons = append(reasons, fmt.Sprintf("%s advertised without clientProtocol41", flags[flag]))
+			}
+		}
+	}
+	if caps.Has(clientPluginAuth) && len(first.AuthPluginName) == 0 {
+		reasons = append(reasons, "clientPluginAuth advertised without an auth plugin name")
+	}
+
+	if source, ok := cannedVersions[string(first.ServerVersion)]; ok {
+		reasons = append(reasons, fmt.Sprintf("version %q is the %s", first.ServerVersion, source))
+	}
+
+	if cannedSalt(salt(first)) {
+		reasons = append(reasons, fmt.Sprintf("auth-plugin-data %q is not random", salt(first)))
+	}
+
+	if f, ok := matchFingerprint(first); ok {
+		reasons = append(reasons, fmt.Sprintf("auth-plugin-data is the fingerprint of %s", f.Name))
+	}
+
+	return reasons
+}
+
+func checkHoneypot(ctx *CheckContext) []Finding {
+	reasons := honeypotReasons(ctx.Handshake)
+	if len(reasons) == 0 {
+		return nil
+	}
+
+	return []Finding{{
+		RuleID:   honeypotRuleID,
+		Severity: SeverityInfo,
+		Title:    "Endpoint is likely a honeypot or emulated MySQL server",
+		Detail:   strings.Join(reasons, "; "),
+	}}
+}
+
+func checkHoneypotRepeated(ctx *CheckContext) []Finding {
+	reason, ok := repeatedConnectionID(ctx.Samples())
+	if !ok {
+		return nil
+	}
+
+	return []Finding{{
+		RuleID:   honeypotRuleID,
+		Severity: SeverityInfo,
+		Title:    "Endpoint is likely a honeypot or emulated MySQL server",
+		Detail:   reason,
+	}}
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestHoneypotFingerprint(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "fingerprints.json")
+	data := `[{"name": "lab canary", "salt": "6162636465666768696a6b6c6d6e6f7071727374", "version": "5.5.43-0ubuntu0.14.04.1"}]`
+	if err := os.WriteFile(path, []byte(data), 0600); err != nil {
+		t.Fatal(err)
+	}
+	defer func() { honeypotFingerprints = nil }()
+	if err := loadHoneypotFingerprints(path); err != nil {
+		t.Fatal(err)
+	}
+
+	packet := &InitialHandshakePacket{
+		ServerVersion:     []byte("5.5.43-0ubuntu0.14.04.1"),
+		AuthPluginData:    []byte("abcdefghijklmnopqrst\x00"),
+		CapabilitiesFlags: clientProtocol41,
+	}
+	reasons := strings.Join(honeypotReasons(packet), "; ")
+	if !strings.Contains(reasons, "fingerprint of lab canary") {
+		t.Errorf("fingerprint not matched: %s", reasons)
+	}
+
+	packet.ServerVersion = []byte("8.0.32")
+	if _, ok := matchFingerprint(packet); ok {
+		t.Error("fingerprint matched another version")
+	}
+}
+
+func TestLoadHoneypotFingerprintsRejectsBadSalt(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "fingerprints.json")
+	if err := os.WriteFile(path, []byte(`[{"name": "x", "salt": "not hex"}]`), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := loadHoneypotFingerprints(path); err == nil {
+		t.Error("bad salt accepted")
+	}
+}
+
+func TestRepeatedConnectionID(t *testing.T) {
+	sample := func(id uint32) HandshakeSample {
+		return HandshakeSample{Packet: &InitialHandshakePacket{ConnectionId: id}}
+	}
+	if _, ok := repeatedConnectionID([]HandshakeSample{sample(7)}); ok {
+		t.Error("one greeting reported as repeated")
+	}
+	if _, ok := repeatedConnectionID([]HandshakeSample{sample(7), sample(8), sample(7)}); ok {
+		t.Error("changing connection IDs reported as repeated")
+	}
+	if reason, ok := repeatedConnectionID([]HandshakeSample{sample(7), sample(7), sample(7)}); !ok || !strings.Contains(reason, "across 3") {
+		t.Errorf("repeated IDs not reported: %q", reason)
+	}
+}
+
+func TestHoneypotRepeatedIsActive(t *testing.T) {
+	for _, check := range checks {
+		if check.ID == "MYSQL-HONEYPOT-REPEATED" && check.Tier != TierActive {
+			t.Errorf("registered at %s, samples are only taken by active checks", check.Tier)
+		}
+	}
+}
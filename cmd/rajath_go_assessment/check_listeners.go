@@ -0,0 +1,164 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+func init() {
+	registerCheck(Check{
+		ID:          "MYSQL-LISTENERS",
+		Description: "Reads the X Plugin, admin interface and clone donor variables for listeners besides the scanned port",
+		Tier:        TierActive,
+		Run:         checkListeners,
+	})
+}
+
+/*
+Listener is a port the server has besides the scanned one, or a clone
+donor it may copy its data from, as its variables tell
+*/
+type Listener struct {
+	Target Target
+	// Variable it was read from
+	Source string
+}
+
+func (l Listener) String() string {
+	return fmt.Sprintf("%s %s from %s", l.Target.Protocol, l.Target.Label(), l.Source)
+}
+
+/*
+loopbackOnly tells whether a bind address keeps the listener out of
+reach from the scanner, which isn't on the server
+*/
+func loopbackOnly(bindAddress string) bool {
+	for _, address := range strings.Split(bindAddress, ",") {
+		address = strings.TrimSpace(address)
+		if address != "localhost" && !net.ParseIP(address).IsLoopback() {
+			return false
+		}
+	}
+	return true
+}
+
+/*
+serverListeners reads the listeners from the server variables: the X
+Plugin port, the admin interface and the clone donors. Ports bound to
+the loopback interface only are left out.
+*/
+func serverListeners(target Target, variable func(name string) (string, bool)) []Listener {
+	var listeners []Listener
+	port := func(name string) (int, bool) {
+		value, ok := variable(name)
+		if !ok {
+			return 0, false
+		}
+		port, err := strconv.Atoi(value)
+		return port, err == nil && port > 0 && port != target.Port
+	}
+
+	if p, ok := port("mysqlx_port"); ok {
+		if bind, _ := variable("mysqlx_bind_address"); !loopbackOnly(bind) {
+			listeners = append(listeners, Listener{Target{Host: target.Host, Port: p, Protocol: ProtocolMySQLX}, "mysqlx_port"})
+		}
+	}
+	// The admin interface is only there when admin_address is set
+	if bind, _ := variable("admin_address"); bind != "" && !loopbackOnly(bind) {
+		if p, ok := port("admin_port"); ok {
+			listeners = append(listeners, Listener{Target{Host: target.Host, Port: p, Protocol: ProtocolMySQL, Role: RoleAdmin}, "admin_port"})
+		}
+	}
+	if donors, _ := variable("clone_valid_donor_list"); donors != "" {
+		for _, donor := range strings.Split(donors, ",") {
+			host, p, err := net.SplitHostPort(strings.TrimSpace(donor))
+			if err != nil {
+				continue
+			}
+			if port, err := strconv.Atoi(p); err == nil && (host != target.Host || port != target.Port) {
+				listeners = append(listeners, Listener{Target{Host: host, Port: port, Protocol: ProtocolMySQL}, "clone_valid_donor_list"})
+			}
+		}
+	}
+	return listeners
+}
+
+/*
+checkListeners records the listeners in the result, for -follow-listeners
+to scan them. It produces no findings of its own.
+*/
+func checkListeners(ctx *CheckContext) []Finding {
+	if ctx.Result == nil || ctx.Target.Pipe != "" || ctx.Options.User == "" {
+		return nil
+	}
+	if _, _, err := ctx.Variable("version"); err != nil {
+		return nil
+	}
+	ctx.Result.Listeners = serverListeners(ctx.Target, func(name string) (string, bool) {
+		value, ok, _ := ctx.Variable(name)
+		return value, ok
+	})
+	return nil
+}
+
+/*
+listenerQueue picks the listeners -follow-listeners adds to the scan,
+each address and protocol once, leaving out the targets given. Only
+listeners on the hosts of the targets given are followed: clone donors
+are whatever hosts the scanned server names, which the scan may not be
+allowed to touch. Listeners of followed listeners aren't followed, the
+crawl goes one step from the targets given.
+*/
+type listenerQueue struct {
+	mu    sync.Mutex
+	seen  map[string]bool
+	hosts map[string]bool
+	// Listeners added, whose own listeners aren't followed
+	followed map[string]bool
+}
+
+func listenerKey(t Target) string {
+	return t.Protocol + "://" + t.Address()
+}
+
+func newListenerQueue(targets []Target) *listenerQueue {
+	q := &listenerQueue{seen: make(map[string]bool), hosts: make(map[string]bool), followed: make(map[string]bool)}
+	for _, target := range targets {
+		q.seen[listenerKey(target)] = true
+		if target.Pipe == "" {
+			q.hosts[normalizeHost(target.Host)] = true
+		}
+	}
+	return q
+}
+
+/*
+add returns the result's listeners not scanned or queued yet that are
+on the hosts of the targets given, nothing for a followed listener
+*/
+func (q *listenerQueue) add(result *ScanResult) []Target {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.followed[listenerKey(result.Target)] {
+		return nil
+	}
+	var added []Target
+	for _, listener := range result.Listeners {
+		key := listenerKey(listener.Target)
+		if q.seen[key] {
+			continue
+		}
+		q.seen[key] = true
+		if !q.hosts[normalizeHost(listener.Target.Host)] {
+			log.Printf("Not following %s, its host isn't among the targets\n", listener)
+			continue
+		}
+		q.followed[key] = true
+		added = append(added, listener.Target)
+	}
+	return added
+}
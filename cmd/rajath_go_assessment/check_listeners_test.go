@@ -0,0 +1,33 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestListenerQueueScope(t *testing.T) {
+	given := Target{Host: "10.0.0.5", Port: 3306, Protocol: ProtocolMySQL}
+	q := newListenerQueue([]Target{given, {Host: "db2.example.com", Port: 3306, Protocol: ProtocolMySQL}})
+
+	xPort := Target{Host: "10.0.0.5", Port: 33060, Protocol: ProtocolMySQLX}
+	donor := Target{Host: "DB2.example.com.", Port: 3307, Protocol: ProtocolMySQL}
+	outside := Target{Host: "192.0.2.10", Port: 3306, Protocol: ProtocolMySQL}
+	added := q.add(&ScanResult{Target: given, Listeners: []Listener{
+		{xPort, "mysqlx_port"},
+		{donor, "clone_valid_donor_list"},
+		{outside, "clone_valid_donor_list"},
+		// The target itself, already scanned
+		{given, "clone_valid_donor_list"},
+	}})
+	if len(added) != 2 || added[0] != xPort || added[1] != donor {
+		t.Fatalf("added %v, want the X Plugin port and the donor on a target host", added)
+	}
+
+	// One step only: a followed listener's listeners stay out
+	if added := q.add(&ScanResult{Target: donor, Listeners: []Listener{{Target{Host: "db2.example.com", Port: 3308, Protocol: ProtocolMySQL}, "admin_port"}}}); len(added) != 0 {
+		t.Errorf("followed listener added %v", added)
+	}
+	// Nor twice
+	if added := q.add(&ScanResult{Target: given, Listeners: []Listener{{xPort, "mysqlx_port"}}}); len(added) != 0 {
+		t.Errorf("listener added twice: %v", added)
+	}
+}
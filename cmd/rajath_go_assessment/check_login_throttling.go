@@ -0,0 +1,123 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+/*
+A failed login answered this much slower than the first one means the
+server is delaying failures, as the connection_control plugin does.
+Half its smallest delay of 1s, so the one delayed attempt the check
+makes isn't lost to round trip jitter.
+*/
+const throttleDelayThreshold = 500 * time.Millisecond
+
+/*
+Most failed logins the check makes: one more than the 3 after which
+connection_control starts delaying by default, and fewer than the 5 or
+more failures account lockouts and intrusion prevention tools are
+usually set to, so the scan doesn't lock anyone out
+*/
+const maxThrottleAttempts = 4
+
+func init() {
+	registerCheck(Check{
+		ID:          "MYSQL-LOGIN-THROTTLING",
+		Description: "Makes repeated failed logins to see whether the server throttles or blocks them",
+		Tier:        TierIntrusive,
+		Run:         checkLoginThrottling,
+	})
+}
+
+/*
+throttleResult describes how the server reacted to repeated failed logins
+*/
+type throttleResult struct {
+	attempts     int
+	blockedAfter int
+	blockedBy    *ServerError
+	firstLatency time.Duration
+	lastLatency  time.Duration
+}
+
+/*
+probeThrottling logs in with an account that cannot exist until the
+server blocks us or the attempts run out, spaced by the login delay
+*/
+func probeThrottling(ctx *CheckContext, attempts int) (throttleResult, error) {
+	var result throttleResult
+	user := randomCredential()
+
+	for i := 0; i < attempts; i++ {
+		if i > 0 {
+			clock.Sleep(ctx.Options.LoginDelay)
+		}
+		start := clock.Now()
+		_, err := ctx.Login(user, randomCredential())
+		latency := clock.Now().Sub(start)
+		result.attempts++
+
+		var serverErr *ServerError
+		if !errors.As(err, &serverErr) {
+			if err == nil {
+				return result, errors.New("Server accepted a random account")
+			}
+			return result, err
+		}
+
+		if _, locked := lockoutErrors[serverErr.Code]; locked {
+			result.blockedAfter = i
+			result.blockedBy = serverErr
+			return result, nil
+		}
+
+		if i == 0 {
+			result.firstLatency = latency
+		}
+		result.lastLatency = latency
+	}
+	return result, nil
+}
+
+func checkLoginThrottling(ctx *CheckContext) []Finding {
+	attempts := ctx.Options.ThrottleAttempts
+	if attempts <= 0 {
+		return nil
+	}
+	if attempts > maxThrottleAttempts {
+		attempts = maxThrottleAttempts
+	}
+
+	result, err := probeThrottling(ctx, attempts)
+	if err != nil {
+		return nil
+	}
+
+	switch {
+	case result.blockedBy != nil:
+		return []Finding{{
+			RuleID:   "MYSQL-LOGIN-THROTTLING",
+			Severity: SeverityInfo,
+			Title:    "Server blocks repeated failed logins",
+			Detail:   fmt.Sprintf("blocked after %d failed logins: %s", result.blockedAfter, result.blockedBy),
+		}}
+	case result.lastLatency-result.firstLatency >= throttleDelayThreshold:
+		return []Finding{{
+			RuleID:   "MYSQL-LOGIN-THROTTLING",
+			Severity: SeverityInfo,
+			Title:    "Server delays repeated failed logins",
+			Detail: fmt.Sprintf("failure latency grew from %s to %s over %d attempts",
+				result.firstLatency.Round(time.Microsecond), result.lastLatency.Round(time.Microsecond), result.attempts),
+		}}
+	}
+
+	return []Finding{{
+		RuleID:   "MYSQL-NO-LOGIN-THROTTLING",
+		Severity: SeverityMedium,
+		Title:    "Server does not throttle failed logins",
+		Detail: fmt.Sprintf("%d failed logins in a row were neither blocked nor delayed (latency %s to %s)",
+			result.attempts, result.firstLatency.Round(time.Microsecond), result.lastLatency.Round(time.Microsecond)),
+	}}
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"net"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestThrottlingIsPacedAndCapped(t *testing.T) {
+	fake, network := useFakes(t)
+	target := Target{Host: "throttling", Port: 3306, Protocol: ProtocolMySQL}
+	server := &mockAuthServer{greeting: greetingBytes(t), replies: [][]byte{errPayload()}}
+	network.Serve(target.Address(), server.serve)
+
+	ctx := &CheckContext{Target: target, Options: ScanOptions{ThrottleAttempts: 10, LoginDelay: 2 * time.Second}, running: TierIntrusive}
+	findings := checkLoginThrottling(ctx)
+	if len(findings) != 1 || findings[0].RuleID != "MYSQL-NO-LOGIN-THROTTLING" {
+		t.Fatalf("got findings %v", findings)
+	}
+	if dials := network.Dials(target.Address()); dials != maxThrottleAttempts {
+		t.Errorf("logged in %d times, want at most %d", dials, maxThrottleAttempts)
+	}
+	slept := fake.Slept()
+	if len(slept) != maxThrottleAttempts-1 {
+		t.Fatalf("slept %v between %d logins", slept, maxThrottleAttempts)
+	}
+	for _, d := range slept {
+		if d != 2*time.Second {
+			t.Errorf("paused %s between logins, want the login delay", d)
+		}
+	}
+}
+
+func TestThrottlingSeesConnectionControlDelay(t *testing.T) {
+	fake, network := useFakes(t)
+	target := Target{Host: "connection-control", Port: 3306, Protocol: ProtocolMySQL}
+	server := &mockAuthServer{greeting: greetingBytes(t), replies: [][]byte{errPayload()}}
+	// connection_control delays the failures past the third by 1s, which
+	// round trips may eat into
+	var logins atomic.Int32
+	network.Serve(target.Address(), func(conn net.Conn) {
+		if logins.Add(1) > 3 {
+			fake.Advance(900 * time.Millisecond)
+		}
+		server.serve(conn)
+	})
+
+	ctx := &CheckContext{Target: target, Options: ScanOptions{ThrottleAttempts: maxThrottleAttempts}, running: TierIntrusive}
+	findings := checkLoginThrottling(ctx)
+	if len(findings) != 1 || findings[0].Title != "Server delays repeated failed logins" {
+		t.Errorf("got findings %v", findings)
+	}
+}
@@ -0,0 +1,130 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+func init() {
+	registerCheck(Check{
+		ID:          "MYSQL-REPLICATION-EXPOSURE",
+		Description: "Reports binary logging, GTIDs and replication accounts reachable from any host, which let a rogue replica copy every change",
+		Tier:        TierActive,
+		Run:         checkReplicationExposure,
+	})
+}
+
+/*
+binlogRetention returns how long binary logs are kept, from
+binlog_expire_logs_seconds since 8.0 or expire_logs_days before, and
+whether the server purges them at all
+*/
+func binlogRetention(variable func(name string) (string, bool)) (time.Duration, bool) {
+	if value, ok := variable("binlog_expire_logs_seconds"); ok {
+		if seconds, err := strconv.Atoi(value); err == nil && seconds > 0 {
+			return time.Duration(seconds) * time.Second, true
+		}
+	}
+	if value, ok := variable("expire_logs_days"); ok {
+		if days, err := strconv.ParseFloat(value, 64); err == nil && days > 0 {
+			return time.Duration(days * float64(24*time.Hour)), true
+		}
+	}
+	return 0, false
+}
+
+/*
+gtidMode returns how the server uses GTIDs: gtid_mode on MySQL 5.6 and
+later, and on MariaDB, whose GTIDs can't be turned off, the position
+it has logged up to
+*/
+func gtidMode(variable func(name string) (string, bool)) (string, bool) {
+	if mode, ok := variable("gtid_mode"); ok {
+		return "gtid_mode " + mode, strings.HasPrefix(strings.ToUpper(mode), "ON")
+	}
+	if position, ok := variable("gtid_binlog_pos"); ok && position != "" {
+		return "gtid_binlog_pos " + position, true
+	}
+	return "", false
+}
+
+/*
+checkReplicationExposure tells what a replica could pull from the
+server. With the binary log on, an account with REPLICATION SLAVE can
+stream every change made to every database, past changes included as
+long as the logs are kept; with GTIDs it can auto-position from the
+oldest one. Replication accounts that accept any host let whoever has
+their password do it from anywhere.
+*/
+func checkReplicationExposure(ctx *CheckContext) []Finding {
+	if _, _, err := ctx.Variable("version"); err != nil {
+		return nil
+	}
+	variable := func(name string) (string, bool) {
+		value, ok, _ := ctx.Variable(name)
+		return value, ok
+	}
+
+	var findings []Finding
+	logBin, _ := variable("log_bin")
+	binlog := strings.EqualFold(logBin, "ON") || logBin == "1"
+	if binlog {
+		detail := "log_bin is ON"
+		if format, ok := variable("binlog_format"); ok {
+			detail += ", binlog_format " + format
+		}
+		if retention, ok := binlogRetention(variable); ok {
+			detail += fmt.Sprintf(", logs kept for %s", retention)
+		} else {
+			detail += ", logs never purged"
+		}
+		findings = append(findings, Finding{
+			RuleID:   "MYSQL-BINLOG-ENABLED",
+			Severity: SeverityInfo,
+			Title:    "Binary logging is enabled, replication accounts can stream every change",
+			Detail:   detail,
+		})
+
+		if mode, ok := gtidMode(variable); ok {
+			findings = append(findings, Finding{
+				RuleID:   "MYSQL-GTID-ENABLED",
+				Severity: SeverityInfo,
+				Title:    "GTIDs are enabled, a replica can auto-position from the oldest retained transaction",
+				Detail:   mode,
+			})
+		}
+	}
+
+	// Without SELECT on mysql.user the accounts aren't known
+	session, err := ctx.Queries()
+	if err != nil {
+		return findings
+	}
+	accounts, err := queryAccounts(session)
+	if err != nil {
+		return findings
+	}
+	logging := "off"
+	if binlog {
+		logging = "on"
+	}
+	for _, account := range accounts {
+		if account.Locked || !account.Replication || !account.WildcardHost() {
+			continue
+		}
+		// Without the binary log there is nothing to stream until it is turned on
+		severity := SeverityMedium
+		if binlog && account.Host == "%" {
+			severity = SeverityHigh
+		}
+		findings = append(findings, Finding{
+			RuleID:   "MYSQL-REPLICATION-WILDCARD-HOST",
+			Severity: severity,
+			Title:    "Replication account accepts connections from any matching host",
+			Detail:   fmt.Sprintf("%s has REPLICATION SLAVE and uses a wildcard host, binary logging is %s", account, logging),
+		})
+	}
+	return findings
+}
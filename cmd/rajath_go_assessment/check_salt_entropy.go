@@ -0,0 +1,101 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"math"
+)
+
+/*
+Salts produced by a healthy server are random printable bytes, which
+comfortably exceed this many bits of entropy per byte once a few
+samples are pooled together
+*/
+const minSaltEntropy = 4.0
+
+/*
+minEntropyOf returns the entropy n pooled salt bytes must reach. n bytes
+have at most log2(n) bits per byte, all of them distinct, and random
+ones repeat a few: fewer bytes, the 16 of two pre-4.1 salts say, are
+held to a bit under their most rather than to minSaltEntropy.
+*/
+func minEntropyOf(n int) float64 {
+	return math.Min(minSaltEntropy, math.Log2(float64(n))-1)
+}
+
+func init() {
+	registerCheck(Check{
+		ID:          "MYSQL-SALT-ENTROPY",
+		Description: "Flags servers returning repeated or low entropy auth-plugin-data",
+		Tier:        TierActive,
+		Run:         checkSaltEntropy,
+	})
+}
+
+/*
+salt returns the auth-plugin-data without its trailing NUL
+*/
+func salt(packet *InitialHandshakePacket) []byte {
+	return bytes.TrimRight(packet.AuthPluginData, "\x00")
+}
+
+/*
+shannonEntropy returns the entropy of data in bits per byte
+*/
+func shannonEntropy(data []byte) float64 {
+	if len(data) == 0 {
+		return 0
+	}
+
+	var counts [256]int
+	for _, b := range data {
+		counts[b]++
+	}
+
+	entropy := 0.0
+	for _, count := range counts {
+		if count == 0 {
+			continue
+		}
+		p := float64(count) / float64(len(data))
+		entropy -= p * math.Log2(p)
+	}
+	return entropy
+}
+
+func checkSaltEntropy(ctx *CheckContext) []Finding {
+	samples := ctx.Samples()
+	if len(samples) < 2 {
+		return nil
+	}
+
+	var findings []Finding
+
+	seen := make(map[string]int)
+	var pooled []byte
+	for _, sample := range samples {
+		s := salt(sample.Packet)
+		seen[string(s)]++
+		pooled = append(pooled, s...)
+	}
+
+	if len(seen) < len(samples) {
+		findings = append(findings, Finding{
+			RuleID:   "MYSQL-SALT-REPEAT",
+			Severity: SeverityHigh,
+			Title:    "Server reuses auth-plugin-data across connections",
+			Detail:   fmt.Sprintf("%d distinct salts in %d connections", len(seen), len(samples)),
+		})
+	}
+
+	if entropy := shannonEntropy(pooled); entropy < minEntropyOf(len(pooled)) {
+		findings = append(findings, Finding{
+			RuleID:   "MYSQL-SALT-LOW-ENTROPY",
+			Severity: SeverityMedium,
+			Title:    "Server auth-plugin-data has low entropy",
+			Detail:   fmt.Sprintf("%.2f bits per byte over %d bytes", entropy, len(pooled)),
+		})
+	}
+
+	return findings
+}
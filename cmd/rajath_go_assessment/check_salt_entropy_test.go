@@ -0,0 +1,39 @@
+package main
+
+import "testing"
+
+/*
+saltContext has the check see the given salts as its handshake samples
+*/
+func saltContext(salts ...string) *CheckContext {
+	ctx := &CheckContext{running: TierActive}
+	ctx.samplesOnce.Do(func() {})
+	for _, s := range salts {
+		ctx.samples = append(ctx.samples, HandshakeSample{Packet: &InitialHandshakePacket{AuthPluginData: append([]byte(s), 0)}})
+	}
+	ctx.Handshake = ctx.samples[0].Packet
+	return ctx
+}
+
+func TestSaltEntropy(t *testing.T) {
+	tests := []struct {
+		name  string
+		salts []string
+		low   bool
+	}{
+		// Two pre-4.1 salts with a byte in common, at most 4 bits per byte
+		{"short salts", []string{"k#9Qa!Lz", "Wp2&xT7k"}, false},
+		{"random salts", []string{"k#9Qa!Lz(Wp2&xT7kB3e", "m^5Rv@Hd8Yc*Nq1Ju%Go"}, false},
+		{"constant salts", []string{"aaaaaaab", "aaaaaaac"}, true},
+		{"counter salts", []string{"00000000000000000001", "00000000000000000002"}, true},
+	}
+	for _, test := range tests {
+		var low bool
+		for _, finding := range checkSaltEntropy(saltContext(test.salts...)) {
+			low = low || finding.RuleID == "MYSQL-SALT-LOW-ENTROPY"
+		}
+		if low != test.low {
+			t.Errorf("%s: low entropy reported %t, want %t", test.name, low, test.low)
+		}
+	}
+}
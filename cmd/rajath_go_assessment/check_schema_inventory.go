@@ -0,0 +1,30 @@
+package main
+
+func init() {
+	registerCheck(Check{
+		ID:          "MYSQL-SCHEMA-INVENTORY",
+		Description: "Collects database names, table counts and storage engines from information_schema",
+		Tier:        TierActive,
+		Run:         checkSchemaInventory,
+	})
+}
+
+/*
+checkSchemaInventory records the schema inventory in the result when
+asked to. It produces no findings of its own.
+*/
+func checkSchemaInventory(ctx *CheckContext) []Finding {
+	if !ctx.Options.SchemaInventory {
+		return nil
+	}
+
+	session, err := ctx.Queries()
+	if err != nil {
+		return nil
+	}
+
+	if inventory, err := querySchemaInventory(session); err == nil {
+		ctx.Result.Schemas = inventory
+	}
+	return nil
+}
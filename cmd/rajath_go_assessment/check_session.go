@@ -0,0 +1,106 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+func init() {
+	registerCheck(Check{
+		ID:          "MYSQL-CREDENTIALS-REJECTED",
+		Description: "Reports when the credentials given for credentialed checks don't work",
+		Tier:        TierActive,
+		Run:         checkSession,
+	})
+}
+
+/*
+checkSession opens the connection shared by the credentialed checks up
+front, so a login failure is reported once instead of silently skipping
+every credentialed check. An expired password is told apart from a
+wrong one, and the factors of a multi-factor account are reported.
+*/
+func checkSession(ctx *CheckContext) []Finding {
+	runner, err := ctx.Queries()
+	if err == nil {
+		// Servers with disconnect_on_expired_password off let us in and refuse every statement
+		_, _, err = runner.Query("SELECT 1")
+		if !passwordExpired(err) {
+			return accountFactors(ctx, runner)
+		}
+	}
+	if errors.Is(err, errNoCredentials) {
+		return nil
+	}
+
+	var factorErr *FactorRequiredError
+	if errors.As(err, &factorErr) {
+		return factorRequired(ctx, factorErr)
+	}
+
+	if passwordExpired(err) {
+		return []Finding{{
+			RuleID:   "MYSQL-ACCOUNT-PASSWORD-EXPIRED",
+			Severity: SeverityLow,
+			Title:    "Scan account's password has expired, credentialed checks skipped",
+			Detail:   fmt.Sprintf("%q must change its password before running statements: %s", ctx.Options.User, err),
+		}}
+	}
+
+	return []Finding{{
+		RuleID:   "MYSQL-CREDENTIALS-REJECTED",
+		Severity: SeverityInfo,
+		Title:    "Credentialed checks skipped, login failed",
+		Detail:   err.Error(),
+	}}
+}
+
+/*
+accountFactors reports the factors of a scan account that has more
+than one, as the session logged in with them
+*/
+func accountFactors(ctx *CheckContext, runner QueryRunner) []Finding {
+	session, ok := runner.(*Session)
+	if !ok || len(session.Factors) < 2 {
+		return nil
+	}
+	return []Finding{{
+		RuleID:   "MYSQL-ACCOUNT-MULTI-FACTOR",
+		Severity: SeverityInfo,
+		Title:    fmt.Sprintf("Scan account authenticates with %d factors", len(session.Factors)),
+		Detail:   fmt.Sprintf("%q authenticates with %s", ctx.Options.User, strings.Join(session.Factors, ", ")),
+	}}
+}
+
+/*
+factorRequired reports the factor the scan account couldn't pass, and
+what its plugin tells about the account, a FIDO device say
+*/
+func factorRequired(ctx *CheckContext, err *FactorRequiredError) []Finding {
+	detail := fmt.Sprintf("%q passed %s and needs factor %d (%s)", ctx.Options.User, strings.Join(err.Passed, ", "), err.Factor, err.Plugin)
+	if answerable(err.Plugin) {
+		env := password2Env
+		if err.Factor == 3 {
+			env = password3Env
+		}
+		detail += fmt.Sprintf(", give its password in $%s", env)
+	} else {
+		detail += ", which the scanner can't answer"
+	}
+	findings := []Finding{{
+		RuleID:   "MYSQL-ACCOUNT-FACTOR-REQUIRED",
+		Severity: SeverityInfo,
+		Title:    "Scan account needs another authentication factor, credentialed checks skipped",
+		Detail:   detail,
+	}}
+	if rule, ok := authPluginRules[err.Plugin]; ok {
+		findings = append(findings, Finding{
+			RuleID:   rule.ruleID,
+			Severity: rule.severity,
+			Title:    rule.title,
+			Detail:   fmt.Sprintf("%q needs %s as factor %d", ctx.Options.User, err.Plugin, err.Factor) + pluginDataDetail(err.Plugin, err.Data),
+		})
+	}
+	return findings
+}
@@ -0,0 +1,17 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestFactorRequiredNamesEnvironment(t *testing.T) {
+	ctx := &CheckContext{Options: ScanOptions{User: "auditor"}}
+	for factor, env := range map[int]string{2: password2Env, 3: password3Env} {
+		err := &FactorRequiredError{Factor: factor, Plugin: "mysql_native_password", Passed: []string{"caching_sha2_password"}}
+		findings := factorRequired(ctx, err)
+		if len(findings) == 0 || !strings.Contains(findings[0].Detail, "$"+env) {
+			t.Errorf("factor %d: got %v, want $%s asked for", factor, findings, env)
+		}
+	}
+}
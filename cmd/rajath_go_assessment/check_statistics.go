@@ -0,0 +1,30 @@
+package main
+
+func init() {
+	registerCheck(Check{
+		ID:          "MYSQL-STATISTICS",
+		Description: "Collects uptime, threads, questions and slow queries with COM_STATISTICS",
+		Tier:        TierActive,
+		Run:         checkStatistics,
+	})
+}
+
+/*
+checkStatistics records a cheap health snapshot in the result. It
+produces no findings of its own.
+*/
+func checkStatistics(ctx *CheckContext) []Finding {
+	if !ctx.Options.Statistics {
+		return nil
+	}
+
+	session, err := ctx.Session()
+	if err != nil {
+		return nil
+	}
+
+	if stats, err := session.Statistics(); err == nil {
+		ctx.Result.Statistics = stats
+	}
+	return nil
+}
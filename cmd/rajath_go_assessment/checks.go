@@ -0,0 +1,330 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+)
+
+/*
+Tier describes how much a check touches the target. Each tier includes
+the ones before it.
+*/
+type Tier int
+
+const (
+	// Only looks at what the scan already received
+	TierPassive Tier = iota + 1
+	// Makes extra unauthenticated connections
+	TierActive
+	// Attempts logins or anything else a target owner could object to
+	TierIntrusive
+)
+
+var tierNames = map[Tier]string{
+	TierPassive:   "passive",
+	TierActive:    "active",
+	TierIntrusive: "intrusive",
+}
+
+func (t Tier) String() string {
+	name, ok := tierNames[t]
+	if !ok {
+		return fmt.Sprintf("tier(%d)", int(t))
+	}
+	return name
+}
+
+/*
+errTierNotPermitted is returned when a check tries to do something its
+tier doesn't allow
+*/
+var errTierNotPermitted = errors.New("Operation not permitted for the check's tier")
+
+/*
+errNoCredentials is returned by Session when no credentials were given
+*/
+var errNoCredentials = errors.New("No credentials given for credentialed checks")
+
+/*
+Check is a single named test run against a target once its handshake
+has been decoded. Checks belonging to a pack only run when the pack is
+selected.
+*/
+type Check struct {
+	ID          string
+	Description string
+	Tier        Tier
+	Pack        string
+	Run         func(ctx *CheckContext) []Finding
+
+	// Loaded from -custom-checks, and replaced when it is reloaded
+	custom bool
+}
+
+/*
+Check packs that can be selected with -pack
+*/
+const (
+	PackCIS = "cis"
+)
+
+var packs = map[string]bool{
+	PackCIS: true,
+}
+
+/*
+parsePacks parses the comma separated list given to -pack
+*/
+func parsePacks(list string) (map[string]bool, error) {
+	selected := make(map[string]bool)
+	for _, name := range strings.Split(list, ",") {
+		name = strings.ToLower(strings.TrimSpace(name))
+		if name == "" {
+			continue
+		}
+		if !packs[name] {
+			return nil, fmt.Errorf("Unknown check pack %q", name)
+		}
+		selected[name] = true
+	}
+	return selected, nil
+}
+
+var (
+	checksMu sync.Mutex
+	checks   []Check
+)
+
+/*
+registerCheck adds a check to the set run against every target.
+Checks register themselves from init functions and must declare their
+tier, so nothing can slip into a scan uncategorised.
+*/
+func registerCheck(check Check) {
+	if _, ok := tierNames[check.Tier]; !ok {
+		panic(fmt.Sprintf("check %s has no valid tier", check.ID))
+	}
+	if check.Pack != "" && !packs[check.Pack] {
+		panic(fmt.Sprintf("check %s belongs to unknown pack %s", check.ID, check.Pack))
+	}
+
+	checksMu.Lock()
+	defer checksMu.Unlock()
+	checks = append(checks, check)
+}
+
+/*
+HandshakeSample is a handshake together with the time it was received
+*/
+type HandshakeSample struct {
+	Packet     *InitialHandshakePacket
+	ReceivedAt time.Time
+}
+
+/*
+CheckContext carries everything a check may need about the target
+*/
+type CheckContext struct {
+	Target     Target
+	Handshake  *InitialHandshakePacket
+	ReceivedAt time.Time
+	Probes     []ProbeSample
+	Options    ScanOptions
+	// Checks that collect data rather than findings record it here
+	Result *ScanResult
+
+	// Tier of the check currently running
+	running Tier
+
+	samplesOnce sync.Once
+	samples     []HandshakeSample
+
+	// Authenticated session shared by every check of the target
+	session    *Session
+	sessionErr error
+	// database/sql connection used instead with -query-driver sql
+	runner    *sqlRunner
+	runnerErr error
+
+	// Global variables, read the first time a check asks for one
+	variables    map[string]string
+	variablesErr error
+}
+
+/*
+Samples returns the handshakes collected from repeated connections to
+the target, including the original one. The connections are only made
+the first time an active check asks for them, passive checks only get
+the original handshake.
+*/
+func (c *CheckContext) Samples() []HandshakeSample {
+	original := HandshakeSample{Packet: c.Handshake, ReceivedAt: c.ReceivedAt}
+	if c.running < TierActive {
+		return []HandshakeSample{original}
+	}
+
+	c.samplesOnce.Do(func() {
+		c.samples = append(c.samples, original)
+		for i := 0; i < c.Options.HandshakeSamples; i++ {
+			clock.Sleep(c.Options.HandshakeInterval)
+			packet, _, err := fetchHandshake(c.Target)
+			if err != nil {
+				continue
+			}
+			c.samples = append(c.samples, HandshakeSample{Packet: packet, ReceivedAt: clock.Now()})
+		}
+	})
+	return c.samples
+}
+
+/*
+Login authenticates against the target. Only intrusive checks may log in.
+*/
+func (c *CheckContext) Login(user, password string) (*Session, error) {
+	if c.running < TierIntrusive {
+		return nil, errTierNotPermitted
+	}
+	return login(c.Target, user, password)
+}
+
+/*
+Session returns an authenticated session with the credentials given
+for the scan, shared by every check run against the target so they
+don't each open their own connection. A broken session is replaced on
+the next call. Passive checks can't use it.
+*/
+func (c *CheckContext) Session() (*Session, error) {
+	if c.running < TierActive {
+		return nil, errTierNotPermitted
+	}
+	if c.Options.User == "" {
+		return nil, errNoCredentials
+	}
+
+	if c.session != nil && c.session.broken {
+		c.session.Close()
+		c.session, c.sessionErr = nil, nil
+	}
+	if c.session == nil && c.sessionErr == nil {
+		defer evidence.during(c.Target, evidenceLogin)()
+		if c.Options.Pool != nil {
+			c.session, c.sessionErr = c.Options.Pool.get(c.Target, c.Options.User, c.Options.Password, c.Options.FactorPasswords...)
+		} else {
+			c.session, c.sessionErr = login(c.Target, c.Options.User, c.Options.Password, c.Options.FactorPasswords...)
+		}
+	}
+	return c.session, c.sessionErr
+}
+
+/*
+Queries returns what credentialed checks run their statements with:
+the shared session, or a database/sql connection with -query-driver
+sql. Passive checks can't use it.
+*/
+func (c *CheckContext) Queries() (QueryRunner, error) {
+	if c.Options.QueryDriver != QueryDriverSQL {
+		session, err := c.Session()
+		if err != nil {
+			return nil, err
+		}
+		return session, nil
+	}
+
+	if c.running < TierActive {
+		return nil, errTierNotPermitted
+	}
+	if c.Options.User == "" {
+		return nil, errNoCredentials
+	}
+	if c.runner == nil && c.runnerErr == nil {
+		c.runner, c.runnerErr = openSQLRunner(c.Target, c.Options.User, c.Options.Password)
+	}
+	if c.runnerErr != nil {
+		return nil, c.runnerErr
+	}
+	return c.runner, nil
+}
+
+/*
+Variable returns a global variable of the server and whether it has
+it. All of them are read with one query the first time a check asks,
+through Queries.
+*/
+func (c *CheckContext) Variable(name string) (string, bool, error) {
+	if c.variables == nil && c.variablesErr == nil {
+		// Not kept, a check of a higher tier may get a session
+		queries, err := c.Queries()
+		if err != nil {
+			return "", false, err
+		}
+		var rows [][][]byte
+		if _, rows, c.variablesErr = queries.Query("SHOW GLOBAL VARIABLES"); c.variablesErr != nil {
+			return "", false, c.variablesErr
+		}
+		c.variables = make(map[string]string)
+		for _, row := range rows {
+			if len(row) == 2 {
+				c.variables[strings.ToLower(string(row[0]))] = string(row[1])
+			}
+		}
+	}
+	if c.variablesErr != nil {
+		return "", false, c.variablesErr
+	}
+	value, ok := c.variables[strings.ToLower(name)]
+	return value, ok, nil
+}
+
+/*
+close releases the shared session, back to the pool when there is one
+*/
+func (c *CheckContext) close() {
+	if c.runner != nil {
+		c.runner.Close()
+		c.runner = nil
+	}
+	if c.session == nil {
+		return
+	}
+	if c.Options.Pool != nil {
+		c.Options.Pool.put(c.session)
+	} else {
+		c.session.Close()
+	}
+	c.session = nil
+}
+
+/*
+runChecks runs every registered check allowed by the scan's tier and
+collects their findings
+*/
+func runChecks(ctx *CheckContext) []Finding {
+	checksMu.Lock()
+	registered := append([]Check(nil), checks...)
+	checksMu.Unlock()
+
+	var findings []Finding
+	for _, check := range registered {
+		if check.Tier > ctx.Options.Tier {
+			continue
+		}
+		if check.Pack != "" && !ctx.Options.Packs[check.Pack] {
+			continue
+		}
+		ctx.running = check.Tier
+		evidence.phase(ctx.Target, check.ID)
+		for _, finding := range check.Run(ctx) {
+			finding.Check = check.ID
+			findings = append(findings, finding)
+		}
+		if ctx.Result != nil {
+			ctx.Result.ChecksRun = append(ctx.Result.ChecksRun, check.ID)
+		}
+	}
+	ctx.running = 0
+	ctx.close()
+	return findings
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"sync"
+	"time"
+)
+
+/*
+Clock is where the scanner gets the time from and sleeps on, for the
+pauses and expiries that a FakeClock lets harnesses run without waiting:
+-jitter, -login-delay, sampling intervals, sink retries, the banner cache,
+pooled sessions and saturation pauses, as well as the latencies and times
+of results. Connection deadlines keep to the real clock.
+*/
+type Clock interface {
+	Now() time.Time
+	Sleep(d time.Duration)
+}
+
+type realClock struct{}
+
+func (realClock) Now() time.Time        { return time.Now() }
+func (realClock) Sleep(d time.Duration) { time.Sleep(d) }
+
+var clock Clock = realClock{}
+
+/*
+FakeClock is a Clock that only moves when it is slept on or advanced,
+and records every sleep, so pauses can be checked without taking them
+*/
+type FakeClock struct {
+	mu    sync.Mutex
+	now   time.Time
+	slept []time.Duration
+}
+
+func NewFakeClock(start time.Time) *FakeClock {
+	return &FakeClock{now: start}
+}
+
+func (c *FakeClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+/*
+Sleep moves the clock on by d at once
+*/
+func (c *FakeClock) Sleep(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.slept = append(c.slept, d)
+	if d > 0 {
+		c.now = c.now.Add(d)
+	}
+}
+
+/*
+Advance moves the clock on without counting it as a sleep
+*/
+func (c *FakeClock) Advance(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.now = c.now.Add(d)
+}
+
+/*
+Slept returns the sleeps so far, in order
+*/
+func (c *FakeClock) Slept() []time.Duration {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return append([]time.Duration(nil), c.slept...)
+}
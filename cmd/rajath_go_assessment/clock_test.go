@@ -0,0 +1,221 @@
+package main
+
+import (
+	"encoding/hex"
+	"errors"
+	"io"
+	"net"
+	"sync"
+	"testing"
+	"time"
+)
+
+var fakeStart = time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)
+
+/*
+useFakes swaps in a FakeClock and a PipeNetwork for the test, putting
+the real ones back when it ends
+*/
+func useFakes(t *testing.T) (*FakeClock, *PipeNetwork) {
+	t.Helper()
+	fake, network := NewFakeClock(fakeStart), NewPipeNetwork()
+	realClock, realDialer := clock, targetDialer
+	clock, targetDialer = fake, network
+	t.Cleanup(func() {
+		clock, targetDialer = realClock, realDialer
+	})
+	return fake, network
+}
+
+func greetingBytes(t *testing.T) []byte {
+	t.Helper()
+	data, err := hex.DecodeString(goldenGreeting)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+func TestJitterPausesRepeatWithSeed(t *testing.T) {
+	j := &probeJitter{min: 100 * time.Millisecond, max: 500 * time.Millisecond, seed: 7}
+	pauses := func() []time.Duration {
+		fake := NewFakeClock(fakeStart)
+		realClock := clock
+		clock = fake
+		defer func() { clock = realClock }()
+		pause := j.worker(3)
+		for i := 0; i < 5; i++ {
+			pause()
+		}
+		return fake.Slept()
+	}
+
+	first := pauses()
+	// None before the worker's first target
+	if len(first) != 4 {
+		t.Fatalf("got %d pauses, want 4", len(first))
+	}
+	for _, d := range first {
+		if d < j.min || d > j.max {
+			t.Errorf("pause %s outside %s", d, j)
+		}
+	}
+	second := pauses()
+	for i := range first {
+		if first[i] != second[i] {
+			t.Fatalf("the same seed paused %v then %v", first, second)
+		}
+	}
+}
+
+func TestJitterDisabled(t *testing.T) {
+	fake, _ := useFakes(t)
+	pause := (&probeJitter{}).worker(0)
+	pause()
+	pause()
+	if slept := fake.Slept(); len(slept) != 0 {
+		t.Errorf("slept %v without -jitter", slept)
+	}
+}
+
+func TestProbeTargetTimesWithClock(t *testing.T) {
+	fake, network := useFakes(t)
+	network.Serve("clock-times:3306", serveGreeting(greetingBytes(t)))
+
+	result := probeTarget(Target{Host: "clock-times", Port: 3306}, ScanOptions{Tier: TierPassive, Samples: 3, SampleInterval: time.Second})
+	if result.Status != StatusMySQL {
+		t.Fatalf("status %s: %v", result.Status, result.Err)
+	}
+	if network.Dials("clock-times:3306") != 3 {
+		t.Errorf("dialed %d times, want 3", network.Dials("clock-times:3306"))
+	}
+
+	slept := fake.Slept()
+	if len(slept) != 2 {
+		t.Fatalf("slept %v between 3 samples", slept)
+	}
+	var total time.Duration
+	for _, d := range slept {
+		if d < 800*time.Millisecond || d > 1200*time.Millisecond {
+			t.Errorf("sample interval %s outside 1s ±20%%", d)
+		}
+		total += d
+	}
+	if !result.StartedAt.Equal(fakeStart) {
+		t.Errorf("started at %s, want %s", result.StartedAt, fakeStart)
+	}
+	if want := fakeStart.Add(total); !result.EndedAt.Equal(want) {
+		t.Errorf("ended at %s, want %s", result.EndedAt, want)
+	}
+	if result.Probes[2].At.Sub(result.Probes[0].At) != total {
+		t.Errorf("probes %s apart, slept %s", result.Probes[2].At.Sub(result.Probes[0].At), total)
+	}
+}
+
+func TestStalledGreetingTimesOut(t *testing.T) {
+	_, network := useFakes(t)
+	// Connection deadlines keep to the real clock, so keep them short
+	timeouts := decodeTimeouts
+	decodeTimeouts.read, decodeTimeouts.total = 20*time.Millisecond, 50*time.Millisecond
+	defer func() { decodeTimeouts = timeouts }()
+
+	network.Serve("clock-silent:3306", func(conn net.Conn) {
+		io.Copy(io.Discard, conn)
+	})
+	greeting := greetingBytes(t)
+	network.Serve("clock-drip:3306", func(conn net.Conn) {
+		for _, b := range greeting {
+			if _, err := conn.Write([]byte{b}); err != nil {
+				return
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+	})
+
+	tests := []struct {
+		host string
+		kind string
+	}{
+		{"clock-silent", TarpitSilent},
+		{"clock-drip", TarpitDrip},
+	}
+	for _, test := range tests {
+		t.Run(test.host, func(t *testing.T) {
+			result := scanEndpoint(Target{Host: test.host, Port: 3306}, ScanOptions{Tier: TierPassive})
+			if result.Status != StatusTarpit {
+				t.Fatalf("status %s: %v", result.Status, result.Err)
+			}
+			if kind := tarpitKind(result.Err); kind != test.kind {
+				t.Errorf("tarpit kind %q, want %q", kind, test.kind)
+			}
+		})
+	}
+}
+
+func TestRefusedIsClosed(t *testing.T) {
+	useFakes(t)
+	result := scanEndpoint(Target{Host: "clock-refused", Port: 3306}, ScanOptions{Tier: TierPassive})
+	if result.Status != StatusClosed {
+		t.Errorf("status %s, want %s", result.Status, StatusClosed)
+	}
+}
+
+/*
+flakySink fails the first deliveries it is given
+*/
+type flakySink struct {
+	mu        sync.Mutex
+	failures  int
+	delivered int
+}
+
+func (s *flakySink) deliver(record []byte) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.failures > 0 {
+		s.failures--
+		return errors.New("unavailable")
+	}
+	s.delivered++
+	return nil
+}
+
+func TestSinkRetries(t *testing.T) {
+	tests := []struct {
+		name      string
+		failures  int
+		slept     []time.Duration
+		delivered int
+		failed    int
+	}{
+		{"first attempt", 0, nil, 1, 0},
+		{"second attempt", 1, []time.Duration{sinkRetryPause}, 1, 0},
+		{"last attempt", 2, []time.Duration{sinkRetryPause, 2 * sinkRetryPause}, 1, 0},
+		{"given up", 3, []time.Duration{sinkRetryPause, 2 * sinkRetryPause}, 0, 1},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			fake, _ := useFakes(t)
+			sink := &flakySink{failures: test.failures}
+			q, err := newSinkQueue(sink, 10, OverflowBlock, "")
+			if err != nil {
+				t.Fatal(err)
+			}
+			q.send(&ScanResult{Target: Target{Host: "clock-sink", Port: 3306}, Status: StatusClosed, Err: errors.New("refused")})
+			q.close()
+
+			slept := fake.Slept()
+			if len(slept) != len(test.slept) {
+				t.Fatalf("slept %v, want %v", slept, test.slept)
+			}
+			for i := range slept {
+				if slept[i] != test.slept[i] {
+					t.Errorf("slept %v, want %v", slept, test.slept)
+				}
+			}
+			if sink.delivered != test.delivered || q.state().Failed != test.failed {
+				t.Errorf("delivered %d and failed %d, want %d and %d", sink.delivered, q.state().Failed, test.delivered, test.failed)
+			}
+		})
+	}
+}
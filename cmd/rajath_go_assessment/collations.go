@@ -0,0 +1,54 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+/*
+Collation ids commonly sent as the character set in the handshake.
+The handshake only has one byte for it, so servers whose default
+collation id is above 255 send a related id instead.
+*/
+var collations = map[uint8]string{
+	1:   "big5_chinese_ci",
+	8:   "latin1_swedish_ci",
+	11:  "ascii_general_ci",
+	13:  "sjis_japanese_ci",
+	28:  "gbk_chinese_ci",
+	33:  "utf8mb3_general_ci",
+	45:  "utf8mb4_general_ci",
+	46:  "utf8mb4_bin",
+	47:  "latin1_bin",
+	48:  "latin1_general_ci",
+	63:  "binary",
+	83:  "utf8mb3_bin",
+	192: "utf8mb3_unicode_ci",
+	224: "utf8mb4_unicode_ci",
+	255: "utf8mb4_0900_ai_ci",
+}
+
+/*
+collationName returns the name of a collation id, or a placeholder
+*/
+func collationName(id uint8) string {
+	name, ok := collations[id]
+	if !ok {
+		return fmt.Sprintf("collation(%d)", id)
+	}
+	return name
+}
+
+/*
+sameCollation compares collation names, treating the utf8 alias the
+same as utf8mb3 since older servers still report it
+*/
+func sameCollation(a, b string) bool {
+	normalize := func(s string) string {
+		if strings.HasPrefix(s, "utf8_") {
+			return "utf8mb3_" + strings.TrimPrefix(s, "utf8_")
+		}
+		return s
+	}
+	return normalize(a) == normalize(b)
+}
@@ -0,0 +1,122 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+/*
+Magic bytes gzip and zstd streams start with. Compressed files are told
+by them rather than by their extension, which may not be there.
+*/
+var (
+	gzipMagic = []byte{0x1f, 0x8b}
+	zstdMagic = []byte{0x28, 0xb5, 0x2f, 0xfd}
+)
+
+/*
+decompress returns a reader of r's decompressed data when it is gzip
+or zstd compressed, or of r as is
+*/
+func decompress(r io.Reader) (io.ReadCloser, error) {
+	buffered := bufio.NewReader(r)
+	magic, _ := buffered.Peek(len(zstdMagic))
+	switch {
+	case bytes.HasPrefix(magic, gzipMagic):
+		return gzip.NewReader(buffered)
+	case bytes.HasPrefix(magic, zstdMagic):
+		decoder, err := zstd.NewReader(buffered)
+		if err != nil {
+			return nil, err
+		}
+		return decoder.IOReadCloser(), nil
+	}
+	return io.NopCloser(buffered), nil
+}
+
+/*
+compressedFile reads a file through decompress, closing both on Close
+*/
+type compressedFile struct {
+	io.ReadCloser
+	file *os.File
+}
+
+func (f *compressedFile) Close() error {
+	f.ReadCloser.Close()
+	return f.file.Close()
+}
+
+/*
+openCompressed opens a file that may be gzip or zstd compressed
+*/
+func openCompressed(path string) (io.ReadCloser, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	r, err := decompress(file)
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+	return &compressedFile{ReadCloser: r, file: file}, nil
+}
+
+/*
+readCompressed reads a whole file that may be gzip or zstd compressed
+*/
+func readCompressed(path string) ([]byte, error) {
+	r, err := openCompressed(path)
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	return io.ReadAll(r)
+}
+
+/*
+decompressBytes is decompress for data already read
+*/
+func decompressBytes(data []byte) ([]byte, error) {
+	r, err := decompress(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	return io.ReadAll(r)
+}
+
+/*
+compressFor compresses data as the extension of path says: .gz for
+gzip, .zst for zstd, and not at all otherwise
+*/
+func compressFor(path string, data []byte) ([]byte, error) {
+	var buf bytes.Buffer
+	switch {
+	case strings.HasSuffix(path, ".gz"):
+		w := gzip.NewWriter(&buf)
+		if _, err := w.Write(data); err != nil {
+			return nil, err
+		}
+		if err := w.Close(); err != nil {
+			return nil, err
+		}
+	case strings.HasSuffix(path, ".zst"):
+		encoder, err := zstd.NewWriter(nil)
+		if err != nil {
+			return nil, err
+		}
+		defer encoder.Close()
+		return encoder.EncodeAll(data, nil), nil
+	default:
+		return data, nil
+	}
+	return buf.Bytes(), nil
+}
@@ -0,0 +1,234 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"io"
+	"log"
+	"net"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+/*
+Coordinator defaults. A shard not reported within the lease is handed
+to another agent of the same region.
+*/
+const (
+	defaultShardSize  = 16
+	defaultShardLease = 10 * time.Minute
+)
+
+type agentInfo struct {
+	Name   string
+	Region string
+}
+
+type shardLease struct {
+	agent string
+	until time.Time
+}
+
+/*
+regionQueue tracks which shards a region still has to scan
+*/
+type regionQueue struct {
+	pending  []int
+	leased   map[int]shardLease
+	received map[int]map[string]bool
+	done     map[int]bool
+}
+
+/*
+coordinator splits the targets into shards and hands every shard to
+one agent of each region, so each target is scanned once from every
+vantage point. Results are passed to report as they arrive.
+*/
+type coordinator struct {
+	mu      sync.Mutex
+	shards  [][]Target
+	lease   time.Duration
+	agents  map[string]agentInfo
+	regions map[string]*regionQueue
+	report  func(agent agentInfo, record *ResultRecord)
+}
+
+func newCoordinator(targets []Target, shardSize int, lease time.Duration, report func(agentInfo, *ResultRecord)) *coordinator {
+	if shardSize <= 0 {
+		shardSize = defaultShardSize
+	}
+
+	c := &coordinator{
+		lease:   lease,
+		agents:  make(map[string]agentInfo),
+		regions: make(map[string]*regionQueue),
+		report:  report,
+	}
+	for start := 0; start < len(targets); start += shardSize {
+		end := start + shardSize
+		if end > len(targets) {
+			end = len(targets)
+		}
+		c.shards = append(c.shards, targets[start:end])
+	}
+	return c
+}
+
+func newAgentID() string {
+	buf := make([]byte, 8)
+	rand.Read(buf)
+	return hex.EncodeToString(buf)
+}
+
+func (c *coordinator) Register(ctx context.Context, req *AgentRegistration) (*AgentID, error) {
+	if req.Region == "" {
+		return nil, status.Error(codes.InvalidArgument, "Agents need a region")
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	id := newAgentID()
+	c.agents[id] = agentInfo{Name: req.Name, Region: req.Region}
+	if _, ok := c.regions[req.Region]; !ok {
+		queue := &regionQueue{
+			leased:   make(map[int]shardLease),
+			received: make(map[int]map[string]bool),
+			done:     make(map[int]bool),
+		}
+		for i := range c.shards {
+			queue.pending = append(queue.pending, i)
+		}
+		c.regions[req.Region] = queue
+	}
+	log.Printf("Agent %s (%s) registered from %s\n", req.Name, id, req.Region)
+	return &AgentID{ID: id}, nil
+}
+
+func (c *coordinator) NextShard(ctx context.Context, req *ShardRequest) (*Shard, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	agent, ok := c.agents[req.AgentID]
+	if !ok {
+		return nil, status.Error(codes.NotFound, "Unknown agent, register first")
+	}
+	queue := c.regions[agent.Region]
+
+	// Give expired leases to whoever asks next
+	now := clock.Now()
+	for id, lease := range queue.leased {
+		if now.After(lease.until) {
+			delete(queue.leased, id)
+			queue.pending = append(queue.pending, id)
+		}
+	}
+
+	// Shards requeued after their lease expired may have been reported since
+	for len(queue.pending) > 0 && queue.done[queue.pending[0]] {
+		queue.pending = queue.pending[1:]
+	}
+	if len(queue.pending) == 0 {
+		if len(queue.leased) > 0 {
+			return &Shard{Wait: true}, nil
+		}
+		return &Shard{Done: true}, nil
+	}
+
+	id := queue.pending[0]
+	queue.pending = queue.pending[1:]
+	queue.leased[id] = shardLease{agent: req.AgentID, until: now.Add(c.lease)}
+	return &Shard{ID: id, Targets: c.shards[id]}, nil
+}
+
+/*
+Report receives the results of a shard. The shard is done once every
+one of its targets has been reported, by whichever agent.
+*/
+func (c *coordinator) Report(stream grpc.ServerStream) error {
+	received := 0
+	for {
+		record := new(ResultRecord)
+		err := stream.RecvMsg(record)
+		if errors.Is(err, io.EOF) {
+			return stream.SendMsg(&ReportAck{Received: received})
+		}
+		if err != nil {
+			return err
+		}
+
+		agent, err := c.recordResult(record)
+		if err != nil {
+			return err
+		}
+		received++
+		c.report(agent, record)
+	}
+}
+
+func (c *coordinator) recordResult(record *ResultRecord) (agentInfo, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	agent, ok := c.agents[record.AgentID]
+	if !ok {
+		return agent, status.Error(codes.NotFound, "Unknown agent, register first")
+	}
+	if record.ShardID < 0 || record.ShardID >= len(c.shards) {
+		return agent, status.Error(codes.InvalidArgument, "Unknown shard")
+	}
+
+	queue := c.regions[agent.Region]
+	if queue.received[record.ShardID] == nil {
+		queue.received[record.ShardID] = make(map[string]bool)
+	}
+	// Told apart by the ID kept through redaction, which can mask the
+	// hosts of a shard to the same label
+	id := record.TargetID
+	if id == "" {
+		id = record.Target.ID()
+	}
+	queue.received[record.ShardID][id] = true
+	if len(queue.received[record.ShardID]) >= len(c.shards[record.ShardID]) {
+		queue.done[record.ShardID] = true
+		delete(queue.leased, record.ShardID)
+	}
+	return agent, nil
+}
+
+/*
+serveCoordinator serves the coordinator on addr until interrupted
+*/
+func serveCoordinator(addr, token string, c *coordinator) error {
+	transport, err := grpcSecurity.serverOption()
+	if err != nil {
+		return err
+	}
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+
+	server := grpc.NewServer(append(tokenInterceptors(token), transport)...)
+	server.RegisterService(&coordinatorServiceDesc, c)
+
+	stop := make(chan os.Signal, 1)
+	signal.Notify(stop, os.Interrupt, syscall.SIGTERM)
+	defer signal.Stop(stop)
+	go func() {
+		<-stop
+		server.GracefulStop()
+	}()
+
+	log.Printf("Coordinator listening on %s with %d shards\n", listener.Addr(), len(c.shards))
+	return server.Serve(listener)
+}
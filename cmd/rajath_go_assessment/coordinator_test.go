@@ -0,0 +1,36 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestRedactedShardCompletes(t *testing.T) {
+	r, err := newRedactor(RedactIPs, ScanOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	previous := redactor
+	redactor = r
+	t.Cleanup(func() { redactor = previous })
+
+	targets := []Target{
+		{Host: "10.0.0.5", Port: 3306, Protocol: ProtocolMySQL},
+		{Host: "10.0.0.6", Port: 3306, Protocol: ProtocolMySQL},
+	}
+	c := newCoordinator(targets, 2, time.Minute, func(agentInfo, *ResultRecord) {})
+	agent, err := c.Register(context.Background(), &AgentRegistration{Name: "a1", Region: "eu"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, target := range targets {
+		record := newResultRecord(agent.ID, 0, &ScanResult{Target: target, Status: StatusMySQL})
+		if _, err := c.recordResult(record); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if !c.regions["eu"].done[0] {
+		t.Error("a shard of two hosts in one /24 wasn't done once both were reported")
+	}
+}
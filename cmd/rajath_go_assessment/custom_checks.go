@@ -0,0 +1,204 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+/*
+CustomCheck is a check declared in the -custom-checks file: a finding
+is reported when its when expression is true for the target
+*/
+type CustomCheck struct {
+	ID       string `yaml:"id"`
+	Title    string `yaml:"title"`
+	Severity string `yaml:"severity"`
+	When     string `yaml:"when"`
+	// Detail of the finding, the expression itself by default
+	Detail string `yaml:"detail"`
+}
+
+/*
+Names custom check and -filter expressions can use, taken from the
+handshake. MariaDB's version is given without its 5.5.5- prefix, so it
+compares as 10.x.
+*/
+var handshakeNames = map[string]func(target Target, h *InitialHandshakePacket) interface{}{
+	"version": func(target Target, h *InitialHandshakePacket) interface{} {
+		return serverVersion(string(h.ServerVersion))
+	},
+	"protocol_version": func(target Target, h *InitialHandshakePacket) interface{} { return float64(h.ProtocolVersion) },
+	"connection_id":    func(target Target, h *InitialHandshakePacket) interface{} { return float64(h.ConnectionId) },
+	"auth_plugin":      func(target Target, h *InitialHandshakePacket) interface{} { return string(h.AuthPluginName) },
+	"character_set":    func(target Target, h *InitialHandshakePacket) interface{} { return float64(h.CharacterSet) },
+	"collation":        func(target Target, h *InitialHandshakePacket) interface{} { return collationName(h.CharacterSet) },
+	"status_flags":     func(target Target, h *InitialHandshakePacket) interface{} { return float64(h.StatusFlags) },
+	"tls":              func(target Target, h *InitialHandshakePacket) interface{} { return h.CapabilitiesFlags.Has(clientSSL) },
+	"host":             func(target Target, h *InitialHandshakePacket) interface{} { return target.Host },
+	"port":             func(target Target, h *InitialHandshakePacket) interface{} { return float64(target.Port) },
+	"role":             func(target Target, h *InitialHandshakePacket) interface{} { return target.Role },
+}
+
+/*
+capabilityByName finds a capability flag written as CLIENT_SSL or
+clientSSL. The prefix can be left out, as MySQL does for
+MULTI_FACTOR_AUTHENTICATION.
+*/
+func capabilityByName(name string) (CapabilityFlag, bool) {
+	normalized := strings.TrimPrefix(strings.ToLower(strings.ReplaceAll(name, "_", "")), "client")
+	for flag, flagName := range flags {
+		if strings.TrimPrefix(strings.ToLower(flagName), "client") == normalized {
+			return flag, true
+		}
+	}
+	return 0, false
+}
+
+/*
+customCheckEnv evaluates custom check expressions against a target
+*/
+type customCheckEnv struct {
+	ctx *CheckContext
+}
+
+func (e customCheckEnv) lookup(name string) (interface{}, error) {
+	return handshakeNames[name](e.ctx.Target, e.ctx.Handshake), nil
+}
+
+func (e customCheckEnv) call(name string, args []interface{}) (interface{}, error) {
+	if len(args) != 1 {
+		return nil, fmt.Errorf("%s takes one argument", name)
+	}
+	arg := fmt.Sprint(args[0])
+	switch name {
+	case "capability":
+		flag, _ := capabilityByName(arg)
+		return e.ctx.Handshake.CapabilitiesFlags.Has(flag), nil
+	case "variable":
+		value, ok, err := e.ctx.Variable(arg)
+		if err != nil {
+			return nil, err
+		}
+		if !ok {
+			return nil, fmt.Errorf("Server has no variable %s", arg)
+		}
+		return value, nil
+	}
+	return nil, fmt.Errorf("Unknown function %s", name)
+}
+
+/*
+compile turns the custom check into a check. Checks reading variables
+are active, they need a session, the others only look at the handshake.
+*/
+func (c CustomCheck) compile() (Check, error) {
+	if c.ID == "" || c.Title == "" || c.When == "" {
+		return Check{}, fmt.Errorf("Custom check %q needs an id, a title and a when expression", c.ID)
+	}
+	severity, err := parseSeverity(c.Severity)
+	if err != nil {
+		return Check{}, fmt.Errorf("Custom check %s: %s", c.ID, err.Error())
+	}
+	when, names, calls, err := compileExpr(c.When)
+	if err != nil {
+		return Check{}, fmt.Errorf("Custom check %s: %s", c.ID, err.Error())
+	}
+	for name := range names {
+		if handshakeNames[name] == nil {
+			return Check{}, fmt.Errorf("Custom check %s: unknown name %s", c.ID, name)
+		}
+	}
+	tier := TierPassive
+	for function, literals := range calls {
+		switch function {
+		case "capability":
+			for _, name := range literals {
+				if _, ok := capabilityByName(name); !ok {
+					return Check{}, fmt.Errorf("Custom check %s: unknown capability %s", c.ID, name)
+				}
+			}
+		case "variable":
+			tier = TierActive
+		default:
+			return Check{}, fmt.Errorf("Custom check %s: unknown function %s", c.ID, function)
+		}
+	}
+
+	detail := c.Detail
+	if detail == "" {
+		detail = c.When
+	}
+	return Check{
+		ID:          c.ID,
+		Description: c.Title,
+		Tier:        tier,
+		Run: func(ctx *CheckContext) []Finding {
+			// Without the variables, say for lack of credentials, there is nothing to report
+			matched, err := evalBool(when, customCheckEnv{ctx: ctx})
+			if err != nil || !matched {
+				return nil
+			}
+			return []Finding{{RuleID: c.ID, Severity: severity, Title: c.Title, Detail: detail}}
+		},
+	}, nil
+}
+
+/*
+loadCustomChecks reads a YAML file of custom checks and registers them,
+after the built-in ones, in place of those of an earlier load, such as
+
+	checks:
+	  - id: LOCAL-INFILE
+	    title: local_infile is enabled
+	    severity: medium
+	    when: variable("local_infile") == "ON"
+*/
+func loadCustomChecks(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	var file struct {
+		Checks []CustomCheck `yaml:"checks"`
+	}
+	if err := yaml.Unmarshal(data, &file); err != nil {
+		return fmt.Errorf("%s: %s", path, err.Error())
+	}
+
+	checksMu.Lock()
+	ids := make(map[string]bool)
+	for _, check := range checks {
+		if !check.custom {
+			ids[check.ID] = true
+		}
+	}
+	checksMu.Unlock()
+
+	var compiled []Check
+	for _, custom := range file.Checks {
+		check, err := custom.compile()
+		if err != nil {
+			return fmt.Errorf("%s: %s", path, err.Error())
+		}
+		if ids[check.ID] {
+			return fmt.Errorf("%s: check %s already exists", path, check.ID)
+		}
+		ids[check.ID] = true
+		check.custom = true
+		compiled = append(compiled, check)
+	}
+
+	checksMu.Lock()
+	defer checksMu.Unlock()
+	var builtIn []Check
+	for _, check := range checks {
+		if !check.custom {
+			builtIn = append(builtIn, check)
+		}
+	}
+	checks = append(builtIn, compiled...)
+	return nil
+}
@@ -0,0 +1,47 @@
+package main
+
+import "testing"
+
+func TestCustomCheckVersions(t *testing.T) {
+	check, err := CustomCheck{ID: "OLD-SERVER", Title: "Older than 5.7", Severity: "low", When: `version < "5.7"`}.compile()
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		version string
+		matched bool
+	}{
+		{"5.6.51-log", true},
+		{"5.7.44", false},
+		{"8.0.32", false},
+		// MariaDB's prefix for old clients isn't its version
+		{"5.5.5-10.6.12-MariaDB", false},
+		{"5.5.5-5.5.68-MariaDB", true},
+	}
+	for _, test := range tests {
+		ctx := &CheckContext{Target: Target{Host: "db1", Port: 3306}, Handshake: &InitialHandshakePacket{ServerVersion: []byte(test.version)}}
+		if findings := check.Run(ctx); (len(findings) == 1) != test.matched {
+			t.Errorf("%s: got findings %v, want a match %v", test.version, findings, test.matched)
+		}
+	}
+}
+
+func TestCompareVersions(t *testing.T) {
+	tests := []struct {
+		a, b string
+		want int
+	}{
+		{"8.0.32", "8.0.4", 1},
+		{"5.7", "5.7.0", 0},
+		{"5.6.51-log", "5.7", -1},
+		{"5.5.5-10.6.12-MariaDB", "10.6.12", 0},
+		{"5.5.5-10.6.12-MariaDB", "5.7", 1},
+		// Only MariaDB's banner has the prefix
+		{"5.5.5", "5.5.4", 1},
+	}
+	for _, test := range tests {
+		if got := compareVersions(test.a, test.b); got != test.want {
+			t.Errorf("compareVersions(%q, %q) = %d, want %d", test.a, test.b, got, test.want)
+		}
+	}
+}
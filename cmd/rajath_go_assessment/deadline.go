@@ -0,0 +1,83 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"time"
+)
+
+/*
+Default limits on how long reading the greeting may take. Each read
+must return within the read timeout, and the whole handshake within the
+handshake timeout, so a server trickling a byte at a time can't hold a
+worker forever.
+*/
+const (
+	defaultReadTimeout      = 5 * time.Second
+	defaultHandshakeTimeout = 10 * time.Second
+)
+
+/*
+Limits used by Decode, set by -read-timeout and -handshake-timeout
+*/
+var decodeTimeouts = struct {
+	read  time.Duration
+	total time.Duration
+}{defaultReadTimeout, defaultHandshakeTimeout}
+
+/*
+errSlowRead is returned when the server is too slow to send its data.
+It is a net.Error timeout, like the read deadline errors it replaces.
+*/
+type errSlowRead struct {
+	msg string
+	// Bytes received before giving up
+	received int
+	// Set when the whole handshake ran out of time rather than a single read
+	overall bool
+}
+
+func (e errSlowRead) Error() string   { return e.msg }
+func (e errSlowRead) Timeout() bool   { return true }
+func (e errSlowRead) Temporary() bool { return false }
+
+/*
+deadlineReader sets a fresh read deadline before every read, never
+later than the overall deadline
+*/
+type deadlineReader struct {
+	conn     net.Conn
+	read     time.Duration
+	total    time.Duration
+	until    time.Time
+	received int
+}
+
+func newDeadlineReader(conn net.Conn, read, total time.Duration) *deadlineReader {
+	return &deadlineReader{conn: conn, read: read, total: total, until: time.Now().Add(total)}
+}
+
+func (d *deadlineReader) Read(p []byte) (int, error) {
+	deadline := time.Now().Add(d.read)
+	if deadline.After(d.until) {
+		deadline = d.until
+	}
+	d.conn.SetReadDeadline(deadline)
+
+	n, err := d.conn.Read(p)
+	d.received += n
+	if netErr, ok := err.(net.Error); ok && netErr.Timeout() {
+		if !time.Now().Before(d.until) {
+			return n, errSlowRead{fmt.Sprintf("Handshake not received within %s", d.total), d.received, true}
+		}
+		return n, errSlowRead{fmt.Sprintf("No data received for %s", d.read), d.received, false}
+	}
+	return n, err
+}
+
+/*
+done clears the deadline so later exchanges on the connection aren't cut short
+*/
+func (d *deadlineReader) done() {
+	d.conn.SetReadDeadline(time.Time{})
+}
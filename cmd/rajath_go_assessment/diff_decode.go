@@ -0,0 +1,214 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/go-sql-driver/mysql"
+)
+
+/*
+The differential decode feeds a greeting to go-sql-driver/mysql over
+an in-memory connection and reads back how the driver answers it: the
+auth plugin it picked and the scramble it computed over the salt it
+decoded. Both are compared with what our decoder makes of the same bytes.
+*/
+const (
+	diffDialNetwork = "rajath-diff"
+	diffUser        = "diff"
+	diffPassword    = "diff"
+	diffTimeout     = 2 * time.Second
+)
+
+/*
+Plugins the driver answers a greeting with itself, it falls back to
+mysql_native_password for any other
+*/
+var driverPlugins = map[string]bool{
+	nativePasswordPlugin:      true,
+	cachingSha2PasswordPlugin: true,
+	"sha256_password":         true,
+	"mysql_clear_password":    true,
+	"mysql_old_password":      true,
+}
+
+var (
+	diffConns sync.Map
+	diffCount atomic.Int64
+)
+
+func init() {
+	mysql.RegisterDialContext(diffDialNetwork, func(ctx context.Context, addr string) (net.Conn, error) {
+		conn, ok := diffConns.LoadAndDelete(addr)
+		if !ok {
+			return nil, fmt.Errorf("Unknown differential decode %s", addr)
+		}
+		return conn.(net.Conn), nil
+	})
+}
+
+/*
+driverAnswer is what the driver sent back for a greeting, or the error
+it gave up with before answering
+*/
+type driverAnswer struct {
+	plugin string
+	auth   []byte
+	err    error
+}
+
+/*
+askDriver serves the greeting, header included, to the driver and
+returns its handshake response
+*/
+func askDriver(greeting []byte) (answer driverAnswer) {
+	server, client := net.Pipe()
+	defer server.Close()
+	addr := strconv.FormatInt(diffCount.Add(1), 10)
+	diffConns.Store(addr, client)
+
+	responses := make(chan []byte, 1)
+	go func() {
+		server.SetDeadline(time.Now().Add(diffTimeout))
+		if _, err := server.Write(greeting); err != nil {
+			responses <- nil
+			return
+		}
+		seq, payload, err := readPacket(server)
+		if err != nil {
+			responses <- nil
+			return
+		}
+		responses <- payload
+		// Turn the login down so the driver stops there
+		denied := append([]byte{0xff, 0x15, 0x04}, "#28000Differential decode"...)
+		writePacket(server, seq+1, denied)
+	}()
+
+	cfg := mysql.NewConfig()
+	cfg.User = diffUser
+	cfg.Passwd = diffPassword
+	cfg.Net = diffDialNetwork
+	cfg.Addr = addr
+	cfg.Timeout = diffTimeout
+	cfg.ReadTimeout = diffTimeout
+	cfg.AllowCleartextPasswords = true
+	cfg.AllowOldPasswords = true
+
+	var err error
+	func() {
+		// A malformed greeting can make the driver index out of range
+		defer func() {
+			if r := recover(); r != nil {
+				err = fmt.Errorf("Driver panicked: %v", r)
+			}
+		}()
+		connector, cerr := mysql.NewConnector(cfg)
+		if cerr != nil {
+			err = cerr
+			return
+		}
+		conn, cerr := connector.Connect(context.Background())
+		if err = cerr; err == nil {
+			conn.Close()
+		}
+	}()
+	diffConns.Delete(addr)
+	client.Close()
+
+	response := <-responses
+	if response == nil {
+		return driverAnswer{err: err}
+	}
+	answer.plugin, answer.auth, answer.err = parseHandshakeResponse(response)
+	return answer
+}
+
+/*
+parseHandshakeResponse reads the auth response and plugin name from a
+HandshakeResponse41 sent without a database, as the driver sends it
+*/
+func parseHandshakeResponse(payload []byte) (string, []byte, error) {
+	truncated := errors.New("Driver's handshake response is truncated")
+	if len(payload) < 32 {
+		return "", nil, truncated
+	}
+	flags := CapabilityFlag(binary.LittleEndian.Uint32(payload[0:4]))
+	rest := payload[32:]
+
+	// User name
+	end := bytes.IndexByte(rest, 0x00)
+	if end < 0 {
+		return "", nil, truncated
+	}
+	rest = rest[end+1:]
+
+	var length int
+	switch {
+	case len(rest) < 1:
+		return "", nil, truncated
+	case flags.Has(clientPluginAuthLenEncClientData) && rest[0] == 0xfc && len(rest) >= 3:
+		length = int(binary.LittleEndian.Uint16(rest[1:3]))
+		rest = rest[3:]
+	default:
+		length = int(rest[0])
+		rest = rest[1:]
+	}
+	if len(rest) < length {
+		return "", nil, truncated
+	}
+	auth := rest[:length]
+	rest = rest[length:]
+
+	if end = bytes.IndexByte(rest, 0x00); end >= 0 {
+		rest = rest[:end]
+	}
+	return string(rest), auth, nil
+}
+
+/*
+diffDecode compares the driver's reading of the greeting with ours and
+returns the divergences. decodeErr is our decoder's error, packet is
+only looked at without one.
+*/
+func diffDecode(greeting []byte, packet *InitialHandshakePacket, decodeErr error) []string {
+	answer := askDriver(greeting)
+	switch {
+	case decodeErr != nil && answer.err == nil:
+		return []string{fmt.Sprintf("The driver accepted the greeting we reject (%s), answering with %s", decodeErr.Error(), answer.plugin)}
+	case decodeErr == nil && answer.err != nil:
+		return []string{fmt.Sprintf("The driver rejected the greeting we decode: %s", answer.err.Error())}
+	case answer.err != nil:
+		// Both gave up
+		return nil
+	}
+
+	var divergences []string
+	plugin := string(packet.AuthPluginName)
+	if !driverPlugins[plugin] {
+		plugin = nativePasswordPlugin
+	}
+	if answer.plugin != plugin {
+		divergences = append(divergences, fmt.Sprintf("Auth plugin: ours implies %q, the driver answered with %q", plugin, answer.plugin))
+	}
+
+	// Only the plugins we scramble for can tell whether the salts match
+	if answer.plugin == nativePasswordPlugin || answer.plugin == cachingSha2PasswordPlugin {
+		ours, err := authResponse(answer.plugin, packet.AuthPluginData, diffPassword)
+		switch {
+		case err != nil:
+			divergences = append(divergences, fmt.Sprintf("Salt: %s, the driver scrambled with its own", err.Error()))
+		case !bytes.Equal(ours, answer.auth):
+			divergences = append(divergences, fmt.Sprintf("Salt: our %x gives another %s scramble than the driver's", packet.AuthPluginData, answer.plugin))
+		}
+	}
+	return divergences
+}
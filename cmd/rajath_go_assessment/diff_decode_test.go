@@ -0,0 +1,75 @@
+package main
+
+import (
+	"encoding/binary"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestDiffDecodeAgreesOnRecordings(t *testing.T) {
+	paths, err := filepath.Glob(filepath.Join("testdata", "recordings", "*", "*-mysql"+recordingExt))
+	if err != nil || len(paths) == 0 {
+		t.Fatalf("no recordings: %v", err)
+	}
+	for _, path := range paths {
+		t.Run(filepath.Base(filepath.Dir(path)), func(t *testing.T) {
+			data, err := os.ReadFile(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			greeting := data[:4+int(binary.LittleEndian.Uint32([]byte{data[0], data[1], data[2], 0}))]
+			packet := &InitialHandshakePacket{}
+			err = packet.decodePacket(greeting)
+			if divergences := diffDecode(greeting, packet, err); len(divergences) > 0 {
+				t.Errorf("divergences %v", divergences)
+			}
+		})
+	}
+}
+
+func TestDiffDecodeDivergences(t *testing.T) {
+	tests := []struct {
+		name  string
+		patch func(data []byte)
+		// Empty when both decoders agree
+		divergence string
+	}{
+		{"both decode", func([]byte) {}, ""},
+		{"both reject version 9", func(d []byte) { d[4] = 0x09 }, ""},
+		{"filler only we check", func(d []byte) { d[24] = 0x01 }, "The driver accepted the greeting we reject (Unable to decode filler value)"},
+		{"auth data length only we check", func(d []byte) { d[32] = 0x00 }, "The driver accepted the greeting we reject (Wrong auth plugin data len)"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			data := greetingBytes(t)
+			test.patch(data)
+			packet := &InitialHandshakePacket{}
+			err := packet.decodePacket(data)
+			divergences := diffDecode(data, packet, err)
+			switch {
+			case test.divergence == "" && len(divergences) > 0:
+				t.Errorf("unexpected divergences %q", divergences)
+			case test.divergence != "" && (len(divergences) != 1 || !strings.HasPrefix(divergences[0], test.divergence)):
+				t.Errorf("divergences %q, want %q", divergences, test.divergence)
+			}
+		})
+	}
+}
+
+func TestReplayDiffFailsOnDivergence(t *testing.T) {
+	dir := t.TempDir()
+	data := greetingBytes(t)
+	data[24] = 0x01
+	if err := os.WriteFile(filepath.Join(dir, "001-mysql.bin"), data, 0600); err != nil {
+		t.Fatal(err)
+	}
+	var code int
+	out := captureStdout(t, func() {
+		code = runReplay([]string{"-diff", dir})
+	})
+	if code != 1 || !strings.Contains(out, "Divergence: The driver accepted the greeting we reject") {
+		t.Errorf("replay exited with %d:\n%s", code, out)
+	}
+}
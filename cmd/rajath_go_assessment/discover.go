@@ -0,0 +1,155 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"net"
+	"os/exec"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+/*
+Port scanners -discover can hand the port discovery phase to
+*/
+const (
+	DiscoverMasscan = "masscan"
+	DiscoverZmap    = "zmap"
+)
+
+/*
+Packets per second sent by the port scanner by default
+*/
+const defaultDiscoverRate = 1000
+
+/*
+discoverer runs masscan or zmap over the targets' addresses and ranges,
+so that only the host:port pairs found open are probed
+*/
+type discoverer struct {
+	tool string
+	path string
+	rate int
+}
+
+func newDiscoverer(tool string, rate int) (*discoverer, error) {
+	if tool != DiscoverMasscan && tool != DiscoverZmap {
+		return nil, fmt.Errorf("Unknown port scanner %q, expected masscan or zmap", tool)
+	}
+	if rate < 1 {
+		return nil, errors.New("-discover-rate must be at least 1")
+	}
+	path, err := exec.LookPath(tool)
+	if err != nil {
+		return nil, fmt.Errorf("%s not found in PATH", tool)
+	}
+	return &discoverer{tool: tool, path: path, rate: rate}, nil
+}
+
+/*
+isRange tells whether the target host is a CIDR range, which only
+-discover can expand
+*/
+func isRange(host string) bool {
+	_, _, err := net.ParseCIDR(host)
+	return err == nil
+}
+
+/*
+discover returns the targets found open: one per responsive address of
+every IP and range target. Names and named pipes are kept as they are,
+as the port scanners only take addresses.
+*/
+func (d *discoverer) discover(targets []Target) ([]Target, error) {
+	// Targets sharing a port, protocol and role are discovered together
+	var order []Target
+	groups := make(map[Target][]string)
+	var kept []Target
+	for _, target := range targets {
+		if target.Pipe != "" || net.ParseIP(target.Host) == nil && !isRange(target.Host) {
+			kept = append(kept, target)
+			continue
+		}
+		key := target
+		key.Host = ""
+		if _, ok := groups[key]; !ok {
+			order = append(order, key)
+		}
+		groups[key] = append(groups[key], target.Host)
+	}
+
+	var found []Target
+	for _, key := range order {
+		open, err := d.scan(key.Port, groups[key])
+		if err != nil {
+			return nil, err
+		}
+		for _, ip := range open {
+			target := key
+			target.Host = ip
+			found = append(found, target)
+		}
+	}
+	return append(found, kept...), nil
+}
+
+/*
+scan runs the port scanner over the hosts for a single port and returns
+the addresses found open, in address order
+*/
+func (d *discoverer) scan(port int, hosts []string) ([]string, error) {
+	var args []string
+	switch d.tool {
+	case DiscoverMasscan:
+		args = []string{"-p", strconv.Itoa(port), "--rate", strconv.Itoa(d.rate), "-oL", "-"}
+	case DiscoverZmap:
+		args = []string{"-p", strconv.Itoa(port), "-r", strconv.Itoa(d.rate), "-o", "-", "-q"}
+	}
+	cmd := exec.Command(d.path, append(args, hosts...)...)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("%s failed on port %d: %s %s", d.tool, port, err.Error(), strings.TrimSpace(stderr.String()))
+	}
+
+	seen := make(map[string]bool)
+	var open []net.IP
+	lines := bufio.NewScanner(bytes.NewReader(out))
+	for lines.Scan() {
+		ip := d.parseLine(lines.Text(), port)
+		if ip != nil && !seen[ip.String()] {
+			seen[ip.String()] = true
+			open = append(open, ip)
+		}
+	}
+	sort.Slice(open, func(i, j int) bool { return bytes.Compare(open[i].To16(), open[j].To16()) < 0 })
+
+	addresses := make([]string, len(open))
+	for i, ip := range open {
+		addresses[i] = ip.String()
+	}
+	return addresses, nil
+}
+
+/*
+parseLine reads an open address from a line of output: masscan's list
+format ("open tcp 3306 10.0.0.5 1690000000"), or zmap's one address per
+line. Comments and anything else are skipped.
+*/
+func (d *discoverer) parseLine(line string, port int) net.IP {
+	fields := strings.Fields(line)
+	if d.tool == DiscoverZmap {
+		if len(fields) != 1 {
+			return nil
+		}
+		return net.ParseIP(fields[0])
+	}
+	if len(fields) < 4 || fields[0] != "open" || fields[1] != "tcp" || fields[2] != strconv.Itoa(port) {
+		return nil
+	}
+	return net.ParseIP(fields[3])
+}
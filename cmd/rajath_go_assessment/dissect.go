@@ -0,0 +1,148 @@
+package main
+
+import (
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+)
+
+/*
+Server status flags, as sent in the greeting and OK packets
+*/
+var statusFlagNames = map[uint16]string{
+	0x0001: "SERVER_STATUS_IN_TRANS",
+	0x0002: "SERVER_STATUS_AUTOCOMMIT",
+	0x0008: "SERVER_MORE_RESULTS_EXISTS",
+	0x0010: "SERVER_QUERY_NO_GOOD_INDEX_USED",
+	0x0020: "SERVER_QUERY_NO_INDEX_USED",
+	0x0040: "SERVER_STATUS_CURSOR_EXISTS",
+	0x0080: "SERVER_STATUS_LAST_ROW_SENT",
+	0x0100: "SERVER_STATUS_DB_DROPPED",
+	0x0200: "SERVER_STATUS_NO_BACKSLASH_ESCAPES",
+	0x0400: "SERVER_STATUS_METADATA_CHANGED",
+	0x0800: "SERVER_QUERY_WAS_SLOW",
+	0x1000: "SERVER_PS_OUT_PARAMS",
+	0x2000: "SERVER_STATUS_IN_TRANS_READONLY",
+	0x4000: "SERVER_SESSION_STATE_CHANGED",
+}
+
+/*
+DissectionRecord is one line of -output dissect: every field of a
+target's greeting with where it sits and what it means, like a
+protocol analyzer's dissection tree
+*/
+type DissectionRecord struct {
+	Target   string            `json:"target"`
+	TargetID string            `json:"target_id"`
+	Status   string            `json:"status"`
+	Error    string            `json:"error,omitempty"`
+	Fields   []DissectionField `json:"fields"`
+	// Quirks of old servers the greeting was decoded despite
+	Warnings []JSONDecodeWarning `json:"warnings,omitempty"`
+}
+
+type DissectionField struct {
+	Name string `json:"name"`
+	// Byte range in the packet, header included
+	Offset int    `json:"offset"`
+	Length int    `json:"length"`
+	Raw    string `json:"raw"`
+	Value  string `json:"value"`
+	// Names of the bits set, for flag fields
+	Flags []string `json:"flags,omitempty"`
+}
+
+/*
+dissect interprets every decoded field of the handshake
+*/
+func (r *InitialHandshakePacket) dissect() []DissectionField {
+	fields := make([]DissectionField, 0, len(r.fields))
+	for _, f := range r.fields {
+		raw := r.raw[f.Offset : f.Offset+f.Length]
+		field := DissectionField{Name: f.Name, Offset: f.Offset, Length: f.Length, Raw: hex.EncodeToString(raw)}
+		field.Value, field.Flags = interpretField(f.Name, raw)
+		// MariaDB's own capabilities fill the last 4 reserved bytes
+		if caps := r.MariaDBCapabilities(); f.Name == "reserved" && caps != 0 {
+			field.Value, field.Flags = fmt.Sprintf("MariaDB capabilities 0x%08x", uint32(caps)), caps.Names()
+			if unknown := caps.Unknown(); unknown != 0 {
+				field.Flags = append(field.Flags, fmt.Sprintf("unknown_bits: 0x%08x", uint32(unknown)))
+			}
+		}
+		fields = append(fields, field)
+	}
+	return fields
+}
+
+/*
+interpretField renders a field's bytes the way the protocol defines them
+*/
+func interpretField(name string, raw []byte) (string, []string) {
+	switch name {
+	case "payload length":
+		return fmt.Sprint(binary.LittleEndian.Uint32(append(append([]byte(nil), raw...), 0))), nil
+	case "sequence id", "protocol version", "filler", "auth-plugin-data length":
+		return fmt.Sprint(raw[0]), nil
+	case "server version", "auth-plugin name":
+		return string(raw), nil
+	case "connection id":
+		return fmt.Sprint(binary.LittleEndian.Uint32(raw)), nil
+	case "auth-plugin-data-part-1", "auth-plugin-data-part-2":
+		return fmt.Sprintf("%q", raw), nil
+	case "character set":
+		return fmt.Sprintf("%d (%s)", raw[0], collationName(raw[0])), nil
+	case "status flags":
+		value := binary.LittleEndian.Uint16(raw)
+		// Lowest bit first, as the flags are documented
+		var set []string
+		for bit := uint16(1); bit != 0; bit <<= 1 {
+			if flagName, ok := statusFlagNames[bit]; ok && value&bit != 0 {
+				set = append(set, flagName)
+			}
+		}
+		return fmt.Sprintf("0x%04x", value), set
+	case "capability flags (lower)", "capability flags (upper)":
+		value := CapabilityFlag(binary.LittleEndian.Uint16(raw))
+		if name == "capability flags (upper)" {
+			value <<= 16
+		}
+		var set []string
+		for bit := CapabilityFlag(1); bit != 0; bit <<= 1 {
+			if flagName, ok := flags[bit]; ok && value.Has(bit) {
+				set = append(set, flagName)
+			}
+		}
+		return fmt.Sprintf("0x%08x", uint32(value)), set
+	case "reserved":
+		for _, b := range raw {
+			if b != 0 {
+				return "not all zero", nil
+			}
+		}
+		return "all zero", nil
+	}
+	return "", nil
+}
+
+/*
+writeDissection writes one dissection record per target and line.
+Targets without a classic handshake are listed with no fields.
+*/
+func writeDissection(w io.Writer, results []*ScanResult) error {
+	encoder := json.NewEncoder(w)
+	for _, result := range results {
+		record := DissectionRecord{Target: result.Target.Label(), TargetID: result.TargetID(), Status: result.Status, Fields: []DissectionField{}}
+		if result.Err != nil {
+			record.Error = result.Err.Error()
+		}
+		if result.Handshake != nil {
+			record.Fields = result.Handshake.dissect()
+			record.Warnings = newJSONDecodeWarnings(result.Handshake.Warnings)
+		}
+		if err := encoder.Encode(record); err != nil {
+			return err
+		}
+	}
+	return nil
+}
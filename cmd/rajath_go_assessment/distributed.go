@@ -0,0 +1,278 @@
+package main
+
+import (
+	"context"
+	"crypto/subtle"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/encoding"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+/*
+Coordinator and agents talk gRPC, but with JSON messages instead of
+generated protobuf code, so the wire types below are plain structs and
+the service is described by hand
+*/
+const (
+	coordinatorService = "rajath.Coordinator"
+	jsonCodecName      = "json"
+)
+
+type jsonCodec struct{}
+
+func (jsonCodec) Marshal(v interface{}) ([]byte, error)      { return json.Marshal(v) }
+func (jsonCodec) Unmarshal(data []byte, v interface{}) error { return json.Unmarshal(data, v) }
+func (jsonCodec) Name() string                               { return jsonCodecName }
+
+func init() {
+	encoding.RegisterCodec(jsonCodec{})
+}
+
+/*
+AgentRegistration is sent by an agent when it starts. Agents of the
+same region share the work of scanning every target once.
+*/
+type AgentRegistration struct {
+	Name   string `json:"name"`
+	Region string `json:"region"`
+}
+
+type AgentID struct {
+	ID string `json:"id"`
+}
+
+type ShardRequest struct {
+	AgentID string `json:"agent_id"`
+}
+
+/*
+Shard is a slice of the targets handed to one agent. Wait means every
+shard is leased but not all are done yet, Done that the region is finished.
+*/
+type Shard struct {
+	ID      int      `json:"id"`
+	Targets []Target `json:"targets,omitempty"`
+	Wait    bool     `json:"wait,omitempty"`
+	Done    bool     `json:"done,omitempty"`
+}
+
+/*
+ResultRecord is the result of one target as streamed back by an agent
+*/
+type ResultRecord struct {
+	AgentID string `json:"agent_id"`
+	ShardID int    `json:"shard_id"`
+	Target  Target `json:"target"`
+	// ID of the target before redaction masked it
+	TargetID string `json:"target_id"`
+	Status   string `json:"status"`
+	Version  string `json:"version,omitempty"`
+	Error    string `json:"error,omitempty"`
+	// Category of a server error, as the text alone can be localized
+	ErrorCategory string        `json:"error_category,omitempty"`
+	Latency       time.Duration `json:"latency"`
+	Findings      []Finding     `json:"findings,omitempty"`
+	Tags          Tags          `json:"tags,omitempty"`
+	StartedAt     time.Time     `json:"started_at"`
+	EndedAt       time.Time     `json:"ended_at"`
+}
+
+type ReportAck struct {
+	Received int `json:"received"`
+}
+
+func newResultRecord(agentID string, shardID int, result *ScanResult) *ResultRecord {
+	result = redactor.apply(result)
+	record := &ResultRecord{
+		AgentID:   agentID,
+		ShardID:   shardID,
+		Target:    result.Target,
+		TargetID:  result.TargetID(),
+		Status:    result.Status,
+		Version:   result.Version(),
+		Latency:   result.Latency,
+		Findings:  result.Findings,
+		Tags:      result.Tags,
+		StartedAt: result.StartedAt,
+		EndedAt:   result.EndedAt,
+	}
+	if result.Err != nil {
+		record.Error = result.Err.Error()
+		record.ErrorCategory = errorCategory(result.Err)
+	}
+	return record
+}
+
+/*
+scanResult rebuilds the parts of a scan result the report writers use
+*/
+func (r *ResultRecord) scanResult() *ScanResult {
+	result := &ScanResult{
+		Target:    r.Target,
+		targetID:  r.TargetID,
+		Status:    r.Status,
+		Latency:   r.Latency,
+		Findings:  r.Findings,
+		Tags:      r.Tags,
+		StartedAt: r.StartedAt,
+		EndedAt:   r.EndedAt,
+	}
+	if r.Error != "" {
+		result.Err = &remoteError{message: r.Error, category: r.ErrorCategory}
+	}
+	return result
+}
+
+/*
+coordinatorServer is implemented by the coordinator
+*/
+type coordinatorServer interface {
+	Register(ctx context.Context, req *AgentRegistration) (*AgentID, error)
+	NextShard(ctx context.Context, req *ShardRequest) (*Shard, error)
+	Report(stream grpc.ServerStream) error
+}
+
+func registerHandler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	req := new(AgentRegistration)
+	if err := dec(req); err != nil {
+		return nil, err
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(coordinatorServer).Register(ctx, req.(*AgentRegistration))
+	}
+	if interceptor == nil {
+		return handler(ctx, req)
+	}
+	return interceptor(ctx, req, &grpc.UnaryServerInfo{Server: srv, FullMethod: "/" + coordinatorService + "/Register"}, handler)
+}
+
+func nextShardHandler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	req := new(ShardRequest)
+	if err := dec(req); err != nil {
+		return nil, err
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(coordinatorServer).NextShard(ctx, req.(*ShardRequest))
+	}
+	if interceptor == nil {
+		return handler(ctx, req)
+	}
+	return interceptor(ctx, req, &grpc.UnaryServerInfo{Server: srv, FullMethod: "/" + coordinatorService + "/NextShard"}, handler)
+}
+
+func reportHandler(srv interface{}, stream grpc.ServerStream) error {
+	return srv.(coordinatorServer).Report(stream)
+}
+
+var coordinatorServiceDesc = grpc.ServiceDesc{
+	ServiceName: coordinatorService,
+	HandlerType: (*coordinatorServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{MethodName: "Register", Handler: registerHandler},
+		{MethodName: "NextShard", Handler: nextShardHandler},
+	},
+	Streams: []grpc.StreamDesc{
+		{StreamName: "Report", Handler: reportHandler, ClientStreams: true},
+	},
+}
+
+/*
+A shared token, when given, must be sent by agents with every call
+*/
+const tokenMetadataKey = "authorization"
+
+func checkToken(ctx context.Context, token string) error {
+	if token == "" {
+		return nil
+	}
+	md, _ := metadata.FromIncomingContext(ctx)
+	for _, value := range md.Get(tokenMetadataKey) {
+		if subtle.ConstantTimeCompare([]byte(value), []byte("Bearer "+token)) == 1 {
+			return nil
+		}
+	}
+	return status.Error(codes.Unauthenticated, "Invalid or missing token")
+}
+
+func tokenInterceptors(token string) []grpc.ServerOption {
+	return []grpc.ServerOption{
+		grpc.UnaryInterceptor(func(ctx context.Context, req interface{}, _ *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+			if err := checkToken(ctx, token); err != nil {
+				return nil, err
+			}
+			return handler(ctx, req)
+		}),
+		grpc.StreamInterceptor(func(srv interface{}, ss grpc.ServerStream, _ *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+			if err := checkToken(ss.Context(), token); err != nil {
+				return err
+			}
+			return handler(srv, ss)
+		}),
+	}
+}
+
+/*
+grpcTransport secures the connections between coordinator and agents,
+which carry the token and every result: the coordinator serves TLS with
+-grpc-cert and -grpc-key, and agents check its certificate against
+-grpc-ca, or the system roots without one. Plaintext takes
+-sink-insecure, for trusted networks.
+*/
+type grpcTransport struct {
+	cert     string
+	key      string
+	ca       string
+	insecure bool
+}
+
+var grpcSecurity = &grpcTransport{}
+
+/*
+serverOption returns the coordinator's transport credentials
+*/
+func (t *grpcTransport) serverOption() (grpc.ServerOption, error) {
+	if t.insecure {
+		return grpc.Creds(insecure.NewCredentials()), nil
+	}
+	if t.cert == "" || t.key == "" {
+		return nil, errors.New("The coordinator needs -grpc-cert and -grpc-key to serve TLS, or -sink-insecure to send the token and results in plaintext")
+	}
+	cert, err := tls.LoadX509KeyPair(t.cert, t.key)
+	if err != nil {
+		return nil, err
+	}
+	return grpc.Creds(credentials.NewTLS(&tls.Config{Certificates: []tls.Certificate{cert}, MinVersion: tls.VersionTLS12})), nil
+}
+
+/*
+dialOption returns an agent's transport credentials
+*/
+func (t *grpcTransport) dialOption() (grpc.DialOption, error) {
+	if t.insecure {
+		return grpc.WithTransportCredentials(insecure.NewCredentials()), nil
+	}
+	config := &tls.Config{MinVersion: tls.VersionTLS12}
+	if t.ca != "" {
+		pem, err := os.ReadFile(t.ca)
+		if err != nil {
+			return nil, err
+		}
+		config.RootCAs = x509.NewCertPool()
+		if !config.RootCAs.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("%s: no PEM certificates", t.ca)
+		}
+	}
+	return grpc.WithTransportCredentials(credentials.NewTLS(config)), nil
+}
@@ -0,0 +1,187 @@
+package main
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+
+	"golang.org/x/crypto/scrypt"
+)
+
+/*
+Encrypted files start with this magic and a byte saying where the key
+came from, then the scrypt salt, the nonce and the AES-256-GCM sealed
+data:
+
+	magic | mode | salt (16) | nonce (12) | ciphertext
+*/
+var encryptedMagic = []byte("RJENC1")
+
+const (
+	encryptedWithPassphrase byte = 'P'
+	encryptedWithKeyFile    byte = 'K'
+	encryptionSaltSize           = 16
+	encryptionKeySize            = 32
+)
+
+/*
+scrypt parameters recommended for interactive use
+*/
+const (
+	scryptN = 1 << 15
+	scryptR = 8
+	scryptP = 1
+)
+
+/*
+Environment variable holding the passphrase, kept off the command line
+so it doesn't show in the process list
+*/
+const passphraseEnv = "RAJATH_STORE_PASSPHRASE"
+
+/*
+errEncryptedNoKey is returned when reading an encrypted file without a
+passphrase or key file
+*/
+var errEncryptedNoKey = errors.New("File is encrypted, give -store-key or set " + passphraseEnv)
+
+/*
+fileCipher encrypts results persisted by the scanner, as scan outputs
+describe infrastructure in detail. Exactly one of key and passphrase is set.
+*/
+type fileCipher struct {
+	key        []byte
+	passphrase []byte
+}
+
+/*
+loadKeyFile reads a 32 byte key, either raw or hex encoded, as made by
+openssl rand -hex 32
+*/
+func loadKeyFile(path string) ([]byte, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	if len(data) == encryptionKeySize {
+		return data, nil
+	}
+	key, err := hex.DecodeString(strings.TrimSpace(string(data)))
+	if err != nil || len(key) != encryptionKeySize {
+		return nil, fmt.Errorf("%s: expected a 32 byte key, raw or hex encoded", path)
+	}
+	return key, nil
+}
+
+/*
+newFileCipher uses the key file when given, else the passphrase from
+the environment. It returns nil when neither is set.
+*/
+func newFileCipher(keyPath string) (*fileCipher, error) {
+	if keyPath != "" {
+		key, err := loadKeyFile(keyPath)
+		if err != nil {
+			return nil, err
+		}
+		return &fileCipher{key: key}, nil
+	}
+	if passphrase := os.Getenv(passphraseEnv); passphrase != "" {
+		return &fileCipher{passphrase: []byte(passphrase)}, nil
+	}
+	return nil, nil
+}
+
+func isEncrypted(data []byte) bool {
+	return bytes.HasPrefix(data, encryptedMagic)
+}
+
+func (c *fileCipher) aead(salt []byte) (cipher.AEAD, error) {
+	key := c.key
+	if key == nil {
+		var err error
+		key, err = scrypt.Key(c.passphrase, salt, scryptN, scryptR, scryptP, encryptionKeySize)
+		if err != nil {
+			return nil, err
+		}
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+func (c *fileCipher) mode() byte {
+	if c.key != nil {
+		return encryptedWithKeyFile
+	}
+	return encryptedWithPassphrase
+}
+
+/*
+seal encrypts data with a fresh salt and nonce
+*/
+func (c *fileCipher) seal(data []byte) ([]byte, error) {
+	salt := make([]byte, encryptionSaltSize)
+	if _, err := rand.Read(salt); err != nil {
+		return nil, err
+	}
+	aead, err := c.aead(salt)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+
+	header := append(append([]byte(nil), encryptedMagic...), c.mode())
+	header = append(append(header, salt...), nonce...)
+	// The header is authenticated too, so it can't be swapped
+	return aead.Seal(header, nonce, data, header), nil
+}
+
+/*
+open decrypts data made by seal
+*/
+func (c *fileCipher) open(data []byte) ([]byte, error) {
+	if c == nil {
+		return nil, errEncryptedNoKey
+	}
+
+	pos := len(encryptedMagic)
+	if len(data) < pos+1+encryptionSaltSize {
+		return nil, errors.New("Encrypted file is truncated")
+	}
+	if data[pos] != c.mode() {
+		if data[pos] == encryptedWithKeyFile {
+			return nil, errors.New("File was encrypted with a key file, give -store-key")
+		}
+		return nil, errors.New("File was encrypted with a passphrase, set " + passphraseEnv)
+	}
+	pos++
+	salt := data[pos : pos+encryptionSaltSize]
+	pos += encryptionSaltSize
+
+	aead, err := c.aead(salt)
+	if err != nil {
+		return nil, err
+	}
+	if len(data) < pos+aead.NonceSize() {
+		return nil, errors.New("Encrypted file is truncated")
+	}
+	nonce := data[pos : pos+aead.NonceSize()]
+	header := data[:pos+aead.NonceSize()]
+
+	plain, err := aead.Open(nil, nonce, data[len(header):], header)
+	if err != nil {
+		return nil, errors.New("Can't decrypt file, wrong key or passphrase")
+	}
+	return plain, nil
+}
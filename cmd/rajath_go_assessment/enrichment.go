@@ -0,0 +1,164 @@
+package main
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+)
+
+/*
+enrichmentRule attaches tags to targets matching a host, a host and
+port, or a network
+*/
+type enrichmentRule struct {
+	host    string
+	port    int
+	network *net.IPNet
+	tags    Tags
+}
+
+/*
+specificity ranks rules so the most specific match wins: host and port,
+then host, then the narrowest network
+*/
+func (r enrichmentRule) specificity() int {
+	switch {
+	case r.network != nil:
+		ones, _ := r.network.Mask.Size()
+		return ones
+	case r.port != 0:
+		return 1000
+	default:
+		return 999
+	}
+}
+
+func (r enrichmentRule) matches(target Target) bool {
+	if r.network != nil {
+		ip := net.ParseIP(target.Host)
+		return ip != nil && r.network.Contains(ip)
+	}
+	if !strings.EqualFold(r.host, target.Host) {
+		return false
+	}
+	return r.port == 0 || r.port == target.Port
+}
+
+/*
+Enrichment maps targets to ownership details, loaded with -enrich
+*/
+type Enrichment []enrichmentRule
+
+/*
+loadEnrichment reads a CSV file whose first column matches targets by
+host, host:port or CIDR. The other columns, named by the header row
+(owner, environment, ticket and anything else), become tags of the
+matching results. Empty cells are left out.
+
+	match,owner,environment,ticket
+	10.1.0.0/16,payments-dba,prod,https://tickets.example.com/DBA-1
+	db1.example.com:3306,platform,staging,
+*/
+func loadEnrichment(path string) (Enrichment, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	reader := csv.NewReader(file)
+	reader.TrimLeadingSpace = true
+	header, err := reader.Read()
+	if err != nil {
+		return nil, fmt.Errorf("%s: %s", path, err.Error())
+	}
+	if len(header) < 2 {
+		return nil, fmt.Errorf("%s: expected a header with match and at least one more column", path)
+	}
+
+	var enrichment Enrichment
+	for line := 2; ; line++ {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("%s: %s", path, err.Error())
+		}
+
+		rule, err := parseEnrichmentMatch(strings.TrimSpace(record[0]))
+		if err != nil {
+			return nil, fmt.Errorf("%s line %d: %s", path, line, err.Error())
+		}
+		rule.tags = make(Tags)
+		for i, value := range record[1:] {
+			key := strings.TrimSpace(header[i+1])
+			if value = strings.TrimSpace(value); key != "" && value != "" {
+				rule.tags[key] = value
+			}
+		}
+		enrichment = append(enrichment, rule)
+	}
+	return enrichment, nil
+}
+
+func parseEnrichmentMatch(match string) (enrichmentRule, error) {
+	if match == "" {
+		return enrichmentRule{}, fmt.Errorf("Empty match")
+	}
+	if strings.Contains(match, "/") {
+		_, network, err := net.ParseCIDR(match)
+		if err != nil {
+			return enrichmentRule{}, err
+		}
+		return enrichmentRule{network: network}, nil
+	}
+	if host, port, err := net.SplitHostPort(match); err == nil {
+		n, err := strconv.Atoi(port)
+		if err != nil || n <= 0 {
+			return enrichmentRule{}, fmt.Errorf("Invalid port in %q", match)
+		}
+		return enrichmentRule{host: host, port: n}, nil
+	}
+	return enrichmentRule{host: match}, nil
+}
+
+/*
+lookup returns the tags of the most specific rule matching the target,
+nil when none does
+*/
+func (e Enrichment) lookup(target Target) Tags {
+	best := -1
+	for i, rule := range e {
+		if rule.matches(target) && (best == -1 || rule.specificity() > e[best].specificity()) {
+			best = i
+		}
+	}
+	if best == -1 {
+		return nil
+	}
+	return e[best].tags
+}
+
+/*
+tagsFor returns the scan's tags with the target's enrichment merged
+in. Enrichment is per target, so it wins over a -tag of the same key.
+*/
+func (e Enrichment) tagsFor(target Target, tags Tags) Tags {
+	extra := e.lookup(target)
+	if len(extra) == 0 {
+		return tags
+	}
+	merged := make(Tags, len(tags)+len(extra))
+	for key, value := range tags {
+		merged[key] = value
+	}
+	for key, value := range extra {
+		merged[key] = value
+	}
+	return merged
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+)
+
+/*
+Prefix of the environment variables flags can be set with, such as
+RAJATH_OUTPUT for -output
+*/
+const envPrefix = "RAJATH_"
+
+/*
+Environment variables that don't follow the flag names. RAJATH_TARGETS
+holds the targets themselves rather than a -targets file, which
+containers seldom have, and RAJATH_TIMEOUT is the handshake timeout.
+*/
+const (
+	envTargets = envPrefix + "TARGETS"
+	envTimeout = envPrefix + "TIMEOUT"
+)
+
+var envAliases = map[string]string{
+	envTimeout: "handshake-timeout",
+}
+
+/*
+Flags never taken from the environment: -version, as images often set
+a VERSION of their own, and -targets, see envTargets
+*/
+var envSkipped = map[string]bool{
+	"version": true,
+	"targets": true,
+}
+
+/*
+envName returns the environment variable setting the flag
+*/
+func envName(flagName string) string {
+	return envPrefix + strings.ToUpper(strings.ReplaceAll(flagName, "-", "_"))
+}
+
+/*
+flagsFromEnv sets the flags given in the environment, for the scanner
+to be configured without a command line or mounted files, as in a
+Kubernetes Job. It runs before the command line args are parsed, and
+leaves alone the flags they set, so a repeatable flag such as -tag
+doesn't add its values to the environment's. Repeatable flags take
+one value this way.
+*/
+func flagsFromEnv(flags *flag.FlagSet, args []string) error {
+	onCommandLine := commandLineFlags(flags, args)
+	var err error
+	flags.VisitAll(func(f *flag.Flag) {
+		name := envName(f.Name)
+		value, ok := os.LookupEnv(name)
+		if err != nil || !ok || envSkipped[f.Name] || onCommandLine[f.Name] {
+			return
+		}
+		if setErr := flags.Set(f.Name, value); setErr != nil {
+			err = fmt.Errorf("%s: %s", name, setErr.Error())
+		}
+	})
+	if err != nil {
+		return err
+	}
+	for name, flagName := range envAliases {
+		value, ok := os.LookupEnv(name)
+		if !ok || onCommandLine[flagName] {
+			continue
+		}
+		if err := flags.Set(flagName, value); err != nil {
+			return fmt.Errorf("%s: %s", name, err.Error())
+		}
+	}
+	return nil
+}
+
+/*
+commandLineFlags returns the names of the flags set in args, read the
+way flag.Parse will: up to the first argument that isn't a flag or
+"--", with the value of a flag other than a boolean taking the next
+argument unless given after "="
+*/
+func commandLineFlags(flags *flag.FlagSet, args []string) map[string]bool {
+	set := make(map[string]bool)
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+		if len(arg) < 2 || arg[0] != '-' || arg == "--" {
+			break
+		}
+		name, _, hasValue := strings.Cut(strings.TrimPrefix(arg[1:], "-"), "=")
+		set[name] = true
+		f := flags.Lookup(name)
+		if f == nil || hasValue {
+			continue
+		}
+		if boolFlag, ok := f.Value.(interface{ IsBoolFlag() bool }); !ok || !boolFlag.IsBoolFlag() {
+			i++
+		}
+	}
+	return set
+}
+
+/*
+envTargetList returns the targets in RAJATH_TARGETS, separated by
+spaces, commas or new lines
+*/
+func envTargetList() []string {
+	return strings.FieldsFunc(os.Getenv(envTargets), func(r rune) bool {
+		return r == ',' || r == ' ' || r == '\n' || r == '\t'
+	})
+}
@@ -0,0 +1,40 @@
+package main
+
+import (
+	"flag"
+	"io"
+	"reflect"
+	"testing"
+)
+
+func TestCommandLineWinsOverEnvironment(t *testing.T) {
+	t.Setenv("RAJATH_TAG", "env=container")
+	t.Setenv("RAJATH_OUTPUT", "json")
+	t.Setenv("RAJATH_WORKERS", "8")
+
+	flags := flag.NewFlagSet("scan", flag.ContinueOnError)
+	flags.SetOutput(io.Discard)
+	tags := make(Tags)
+	flags.Var(tags, "tag", "")
+	output := flags.String("output", "text", "")
+	workers := flags.Int("workers", 1, "")
+	verbose := flags.Bool("verbose", false, "")
+
+	args := []string{"-verbose", "-tag", "owner=dba", "--output=sarif", "db1:3306", "-workers", "2"}
+	if err := flagsFromEnv(flags, args); err != nil {
+		t.Fatal(err)
+	}
+	if err := flags.Parse(args); err != nil {
+		t.Fatal(err)
+	}
+	if want := (Tags{"owner": "dba"}); !reflect.DeepEqual(tags, want) {
+		t.Errorf("tags %v, want %v", tags, want)
+	}
+	if *output != "sarif" || !*verbose {
+		t.Errorf("output %s, verbose %t", *output, *verbose)
+	}
+	// Past the first target, as flag.Parse stops there
+	if *workers != 8 {
+		t.Errorf("workers %d, want the environment's", *workers)
+	}
+}
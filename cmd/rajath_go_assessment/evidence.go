@@ -0,0 +1,320 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+/*
+Phase of a target scan before its checks run: the greeting and the
+probes every finding rests on
+*/
+const evidenceHandshake = "handshake"
+
+/*
+Phase of the shared session logging in, which whichever check first
+needs it opens for all of them
+*/
+const evidenceLogin = "login"
+
+/*
+evidenceCollector keeps, when -evidence-dir is given, every byte sent
+to and received from a target during its scan, timed and tagged with
+the check that was running. Once the target is scanned each finding
+gets a directory with the exchanges it rests on: the handshake phase,
+plus the shared session's login and the exchanges of the check that
+reported it. With -redact the directory is named after the redacted
+target and the bytes are masked as in -record.
+
+One scan of a target collects at a time, a second one waits for the
+first to finish, so every exchange goes to the scan that made it. A
+connection, such as a pooled session's, belongs to whichever scan of
+its target is running when it is used.
+
+	dir/host_port/20261016T171211.958006615Z/01-MYSQL-AUTH-NATIVE/finding.json
+	dir/host_port/20261016T171211.958006615Z/01-MYSQL-AUTH-NATIVE/001-received.bin
+*/
+type evidenceCollector struct {
+	dir string
+
+	mu sync.Mutex
+	// Signalled when a scan finishes, for the one waiting to start
+	finished *sync.Cond
+	targets  map[string]*targetEvidence
+}
+
+var evidence = newEvidenceCollector()
+
+func newEvidenceCollector() *evidenceCollector {
+	c := &evidenceCollector{targets: make(map[string]*targetEvidence)}
+	c.finished = sync.NewCond(&c.mu)
+	return c
+}
+
+/*
+targetEvidence is what was exchanged with a target in one scan of it
+*/
+type targetEvidence struct {
+	mu    sync.Mutex
+	phase string
+	// Connections numbered in the order this scan first used them
+	connections map[*evidenceConn]int
+	exchanges   []evidenceExchange
+}
+
+/*
+evidenceExchange is the data of one read from or write to a target
+connection, exactly as it went over the wire
+*/
+type evidenceExchange struct {
+	At         time.Time
+	Phase      string
+	Connection int
+	Sent       bool
+	Data       []byte
+}
+
+/*
+start begins collecting the target's exchanges, once any other scan of
+it finished, or does nothing without -evidence-dir
+*/
+func (c *evidenceCollector) start(target Target) {
+	if c.dir == "" {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for c.targets[target.Label()] != nil {
+		c.finished.Wait()
+	}
+	c.targets[target.Label()] = &targetEvidence{phase: evidenceHandshake, connections: make(map[*evidenceConn]int)}
+}
+
+func (c *evidenceCollector) of(target Target) *targetEvidence {
+	return c.ofLabel(target.Label())
+}
+
+func (c *evidenceCollector) ofLabel(label string) *targetEvidence {
+	if c.dir == "" {
+		return nil
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.targets[label]
+}
+
+/*
+phase tags what is exchanged with the target from now on with the
+check about to run
+*/
+func (c *evidenceCollector) phase(target Target, check string) {
+	if e := c.of(target); e != nil {
+		e.mu.Lock()
+		e.phase = check
+		e.mu.Unlock()
+	}
+}
+
+/*
+during tags the exchanges with the given phase until the returned
+function puts back the one before
+*/
+func (c *evidenceCollector) during(target Target, phase string) func() {
+	e := c.of(target)
+	if e == nil {
+		return func() {}
+	}
+	e.mu.Lock()
+	previous := e.phase
+	e.phase = phase
+	e.mu.Unlock()
+	return func() { c.phase(target, previous) }
+}
+
+/*
+wrap returns conn keeping its exchanges for the scans of the target, or
+conn itself when no evidence is collected
+*/
+func (c *evidenceCollector) wrap(target Target, conn net.Conn) net.Conn {
+	if c.dir == "" {
+		return conn
+	}
+	return &evidenceConn{Conn: conn, collector: c, label: target.Label()}
+}
+
+func (e *targetEvidence) add(conn *evidenceConn, sent bool, data []byte) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	connection, ok := e.connections[conn]
+	if !ok {
+		connection = len(e.connections) + 1
+		e.connections[conn] = connection
+	}
+	e.exchanges = append(e.exchanges, evidenceExchange{
+		At:         clock.Now().UTC(),
+		Phase:      e.phase,
+		Connection: connection,
+		Sent:       sent,
+		Data:       append([]byte(nil), data...),
+	})
+}
+
+/*
+evidenceConn copies the bytes of every read and write to the evidence
+of the scan of its target running at the time, if any
+*/
+type evidenceConn struct {
+	net.Conn
+	collector *evidenceCollector
+	label     string
+}
+
+func (c *evidenceConn) Read(p []byte) (int, error) {
+	n, err := c.Conn.Read(p)
+	if e := c.collector.ofLabel(c.label); n > 0 && e != nil {
+		e.add(c, false, p[:n])
+	}
+	return n, err
+}
+
+func (c *evidenceConn) Write(p []byte) (int, error) {
+	n, err := c.Conn.Write(p)
+	if e := c.collector.ofLabel(c.label); n > 0 && e != nil {
+		e.add(c, true, p[:n])
+	}
+	return n, err
+}
+
+/*
+masked returns the exchanges as -redact leaves them. What a connection
+received is masked as one stream, so its greeting is found whole
+however it was read.
+*/
+func (e *targetEvidence) masked() []evidenceExchange {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if redactor == nil {
+		return e.exchanges
+	}
+	received := make(map[int][]byte)
+	for _, exchange := range e.exchanges {
+		if !exchange.Sent {
+			received[exchange.Connection] = append(received[exchange.Connection], exchange.Data...)
+		}
+	}
+	for connection, stream := range received {
+		received[connection] = redactor.raw(stream)
+	}
+
+	masked := make([]evidenceExchange, len(e.exchanges))
+	for i, exchange := range e.exchanges {
+		if exchange.Sent {
+			exchange.Data = redactor.maskBytes(append([]byte(nil), exchange.Data...))
+		} else {
+			stream := received[exchange.Connection]
+			exchange.Data, received[exchange.Connection] = stream[:len(exchange.Data)], stream[len(exchange.Data):]
+		}
+		masked[i] = exchange
+	}
+	return masked
+}
+
+/*
+EvidenceRecord is the finding.json of an evidence directory
+*/
+type EvidenceRecord struct {
+	RunID     string             `json:"run_id"`
+	StartedAt time.Time          `json:"started_at"`
+	EndedAt   time.Time          `json:"ended_at"`
+	Target    JSONTarget         `json:"target"`
+	Finding   JSONFinding        `json:"finding"`
+	Exchanges []EvidenceExchange `json:"exchanges"`
+}
+
+type EvidenceExchange struct {
+	File       string    `json:"file"`
+	At         time.Time `json:"at"`
+	Phase      string    `json:"phase"`
+	Connection int       `json:"connection"`
+	Direction  string    `json:"direction"`
+	Bytes      int       `json:"bytes"`
+}
+
+/*
+finish writes the evidence of every finding of the result and forgets
+the target's exchanges. Findings made outside of checks, such as the
+inventory's, only get the handshake phase. A result from the banner
+cache has nothing exchanged to show, and gets no evidence.
+*/
+func (c *evidenceCollector) finish(result *ScanResult) error {
+	e := c.of(result.Target)
+	if e == nil {
+		return nil
+	}
+	c.mu.Lock()
+	delete(c.targets, result.Target.Label())
+	c.finished.Broadcast()
+	c.mu.Unlock()
+	if !result.CachedAt.IsZero() {
+		return nil
+	}
+
+	record := newJSONRecord(redactor.apply(result))
+	exchanges := e.masked()
+	// Named like the store entry, apart for targets alike once masked
+	scanDir := filepath.Join(c.dir, recordingName(redactor.storeKey(result.Target)), result.StartedAt.Format("20060102T150405.000000000Z"))
+	for i, finding := range record.Findings {
+		dir := filepath.Join(scanDir, fmt.Sprintf("%02d-%s", i+1, recordingName(finding.RuleID)))
+		// Evidence holds login packets and query results, keep it private
+		if err := os.MkdirAll(dir, 0700); err != nil {
+			return err
+		}
+
+		evidenceRecord := EvidenceRecord{
+			RunID:     runID,
+			StartedAt: record.StartedAt,
+			EndedAt:   record.EndedAt,
+			Target:    record.Target,
+			Finding:   finding,
+			Exchanges: []EvidenceExchange{},
+		}
+		for _, exchange := range exchanges {
+			switch {
+			case exchange.Phase == evidenceHandshake:
+			case finding.Check != "" && (exchange.Phase == evidenceLogin || exchange.Phase == finding.Check):
+			default:
+				continue
+			}
+			direction := "received"
+			if exchange.Sent {
+				direction = "sent"
+			}
+			file := fmt.Sprintf("%03d-%s.bin", len(evidenceRecord.Exchanges)+1, direction)
+			if err := os.WriteFile(filepath.Join(dir, file), exchange.Data, 0600); err != nil {
+				return err
+			}
+			evidenceRecord.Exchanges = append(evidenceRecord.Exchanges, EvidenceExchange{
+				File:       file,
+				At:         exchange.At,
+				Phase:      exchange.Phase,
+				Connection: exchange.Connection,
+				Direction:  direction,
+				Bytes:      len(exchange.Data),
+			})
+		}
+
+		data, err := json.MarshalIndent(evidenceRecord, "", "  ")
+		if err != nil {
+			return err
+		}
+		if err := os.WriteFile(filepath.Join(dir, "finding.json"), append(data, '\n'), 0600); err != nil {
+			return err
+		}
+	}
+	return nil
+}
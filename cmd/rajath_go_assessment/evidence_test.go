@@ -0,0 +1,172 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+/*
+useEvidence collects evidence to a temporary directory for the test,
+with the given redactor
+*/
+func useEvidence(t *testing.T, r *Redactor) string {
+	t.Helper()
+	dir := t.TempDir()
+	previous, previousRedactor := evidence, redactor
+	evidence, redactor = newEvidenceCollector(), r
+	evidence.dir = dir
+	t.Cleanup(func() {
+		evidence, redactor = previous, previousRedactor
+	})
+	return dir
+}
+
+var evidenceFinding = Finding{RuleID: "MYSQL-TEST", Severity: SeverityInfo, Title: "Test finding"}
+
+/*
+readEvidence returns the record and the received bytes of the only
+finding's evidence under dir
+*/
+func readEvidence(t *testing.T, dir string) (string, EvidenceRecord, []byte) {
+	t.Helper()
+	paths, _ := filepath.Glob(filepath.Join(dir, "*", "*", "*", "finding.json"))
+	if len(paths) != 1 {
+		t.Fatalf("got evidence %v, want one finding", paths)
+	}
+	data, err := os.ReadFile(paths[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	var record EvidenceRecord
+	if err := json.Unmarshal(data, &record); err != nil {
+		t.Fatal(err)
+	}
+	var received []byte
+	for _, exchange := range record.Exchanges {
+		if exchange.Direction == "received" {
+			data, err := os.ReadFile(filepath.Join(filepath.Dir(paths[0]), exchange.File))
+			if err != nil {
+				t.Fatal(err)
+			}
+			received = append(received, data...)
+		}
+	}
+	return paths[0], record, received
+}
+
+func TestEvidenceIsRedacted(t *testing.T) {
+	r, err := newRedactor("salt,ips", ScanOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir := useEvidence(t, r)
+	_, network := useFakes(t)
+	target := Target{Host: "10.0.0.5", Port: 3306, Protocol: ProtocolMySQL}
+	network.Serve(target.Address(), serveGreeting(greetingBytes(t)))
+
+	evidence.start(target)
+	if _, _, err := fetchHandshake(target); err != nil {
+		t.Fatal(err)
+	}
+	if err := evidence.finish(&ScanResult{Target: target, Status: StatusMySQL, Findings: []Finding{evidenceFinding}}); err != nil {
+		t.Fatal(err)
+	}
+
+	path, record, received := readEvidence(t, dir)
+	if strings.Contains(path, "10.0.0.5") || !strings.Contains(path, "10.0.0.x_3306_hmac_") {
+		t.Errorf("evidence under %s", path)
+	}
+	if record.Target.Host != "10.0.0.x" {
+		t.Errorf("record of %s", record.Target.Host)
+	}
+	// Read as a header and a payload, masked as one stream
+	if len(record.Exchanges) != 2 {
+		t.Errorf("got %d exchanges, want the header and payload reads", len(record.Exchanges))
+	}
+	packet := &InitialHandshakePacket{}
+	if err := packet.decodePacket(received); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(packet.AuthPluginData, make([]byte, 21)) {
+		t.Errorf("salt %x not masked", packet.AuthPluginData)
+	}
+}
+
+func TestEvidenceOfPooledConnection(t *testing.T) {
+	dir := useEvidence(t, nil)
+	_, network := useFakes(t)
+	target := Target{Host: "db1", Port: 3306, Protocol: ProtocolMySQL}
+	network.Serve(target.Address(), serveGreeting(greetingBytes(t)))
+
+	// The connection is opened by a first scan, and used again by the next
+	evidence.start(target)
+	conn, packet, _, err := openHandshake(target)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	if err := evidence.finish(&ScanResult{Target: target, Status: StatusMySQL}); err != nil {
+		t.Fatal(err)
+	}
+
+	evidence.start(target)
+	evidence.phase(target, "MYSQL-TEST")
+	if err := writePacket(conn, packet.header.SequenceId+1, []byte("pooled")); err != nil {
+		t.Fatal(err)
+	}
+	finding := evidenceFinding
+	finding.Check = "MYSQL-TEST"
+	if err := evidence.finish(&ScanResult{Target: target, Status: StatusMySQL, Findings: []Finding{finding}}); err != nil {
+		t.Fatal(err)
+	}
+
+	_, record, _ := readEvidence(t, dir)
+	if len(record.Exchanges) != 1 {
+		t.Fatalf("got exchanges %+v, want the write of the second scan", record.Exchanges)
+	}
+	if exchange := record.Exchanges[0]; exchange.Direction != "sent" || exchange.Connection != 1 || exchange.Bytes != 4+len("pooled") {
+		t.Errorf("got %+v", exchange)
+	}
+}
+
+func TestEvidenceOneScanAtATime(t *testing.T) {
+	useEvidence(t, nil)
+	target := Target{Host: "db1", Port: 3306, Protocol: ProtocolMySQL}
+	evidence.start(target)
+
+	started := make(chan struct{})
+	go func() {
+		evidence.start(target)
+		close(started)
+	}()
+	select {
+	case <-started:
+		t.Fatal("a second scan of the target collected while the first did")
+	case <-time.After(20 * time.Millisecond):
+	}
+	evidence.finish(&ScanResult{Target: target, Status: StatusClosed})
+	select {
+	case <-started:
+	case <-time.After(time.Second):
+		t.Fatal("the second scan didn't start once the first finished")
+	}
+	evidence.finish(&ScanResult{Target: target, Status: StatusClosed})
+}
+
+func TestEvidenceSkipsCachedResults(t *testing.T) {
+	dir := useEvidence(t, nil)
+	target := Target{Host: "db1", Port: 3306, Protocol: ProtocolMySQL}
+	evidence.start(target)
+	result := &ScanResult{Target: target, Status: StatusMySQL, CachedAt: time.Now(), Findings: []Finding{evidenceFinding}}
+	if err := evidence.finish(result); err != nil {
+		t.Fatal(err)
+	}
+	if entries, _ := os.ReadDir(dir); len(entries) != 0 {
+		t.Errorf("cached result got evidence %v", entries)
+	}
+}
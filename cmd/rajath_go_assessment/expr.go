@@ -0,0 +1,389 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+/*
+A small expression language for custom checks:
+
+	capability("CLIENT_SSL") == false && version < "8.0.28"
+	variable("local_infile") == "ON" || auth_plugin =~ "native"
+
+Values are strings, numbers and booleans. Strings are ordered as
+versions, number by number, so "8.0.9" < "8.0.28". =~ matches a regular
+expression. Names and functions are looked up in an exprEnv when the
+expression is evaluated.
+*/
+type expr func(env exprEnv) (interface{}, error)
+
+/*
+exprEnv resolves the names and calls of an expression
+*/
+type exprEnv interface {
+	lookup(name string) (interface{}, error)
+	call(name string, args []interface{}) (interface{}, error)
+}
+
+type exprToken struct {
+	kind  byte // 'i'dent, 's'tring, 'n'umber, 'o'perator or 0 at the end
+	text  string
+	value interface{}
+}
+
+type exprParser struct {
+	tokens []exprToken
+	pos    int
+	// Names the expression uses, and the functions with their literal arguments
+	names map[string]bool
+	calls map[string][]string
+}
+
+/*
+compileExpr parses source once, for it to be evaluated on every target.
+It also returns the names and the functions used, with the arguments
+given as literal strings, for callers to check them up front.
+*/
+func compileExpr(source string) (expr, map[string]bool, map[string][]string, error) {
+	tokens, err := lexExpr(source)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	p := &exprParser{tokens: tokens, names: make(map[string]bool), calls: make(map[string][]string)}
+	e, err := p.or()
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	if next := p.peek(); next.kind != 0 {
+		return nil, nil, nil, fmt.Errorf("Unexpected %q", next.text)
+	}
+	return e, p.names, p.calls, nil
+}
+
+var exprOperators = []string{"&&", "||", "==", "!=", "<=", ">=", "=~", "<", ">", "!", "(", ")", ","}
+
+func lexExpr(source string) ([]exprToken, error) {
+	var tokens []exprToken
+	for i := 0; i < len(source); {
+		c := rune(source[i])
+		switch {
+		case unicode.IsSpace(c):
+			i++
+		case c == '"' || c == '\'':
+			end := strings.IndexByte(source[i+1:], source[i])
+			if end < 0 {
+				return nil, fmt.Errorf("Unterminated string at %d", i)
+			}
+			text := source[i+1 : i+1+end]
+			tokens = append(tokens, exprToken{kind: 's', text: text, value: text})
+			i += end + 2
+		case unicode.IsDigit(c):
+			j := i
+			for j < len(source) && (unicode.IsDigit(rune(source[j])) || source[j] == '.') {
+				j++
+			}
+			number, err := strconv.ParseFloat(source[i:j], 64)
+			if err != nil {
+				return nil, fmt.Errorf("Invalid number %q, quote versions", source[i:j])
+			}
+			tokens = append(tokens, exprToken{kind: 'n', text: source[i:j], value: number})
+			i = j
+		case unicode.IsLetter(c) || c == '_':
+			j := i
+			for j < len(source) && (unicode.IsLetter(rune(source[j])) || unicode.IsDigit(rune(source[j])) || source[j] == '_') {
+				j++
+			}
+			tokens = append(tokens, exprToken{kind: 'i', text: source[i:j]})
+			i = j
+		default:
+			matched := false
+			for _, op := range exprOperators {
+				if strings.HasPrefix(source[i:], op) {
+					tokens = append(tokens, exprToken{kind: 'o', text: op})
+					i += len(op)
+					matched = true
+					break
+				}
+			}
+			if !matched {
+				return nil, fmt.Errorf("Unexpected %q at %d", c, i)
+			}
+		}
+	}
+	return tokens, nil
+}
+
+func (p *exprParser) peek() exprToken {
+	if p.pos < len(p.tokens) {
+		return p.tokens[p.pos]
+	}
+	return exprToken{}
+}
+
+func (p *exprParser) accept(op string) bool {
+	if next := p.peek(); next.kind == 'o' && next.text == op {
+		p.pos++
+		return true
+	}
+	return false
+}
+
+func (p *exprParser) or() (expr, error) {
+	left, err := p.and()
+	for err == nil && p.accept("||") {
+		var right expr
+		if right, err = p.and(); err == nil {
+			left = logical(left, right, true)
+		}
+	}
+	return left, err
+}
+
+func (p *exprParser) and() (expr, error) {
+	left, err := p.not()
+	for err == nil && p.accept("&&") {
+		var right expr
+		if right, err = p.not(); err == nil {
+			left = logical(left, right, false)
+		}
+	}
+	return left, err
+}
+
+/*
+logical short-circuits, so variable() isn't queried when the handshake
+already decides
+*/
+func logical(left, right expr, or bool) expr {
+	return func(env exprEnv) (interface{}, error) {
+		l, err := evalBool(left, env)
+		if err != nil || l == or {
+			return l, err
+		}
+		return evalBool(right, env)
+	}
+}
+
+func evalBool(e expr, env exprEnv) (bool, error) {
+	v, err := e(env)
+	if err != nil {
+		return false, err
+	}
+	b, ok := v.(bool)
+	if !ok {
+		return false, fmt.Errorf("Expected true or false, got %v", v)
+	}
+	return b, nil
+}
+
+func (p *exprParser) not() (expr, error) {
+	if p.accept("!") {
+		operand, err := p.not()
+		if err != nil {
+			return nil, err
+		}
+		return func(env exprEnv) (interface{}, error) {
+			b, err := evalBool(operand, env)
+			return !b, err
+		}, nil
+	}
+	return p.comparison()
+}
+
+func (p *exprParser) comparison() (expr, error) {
+	left, err := p.primary()
+	if err != nil {
+		return nil, err
+	}
+	next := p.peek()
+	if next.kind != 'o' {
+		return left, nil
+	}
+	switch next.text {
+	case "==", "!=", "<", "<=", ">", ">=":
+	case "=~":
+		p.pos++
+		pattern := p.peek()
+		if pattern.kind != 's' {
+			return nil, errors.New("=~ needs a quoted regular expression")
+		}
+		p.pos++
+		re, err := regexp.Compile(pattern.text)
+		if err != nil {
+			return nil, err
+		}
+		return func(env exprEnv) (interface{}, error) {
+			v, err := left(env)
+			if err != nil {
+				return nil, err
+			}
+			return re.MatchString(fmt.Sprint(v)), nil
+		}, nil
+	default:
+		return left, nil
+	}
+	p.pos++
+	right, err := p.primary()
+	if err != nil {
+		return nil, err
+	}
+	return func(env exprEnv) (interface{}, error) {
+		l, err := left(env)
+		if err != nil {
+			return nil, err
+		}
+		r, err := right(env)
+		if err != nil {
+			return nil, err
+		}
+		return compareValues(next.text, l, r)
+	}, nil
+}
+
+func (p *exprParser) primary() (expr, error) {
+	token := p.peek()
+	p.pos++
+	switch token.kind {
+	case 's', 'n':
+		return func(exprEnv) (interface{}, error) { return token.value, nil }, nil
+	case 'i':
+		switch token.text {
+		case "true", "false":
+			value := token.text == "true"
+			return func(exprEnv) (interface{}, error) { return value, nil }, nil
+		}
+		if !p.accept("(") {
+			p.names[token.text] = true
+			return func(env exprEnv) (interface{}, error) { return env.lookup(token.text) }, nil
+		}
+		literals := p.calls[token.text]
+		var args []expr
+		for !p.accept(")") {
+			if len(args) > 0 && !p.accept(",") {
+				return nil, fmt.Errorf("Expected , or ) in the arguments of %s", token.text)
+			}
+			if arg := p.peek(); arg.kind == 's' {
+				literals = append(literals, arg.text)
+			}
+			arg, err := p.or()
+			if err != nil {
+				return nil, err
+			}
+			args = append(args, arg)
+		}
+		p.calls[token.text] = literals
+		return func(env exprEnv) (interface{}, error) {
+			values := make([]interface{}, len(args))
+			for i, arg := range args {
+				v, err := arg(env)
+				if err != nil {
+					return nil, err
+				}
+				values[i] = v
+			}
+			return env.call(token.text, values)
+		}, nil
+	case 'o':
+		if token.text == "(" {
+			e, err := p.or()
+			if err != nil {
+				return nil, err
+			}
+			if !p.accept(")") {
+				return nil, errors.New("Missing )")
+			}
+			return e, nil
+		}
+		return nil, fmt.Errorf("Unexpected %q", token.text)
+	}
+	return nil, errors.New("Unexpected end of expression")
+}
+
+/*
+compareValues compares numbers as numbers, and strings as versions
+when ordered
+*/
+func compareValues(op string, l, r interface{}) (interface{}, error) {
+	var order int
+	switch lv := l.(type) {
+	case float64:
+		rv, ok := r.(float64)
+		if !ok {
+			return nil, fmt.Errorf("Can't compare %v with %v", l, r)
+		}
+		switch {
+		case lv < rv:
+			order = -1
+		case lv > rv:
+			order = 1
+		}
+	case string:
+		rv, ok := r.(string)
+		if !ok {
+			return nil, fmt.Errorf("Can't compare %q with %v", lv, r)
+		}
+		if op == "==" || op == "!=" {
+			return (lv == rv) == (op == "=="), nil
+		}
+		order = compareVersions(lv, rv)
+	case bool:
+		rv, ok := r.(bool)
+		if !ok || op != "==" && op != "!=" {
+			return nil, fmt.Errorf("Can't compare %v with %v using %s", l, r, op)
+		}
+		return (lv == rv) == (op == "=="), nil
+	default:
+		return nil, fmt.Errorf("Can't compare %v", l)
+	}
+	switch op {
+	case "==":
+		return order == 0, nil
+	case "!=":
+		return order != 0, nil
+	case "<":
+		return order < 0, nil
+	case "<=":
+		return order <= 0, nil
+	case ">":
+		return order > 0, nil
+	}
+	return order >= 0, nil
+}
+
+/*
+compareVersions orders two versions number by number, ignoring
+suffixes such as -log and MariaDB's 5.5.5- prefix
+*/
+func compareVersions(a, b string) int {
+	a, b = serverVersion(a), serverVersion(b)
+	numbers := func(version string) []int {
+		var parts []int
+		for _, field := range strings.FieldsFunc(version, func(r rune) bool { return !unicode.IsDigit(r) }) {
+			n, _ := strconv.Atoi(field)
+			parts = append(parts, n)
+		}
+		return parts
+	}
+	x, y := numbers(a), numbers(b)
+	for i := 0; i < len(x) || i < len(y); i++ {
+		var xi, yi int
+		if i < len(x) {
+			xi = x[i]
+		}
+		if i < len(y) {
+			yi = y[i]
+		}
+		if xi != yi {
+			if xi < yi {
+				return -1
+			}
+			return 1
+		}
+	}
+	return 0
+}
@@ -0,0 +1,171 @@
+package main
+
+import (
+	"fmt"
+	"log"
+)
+
+/*
+resultFilter keeps the results whose -filter expression holds, such as
+
+	version < "5.7" && tls == false
+
+It uses the custom checks' expression language, with their handshake
+names, empty for targets that sent no handshake, and the result's own.
+*/
+type resultFilter struct {
+	match expr
+}
+
+var filter = &resultFilter{}
+
+/*
+Names only -filter expressions can use, taken from the result
+*/
+var resultNames = map[string]func(r *ScanResult) interface{}{
+	"status":         func(r *ScanResult) interface{} { return r.Status },
+	"protocol":       func(r *ScanResult) interface{} { return r.Target.Protocol },
+	"error":          func(r *ScanResult) interface{} { return errorString(r.Err) },
+	"error_category": func(r *ScanResult) interface{} { return errorCategory(r.Err) },
+	"latency_ms":     func(r *ScanResult) interface{} { return float64(r.Latency.Milliseconds()) },
+	"cached":         func(r *ScanResult) interface{} { return !r.CachedAt.IsZero() },
+}
+
+func errorString(err error) string {
+	if err == nil {
+		return ""
+	}
+	return err.Error()
+}
+
+/*
+compile checks the expression's names and functions up front
+*/
+func (f *resultFilter) compile(source string) error {
+	e, names, calls, err := compileExpr(source)
+	if err != nil {
+		return fmt.Errorf("-filter: %s", err.Error())
+	}
+	for name := range names {
+		if handshakeNames[name] == nil && resultNames[name] == nil {
+			return fmt.Errorf("-filter: unknown name %s", name)
+		}
+	}
+	for function, literals := range calls {
+		for _, literal := range literals {
+			switch function {
+			case "capability":
+				if _, ok := capabilityByName(literal); !ok {
+					return fmt.Errorf("-filter: unknown capability %s", literal)
+				}
+			case "findings":
+				if _, err := parseSeverity(literal); err != nil {
+					return fmt.Errorf("-filter: %s", err.Error())
+				}
+			}
+		}
+		switch function {
+		case "capability", "finding", "findings", "tag":
+		default:
+			return fmt.Errorf("-filter: unknown function %s", function)
+		}
+	}
+	f.match = e
+	return nil
+}
+
+/*
+keep tells whether the result passes the filter. A result the
+expression can't be evaluated on, comparing a number with a string
+say, is kept and logged.
+*/
+func (f *resultFilter) keep(result *ScanResult) bool {
+	if f.match == nil {
+		return true
+	}
+	matched, err := evalBool(f.match, resultEnv{result: result})
+	if err != nil {
+		log.Printf("Filter failed on %s: %s\n", result.Target, err.Error())
+		return true
+	}
+	return matched
+}
+
+/*
+apply returns the results passing the filter
+*/
+func (f *resultFilter) apply(results []*ScanResult) []*ScanResult {
+	if f.match == nil {
+		return results
+	}
+	var kept []*ScanResult
+	for _, result := range results {
+		if f.keep(result) {
+			kept = append(kept, result)
+		}
+	}
+	return kept
+}
+
+/*
+resultEnv evaluates -filter expressions against a result
+*/
+type resultEnv struct {
+	result *ScanResult
+}
+
+func (e resultEnv) handshake() *InitialHandshakePacket {
+	if e.result.Handshake == nil {
+		return &InitialHandshakePacket{}
+	}
+	return e.result.Handshake
+}
+
+func (e resultEnv) lookup(name string) (interface{}, error) {
+	if value, ok := resultNames[name]; ok {
+		return value(e.result), nil
+	}
+	return handshakeNames[name](e.result.Target, e.handshake()), nil
+}
+
+/*
+call serves capability("CLIENT_SSL"), tag("env"), finding("RULE-ID"),
+true when the result has that finding, and findings("high"), the
+number of findings of that severity or above, or of any with no argument
+*/
+func (e resultEnv) call(name string, args []interface{}) (interface{}, error) {
+	if name == "findings" && len(args) == 0 {
+		return float64(len(e.result.Findings)), nil
+	}
+	if len(args) != 1 {
+		return nil, fmt.Errorf("%s takes one argument", name)
+	}
+	arg := fmt.Sprint(args[0])
+	switch name {
+	case "capability":
+		flag, _ := capabilityByName(arg)
+		return e.handshake().CapabilitiesFlags.Has(flag), nil
+	case "tag":
+		return e.result.Tags[arg], nil
+	case "finding":
+		for _, finding := range e.result.Findings {
+			if finding.RuleID == arg {
+				return true, nil
+			}
+		}
+		return false, nil
+	case "findings":
+		severity, err := parseSeverity(arg)
+		if err != nil {
+			return nil, err
+		}
+		count := 0
+		for _, finding := range e.result.Findings {
+			if finding.Severity >= severity {
+				count++
+			}
+		}
+		return float64(count), nil
+	}
+	return nil, fmt.Errorf("Unknown function %s", name)
+}
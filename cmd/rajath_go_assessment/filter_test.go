@@ -0,0 +1,30 @@
+package main
+
+import "testing"
+
+func TestFilterVersions(t *testing.T) {
+	f := &resultFilter{}
+	if err := f.compile(`version < "5.7" && tls == false`); err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		version string
+		kept    bool
+	}{
+		{"5.6.51-log", true},
+		{"8.0.32", false},
+		{"5.5.5-10.6.12-MariaDB", false},
+		{"5.5.5-5.5.68-MariaDB", true},
+	}
+	for _, test := range tests {
+		result := &ScanResult{Target: Target{Host: "db1", Port: 3306}, Status: StatusMySQL, Handshake: &InitialHandshakePacket{ServerVersion: []byte(test.version)}}
+		if kept := f.keep(result); kept != test.kept {
+			t.Errorf("%s: kept %v, want %v", test.version, kept, test.kept)
+		}
+	}
+
+	// Targets that sent no handshake have an empty version
+	if !f.keep(&ScanResult{Target: Target{Host: "db2", Port: 3306}, Status: StatusClosed}) {
+		t.Error("a closed target was filtered out")
+	}
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+/*
+Severity ranks how serious a finding is
+*/
+type Severity int
+
+const (
+	SeverityInfo Severity = iota
+	SeverityLow
+	SeverityMedium
+	SeverityHigh
+	SeverityCritical
+)
+
+var severityNames = map[Severity]string{
+	SeverityInfo:     "info",
+	SeverityLow:      "low",
+	SeverityMedium:   "medium",
+	SeverityHigh:     "high",
+	SeverityCritical: "critical",
+}
+
+func (s Severity) String() string {
+	name, ok := severityNames[s]
+	if !ok {
+		return fmt.Sprintf("severity(%d)", int(s))
+	}
+	return name
+}
+
+/*
+parseSeverity returns the severity with the given name
+*/
+func parseSeverity(name string) (Severity, error) {
+	for severity, n := range severityNames {
+		if strings.EqualFold(n, name) {
+			return severity, nil
+		}
+	}
+	return SeverityInfo, fmt.Errorf("Unknown severity %q", name)
+}
+
+/*
+Finding represents a single issue detected on a target
+*/
+type Finding struct {
+	RuleID   string
+	Severity Severity
+	Title    string
+	Detail   string
+	// Check that reported it, empty for findings made outside of checks
+	Check string
+}
+
+func (f Finding) String() string {
+	if f.Detail == "" {
+		return fmt.Sprintf("[%s] %s: %s", f.Severity, f.RuleID, f.Title)
+	}
+	return fmt.Sprintf("[%s] %s: %s (%s)", f.Severity, f.RuleID, f.Title, f.Detail)
+}
+
+/*
+maxSeverity returns the highest severity among the findings
+*/
+func maxSeverity(findings []Finding) (Severity, bool) {
+	if len(findings) == 0 {
+		return SeverityInfo, false
+	}
+
+	max := findings[0].Severity
+	for _, f := range findings[1:] {
+		if f.Severity > max {
+			max = f.Severity
+		}
+	}
+	return max, true
+}
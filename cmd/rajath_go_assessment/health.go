@@ -0,0 +1,121 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"net"
+	"net/http"
+	"sync/atomic"
+	"time"
+)
+
+/*
+How long targets can be in progress without any finishing or starting
+before /healthz reports the scanner as stuck. Intrusive checks pausing
+between logins take minutes, not this long.
+*/
+const healthStallTimeout = 15 * time.Minute
+
+/*
+scanHealth tracks the workers for the -health-addr endpoints, which
+Kubernetes liveness and readiness probes poll in watch, coordinator
+and agent mode
+*/
+type scanHealth struct {
+	// Set once the scanner is set up and scanning or serving
+	ready atomic.Bool
+	// Targets being scanned, and scanned since start up
+	busy    atomic.Int64
+	scanned atomic.Int64
+	// When a target last started or finished, in Unix nanoseconds
+	progress atomic.Int64
+}
+
+var health = &scanHealth{}
+
+/*
+start notes a target scan starting, the returned function its end
+*/
+func (h *scanHealth) start() func() {
+	h.busy.Add(1)
+	h.progress.Store(clock.Now().UnixNano())
+	return func() {
+		h.busy.Add(-1)
+		h.scanned.Add(1)
+		h.progress.Store(clock.Now().UnixNano())
+	}
+}
+
+/*
+HealthStatus is the JSON body of /healthz and /readyz
+*/
+type HealthStatus struct {
+	Status       string     `json:"status"`
+	Reason       string     `json:"reason,omitempty"`
+	Busy         int64      `json:"busy_workers"`
+	Scanned      int64      `json:"scanned"`
+	LastProgress *time.Time `json:"last_progress,omitempty"`
+	Sink         *SinkState `json:"sink,omitempty"`
+}
+
+func (h *scanHealth) status() HealthStatus {
+	status := HealthStatus{Status: "ok", Busy: h.busy.Load(), Scanned: h.scanned.Load(), Sink: sink.state()}
+	if progress := h.progress.Load(); progress != 0 {
+		at := time.Unix(0, progress).UTC()
+		status.LastProgress = &at
+	}
+	return status
+}
+
+/*
+live fails when workers are busy but nothing moved for too long, as a
+restart is all that helps then
+*/
+func (h *scanHealth) live() HealthStatus {
+	status := h.status()
+	if status.Busy > 0 && status.LastProgress != nil && clock.Now().Sub(*status.LastProgress) > healthStallTimeout {
+		status.Status, status.Reason = "stalled", "no target started or finished in "+healthStallTimeout.String()
+	}
+	return status
+}
+
+/*
+readiness fails until the scanner is set up, and while the sink can't
+take results: its last delivery failed, or it is full and blocking the scan
+*/
+func (h *scanHealth) readiness() HealthStatus {
+	status := h.status()
+	switch {
+	case !h.ready.Load():
+		status.Status, status.Reason = "starting", "setting up"
+	case status.Sink != nil && status.Sink.LastError != "":
+		status.Status, status.Reason = "unready", "sink: "+status.Sink.LastError
+	case status.Sink != nil && status.Sink.Blocking:
+		status.Status, status.Reason = "unready", "sink queue is full"
+	}
+	return status
+}
+
+func writeHealth(w http.ResponseWriter, status HealthStatus) {
+	w.Header().Set("Content-Type", "application/json")
+	if status.Status != "ok" {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+	json.NewEncoder(w).Encode(status)
+}
+
+/*
+serve serves /healthz and /readyz on addr in the background
+*/
+func (h *scanHealth) serve(addr string) error {
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/healthz", func(w http.ResponseWriter, r *http.Request) { writeHealth(w, h.live()) })
+	mux.HandleFunc("/readyz", func(w http.ResponseWriter, r *http.Request) { writeHealth(w, h.readiness()) })
+	log.Printf("Health endpoints listening on %s\n", listener.Addr())
+	go http.Serve(listener, mux)
+	return nil
+}
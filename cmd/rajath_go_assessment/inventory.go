@@ -0,0 +1,134 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+/*
+ExpectedServer is one entry of an expected inventory file. Version is
+matched as a prefix of the advertised version ("8.0" matches
+"8.0.36"), and TLS, when set, must match whether TLS is offered.
+*/
+type ExpectedServer struct {
+	Host    string `json:"host"`
+	Port    int    `json:"port"`
+	Version string `json:"version,omitempty"`
+	TLS     *bool  `json:"tls,omitempty"`
+}
+
+func (e ExpectedServer) Target() Target {
+	return Target{Host: e.Host, Port: e.Port, Protocol: ProtocolMySQL}
+}
+
+/*
+Inventory is the set of servers expected to be live, keyed by address.
+Hosts are compared as written, without resolving names.
+*/
+type Inventory map[string]ExpectedServer
+
+/*
+loadInventory reads a JSON array of expected servers, such as
+
+	[{"host": "db1", "port": 3306, "version": "8.0", "tls": true}]
+
+which is easy to produce from Terraform outputs or a CMDB export
+*/
+func loadInventory(path string) (Inventory, error) {
+	data, err := readCompressed(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var servers []ExpectedServer
+	if err := json.Unmarshal(data, &servers); err != nil {
+		return nil, fmt.Errorf("%s: %s", path, err.Error())
+	}
+
+	inventory := make(Inventory)
+	for i, server := range servers {
+		if server.Host == "" || server.Port <= 0 {
+			return nil, fmt.Errorf("%s: entry %d needs a host and a port", path, i+1)
+		}
+		inventory[server.Target().Address()] = server
+	}
+	return inventory, nil
+}
+
+/*
+withExpected adds every expected server missing from targets, so the
+missing ones are probed too
+*/
+func (inv Inventory) withExpected(targets []Target) []Target {
+	seen := make(map[string]bool)
+	for _, t := range targets {
+		seen[t.Address()] = true
+	}
+	var missing []string
+	for address := range inv {
+		if !seen[address] {
+			missing = append(missing, address)
+		}
+	}
+	sort.Strings(missing)
+	for _, address := range missing {
+		targets = append(targets, inv[address].Target())
+	}
+	return targets
+}
+
+/*
+reconcile compares a scan result with the inventory. Only classic
+client ports are reconciled, admin and group replication ports have
+their own findings.
+*/
+func (inv Inventory) reconcile(result *ScanResult) []Finding {
+	if inv == nil || result.Target.Role != RoleClient || result.Target.Protocol != ProtocolMySQL {
+		return nil
+	}
+
+	address := result.Target.Address()
+	expected, known := inv[address]
+	live := !result.Failed()
+
+	switch {
+	case !known && live:
+		return []Finding{{
+			RuleID:   "MYSQL-INVENTORY-UNEXPECTED",
+			Severity: SeverityMedium,
+			Title:    "Live server is not in the expected inventory",
+			Detail:   address,
+		}}
+	case known && !live:
+		return []Finding{{
+			RuleID:   "MYSQL-INVENTORY-MISSING",
+			Severity: SeverityMedium,
+			Title:    "Expected server is not answering",
+			Detail:   fmt.Sprintf("%s: %v", address, result.Err),
+		}}
+	case !known || result.Handshake == nil:
+		return nil
+	}
+
+	var findings []Finding
+	// Without MariaDB's 5.5.5- prefix, which no inventory lists
+	if version := serverVersion(result.Version()); expected.Version != "" && !strings.HasPrefix(version, expected.Version) {
+		findings = append(findings, Finding{
+			RuleID:   "MYSQL-INVENTORY-MISMATCH",
+			Severity: SeverityLow,
+			Title:    "Server version differs from the inventory",
+			Detail:   fmt.Sprintf("expected %s, got %s", expected.Version, version),
+		})
+	}
+	if tls := result.Handshake.CapabilitiesFlags.Has(clientSSL); expected.TLS != nil && tls != *expected.TLS {
+		findings = append(findings, Finding{
+			RuleID:   "MYSQL-INVENTORY-MISMATCH",
+			Severity: SeverityMedium,
+			Title:    "Server TLS support differs from the inventory",
+			Detail:   fmt.Sprintf("expected TLS %t, got %t", *expected.TLS, tls),
+		})
+	}
+	return findings
+}
@@ -0,0 +1,24 @@
+package main
+
+import "testing"
+
+func TestInventoryVersions(t *testing.T) {
+	target := Target{Host: "db1", Port: 3306, Protocol: ProtocolMySQL, Role: RoleClient}
+	tests := []struct {
+		expected, version string
+		mismatch          bool
+	}{
+		{"8.0", "8.0.32", false},
+		{"8.0", "5.7.44-log", true},
+		{"10.6", "5.5.5-10.6.12-MariaDB", false},
+		{"10.11", "5.5.5-10.6.12-MariaDB", true},
+	}
+	for _, test := range tests {
+		inv := Inventory{target.Address(): {Host: "db1", Port: 3306, Version: test.expected}}
+		result := &ScanResult{Target: target, Status: StatusMySQL, Handshake: &InitialHandshakePacket{ServerVersion: []byte(test.version)}}
+		findings := inv.reconcile(result)
+		if (len(findings) == 1) != test.mismatch {
+			t.Errorf("%s in an inventory of %s: got findings %v, want a mismatch %v", test.version, test.expected, findings, test.mismatch)
+		}
+	}
+}
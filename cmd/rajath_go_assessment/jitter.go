@@ -0,0 +1,77 @@
+package main
+
+import (
+	"fmt"
+	"math/rand"
+	"strings"
+	"time"
+)
+
+/*
+probeJitter is the random pause -jitter puts between the connections of
+each worker, so workers don't probe in lockstep bursts that intrusion
+and anomaly detection pick up. Each worker draws from its own source,
+seeded from -seed and its number, so a seed repeats the same pauses.
+*/
+type probeJitter struct {
+	min, max time.Duration
+	seed     int64
+}
+
+var jitter = &probeJitter{}
+
+func (j *probeJitter) String() string {
+	if j == nil || j.max == 0 {
+		return ""
+	}
+	return j.min.String() + "-" + j.max.String()
+}
+
+/*
+Set parses -jitter, a range such as 0-500ms or 100ms-2s, or a single
+duration for a range starting at 0. The unit of the upper bound applies
+to a lower bound without one.
+*/
+func (j *probeJitter) Set(value string) error {
+	low, high, isRange := strings.Cut(strings.TrimSpace(value), "-")
+	if !isRange {
+		low, high = "0", low
+	}
+	max, err := time.ParseDuration(strings.TrimSpace(high))
+	if err != nil || max < 0 {
+		return fmt.Errorf("Invalid jitter %q", value)
+	}
+	low = strings.TrimSpace(low)
+	min, err := time.ParseDuration(low)
+	if err != nil {
+		min, err = time.ParseDuration(low + strings.TrimLeft(strings.TrimSpace(high), "0123456789."))
+	}
+	if err != nil || min < 0 || min > max {
+		return fmt.Errorf("Invalid jitter %q", value)
+	}
+	j.min, j.max = min, max
+	return nil
+}
+
+func (j *probeJitter) enabled() bool {
+	return j.max > 0
+}
+
+/*
+worker returns the pause of worker i, taken before each of its targets
+but the first. It does nothing without -jitter.
+*/
+func (j *probeJitter) worker(i int) func() {
+	if !j.enabled() {
+		return func() {}
+	}
+	source := rand.New(rand.NewSource(j.seed + int64(i)))
+	first := true
+	return func() {
+		if first {
+			first = false
+			return
+		}
+		clock.Sleep(j.min + time.Duration(source.Int63n(int64(j.max-j.min)+1)))
+	}
+}
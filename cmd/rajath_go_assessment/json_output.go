@@ -0,0 +1,301 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	_ "embed"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/santhosh-tekuri/jsonschema/v5"
+)
+
+/*
+Version of schema/result.schema.json written into every JSON record.
+The minor version goes up when fields are added, the major version
+when a field is removed or changes meaning.
+*/
+const resultSchemaVersion = "1.12"
+
+const resultSchemaURL = "https://github.com/avrajath/rajath_go_assessment/schema/result/1.json"
+
+//go:embed schema/result.schema.json
+var resultSchema []byte
+
+/*
+JSONRecord is one line of -output json, the result of one target
+*/
+type JSONRecord struct {
+	SchemaVersion string         `json:"schema_version"`
+	RunID         string         `json:"run_id"`
+	StartedAt     time.Time      `json:"started_at"`
+	EndedAt       time.Time      `json:"ended_at"`
+	Target        JSONTarget     `json:"target"`
+	Status        string         `json:"status"`
+	Error         string         `json:"error,omitempty"`
+	ErrorCategory string         `json:"error_category,omitempty"`
+	LatencyMs     float64        `json:"latency_ms"`
+	Handshake     *JSONHandshake `json:"handshake,omitempty"`
+	AnomalyScore  int            `json:"anomaly_score"`
+	Findings      []JSONFinding  `json:"findings"`
+	Tags          Tags           `json:"tags,omitempty"`
+	Traffic       *JSONTraffic   `json:"traffic,omitempty"`
+	CachedAt      *time.Time     `json:"cached_at,omitempty"`
+	// Addresses connected to the server, sampled with -processlist
+	Processlist []JSONConnectedSource `json:"processlist,omitempty"`
+	// Server the credentialed statements ran on, behind a proxy its backend
+	SessionServer *JSONSessionServer `json:"session_server,omitempty"`
+}
+
+type JSONTarget struct {
+	ID       string `json:"id"`
+	Label    string `json:"label"`
+	Host     string `json:"host,omitempty"`
+	Port     int    `json:"port,omitempty"`
+	Pipe     string `json:"pipe,omitempty"`
+	Protocol string `json:"protocol"`
+	Role     string `json:"role,omitempty"`
+}
+
+type JSONHandshake struct {
+	ProtocolVersion uint8    `json:"protocol_version"`
+	ServerVersion   string   `json:"server_version"`
+	ConnectionID    uint32   `json:"connection_id"`
+	Capabilities    uint32   `json:"capabilities"`
+	CapabilityNames []string `json:"capability_names"`
+	CharacterSet    uint8    `json:"character_set"`
+	StatusFlags     uint16   `json:"status_flags"`
+	AuthPlugin      string   `json:"auth_plugin"`
+	// MariaDB's own capabilities, and those of them without a name
+	MariaDBCapabilityNames []string `json:"mariadb_capability_names,omitempty"`
+	UnknownBits            string   `json:"unknown_bits,omitempty"`
+	// Legacy encodings the greeting was decoded despite
+	Warnings []JSONDecodeWarning `json:"warnings,omitempty"`
+}
+
+type JSONDecodeWarning struct {
+	Code    string   `json:"code"`
+	Field   string   `json:"field"`
+	Detail  string   `json:"detail"`
+	FixedIn []string `json:"fixed_in,omitempty"`
+}
+
+func newJSONDecodeWarnings(warnings []DecodeWarning) []JSONDecodeWarning {
+	var records []JSONDecodeWarning
+	for _, w := range warnings {
+		records = append(records, JSONDecodeWarning{Code: w.Code, Field: w.Field, Detail: w.Detail, FixedIn: w.FixedIn})
+	}
+	return records
+}
+
+type JSONSessionServer struct {
+	ConnectionID uint32 `json:"connection_id"`
+	Hostname     string `json:"hostname"`
+	Port         int    `json:"port"`
+	ServerUUID   string `json:"server_uuid,omitempty"`
+	Proxied      bool   `json:"proxied"`
+}
+
+type JSONConnectedSource struct {
+	Address  string   `json:"address"`
+	Sessions int      `json:"sessions"`
+	Users    []string `json:"users"`
+}
+
+type JSONFinding struct {
+	RuleID   string `json:"rule_id"`
+	Check    string `json:"check,omitempty"`
+	Severity string `json:"severity"`
+	Title    string `json:"title"`
+	Detail   string `json:"detail,omitempty"`
+}
+
+type JSONTraffic struct {
+	Sent     int64 `json:"sent"`
+	Received int64 `json:"received"`
+}
+
+func newJSONRecord(result *ScanResult) *JSONRecord {
+	target := result.Target
+	record := &JSONRecord{
+		SchemaVersion: resultSchemaVersion,
+		RunID:         runID,
+		StartedAt:     result.StartedAt,
+		EndedAt:       result.EndedAt,
+		Target: JSONTarget{
+			ID:       result.TargetID(),
+			Label:    target.Label(),
+			Protocol: target.Protocol,
+			Role:     target.Role,
+		},
+		Status:       result.Status,
+		LatencyMs:    float64(result.Latency) / float64(time.Millisecond),
+		AnomalyScore: result.Anomaly().Score,
+		Findings:     []JSONFinding{},
+		Tags:         result.Tags,
+	}
+	if target.Pipe != "" {
+		record.Target.Pipe = target.Pipe
+	} else {
+		record.Target.Host = target.Host
+		record.Target.Port = target.Port
+	}
+	if result.Err != nil {
+		record.Error = result.Err.Error()
+		record.ErrorCategory = errorCategory(result.Err)
+	}
+	if result.Handshake != nil {
+		info := result.Handshake.Info()
+		record.Handshake = &JSONHandshake{
+			ProtocolVersion: info.ProtocolVersion,
+			ServerVersion:   info.ServerVersion,
+			ConnectionID:    info.ConnectionID,
+			Capabilities:    uint32(info.Capabilities),
+			CapabilityNames: info.Capabilities.Names(),
+			CharacterSet:    info.CharacterSet,
+			StatusFlags:     info.StatusFlags,
+			AuthPlugin:      info.AuthPluginName,
+		}
+		record.Handshake.MariaDBCapabilityNames = info.MariaDBCapabilities.Names()
+		if unknown := info.MariaDBCapabilities.Unknown(); unknown != 0 {
+			record.Handshake.UnknownBits = fmt.Sprintf("0x%08x", uint32(unknown))
+		}
+		record.Handshake.Warnings = newJSONDecodeWarnings(result.Handshake.Warnings)
+	}
+	for _, f := range result.Findings {
+		record.Findings = append(record.Findings, JSONFinding{
+			RuleID:   f.RuleID,
+			Check:    f.Check,
+			Severity: f.Severity.String(),
+			Title:    f.Title,
+			Detail:   f.Detail,
+		})
+	}
+	if !result.CachedAt.IsZero() {
+		at := result.CachedAt.UTC()
+		record.CachedAt = &at
+	}
+	if result.Traffic != nil {
+		record.Traffic = &JSONTraffic{Sent: result.Traffic.Sent(), Received: result.Traffic.Received()}
+	}
+	for _, source := range result.Processlist {
+		record.Processlist = append(record.Processlist, JSONConnectedSource{Address: source.Address, Sessions: source.Sessions, Users: source.Users})
+	}
+	if server := result.Server; server != nil {
+		record.SessionServer = &JSONSessionServer{
+			ConnectionID: server.ConnectionID,
+			Hostname:     server.Hostname,
+			Port:         server.Port,
+			ServerUUID:   server.ServerUUID,
+			Proxied:      server.Proxied(),
+		}
+	}
+	return record
+}
+
+/*
+writeJSON writes one JSON record per line, so results can be streamed
+and processed line by line
+*/
+func writeJSON(w io.Writer, results []*ScanResult) error {
+	encoder := json.NewEncoder(w)
+	for _, result := range results {
+		if err := encoder.Encode(newJSONRecord(result)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func compileResultSchema() (*jsonschema.Schema, error) {
+	compiler := jsonschema.NewCompiler()
+	if err := compiler.AddResource(resultSchemaURL, bytes.NewReader(resultSchema)); err != nil {
+		return nil, err
+	}
+	return compiler.Compile(resultSchemaURL)
+}
+
+/*
+validateRecords checks every line of a -output json file against the
+schema, returning one error per invalid line
+*/
+func validateRecords(r io.Reader) (int, []error, error) {
+	schema, err := compileResultSchema()
+	if err != nil {
+		return 0, nil, err
+	}
+
+	var invalid []error
+	records := 0
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 16<<20)
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" {
+			continue
+		}
+		records++
+
+		decoder := json.NewDecoder(strings.NewReader(text))
+		decoder.UseNumber()
+		var record interface{}
+		if err := decoder.Decode(&record); err != nil {
+			invalid = append(invalid, fmt.Errorf("line %d: %s", line, err.Error()))
+			continue
+		}
+		if err := schema.Validate(record); err != nil {
+			var validationErr *jsonschema.ValidationError
+			if errors.As(err, &validationErr) {
+				err = errors.New(strings.TrimSpace(fmt.Sprintf("%#v", validationErr)))
+			}
+			invalid = append(invalid, fmt.Errorf("line %d: %s", line, err.Error()))
+		}
+	}
+	return records, invalid, scanner.Err()
+}
+
+/*
+runValidate implements the validate subcommand
+*/
+func runValidate(args []string) int {
+	flags := flag.NewFlagSet("validate", flag.ExitOnError)
+	printSchema := flags.Bool("schema", false, "print the JSON Schema instead of validating")
+	flags.Parse(args)
+
+	if *printSchema {
+		os.Stdout.Write(resultSchema)
+		return 0
+	}
+	if flags.NArg() != 1 {
+		fmt.Println("Usage: ./bin/rajath_go_assessment validate [-schema] results.json")
+		return 2
+	}
+
+	file, err := openCompressed(flags.Arg(0))
+	if err != nil {
+		fmt.Println(err.Error())
+		return 1
+	}
+	defer file.Close()
+
+	records, invalid, err := validateRecords(file)
+	if err != nil {
+		fmt.Println(err.Error())
+		return 1
+	}
+	for _, err := range invalid {
+		fmt.Println(err.Error())
+	}
+	if len(invalid) > 0 {
+		fmt.Printf("%d of %d records don't match schema version %s\n", len(invalid), records, resultSchemaVersion)
+		return 1
+	}
+	fmt.Printf("%d records match schema version %s\n", records, resultSchemaVersion)
+	return 0
+}
@@ -0,0 +1,175 @@
+package main
+
+import (
+	"encoding/xml"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+)
+
+/*
+Findings at or above this severity fail their test case, lower ones
+are only listed in its output. Set with -fail-severity.
+*/
+var junitFailSeverity = SeverityLow
+
+type junitTestSuites struct {
+	XMLName  xml.Name         `xml:"testsuites"`
+	Name     string           `xml:"name,attr"`
+	Tests    int              `xml:"tests,attr"`
+	Failures int              `xml:"failures,attr"`
+	Errors   int              `xml:"errors,attr"`
+	Suites   []junitTestSuite `xml:"testsuite"`
+}
+
+type junitTestSuite struct {
+	Name       string          `xml:"name,attr"`
+	Tests      int             `xml:"tests,attr"`
+	Failures   int             `xml:"failures,attr"`
+	Errors     int             `xml:"errors,attr"`
+	Time       string          `xml:"time,attr"`
+	Timestamp  string          `xml:"timestamp,attr,omitempty"`
+	Properties []junitProperty `xml:"properties>property,omitempty"`
+	Cases      []junitTestCase `xml:"testcase"`
+}
+
+/*
+Scan tags become test suite properties
+*/
+type junitProperty struct {
+	Name  string `xml:"name,attr"`
+	Value string `xml:"value,attr"`
+}
+
+type junitTestCase struct {
+	ClassName string        `xml:"classname,attr"`
+	Name      string        `xml:"name,attr"`
+	Failure   *junitProblem `xml:"failure,omitempty"`
+	Error     *junitProblem `xml:"error,omitempty"`
+	SystemOut string        `xml:"system-out,omitempty"`
+}
+
+type junitProblem struct {
+	Message string `xml:"message,attr"`
+	Type    string `xml:"type,attr"`
+	Text    string `xml:",chardata"`
+}
+
+/*
+junitCase turns the findings of one check into a test case, failed when
+any of them reaches junitFailSeverity
+*/
+func junitCase(target Target, name string, findings []Finding) junitTestCase {
+	tc := junitTestCase{ClassName: target.Label(), Name: name}
+
+	var failed, passed []string
+	worst := SeverityInfo
+	for _, f := range findings {
+		if f.Severity >= junitFailSeverity {
+			failed = append(failed, f.String())
+			if f.Severity > worst {
+				worst = f.Severity
+			}
+			continue
+		}
+		passed = append(passed, f.String())
+	}
+
+	if len(failed) > 0 {
+		tc.Failure = &junitProblem{
+			Message: fmt.Sprintf("%d finding(s) at or above %s", len(failed), junitFailSeverity),
+			Type:    worst.String(),
+			Text:    strings.Join(failed, "\n"),
+		}
+	}
+	if len(passed) > 0 {
+		tc.SystemOut = strings.Join(passed, "\n")
+	}
+	return tc
+}
+
+/*
+junitSuite makes one test suite per target with a test case per check
+run. Findings made outside of checks get a test case per rule, and a
+target that couldn't be scanned gets an errored "scan" test case.
+*/
+func junitSuite(result *ScanResult) junitTestSuite {
+	suite := junitTestSuite{
+		Name: result.Target.Label(),
+		Time: fmt.Sprintf("%.3f", result.Latency.Seconds()),
+	}
+	if !result.StartedAt.IsZero() {
+		suite.Timestamp = result.StartedAt.Format(time.RFC3339Nano)
+	}
+	suite.Properties = append(suite.Properties,
+		junitProperty{Name: "target_id", Value: result.TargetID()},
+		junitProperty{Name: "run_id", Value: runID})
+	for _, key := range result.Tags.keys() {
+		suite.Properties = append(suite.Properties, junitProperty{Name: key, Value: result.Tags[key]})
+	}
+
+	if result.Failed() {
+		suite.Cases = []junitTestCase{{
+			ClassName: result.Target.Label(),
+			Name:      "scan",
+			Error:     &junitProblem{Message: result.Status, Type: result.Status, Text: fmt.Sprint(result.Err)},
+		}}
+	}
+
+	byCheck := make(map[string][]Finding)
+	var other []string
+	for _, f := range result.Findings {
+		name := f.Check
+		if name == "" {
+			name = f.RuleID
+			if _, seen := byCheck[name]; !seen {
+				other = append(other, name)
+			}
+		}
+		byCheck[name] = append(byCheck[name], f)
+	}
+	for _, name := range append(append([]string(nil), result.ChecksRun...), other...) {
+		suite.Cases = append(suite.Cases, junitCase(result.Target, name, byCheck[name]))
+	}
+	if len(suite.Cases) == 0 {
+		suite.Cases = []junitTestCase{{ClassName: result.Target.Label(), Name: "scan"}}
+	}
+
+	for _, tc := range suite.Cases {
+		suite.Tests++
+		if tc.Failure != nil {
+			suite.Failures++
+		}
+		if tc.Error != nil {
+			suite.Errors++
+		}
+	}
+	return suite
+}
+
+/*
+writeJUnit writes the results as JUnit XML so CI pipelines can gate on
+them and show them like test results
+*/
+func writeJUnit(w io.Writer, results []*ScanResult) error {
+	report := junitTestSuites{Name: toolName}
+	for _, result := range results {
+		suite := junitSuite(result)
+		report.Tests += suite.Tests
+		report.Failures += suite.Failures
+		report.Errors += suite.Errors
+		report.Suites = append(report.Suites, suite)
+	}
+
+	if _, err := io.WriteString(w, xml.Header); err != nil {
+		return err
+	}
+	encoder := xml.NewEncoder(w)
+	encoder.Indent("", "  ")
+	if err := encoder.Encode(report); err != nil {
+		return err
+	}
+	_, err := io.WriteString(w, "\n")
+	return err
+}
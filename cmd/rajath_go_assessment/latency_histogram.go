@@ -0,0 +1,255 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+/*
+Histogram buckets grow exponentially by histogramFactor from
+histogramMin, so every bucket spans the same relative error (about 41%)
+whether latency is measured in microseconds or seconds
+*/
+const (
+	histogramMin     = 100 * time.Microsecond
+	histogramFactor  = math.Sqrt2
+	histogramBuckets = 41
+)
+
+/*
+histogramBounds are the bucket upper bounds, from 100µs to about 105s.
+Anything slower falls in the last, unbounded bucket.
+*/
+var histogramBounds = func() []time.Duration {
+	bounds := make([]time.Duration, histogramBuckets)
+	for i := range bounds {
+		bounds[i] = time.Duration(float64(histogramMin) * math.Pow(histogramFactor, float64(i)))
+	}
+	return bounds
+}()
+
+/*
+latencyHistogram counts latencies per exponential bucket
+*/
+type latencyHistogram struct {
+	// One more than the bounds, for the unbounded bucket
+	counts []uint64
+	count  uint64
+	sum    time.Duration
+}
+
+func newLatencyHistogram() *latencyHistogram {
+	return &latencyHistogram{counts: make([]uint64, histogramBuckets+1)}
+}
+
+func (h *latencyHistogram) observe(d time.Duration) {
+	i := sort.Search(len(histogramBounds), func(i int) bool { return d <= histogramBounds[i] })
+	h.counts[i]++
+	h.count++
+	h.sum += d
+}
+
+/*
+quantile returns the upper bound of the bucket holding the q quantile,
+or -1 when it is in the unbounded bucket
+*/
+func (h *latencyHistogram) quantile(q float64) time.Duration {
+	rank := uint64(math.Ceil(q * float64(h.count)))
+	var seen uint64
+	for i, n := range h.counts[:histogramBuckets] {
+		seen += n
+		if seen >= rank {
+			return histogramBounds[i]
+		}
+	}
+	return -1
+}
+
+func (h *latencyHistogram) String() string {
+	quantiles := []float64{0.5, 0.9, 0.99}
+	parts := []string{fmt.Sprintf("count %d", h.count)}
+	for _, q := range quantiles {
+		bound := "more than " + histogramBounds[histogramBuckets-1].Round(time.Millisecond).String()
+		if d := h.quantile(q); d >= 0 {
+			bound = "at most " + d.Round(time.Microsecond).String()
+		}
+		parts = append(parts, fmt.Sprintf("p%g %s", q*100, bound))
+	}
+	return strings.Join(parts, ", ")
+}
+
+/*
+fleetLatency aggregates handshake latency across every target, with a
+histogram per combination of the -latency-by tag values
+*/
+type fleetLatency struct {
+	mu sync.Mutex
+	// Tag keys results are grouped by, none for a single histogram
+	by     []string
+	groups map[string]*latencyHistogram
+	values map[string][]string
+	// Prometheus textfile the histograms are saved to, none when empty
+	path string
+}
+
+var latencies = &fleetLatency{groups: make(map[string]*latencyHistogram), values: make(map[string][]string)}
+
+/*
+observe adds the result's latency to its group. Targets that didn't
+send a handshake have no latency worth comparing.
+*/
+func (f *fleetLatency) observe(result *ScanResult) {
+	if result.Handshake == nil && result.XCapabilities == nil {
+		return
+	}
+
+	values := make([]string, len(f.by))
+	for i, key := range f.by {
+		values[i] = result.Tags[key]
+	}
+	group := strings.Join(values, "\x00")
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	h, ok := f.groups[group]
+	if !ok {
+		h = newLatencyHistogram()
+		f.groups[group] = h
+		f.values[group] = values
+	}
+	h.observe(result.Latency)
+}
+
+/*
+sortedGroups returns the groups ordered by their tag values
+*/
+func (f *fleetLatency) sortedGroups() []string {
+	groups := make([]string, 0, len(f.groups))
+	for group := range f.groups {
+		groups = append(groups, group)
+	}
+	sort.Strings(groups)
+	return groups
+}
+
+/*
+summary returns a line per group, like
+
+	Handshake latency dc=eu1: count 12, p50 at most 1.6ms, ...
+*/
+func (f *fleetLatency) summary() []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	var lines []string
+	for _, group := range f.sortedGroups() {
+		label := ""
+		for i, key := range f.by {
+			label += fmt.Sprintf(" %s=%s", key, f.values[group][i])
+		}
+		lines = append(lines, fmt.Sprintf(tr("Handshake latency%s: %s"), label, f.groups[group]))
+	}
+	return lines
+}
+
+/*
+writeMetrics writes the histograms in the Prometheus text exposition
+format, one label per -latency-by tag
+*/
+func (f *fleetLatency) writeMetrics(w io.Writer) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	const name = "rajath_handshake_latency_seconds"
+	fmt.Fprintf(w, "# HELP %s Time to connect to a target and receive its handshake.\n", name)
+	fmt.Fprintf(w, "# TYPE %s histogram\n", name)
+	for _, group := range f.sortedGroups() {
+		h := f.groups[group]
+		var labels []string
+		for i, key := range f.by {
+			labels = append(labels, metricLabel(key)+`="`+labelEscaper.Replace(f.values[group][i])+`"`)
+		}
+
+		var cumulative uint64
+		for i, n := range h.counts {
+			cumulative += n
+			le := "+Inf"
+			if i < histogramBuckets {
+				le = strconv.FormatFloat(histogramBounds[i].Seconds(), 'g', -1, 64)
+			}
+			fmt.Fprintf(w, "%s_bucket{%s} %d\n", name, strings.Join(append(labels, `le="`+le+`"`), ","), cumulative)
+		}
+		fmt.Fprintf(w, "%s_sum%s %g\n", name, labelSet(labels), h.sum.Seconds())
+		if _, err := fmt.Fprintf(w, "%s_count%s %d\n", name, labelSet(labels), h.count); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+/*
+Escapes of label values in the text exposition format
+*/
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+/*
+labelSet formats labels for a sample, nothing when there are none
+*/
+func labelSet(labels []string) string {
+	if len(labels) == 0 {
+		return ""
+	}
+	return "{" + strings.Join(labels, ",") + "}"
+}
+
+/*
+metricLabel turns a tag key into a valid Prometheus label name
+*/
+func metricLabel(key string) string {
+	label := []byte(key)
+	for i, c := range label {
+		letter := c == '_' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z'
+		if !letter && (i == 0 || c < '0' || c > '9') {
+			label[i] = '_'
+		}
+	}
+	return string(label)
+}
+
+/*
+save replaces the -metrics-file, for node_exporter's textfile collector
+to pick up. It is written next to its final place and renamed, so the
+collector never reads half of it.
+*/
+func (f *fleetLatency) save() error {
+	if f.path == "" {
+		return nil
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(f.path), filepath.Base(f.path)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	// Readable by the collector, which seldom runs as the same user
+	if err := tmp.Chmod(0644); err != nil {
+		tmp.Close()
+		return err
+	}
+
+	if err := f.writeMetrics(tmp); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), f.path)
+}
@@ -0,0 +1,138 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"time"
+)
+
+/*
+Consecutive rounds over the SLO reported as a breach by default
+*/
+const defaultSLOBreaches = 3
+
+/*
+LatencySLO holds the handshake latency allowed for each target, given
+with -latency-slo as a duration for every target or address=duration
+for one of them
+*/
+type LatencySLO struct {
+	all     time.Duration
+	targets map[string]time.Duration
+}
+
+func (s *LatencySLO) String() string {
+	if s == nil {
+		return ""
+	}
+	var limits []string
+	if s.all > 0 {
+		limits = append(limits, s.all.String())
+	}
+	for address, limit := range s.targets {
+		limits = append(limits, address+"="+limit.String())
+	}
+	return strings.Join(limits, ", ")
+}
+
+/*
+Set parses one -latency-slo flag
+*/
+func (s *LatencySLO) Set(value string) error {
+	address, limit, perTarget := strings.Cut(value, "=")
+	if !perTarget {
+		limit = address
+	}
+	d, err := time.ParseDuration(strings.TrimSpace(limit))
+	if err != nil || d <= 0 {
+		return fmt.Errorf("Invalid latency %q", limit)
+	}
+
+	if !perTarget {
+		s.all = d
+		return nil
+	}
+	address = strings.TrimSpace(address)
+	if _, _, err := net.SplitHostPort(address); err != nil {
+		return fmt.Errorf("Invalid target %q: %s", address, err.Error())
+	}
+	if s.targets == nil {
+		s.targets = make(map[string]time.Duration)
+	}
+	s.targets[address] = d
+	return nil
+}
+
+/*
+limit returns the latency allowed for the target, 0 when it has none
+*/
+func (s *LatencySLO) limit(target Target) time.Duration {
+	if limit, ok := s.targets[target.Address()]; ok {
+		return limit
+	}
+	return s.all
+}
+
+/*
+sloTracker counts consecutive watch rounds over the latency SLO, and
+reports a breach once it lasted long enough and again when it's over
+*/
+type sloTracker struct {
+	slo      *LatencySLO
+	breaches int
+
+	mu sync.Mutex
+	// Consecutive rounds over the limit, by address
+	over map[string]int
+}
+
+func newSLOTracker(slo *LatencySLO, breaches int) *sloTracker {
+	return &sloTracker{slo: slo, breaches: breaches, over: make(map[string]int)}
+}
+
+/*
+observe records the result's latency and returns a finding when the
+target just breached its SLO or just recovered. Rounds without a
+handshake are left out.
+*/
+func (t *sloTracker) observe(result *ScanResult) []Finding {
+	if t == nil || result.Handshake == nil {
+		return nil
+	}
+	limit := t.slo.limit(result.Target)
+	if limit == 0 {
+		return nil
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	address := result.Target.Address()
+	latency := result.Latency.Round(time.Microsecond)
+	if result.Latency <= limit {
+		over := t.over[address]
+		delete(t.over, address)
+		if over < t.breaches {
+			return nil
+		}
+		return []Finding{{
+			RuleID:   "MYSQL-LATENCY-SLO-RECOVERED",
+			Severity: SeverityInfo,
+			Title:    "Handshake latency is back within its SLO",
+			Detail:   fmt.Sprintf("%s, allowed %s, after %d rounds over", latency, limit, over),
+		}}
+	}
+
+	t.over[address]++
+	if t.over[address] != t.breaches {
+		return nil
+	}
+	return []Finding{{
+		RuleID:   "MYSQL-LATENCY-SLO-BREACH",
+		Severity: SeverityMedium,
+		Title:    "Handshake latency breached its SLO",
+		Detail:   fmt.Sprintf("%s, allowed %s, over for %d consecutive rounds", latency, limit, t.breaches),
+	}}
+}
@@ -0,0 +1,229 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/net/icmp"
+	"golang.org/x/net/ipv4"
+	"golang.org/x/net/ipv6"
+)
+
+/*
+Liveness check methods selected with -alive-check
+*/
+const (
+	LivenessICMP = "icmp"
+	LivenessTCP  = "tcp"
+)
+
+/*
+Hosts checked at once, checks mostly wait on the network
+*/
+const livenessWorkers = 64
+
+/*
+How long a host has to answer the liveness check by default
+*/
+const defaultLivenessTimeout = time.Second
+
+/*
+livenessCheck tells dead hosts apart cheaply, before the probe stage,
+with an ICMP echo or a TCP connection to ports expected to be open
+*/
+type livenessCheck struct {
+	method  string
+	ports   []int
+	timeout time.Duration
+}
+
+/*
+parseLivenessCheck parses -alive-check: icmp, or tcp:port[,port...]
+*/
+func parseLivenessCheck(spec string, timeout time.Duration) (*livenessCheck, error) {
+	check := &livenessCheck{method: spec, timeout: timeout}
+	if spec == LivenessICMP {
+		// Find out now rather than on every host that we can't send echoes
+		conn, _, err := listenICMP(false)
+		if err != nil {
+			return nil, fmt.Errorf("Can't send ICMP echoes (%s), run with privileges or use -alive-check tcp:port", err.Error())
+		}
+		conn.Close()
+		return check, nil
+	}
+
+	list, ok := strings.CutPrefix(spec, LivenessTCP+":")
+	if !ok {
+		return nil, fmt.Errorf("Unknown liveness check %q, expected icmp or tcp:port[,port...]", spec)
+	}
+	check.method = LivenessTCP
+	for _, p := range strings.Split(list, ",") {
+		port, err := strconv.Atoi(strings.TrimSpace(p))
+		if err != nil || port < 1 || port > 65535 {
+			return nil, fmt.Errorf("Invalid liveness check port %q", p)
+		}
+		check.ports = append(check.ports, port)
+	}
+	return check, nil
+}
+
+/*
+alive tells whether the host answered. A refused connection is an
+answer too: only a live host sends the reset.
+*/
+func (c *livenessCheck) alive(host string) bool {
+	if c.method == LivenessICMP {
+		return c.echo(host)
+	}
+	for _, port := range c.ports {
+		conn, err := net.DialTimeout("tcp", net.JoinHostPort(host, strconv.Itoa(port)), c.timeout)
+		if err == nil {
+			conn.Close()
+			return true
+		}
+		if connRefused(err) {
+			return true
+		}
+	}
+	return false
+}
+
+/*
+listenICMP opens an ICMP socket, unprivileged where the system allows
+it (a datagram socket on Linux and macOS), raw otherwise
+*/
+func listenICMP(ipv6 bool) (conn *icmp.PacketConn, privileged bool, err error) {
+	datagram, raw := "udp4", "ip4:icmp"
+	if ipv6 {
+		datagram, raw = "udp6", "ip6:ipv6-icmp"
+	}
+	if conn, err = icmp.ListenPacket(datagram, ""); err == nil {
+		return conn, false, nil
+	}
+	conn, err = icmp.ListenPacket(raw, "")
+	return conn, true, err
+}
+
+/*
+echo sends an ICMP echo request to the host and waits for the reply
+*/
+func (c *livenessCheck) echo(host string) bool {
+	addr, err := net.ResolveIPAddr("ip", host)
+	if err != nil {
+		return false
+	}
+	v6 := addr.IP.To4() == nil
+
+	conn, privileged, err := listenICMP(v6)
+	if err != nil {
+		return false
+	}
+	defer conn.Close()
+
+	var request, reply icmp.Type = ipv4.ICMPTypeEcho, ipv4.ICMPTypeEchoReply
+	protocol := 1
+	if v6 {
+		request, reply, protocol = ipv6.ICMPTypeEchoRequest, ipv6.ICMPTypeEchoReply, 58
+	}
+	// Datagram sockets have the kernel pick the ID, so the payload tells our replies apart
+	body := &icmp.Echo{ID: os.Getpid() & 0xffff, Seq: 1, Data: []byte("rajath " + host)}
+	message, err := (&icmp.Message{Type: request, Body: body}).Marshal(nil)
+	if err != nil {
+		return false
+	}
+
+	var dst net.Addr = addr
+	if !privileged {
+		dst = &net.UDPAddr{IP: addr.IP, Zone: addr.Zone}
+	}
+	if _, err := conn.WriteTo(message, dst); err != nil {
+		return false
+	}
+
+	conn.SetReadDeadline(time.Now().Add(c.timeout))
+	buf := make([]byte, 1500)
+	for {
+		n, peer, err := conn.ReadFrom(buf)
+		if err != nil {
+			return false
+		}
+		received, err := icmp.ParseMessage(protocol, buf[:n])
+		if err != nil || received.Type != reply {
+			continue
+		}
+		echo, ok := received.Body.(*icmp.Echo)
+		if ok && bytes.Equal(echo.Data, body.Data) && sameHost(peer, addr.IP) {
+			return true
+		}
+	}
+}
+
+func sameHost(peer net.Addr, ip net.IP) bool {
+	switch peer := peer.(type) {
+	case *net.IPAddr:
+		return peer.IP.Equal(ip)
+	case *net.UDPAddr:
+		return peer.IP.Equal(ip)
+	}
+	return false
+}
+
+/*
+prune checks every host once and returns the targets on hosts that
+answered, with the number of hosts that didn't. Named pipes are local
+and always kept.
+*/
+func (c *livenessCheck) prune(targets []Target) ([]Target, int) {
+	// The hosts are listed before the workers write to the map, which
+	// can't be ranged over meanwhile
+	hosts := make(map[string]bool)
+	var order []string
+	for _, target := range targets {
+		if _, ok := hosts[target.Host]; !ok && target.Pipe == "" {
+			hosts[target.Host] = false
+			order = append(order, target.Host)
+		}
+	}
+
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	jobs := make(chan string)
+	workers := openFiles.workers("liveness check", livenessWorkers)
+	for i := 0; i < workers && i < len(order); i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for host := range jobs {
+				alive := c.alive(host)
+				mu.Lock()
+				hosts[host] = alive
+				mu.Unlock()
+			}
+		}()
+	}
+	for _, host := range order {
+		jobs <- host
+	}
+	close(jobs)
+	wg.Wait()
+
+	dead := 0
+	for _, alive := range hosts {
+		if !alive {
+			dead++
+		}
+	}
+	var live []Target
+	for _, target := range targets {
+		if target.Pipe != "" || hosts[target.Host] {
+			live = append(live, target)
+		}
+	}
+	return live, dead
+}
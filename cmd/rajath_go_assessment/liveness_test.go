@@ -0,0 +1,33 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestPruneChecksEveryHostOnce(t *testing.T) {
+	// A closed port refuses, which counts as alive
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	port := listener.Addr().(*net.TCPAddr).Port
+	listener.Close()
+
+	check, err := parseLivenessCheck("tcp:"+strconv.Itoa(port), time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var targets []Target
+	for i := 1; i <= 32; i++ {
+		host := fmt.Sprintf("127.0.0.%d", i)
+		targets = append(targets, Target{Host: host, Port: 3306}, Target{Host: host, Port: 33060})
+	}
+	live, dead := check.prune(targets)
+	if dead != 0 || len(live) != len(targets) {
+		t.Errorf("kept %d of %d targets, %d hosts dead", len(live), len(targets), dead)
+	}
+}
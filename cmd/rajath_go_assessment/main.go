@@ -4,48 +4,932 @@ import (
 	"flag"
 	"fmt"
 	"log"
-	"net"
 	"os"
-	"strconv"
 	"strings"
+	"sync"
+	"time"
 )
 
-func scanHostPort(host string, port int) {
+/*
+Version of the scanner, set by make release
+*/
+var version = "dev"
 
-	fmt.Printf(fmt.Sprintf("%s\n", strings.Repeat("-", 70)))
+/*
+Whether printResult shows the annotated handshake bytes, set by -hexdump
+*/
+var showHexdump bool
 
-	target := fmt.Sprintf("%s:%d", host, port)
-	conn, err := net.Dial("tcp", target)
-	if err != nil {
-		log.Printf("MySQL is not running on the given host and port: %s\n", err.Error())
+/*
+Environment variables holding the passwords of the second and third
+factors of a multi-factor -user, kept off the command line where other
+users of the box can read them
+*/
+const (
+	password2Env = "RAJATH_PASSWORD2"
+	password3Env = "RAJATH_PASSWORD3"
+)
+
+func printResult(result *ScanResult) {
+	if !filter.keep(result) {
 		return
 	}
+	result = redactor.apply(result)
+	target := result.Target
 
-	handshakePacket := &InitialHandshakePacket{}
-	err = handshakePacket.Decode(conn)
-	if err != nil {
-		log.Printf("Failed to decode packet: %s\n", err.Error())
+	render.separator(os.Stdout)
+
+	switch result.Status {
+	case StatusClosed:
+		log.Printf(tr("MySQL is not running on the given host and port: %s\n"), result.Err.Error())
+		printUnreachableFindings(result)
+		return
+	case StatusError:
+		if category := errorCategory(result.Err); category != "" {
+			log.Printf(tr("Server refused the connection (%s): %s\n"), category, result.Err.Error())
+		} else {
+			log.Printf(tr("Failed to decode packet: %s\n"), result.Err.Error())
+		}
+		printUnreachableFindings(result)
+		return
+	case StatusTarpit:
+		log.Printf(tr("Tarpit suspected (%s): %s\n"), tarpitKind(result.Err), result.Err.Error())
+		printUnreachableFindings(result)
+		return
+	case StatusFiltered:
+		log.Printf(tr("Filtered, a SYN proxy accepted the connection and reset it on write: %s\n"), result.Err.Error())
+		printUnreachableFindings(result)
 		return
 	}
 
-	fmt.Printf("%s\n", target)
-	fmt.Printf(handshakePacket.GetPacketInfo())
+	fmt.Printf("%s\n", target.Label())
+	if textCompat == TextCompatV1 {
+		// The label and the handshake fields are all v1 printed
+		if result.Handshake != nil {
+			fmt.Print(result.Handshake.Info().v1())
+		}
+		return
+	}
+	fmt.Printf(tr("Target ID: %s")+"\n", result.TargetID())
+	fmt.Printf(tr("Scanned: %s to %s")+"\n", result.StartedAt.Format(time.RFC3339Nano), result.EndedAt.Format(time.RFC3339Nano))
+	if len(result.Tags) > 0 {
+		fmt.Printf(tr("Tags: %s")+"\n", result.Tags)
+	}
+	if result.Traffic != nil {
+		fmt.Printf(tr("Traffic: %s")+"\n", result.Traffic)
+	}
+	if !result.CachedAt.IsZero() {
+		fmt.Printf(tr("Cached: probed %s ago")+"\n", clock.Now().Sub(result.CachedAt).Round(time.Second))
+	}
+	if result.Status == StatusXCom {
+		fmt.Print(tr("Open with no greeting, consistent with group replication (XCom)"))
+		printFindings(result)
+		return
+	}
+	if result.XCapabilities != nil {
+		fmt.Print(result.XCapabilities)
+		return
+	}
+	fmt.Print(result.Handshake.Info())
+	for _, warning := range result.Handshake.Warnings {
+		fmt.Printf("\n"+tr("Decode warning: %s"), warning)
+	}
+	if showHexdump {
+		fmt.Printf("\n%s\n%s", tr("Handshake packet:"), result.Handshake.Hexdump())
+	}
+	if s, ok := result.Stability(); ok {
+		fmt.Printf("\n"+tr("Stability: %s"), s)
+		if backends := result.Backends(); len(backends) > 1 {
+			for i, b := range backends {
+				fmt.Printf("\n"+tr("Backend %d: %s"), i+1, b)
+			}
+		}
+	}
+	if result.Statistics != nil {
+		fmt.Printf("\n"+tr("Statistics: %s"), result.Statistics)
+	}
+	if result.ClockSkew != nil {
+		fmt.Printf("\n"+tr("Clock skew: %s"), result.ClockSkew)
+	}
+	if result.Schemas != nil {
+		fmt.Printf("\n"+tr("Schemas: %d"), len(result.Schemas))
+		for _, schema := range result.Schemas {
+			fmt.Printf("\n"+tr("Schema: %s"), schema)
+		}
+	}
+	for _, source := range result.Processlist {
+		fmt.Printf("\n"+tr("Connected from: %s"), source)
+	}
+	for _, listener := range result.Listeners {
+		fmt.Printf("\n"+tr("Listener: %s"), listener)
+	}
+	printFindings(result)
 }
 
-func main() {
+/*
+printUnreachableFindings prints what was found about a target that
+couldn't be scanned, such as an expected server that is missing
+*/
+func printUnreachableFindings(result *ScanResult) {
+	if len(result.Findings) == 0 || textCompat == TextCompatV1 {
+		return
+	}
+	fmt.Printf("%s\n"+tr("Target ID: %s"), result.Target.Label(), result.TargetID())
+	if len(result.Tags) > 0 {
+		fmt.Printf("\n"+tr("Tags: %s"), result.Tags)
+	}
+	printFindings(result)
+	fmt.Println()
+}
 
-	if len(os.Args) != 3 {
-		fmt.Println("Usage: ./bin/rajath_go_assessment hostname port_number")
+func saveStore(store *resultStore) {
+	if store == nil {
 		return
 	}
+	if err := store.save(); err != nil {
+		log.Printf("Failed to save results: %s\n", err.Error())
+	}
+}
+
+func saveMetrics() {
+	if err := latencies.save(); err != nil {
+		log.Printf("Failed to save metrics: %s\n", err.Error())
+	}
+}
+
+/*
+coordinate serves the targets to agents until interrupted, printing
+results as they arrive, or writing them in the chosen format at the end
+*/
+func coordinate(addr, token string, targets []Target, shardSize int, lease time.Duration, output string) error {
+	if output != OutputText {
+		if _, ok := reportWriters[output]; !ok {
+			return fmt.Errorf("Unknown output format %q", output)
+		}
+	}
+
+	var mu sync.Mutex
+	var results []*ScanResult
+	c := newCoordinator(targets, shardSize, lease, func(agent agentInfo, record *ResultRecord) {
+		result := redactor.apply(record.scanResult())
+		tags := make(Tags)
+		for key, value := range result.Tags {
+			tags[key] = value
+		}
+		tags["agent"] = agent.Name
+		tags["region"] = agent.Region
+		result.Tags = tags
+		if result = script.apply(result); result == nil {
+			return
+		}
+		sink.send(result)
+		latencies.observe(result)
+		saveMetrics()
+
+		mu.Lock()
+		defer mu.Unlock()
+		if output != OutputText {
+			results = append(results, result)
+			return
+		}
+		if !filter.keep(result) {
+			return
+		}
+		fmt.Printf("%s %s [%s/%s] %s %s", result.Target.Label(), result.TargetID(), agent.Region, agent.Name, result.Status, record.Version)
+		if record.Error != "" {
+			fmt.Printf(" (%s)", record.Error)
+		}
+		printFindings(result)
+		fmt.Println()
+	})
+
+	if err := serveCoordinator(addr, token, c); err != nil {
+		return err
+	}
+	if output == OutputText {
+		return nil
+	}
+	return writeReport(output, os.Stdout, results)
+}
+
+func printFindings(result *ScanResult) {
+	for _, finding := range result.Findings {
+		fmt.Printf("\n"+tr("Finding: %s"), finding)
+	}
+}
 
+func main() {
+	if len(os.Args) > 1 && os.Args[1] == "verify" {
+		os.Exit(runVerify(os.Args[2:]))
+	}
+	if len(os.Args) > 1 && os.Args[1] == "validate" {
+		os.Exit(runValidate(os.Args[2:]))
+	}
+	if len(os.Args) > 1 && os.Args[1] == "replay" {
+		os.Exit(runReplay(os.Args[2:]))
+	}
+	if len(os.Args) > 1 && os.Args[1] == "capabilities" {
+		os.Exit(runCapabilities(os.Args[2:]))
+	}
+
+	protocol := flag.String("protocol", ProtocolMySQL, "protocol to probe targets with: mysql or mysqlx")
+	planFile := flag.String("plan", "", "YAML scan plan of discover, fingerprint and audit stages, each with its own workers and gate")
+	targetsFile := flag.String("targets", "", "file of targets, one per line, with optional protocol= and tls= options, - for stdin")
+	chunkSize := flag.Int("chunk-size", 0, "read the -targets file and scan it this many targets at a time, for lists too large to hold in memory")
+	rankAnomalies := flag.Bool("rank-anomalies", false, "list targets with the most anomalous handshakes first")
+	flag.BoolVar(&tracer.enabled, "trace", false, "log every packet sent and received, with a hexdump of its first bytes")
+	flag.IntVar(&tracer.limit, "trace-bytes", defaultTraceBytes, "bytes of each packet dumped by -trace")
+	flag.StringVar(&evidence.dir, "evidence-dir", "", "directory each finding's exchanged bytes and their times are saved to, for audit tickets")
+	flag.StringVar(&recorder.dir, "record", "", "directory the bytes servers send are saved to, one file per connection, for the replay subcommand")
+	flag.BoolVar(&showHexdump, "hexdump", false, "show the handshake bytes annotated with the field each belongs to")
+	tuiMode := flag.Bool("tui", false, "show an interactive live-updating table of targets")
+	flag.BoolVar(&render.plain, "plain", false, "no separator rules or screen redraws, for screen readers; -tui then prints each result as a line")
+	samples := flag.Int("handshake-samples", 0, "number of extra connections made for checks comparing handshakes")
+	interval := flag.Duration("handshake-interval", 0, "pause before each extra handshake connection")
+	probeSamples := flag.Int("samples", 1, "number of times each target is probed for stability statistics")
+	probeInterval := flag.Duration("interval", 0, "pause between repeated probes of a target, with random jitter")
+	adminPort := flag.Int("admin-port", 0, "also probe this admin_port on every host")
+	grPort := flag.Int("gr-port", 0, "also probe this group replication port on every host")
+	intrusive := flag.Bool("intrusive", false, "allow intrusive checks, such as login attempts")
+	passive := flag.Bool("passive", false, "only run passive checks, never making extra connections")
+	credentialsFile := flag.String("credentials", "", "file of user:password lines tried by the default credentials check")
+	loginDelay := flag.Duration("login-delay", time.Second, "pause between login attempts against the same target")
+	throttleAttempts := flag.Int("throttle-attempts", maxThrottleAttempts, "failed logins made to test whether the server throttles them, at most 4, 0 disables")
+	maxHandshake := flag.Uint("max-handshake-size", defaultMaxHandshakeSize, "largest handshake payload accepted, in bytes")
+	readTimeout := flag.Duration("read-timeout", defaultReadTimeout, "longest wait for data on any single read of the handshake")
+	handshakeTimeout := flag.Duration("handshake-timeout", defaultHandshakeTimeout, "longest time receiving the whole handshake may take")
+	saturationPause := flag.Duration("saturation-pause", defaultSaturationPause, "how long to stop probing a host that reports too many connections")
+	workers := flag.Int("workers", 1, "number of targets scanned at once")
+	flag.IntVar(&stages.resolve, "resolve-workers", 0, "host names looked up at once ahead of the probes, 0 for as many as -workers")
+	flag.IntVar(&stages.enrich, "enrich-workers", 0, "results enriched and passed through the -script at once, 0 for as many as -workers")
+	maxOpenFiles := flag.Int("max-open-files", 0, "open files the scan may use, workers are lowered to fit; 0 for the process limit (ulimit -n)")
+	shuffle := flag.Bool("shuffle", false, "probe targets in a random order, spread across subnets")
+	seed := flag.Int64("seed", 0, "seed of the -shuffle order and -jitter pauses, to repeat them; a random one is used and logged otherwise")
+	flag.Var(jitter, "jitter", "random pause between the connections of each worker, as a range such as 0-500ms")
+	discover := flag.String("discover", "", "find open ports with masscan or zmap first and only probe those; targets may then be CIDR ranges")
+	discoverRate := flag.Int("discover-rate", defaultDiscoverRate, "packets per second sent by the -discover port scanner")
+	aliveCheck := flag.String("alive-check", "", "skip hosts that don't answer an ICMP echo (icmp) or a TCP connection (tcp:port[,port...]) before probing")
+	aliveTimeout := flag.Duration("alive-timeout", defaultLivenessTimeout, "how long a host has to answer the -alive-check")
+	flag.IntVar(&parallelism.perHost, "max-per-host", 0, "most targets on the same host scanned at once, 0 for no cap")
+	flag.IntVar(&parallelism.perSubnet, "max-per-subnet", 0, "most targets in the same /24 (/64 for IPv6) scanned at once, 0 for no cap")
+	user := flag.String("user", "", "user for credentialed checks, which are skipped without one")
+	queryDriver := flag.String("query-driver", QueryDriverRaw, "how credentialed checks run statements: raw (built-in protocol) or sql (go-sql-driver/mysql)")
+	password := flag.String("password", "", "password for credentialed checks, defaults to $MYSQL_PWD")
+	statistics := flag.Bool("statistics", false, "collect uptime, threads, questions and slow queries with COM_STATISTICS (needs -user)")
+	maxClockSkew := flag.Duration("max-clock-skew", defaultMaxClockSkew, "server clock skew tolerated before it is reported (needs -user)")
+	schemaInventory := flag.Bool("schema-inventory", false, "collect database names, table counts and storage engines from information_schema (needs -user)")
+	processlist := flag.Bool("processlist", false, "sample the processlist for the source addresses connected to the server (needs -user, and PROCESS to see other accounts)")
+	followListeners := flag.Bool("follow-listeners", false, "also scan the X Plugin and admin ports and clone donors found in the server variables (needs -user)")
+	filterExpr := flag.String("filter", "", "expression results must match to be printed or written, such as 'version < \"5.7\" && tls == false'")
+	scriptFile := flag.String("script", "", "Starlark script whose process(result) can change the findings and tags of every result, or drop it")
+	honeypotFingerprintsFile := flag.String("honeypot-fingerprints", "", "JSON file of the salts, and optionally versions, of known honeypots to flag")
+	customChecks := flag.String("custom-checks", "", "YAML file of custom checks, each a finding reported when its expression holds")
+	pack := flag.String("pack", "", "comma separated check packs to run as well, such as cis")
+	healthAddr := flag.String("health-addr", "", "address /healthz and /readyz are served on, for Kubernetes probes in watch, coordinator and agent mode")
+	webhook := flag.String("webhook", "", "URL every result is POSTed to as a JSON record")
+	sinkQueueSize := flag.Int("sink-queue", defaultSinkQueueSize, "results kept in memory while the -webhook is slow")
+	sinkOverflow := flag.String("sink-overflow", OverflowBlock, "when the sink queue is full: block, drop-oldest or spill")
+	sinkSpillFile := flag.String("sink-spill-file", "", "file results are spilled to with -sink-overflow spill, a temporary file by default")
+	output := flag.String("output", OutputText, "output format: text, json, sarif, junit or dissect")
+	flag.StringVar(&textCompat, "compat", "", "text output format to keep printing as the default one changes: v1")
+	flag.StringVar(&outputLang, "lang", defaultLang, "language of the text output: en, es, de or ja")
+	failSeverity := flag.String("fail-severity", junitFailSeverity.String(), "lowest finding severity that fails a JUnit test case")
+	enrich := flag.String("enrich", "", "CSV file mapping hosts, host:port and CIDRs to owner, environment and ticket tags")
+	expect := flag.String("expect", "", "JSON file of expected servers to reconcile the scan against")
+	storePath := flag.String("store", "", "JSON file keeping the last result of every target between runs")
+	changedOnly := flag.Bool("changed-only", false, "only report targets whose fingerprint changed since the last run (needs -store)")
+	freshness := flag.Duration("fresh", 0, "skip targets scanned less than this long ago (needs -store)")
+	tags := make(Tags)
+	flag.Var(tags, "tag", "key=value tag copied into every result, can be repeated")
+	latencyBy := flag.String("latency-by", "", "comma separated tags the handshake latency histograms are split by, such as datacenter,provider")
+	flag.StringVar(&latencies.path, "metrics-file", "", "Prometheus textfile the handshake latency histograms are written to")
+	coordinatorAddr := flag.String("coordinator", "", "serve as a coordinator on this address, handing the targets to agents")
+	agentAddr := flag.String("agent", "", "run as an agent of the coordinator at this address")
+	region := flag.String("region", "", "region (network vantage point) of this agent")
+	agentName := flag.String("agent-name", "", "name of this agent, defaults to the hostname")
+	grpcToken := flag.String("grpc-token", os.Getenv("RAJATH_GRPC_TOKEN"), "shared token between coordinator and agents, defaults to $RAJATH_GRPC_TOKEN")
+	flag.StringVar(&grpcSecurity.cert, "grpc-cert", "", "certificate the coordinator serves TLS with, PEM")
+	flag.StringVar(&grpcSecurity.key, "grpc-key", "", "private key of -grpc-cert, PEM")
+	flag.StringVar(&grpcSecurity.ca, "grpc-ca", "", "CA certificates agents check the coordinator's against, PEM, the system roots by default")
+	flag.BoolVar(&grpcSecurity.insecure, "sink-insecure", false, "let coordinator and agents talk plaintext, token and results included")
+	shardSize := flag.Int("shard-size", defaultShardSize, "targets per shard handed to an agent")
+	shardLease := flag.Duration("shard-lease", defaultShardLease, "time an agent has to report a shard before it is handed to another")
+	signKey := flag.String("sign-key", "", "PEM Ed25519 private key to sign the -output report with")
+	signaturePath := flag.String("signature", defaultSignaturePath, "file the report signature is written to (with -sign-key)")
+	storeKey := flag.String("store-key", "", "file with a 32 byte key (raw or hex) encrypting the -store file; $"+passphraseEnv+" is used otherwise")
+	redact := flag.String("redact", "", "comma separated redaction rules applied to every output: salt, users, passwords, ips")
+	maxBytes := flag.Int64("max-bytes-per-target", 0, "cap on the bytes sent and received per target and scan, 0 for no cap")
+	watchInterval := flag.Duration("watch", 0, "rescan the targets at this interval until interrupted")
+	latencySLO := &LatencySLO{}
+	flag.Var(latencySLO, "latency-slo", "handshake latency allowed in watch mode, for every target or as host:port=duration, can be repeated")
+	sloBreaches := flag.Int("slo-breaches", defaultSLOBreaches, "consecutive watch rounds over the latency SLO before it is reported")
+	cacheTTL := flag.Duration("cache-ttl", 0, "reuse a target's probe results for this long instead of connecting again, 0 disables")
+	tlsCert := flag.Bool("tls-cert", false, "upgrade to TLS when offered and record the server certificate fingerprint")
+	sshDest := flag.String("ssh", "", "user@host[:port] of an SSH jump host targets are connected to from")
+	flag.StringVar(&sshDefaults.keyPath, "ssh-key", "", "private key logging in to the -ssh jump host, protected ones are decrypted with $"+sshPassphraseEnv+"; the SSH agent and ~/.ssh keys are used otherwise")
+	flag.StringVar(&sshDefaults.agent, "ssh-agent", "", "SSH agent socket or named pipe, defaults to $SSH_AUTH_SOCK")
+	flag.StringVar(&sshDefaults.knownHosts, "ssh-known-hosts", "", "known_hosts file the -ssh jump host key is checked against, ~/.ssh/known_hosts by default")
+	flag.StringVar(&sshDefaults.hostKeys, "ssh-host-keys", HostKeysStrict, "jump host keys accepted: strict (known only), accept-new (adds unknown hosts to known_hosts) or insecure")
+	showVersion := flag.Bool("version", false, "print the version and exit")
+	if err := flagsFromEnv(flag.CommandLine, os.Args[1:]); err != nil {
+		log.Println(err.Error())
+		os.Exit(-1)
+	}
 	flag.Parse()
-	host := flag.Arg(0)
-	port, err := strconv.Atoi(flag.Arg(1))
+
+	if *showVersion {
+		fmt.Printf("rajath_go_assessment %s\n", version)
+		return
+	}
+	args := flag.Args()
+	if len(args) == 0 {
+		args = envTargetList()
+	}
+	if len(args) == 0 && *targetsFile == "" && *expect == "" && *agentAddr == "" {
+		fmt.Println("Usage: ./bin/rajath_go_assessment [-tui] hostname port_number | host:port... | -targets file")
+		return
+	}
+
+	var targets, fileTargets []Target
+	var err error
+	if len(args) > 0 {
+		targets, err = parseTargets(args, *protocol)
+		if err != nil {
+			log.Println(err.Error())
+			os.Exit(-1)
+		}
+	}
+	if *targetsFile == "-" && *tuiMode {
+		log.Println("-targets - can't be used with -tui, which reads keys from stdin")
+		os.Exit(-1)
+	}
+	var chunks *targetsReader
+	switch {
+	case *chunkSize < 0:
+		log.Println("-chunk-size can't be negative")
+		os.Exit(-1)
+	case *chunkSize > 0:
+		if *targetsFile == "" {
+			log.Println("-chunk-size needs -targets")
+			os.Exit(-1)
+		}
+		if *expect != "" || *planFile != "" || *tuiMode || *watchInterval > 0 || *coordinatorAddr != "" || *agentAddr != "" || *rankAnomalies || *signKey != "" {
+			log.Println("-chunk-size can't be used with -expect, -plan, -tui, -watch, -coordinator, -agent, -rank-anomalies or -sign-key, which need all the targets at once")
+			os.Exit(-1)
+		}
+		if *output == OutputSARIF || *output == OutputJUnit {
+			log.Printf("-chunk-size can't be used with -output %s, which is written once all targets are in\n", *output)
+			os.Exit(-1)
+		}
+		if *storePath != "" {
+			log.Println("-chunk-size can't be used with -store, which keeps an entry for every target in memory")
+			os.Exit(-1)
+		}
+		if chunks, err = openTargetsFile(*targetsFile, *protocol); err != nil {
+			log.Println(err.Error())
+			os.Exit(-1)
+		}
+		defer chunks.close()
+	case *targetsFile != "":
+		fileTargets, err = loadTargetsFile(*targetsFile, *protocol)
+		if err != nil {
+			log.Println(err.Error())
+			os.Exit(-1)
+		}
+		targets = append(targets, fileTargets...)
+	}
+
+	for _, key := range strings.Split(*latencyBy, ",") {
+		if key = strings.TrimSpace(key); key != "" {
+			latencies.by = append(latencies.by, key)
+		}
+	}
+
+	var inventory Inventory
+	if *expect != "" {
+		inventory, err = loadInventory(*expect)
+		if err != nil {
+			log.Println(err.Error())
+			os.Exit(-1)
+		}
+		targets = inventory.withExpected(targets)
+	}
+
+	saturation.pause = *saturationPause
+	if tracer.limit < 0 {
+		log.Println("-trace-bytes can't be negative")
+		os.Exit(-1)
+	}
+	if *workers < 1 || parallelism.perHost < 0 || parallelism.perSubnet < 0 || stages.resolve < 0 || stages.enrich < 0 {
+		log.Println("-workers must be at least 1, -max-per-host, -max-per-subnet, -resolve-workers and -enrich-workers can't be negative")
+		os.Exit(-1)
+	}
+	if err := openFiles.init(*maxOpenFiles, *workers); err != nil {
+		log.Println(err.Error())
+		os.Exit(-1)
+	}
+	decodeTimeouts.read = *readTimeout
+	if *maxHandshake == 0 || *maxHandshake > clientMaxPacketSize-1 {
+		log.Printf("-max-handshake-size must be between 1 and %d\n", clientMaxPacketSize-1)
+		os.Exit(-1)
+	}
+	maxHandshakeSize = uint32(*maxHandshake)
+	decodeTimeouts.total = *handshakeTimeout
+	traffic.limit = *maxBytes
+	junitFailSeverity, err = parseSeverity(*failSeverity)
+	if err != nil {
+		log.Println(err.Error())
+		os.Exit(-1)
+	}
+	if *sshDest != "" && (*discover != "" || *aliveCheck != "" || *coordinatorAddr != "") {
+		log.Println("-ssh can't be used with -discover, -alive-check or -coordinator, which connect from this machine")
+		os.Exit(-1)
+	}
+	var plan *ScanPlan
+	if *planFile != "" {
+		if *discover != "" || *aliveCheck != "" || *coordinatorAddr != "" || *agentAddr != "" || *tuiMode || *watchInterval > 0 || *storePath != "" {
+			log.Println("-plan can't be used with -discover, -alive-check, -coordinator, -agent, -tui, -watch or -store")
+			os.Exit(-1)
+		}
+		if plan, err = loadScanPlan(*planFile); err != nil {
+			log.Println(err.Error())
+			os.Exit(-1)
+		}
+		if *sshDest != "" && len(plan.ProxyChain) > 0 {
+			log.Println("-ssh can't be used with the plan's proxy_chain, add the jump host to the chain")
+			os.Exit(-1)
+		}
+	}
+	var portScanner *discoverer
+	if *discover != "" {
+		if portScanner, err = newDiscoverer(*discover, *discoverRate); err != nil {
+			log.Println(err.Error())
+			os.Exit(-1)
+		}
+	}
+	if (*shuffle || jitter.enabled()) && *seed == 0 {
+		*seed = clock.Now().UnixNano()
+		log.Printf("Randomising the scan with -seed %d\n", *seed)
+	}
+	jitter.seed = *seed
+	var liveness *livenessCheck
+	if *aliveCheck != "" {
+		if *coordinatorAddr != "" {
+			log.Println("-alive-check can't be used with -coordinator")
+			os.Exit(-1)
+		}
+		if liveness, err = parseLivenessCheck(*aliveCheck, *aliveTimeout); err != nil {
+			log.Println(err.Error())
+			os.Exit(-1)
+		}
+	}
+	// Turns the given targets into the ones probed, for all of them, chunk by chunk or on reload
+	prepare := func(targets []Target) ([]Target, error) {
+		targets = withRolePorts(targets, *adminPort, *grPort)
+		if portScanner != nil {
+			all := len(targets)
+			var err error
+			if targets, err = portScanner.discover(targets); err != nil {
+				return nil, err
+			}
+			log.Printf("%s found %d targets to probe out of %d given\n", *discover, len(targets), all)
+		} else if !plan.discovers() {
+			for _, target := range targets {
+				if isRange(target.Host) {
+					return nil, fmt.Errorf("Range target %s needs -discover", target)
+				}
+			}
+		}
+		if *shuffle {
+			targets = shuffleTargets(targets, *seed)
+		}
+		if liveness != nil {
+			var dead int
+			all := len(targets)
+			targets, dead = liveness.prune(targets)
+			log.Printf("Liveness check skipped %d of %d targets, hosts not answering: %d\n", all-len(targets), all, dead)
+		}
+		return targets, nil
+	}
+	if chunks == nil {
+		if targets, err = prepare(targets); err != nil {
+			log.Println(err.Error())
+			os.Exit(-1)
+		}
+	}
+
+	opts := ScanOptions{
+		HandshakeSamples:  *samples,
+		HandshakeInterval: *interval,
+		Samples:           *probeSamples,
+		SampleInterval:    *probeInterval,
+		TLSCert:           *tlsCert,
+		Tier:              TierActive,
+		LoginDelay:        *loginDelay,
+		ThrottleAttempts:  *throttleAttempts,
+		User:              *user,
+		Password:          *password,
+		QueryDriver:       *queryDriver,
+		Statistics:        *statistics,
+		MaxClockSkew:      *maxClockSkew,
+		SchemaInventory:   *schemaInventory,
+		Processlist:       *processlist,
+		FollowListeners:   *followListeners,
+		Inventory:         inventory,
+		Workers:           *workers,
+		Tags:              tags,
+	}
+	if *cacheTTL < 0 {
+		log.Println("-cache-ttl can't be negative")
+		os.Exit(-1)
+	}
+	if *cacheTTL > 0 {
+		opts.Banners = newBannerCache(*cacheTTL)
+	}
+	if *enrich != "" {
+		opts.Enrichment, err = loadEnrichment(*enrich)
+		if err != nil {
+			log.Println(err.Error())
+			os.Exit(-1)
+		}
+	}
+	if !queryDrivers[opts.QueryDriver] {
+		log.Printf("Unknown query driver %q\n", opts.QueryDriver)
+		os.Exit(-1)
+	}
+	if *filterExpr != "" {
+		if *tuiMode {
+			log.Println("-filter can't be used with -tui")
+			os.Exit(-1)
+		}
+		if err := filter.compile(*filterExpr); err != nil {
+			log.Println(err.Error())
+			os.Exit(-1)
+		}
+	}
+	if *scriptFile != "" {
+		if err := script.load(*scriptFile); err != nil {
+			log.Println(err.Error())
+			os.Exit(-1)
+		}
+	}
+	if *customChecks != "" {
+		if err := loadCustomChecks(*customChecks); err != nil {
+			log.Println(err.Error())
+			os.Exit(-1)
+		}
+	}
+	if *honeypotFingerprintsFile != "" {
+		if err := loadHoneypotFingerprints(*honeypotFingerprintsFile); err != nil {
+			log.Println(err.Error())
+			os.Exit(-1)
+		}
+	}
+	opts.Packs, err = parsePacks(*pack)
 	if err != nil {
+		log.Println(err.Error())
 		os.Exit(-1)
 	}
-	scanHostPort(host, port)
+	if opts.Password == "" {
+		opts.Password = os.Getenv("MYSQL_PWD")
+	}
+	password2, password3 := os.Getenv(password2Env), os.Getenv(password3Env)
+	if password2 != "" || password3 != "" {
+		if password2 == "" {
+			log.Printf("$%s needs $%s\n", password3Env, password2Env)
+			os.Exit(-1)
+		}
+		if opts.QueryDriver == QueryDriverSQL {
+			log.Printf("$%s and $%s can't be used with -query-driver %s, which has no multi-factor authentication\n", password2Env, password3Env, QueryDriverSQL)
+			os.Exit(-1)
+		}
+		opts.FactorPasswords = []string{password2}
+		if password3 != "" {
+			opts.FactorPasswords = append(opts.FactorPasswords, password3)
+		}
+	}
+	if *credentialsFile != "" {
+		opts.Credentials, err = loadCredentials(*credentialsFile)
+		if err != nil {
+			log.Println(err.Error())
+			os.Exit(-1)
+		}
+	}
+	switch {
+	case *intrusive && *passive:
+		log.Println("-intrusive and -passive can't be used together")
+		os.Exit(-1)
+	case *intrusive:
+		opts.Tier = TierIntrusive
+	case *passive:
+		opts.Tier = TierPassive
+	}
+
+	redactor, err = newRedactor(*redact, opts)
+	if err != nil {
+		log.Println(err.Error())
+		os.Exit(-1)
+	}
+
+	if *signKey != "" {
+		if *output == OutputText {
+			log.Println("-sign-key needs an -output report to sign")
+			os.Exit(-1)
+		}
+		key, err := loadSigningKey(*signKey)
+		if err != nil {
+			log.Println(err.Error())
+			os.Exit(-1)
+		}
+		signer = &reportSigner{key: key, signaturePath: *signaturePath}
+	}
+
+	var hops []ProxyHop
+	if *sshDest != "" {
+		hops = []ProxyHop{{Type: HopSSH, Address: *sshDest}}
+	}
+	if plan != nil && len(plan.ProxyChain) > 0 {
+		hops = plan.ProxyChain
+	}
+	if len(hops) > 0 {
+		if err := chain.build(hops); err != nil {
+			log.Println(err.Error())
+			os.Exit(-1)
+		}
+		defer chain.close()
+	}
+	if *healthAddr != "" {
+		if err := health.serve(*healthAddr); err != nil {
+			log.Println(err.Error())
+			os.Exit(-1)
+		}
+	}
+
+	if *agentAddr != "" {
+		if len(targets) > 0 || *coordinatorAddr != "" {
+			log.Println("Agents get their targets from the coordinator")
+			os.Exit(-1)
+		}
+		name := *agentName
+		if name == "" {
+			name, _ = os.Hostname()
+		}
+		health.ready.Store(true)
+		if err := runAgent(*agentAddr, *grpcToken, name, *region, opts); err != nil {
+			log.Println(err.Error())
+			os.Exit(-1)
+		}
+		return
+	}
+
+	if *webhook != "" {
+		sink, err = newSinkQueue(newWebhookSink(*webhook), *sinkQueueSize, *sinkOverflow, *sinkSpillFile)
+		if err != nil {
+			log.Println(err.Error())
+			os.Exit(-1)
+		}
+		defer sink.close()
+	}
+
+	if *coordinatorAddr != "" {
+		health.ready.Store(true)
+		if err := coordinate(*coordinatorAddr, *grpcToken, targets, *shardSize, *shardLease, *output); err != nil {
+			log.Println(err.Error())
+			exit(-1)
+		}
+		return
+	}
+
+	var store *resultStore
+	storeOpts := StoreOptions{ChangedOnly: *changedOnly, Freshness: *freshness}
+	if *storePath != "" {
+		if *tuiMode {
+			log.Println("-store can't be used with -tui")
+			exit(-1)
+		}
+		cipher, err := newFileCipher(*storeKey)
+		if err != nil {
+			log.Println(err.Error())
+			exit(-1)
+		}
+		store, err = openStore(*storePath, cipher)
+		if err != nil {
+			log.Println(err.Error())
+			exit(-1)
+		}
+	} else if *changedOnly || *freshness > 0 {
+		log.Println("-changed-only and -fresh need -store")
+		exit(-1)
+	}
+	health.ready.Store(true)
+
+	if latencySLO.String() != "" && *watchInterval <= 0 {
+		log.Println("-latency-slo needs -watch")
+		exit(-1)
+	}
+	if *throttleAttempts < 0 || *throttleAttempts > maxThrottleAttempts {
+		log.Printf("-throttle-attempts must be between 0 and %d, to stay under lockout thresholds\n", maxThrottleAttempts)
+		exit(-1)
+	}
+	if *sloBreaches < 1 {
+		log.Println("-slo-breaches must be at least 1")
+		exit(-1)
+	}
+
+	scan := func(report func(*ScanResult)) {
+		if chunks != nil {
+			err := chunks.each(*chunkSize, targets, func(chunk []Target) {
+				chunk, err := prepare(chunk)
+				if err != nil {
+					log.Println(err.Error())
+					exit(-1)
+				}
+				scanWithStore(chunk, opts, nil, storeOpts, report)
+			})
+			if err != nil {
+				log.Println(err.Error())
+				exit(-1)
+			}
+			return
+		}
+		if plan == nil {
+			scanWithStore(targets, opts, store, storeOpts, report)
+			return
+		}
+		err := plan.run(targets, opts, func(result *ScanResult) {
+			sink.send(result)
+			report(result)
+		})
+		if err != nil {
+			log.Println(err.Error())
+			exit(-1)
+		}
+	}
+
+	if textCompat != "" && !textFormats[textCompat] {
+		log.Printf("Unknown text output format %q for -compat\n", textCompat)
+		exit(-1)
+	}
+	if _, ok := catalogs[outputLang]; !ok && outputLang != defaultLang {
+		log.Printf("Unknown language %q for -lang, use en, es, de or ja\n", outputLang)
+		exit(-1)
+	}
+	if textCompat != "" && outputLang != defaultLang {
+		log.Println("-compat keeps the English text output and can't be used with -lang")
+		exit(-1)
+	}
+	if *output != OutputText {
+		if outputLang != defaultLang {
+			log.Println("-lang only applies to -output text")
+			exit(-1)
+		}
+		if textCompat != "" {
+			log.Println("-compat only applies to -output text")
+			exit(-1)
+		}
+		if _, ok := reportWriters[*output]; !ok {
+			log.Printf("Unknown output format %q\n", *output)
+			exit(-1)
+		}
+		if *tuiMode || *watchInterval > 0 {
+			log.Println("-output can't be used with -tui or -watch")
+			exit(-1)
+		}
+		var results []*ScanResult
+		proxies := &topology{}
+		scan(func(result *ScanResult) {
+			proxies.add(redactor.apply(result))
+			if chunks == nil {
+				results = append(results, result)
+				return
+			}
+			// Chunked scans only write formats of a record per line, so results needn't be kept
+			if err := writeReport(*output, os.Stdout, []*ScanResult{result}); err != nil {
+				log.Println(err.Error())
+				exit(-1)
+			}
+		})
+		if *rankAnomalies {
+			rankByAnomaly(results)
+		}
+		if chunks == nil {
+			if err := writeReport(*output, os.Stdout, results); err != nil {
+				log.Println(err.Error())
+				exit(-1)
+			}
+		}
+		saveStore(store)
+		saveMetrics()
+		for _, line := range proxies.lines() {
+			log.Println(line)
+		}
+		log.Printf(tr("Total traffic: %s\n"), &traffic.total)
+		for _, line := range latencies.summary() {
+			log.Println(line)
+		}
+		return
+	}
+
+	if *tuiMode {
+		newTUI(targets, opts, os.Stdout).run(os.Stdin)
+		return
+	}
+
+	if *watchInterval > 0 {
+		opts.LatencySLO = newSLOTracker(latencySLO, *sloBreaches)
+		// Reads the targets, policy and credentials again as main does, without exiting on errors
+		load := func() ([]Target, ScanOptions, error) {
+			var next []Target
+			var err error
+			if len(args) > 0 {
+				if next, err = parseTargets(args, *protocol); err != nil {
+					return nil, opts, err
+				}
+			}
+			if *targetsFile == "-" {
+				next = append(next, fileTargets...)
+			} else if *targetsFile != "" {
+				reloaded, err := loadTargetsFile(*targetsFile, *protocol)
+				if err != nil {
+					return nil, opts, err
+				}
+				next = append(next, reloaded...)
+			}
+			nextOpts := opts
+			if *expect != "" {
+				if nextOpts.Inventory, err = loadInventory(*expect); err != nil {
+					return nil, opts, err
+				}
+				next = nextOpts.Inventory.withExpected(next)
+			}
+			if next, err = prepare(next); err != nil {
+				return nil, opts, err
+			}
+			if *credentialsFile != "" {
+				if nextOpts.Credentials, err = loadCredentials(*credentialsFile); err != nil {
+					return nil, opts, err
+				}
+			}
+			if *enrich != "" {
+				if nextOpts.Enrichment, err = loadEnrichment(*enrich); err != nil {
+					return nil, opts, err
+				}
+			}
+			// The redactor hides the passwords, which may have changed. It
+			// keeps the key of redacted IDs, or every target would look new.
+			nextRedactor, err := newRedactor(*redact, nextOpts)
+			if err != nil {
+				return nil, opts, err
+			}
+			if nextRedactor != nil && redactor != nil {
+				nextRedactor.idKey = redactor.idKey
+			}
+			// Last, as they take effect at once
+			if *customChecks != "" {
+				if err := loadCustomChecks(*customChecks); err != nil {
+					return nil, opts, err
+				}
+			}
+			redactor = nextRedactor
+			return next, nextOpts, nil
+		}
+		reload := newReloader([]string{*targetsFile, *expect, *credentialsFile, *enrich, *customChecks}, load)
+		watch(targets, opts, store, storeOpts, *watchInterval, reload)
+		return
+	}
+
+	proxies := &topology{}
+	if *rankAnomalies {
+		// Results can only be ranked once all of them are in
+		var results []*ScanResult
+		scan(func(result *ScanResult) {
+			results = append(results, result)
+		})
+		rankByAnomaly(results)
+		for _, result := range results {
+			proxies.add(redactor.apply(result))
+			printResult(result)
+		}
+	} else {
+		scan(func(result *ScanResult) {
+			proxies.add(redactor.apply(result))
+			printResult(result)
+		})
+	}
+	saveStore(store)
+	saveMetrics()
+	// v1 ends with the last target's fields
+	if textCompat == TextCompatV1 {
+		return
+	}
+	render.separator(os.Stdout)
+	for _, line := range proxies.lines() {
+		fmt.Println(line)
+	}
+	fmt.Printf(tr("Total traffic: %s\n"), &traffic.total)
+	for _, line := range latencies.summary() {
+		fmt.Println(line)
+	}
 	return
 
 }
@@ -0,0 +1,152 @@
+package main
+
+/*
+Language the text output is printed in unless -lang says otherwise
+*/
+const defaultLang = "en"
+
+/*
+Language of the text output, set with -lang
+*/
+var outputLang = defaultLang
+
+/*
+catalogs translates the labels and messages of the text output, keyed
+by language and by the English text, format verbs included. Strings a
+catalog lacks print in English. Finding titles and details, rule IDs
+and the values themselves are left as they are, so they can be searched
+for whatever the language.
+*/
+var catalogs = map[string]map[string]string{
+	"es": {
+		"MySQL is not running on the given host and port: %s\n":                     "MySQL no se está ejecutando en el host y puerto indicados: %s\n",
+		"Server refused the connection (%s): %s\n":                                  "El servidor rechazó la conexión (%s): %s\n",
+		"Failed to decode packet: %s\n":                                             "No se pudo decodificar el paquete: %s\n",
+		"Tarpit suspected (%s): %s\n":                                               "Posible tarpit (%s): %s\n",
+		"Filtered, a SYN proxy accepted the connection and reset it on write: %s\n": "Filtrado, un proxy SYN aceptó la conexión y la restableció al escribir: %s\n",
+		"Target ID: %s":         "ID del objetivo: %s",
+		"Scanned: %s to %s":     "Escaneado: de %s a %s",
+		"Tags: %s":              "Etiquetas: %s",
+		"Traffic: %s":           "Tráfico: %s",
+		"Cached: probed %s ago": "En caché: sondeado hace %s",
+		"Open with no greeting, consistent with group replication (XCom)": "Abierto sin saludo, compatible con la replicación de grupo (XCom)",
+		"Handshake packet:":               "Paquete de handshake:",
+		"Stability: %s":                   "Estabilidad: %s",
+		"Statistics: %s":                  "Estadísticas: %s",
+		"Clock skew: %s":                  "Desfase del reloj: %s",
+		"Schemas: %d":                     "Esquemas: %d",
+		"Schema: %s":                      "Esquema: %s",
+		"Listener: %s":                    "Puerto de escucha: %s",
+		"Finding: %s":                     "Hallazgo: %s",
+		"Protocol version: %d":            "Versión del protocolo: %d",
+		"Server version: %s":              "Versión del servidor: %s",
+		"Connection ID: %d":               "ID de conexión: %d",
+		"Auth Plugin Data Len: %d":        "Longitud de los datos del plugin de autenticación: %d",
+		"Authentication plugin name: %s":  "Plugin de autenticación: %s",
+		"Status flags: %d":                "Indicadores de estado: %d",
+		"Capability flag: %d":             "Indicador de capacidades: %d",
+		"Capabilities: %s":                "Capacidades: %s",
+		"MariaDB capabilities: %s":        "Capacidades de MariaDB: %s",
+		"Unknown capability bits: 0x%08x": "Bits de capacidad desconocidos: 0x%08x",
+		"Character set: %d":               "Juego de caracteres: %d",
+		"Protocol: X Protocol":            "Protocolo: X Protocol",
+		"Authentication mechanisms: %s":   "Mecanismos de autenticación: %s",
+		"Node type: %s":                   "Tipo de nodo: %s",
+		"Capability %s: %s":               "Capacidad %s: %s",
+		"Decode warning: %s":              "Aviso de decodificación: %s",
+		"Total traffic: %s\n":             "Tráfico total: %s\n",
+		"Handshake latency%s: %s":         "Latencia del handshake%s: %s",
+		"Topology:":                       "Topología:",
+		"Connected from: %s":              "Conectado desde: %s",
+	},
+	"de": {
+		"MySQL is not running on the given host and port: %s\n":                     "MySQL läuft nicht auf dem angegebenen Host und Port: %s\n",
+		"Server refused the connection (%s): %s\n":                                  "Der Server hat die Verbindung abgelehnt (%s): %s\n",
+		"Failed to decode packet: %s\n":                                             "Paket konnte nicht dekodiert werden: %s\n",
+		"Tarpit suspected (%s): %s\n":                                               "Tarpit vermutet (%s): %s\n",
+		"Filtered, a SYN proxy accepted the connection and reset it on write: %s\n": "Gefiltert, ein SYN-Proxy hat die Verbindung angenommen und beim Schreiben zurückgesetzt: %s\n",
+		"Target ID: %s":         "Ziel-ID: %s",
+		"Scanned: %s to %s":     "Gescannt: %s bis %s",
+		"Traffic: %s":           "Datenverkehr: %s",
+		"Cached: probed %s ago": "Zwischengespeichert: vor %s geprüft",
+		"Open with no greeting, consistent with group replication (XCom)": "Offen ohne Begrüßung, passend zu Group Replication (XCom)",
+		"Handshake packet:":               "Handshake-Paket:",
+		"Stability: %s":                   "Stabilität: %s",
+		"Statistics: %s":                  "Statistiken: %s",
+		"Clock skew: %s":                  "Uhrabweichung: %s",
+		"Schemas: %d":                     "Schemata: %d",
+		"Finding: %s":                     "Befund: %s",
+		"Protocol version: %d":            "Protokollversion: %d",
+		"Server version: %s":              "Serverversion: %s",
+		"Connection ID: %d":               "Verbindungs-ID: %d",
+		"Auth Plugin Data Len: %d":        "Länge der Auth-Plugin-Daten: %d",
+		"Authentication plugin name: %s":  "Authentifizierungs-Plugin: %s",
+		"Status flags: %d":                "Statusflags: %d",
+		"Capability flag: %d":             "Fähigkeitsflags: %d",
+		"Capabilities: %s":                "Fähigkeiten: %s",
+		"MariaDB capabilities: %s":        "MariaDB-Fähigkeiten: %s",
+		"Unknown capability bits: 0x%08x": "Unbekannte Fähigkeitsbits: 0x%08x",
+		"Character set: %d":               "Zeichensatz: %d",
+		"Protocol: X Protocol":            "Protokoll: X Protocol",
+		"Authentication mechanisms: %s":   "Authentifizierungsmechanismen: %s",
+		"Node type: %s":                   "Knotentyp: %s",
+		"Capability %s: %s":               "Fähigkeit %s: %s",
+		"Decode warning: %s":              "Dekodierungswarnung: %s",
+		"Total traffic: %s\n":             "Gesamter Datenverkehr: %s\n",
+		"Handshake latency%s: %s":         "Handshake-Latenz%s: %s",
+		"Topology:":                       "Topologie:",
+		"Connected from: %s":              "Verbunden von: %s",
+	},
+	"ja": {
+		"MySQL is not running on the given host and port: %s\n":                     "指定されたホストとポートで MySQL が動作していません: %s\n",
+		"Server refused the connection (%s): %s\n":                                  "サーバーが接続を拒否しました (%s): %s\n",
+		"Failed to decode packet: %s\n":                                             "パケットのデコードに失敗しました: %s\n",
+		"Tarpit suspected (%s): %s\n":                                               "ターピットの疑い (%s): %s\n",
+		"Filtered, a SYN proxy accepted the connection and reset it on write: %s\n": "フィルタリング済み、SYN プロキシが接続を受け付け、書き込み時にリセットしました: %s\n",
+		"Target ID: %s":         "ターゲット ID: %s",
+		"Scanned: %s to %s":     "スキャン: %s から %s",
+		"Tags: %s":              "タグ: %s",
+		"Traffic: %s":           "通信量: %s",
+		"Cached: probed %s ago": "キャッシュ: %s 前にプローブ",
+		"Open with no greeting, consistent with group replication (XCom)": "グリーティングなしで開いています、グループレプリケーション (XCom) と一致します",
+		"Handshake packet:":               "ハンドシェイクパケット:",
+		"Stability: %s":                   "安定性: %s",
+		"Backend %d: %s":                  "バックエンド %d: %s",
+		"Statistics: %s":                  "統計: %s",
+		"Clock skew: %s":                  "時刻のずれ: %s",
+		"Schemas: %d":                     "スキーマ数: %d",
+		"Schema: %s":                      "スキーマ: %s",
+		"Listener: %s":                    "リスナー: %s",
+		"Finding: %s":                     "検出事項: %s",
+		"Protocol version: %d":            "プロトコルバージョン: %d",
+		"Server version: %s":              "サーバーバージョン: %s",
+		"Connection ID: %d":               "接続 ID: %d",
+		"Auth Plugin Data Len: %d":        "認証プラグインデータ長: %d",
+		"Authentication plugin name: %s":  "認証プラグイン名: %s",
+		"Status flags: %d":                "ステータスフラグ: %d",
+		"Capability flag: %d":             "ケーパビリティフラグ: %d",
+		"Capabilities: %s":                "ケーパビリティ: %s",
+		"MariaDB capabilities: %s":        "MariaDB ケーパビリティ: %s",
+		"Unknown capability bits: 0x%08x": "不明なケーパビリティビット: 0x%08x",
+		"Character set: %d":               "文字セット: %d",
+		"Protocol: X Protocol":            "プロトコル: X Protocol",
+		"Authentication mechanisms: %s":   "認証メカニズム: %s",
+		"Node type: %s":                   "ノードタイプ: %s",
+		"Capability %s: %s":               "ケーパビリティ %s: %s",
+		"Decode warning: %s":              "デコードの警告: %s",
+		"Total traffic: %s\n":             "総通信量: %s\n",
+		"Handshake latency%s: %s":         "ハンドシェイクのレイテンシ%s: %s",
+		"Topology:":                       "トポロジー:",
+		"Connected from: %s":              "接続元: %s",
+	},
+}
+
+/*
+tr returns the -lang translation of a text output string
+*/
+func tr(text string) string {
+	if translated, ok := catalogs[outputLang][text]; ok {
+		return translated
+	}
+	return text
+}
@@ -0,0 +1,147 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+/*
+Global privileges granted by GRANT ALL ON *.*, present in mysql.user
+since 5.x
+*/
+var allPrivilegeColumns = []string{
+	"Select_priv", "Insert_priv", "Update_priv", "Delete_priv", "Create_priv",
+	"Drop_priv", "Reload_priv", "Shutdown_priv", "Process_priv", "File_priv",
+	"Index_priv", "Alter_priv", "Super_priv",
+}
+
+/*
+Plugins that keep a password hash in authentication_string. Others,
+like auth_socket, legitimately leave it empty.
+*/
+var passwordPlugins = map[string]bool{
+	"mysql_old_password":    true,
+	"mysql_native_password": true,
+	"sha256_password":       true,
+	"caching_sha2_password": true,
+}
+
+/*
+Account is a row of mysql.user, reduced to what the audit looks at
+*/
+type Account struct {
+	User          string
+	Host          string
+	Plugin        string
+	EmptyPassword bool
+	Locked        bool
+	AllPrivileges bool
+	// REPLICATION SLAVE, which lets the account stream the binary log
+	Replication bool
+}
+
+func (a Account) String() string {
+	return fmt.Sprintf("'%s'@'%s'", a.User, a.Host)
+}
+
+/*
+WildcardHost tells whether the account accepts connections from more
+than one host
+*/
+func (a Account) WildcardHost() bool {
+	return strings.ContainsAny(a.Host, "%_")
+}
+
+/*
+userColumns returns the columns of mysql.user, lower case. plugin and
+authentication_string came in 5.5.7, account_locked in 5.7.6, when the
+Password column went away.
+*/
+func userColumns(s QueryRunner) (map[string]bool, error) {
+	_, rows, err := s.Query("SHOW COLUMNS FROM mysql.user")
+	if err != nil {
+		return nil, err
+	}
+	columns := make(map[string]bool)
+	for _, row := range rows {
+		if len(row) > 0 {
+			columns[strings.ToLower(string(row[0]))] = true
+		}
+	}
+	return columns, nil
+}
+
+/*
+accountsQuery selects the columns of Account from mysql.user, with what
+stands for those the server's version of the table doesn't have. Before
+5.7.6 an empty plugin means the built-in one the Password hash is for,
+and accounts can't be locked.
+*/
+func accountsQuery(columns map[string]bool) string {
+	var plugin string
+	switch {
+	case columns["plugin"] && columns["password"]:
+		plugin = "CASE WHEN plugin <> '' THEN plugin WHEN LENGTH(Password) = 16 THEN 'mysql_old_password' ELSE 'mysql_native_password' END"
+	case columns["plugin"]:
+		plugin = "plugin"
+	default:
+		plugin = "IF(LENGTH(Password) = 16, 'mysql_old_password', 'mysql_native_password')"
+	}
+
+	var empty []string
+	for _, column := range []string{"authentication_string", "Password"} {
+		if columns[strings.ToLower(column)] {
+			empty = append(empty, "COALESCE("+column+", '') = ''")
+		}
+	}
+	if empty == nil {
+		empty = []string{"0"}
+	}
+
+	locked := "0"
+	if columns["account_locked"] {
+		locked = "account_locked = 'Y'"
+	}
+
+	var allPrivileges []string
+	for _, column := range allPrivilegeColumns {
+		if columns[strings.ToLower(column)] {
+			allPrivileges = append(allPrivileges, column+" = 'Y'")
+		}
+	}
+
+	return "SELECT User, Host, " + plugin + ", " + strings.Join(empty, " AND ") + ", " + locked + ", " +
+		strings.Join(allPrivileges, " AND ") + ", Repl_slave_priv = 'Y' FROM mysql.user"
+}
+
+/*
+queryAccounts reads every account from mysql.user. The account needs
+SELECT on mysql.user, no password hashes leave the server.
+*/
+func queryAccounts(s QueryRunner) ([]Account, error) {
+	columns, err := userColumns(s)
+	if err != nil {
+		return nil, err
+	}
+	_, rows, err := s.Query(accountsQuery(columns))
+	if err != nil {
+		return nil, err
+	}
+
+	accounts := make([]Account, 0, len(rows))
+	for _, row := range rows {
+		if len(row) != 7 {
+			return nil, fmt.Errorf("Unexpected mysql.user row with %d columns", len(row))
+		}
+		accounts = append(accounts, Account{
+			User:          string(row[0]),
+			Host:          string(row[1]),
+			Plugin:        string(row[2]),
+			EmptyPassword: string(row[3]) == "1",
+			Locked:        string(row[4]) == "1",
+			AllPrivileges: string(row[5]) == "1",
+			Replication:   string(row[6]) == "1",
+		})
+	}
+	return accounts, nil
+}
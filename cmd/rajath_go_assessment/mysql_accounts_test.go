@@ -0,0 +1,111 @@
+package main
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+/*
+fakeUserTable answers SHOW COLUMNS with the columns of a version of
+mysql.user, and the accounts query with rows, recording its statement.
+Columns missing from the table are refused as the server would.
+*/
+type fakeUserTable struct {
+	columns []string
+	rows    [][][]byte
+	query   string
+}
+
+func (f *fakeUserTable) Query(sql string) ([]string, [][][]byte, error) {
+	if sql == "SHOW COLUMNS FROM mysql.user" {
+		var rows [][][]byte
+		for _, column := range f.columns {
+			rows = append(rows, [][]byte{[]byte(column), []byte("char(1)")})
+		}
+		return []string{"Field", "Type"}, rows, nil
+	}
+	f.query = sql
+	for _, column := range []string{"account_locked", "authentication_string", "plugin", "Password"} {
+		if strings.Contains(sql, column) && !f.has(column) {
+			return nil, nil, &ServerError{Code: 1054, SQLState: "42S22", Message: "Unknown column '" + column + "' in 'field list'"}
+		}
+	}
+	return nil, f.rows, nil
+}
+
+func (f *fakeUserTable) has(column string) bool {
+	for _, c := range f.columns {
+		if c == column {
+			return true
+		}
+	}
+	return false
+}
+
+func userTable(extra ...string) []string {
+	columns := append([]string{"Host", "User", "Repl_slave_priv"}, allPrivilegeColumns...)
+	return append(columns, extra...)
+}
+
+func accountRow(values ...string) [][]byte {
+	row := make([][]byte, len(values))
+	for i, v := range values {
+		row[i] = []byte(v)
+	}
+	return row
+}
+
+func TestQueryAccountsAcrossVersions(t *testing.T) {
+	tests := []struct {
+		name    string
+		columns []string
+		// Expressions the query must and mustn't have
+		has, hasNot []string
+	}{
+		{"8.0", userTable("plugin", "authentication_string", "account_locked"),
+			[]string{"account_locked = 'Y'", ", plugin,", "COALESCE(authentication_string, '') = ''"}, []string{"Password"}},
+		{"5.6", userTable("Password", "plugin", "authentication_string"),
+			[]string{"LENGTH(Password) = 16", "COALESCE(authentication_string, '') = '' AND COALESCE(Password, '') = ''", ", 0, "}, []string{"account_locked"}},
+		{"5.1", userTable("Password"),
+			[]string{"IF(LENGTH(Password) = 16", "COALESCE(Password, '') = ''", ", 0, "}, []string{"account_locked", "plugin <>", "authentication_string"}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			table := &fakeUserTable{
+				columns: test.columns,
+				rows:    [][][]byte{accountRow("app", "%", "mysql_native_password", "1", "0", "0", "1")},
+			}
+			accounts, err := queryAccounts(table)
+			if err != nil {
+				t.Fatalf("%v with %s", err, table.query)
+			}
+			for _, s := range test.has {
+				if !strings.Contains(table.query, s) {
+					t.Errorf("query lacks %q: %s", s, table.query)
+				}
+			}
+			for _, s := range test.hasNot {
+				if strings.Contains(table.query, s) {
+					t.Errorf("query has %q: %s", s, table.query)
+				}
+			}
+			want := Account{User: "app", Host: "%", Plugin: "mysql_native_password", EmptyPassword: true, Replication: true}
+			if len(accounts) != 1 || accounts[0] != want {
+				t.Errorf("got %+v, want %+v", accounts, want)
+			}
+		})
+	}
+}
+
+func TestQueryAccountsWithoutAccess(t *testing.T) {
+	denied := &ServerError{Code: 1142, Message: "SELECT command denied to user"}
+	runner := queryFunc(func(string) ([]string, [][][]byte, error) { return nil, nil, denied })
+	if _, err := queryAccounts(runner); !errors.Is(err, denied) {
+		t.Errorf("got %v, want %v", err, denied)
+	}
+}
+
+type queryFunc func(sql string) ([]string, [][][]byte, error)
+
+func (f queryFunc) Query(sql string) ([]string, [][][]byte, error) { return f(sql) }
@@ -0,0 +1,408 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/binary"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+)
+
+/*
+Authentication plugins the client knows how to answer
+*/
+const (
+	nativePasswordPlugin      = "mysql_native_password"
+	cachingSha2PasswordPlugin = "caching_sha2_password"
+)
+
+/*
+Capabilities sent by the client in its handshake response
+*/
+const clientCapabilities = clientLongPassword | clientLongFlag | clientProtocol41 |
+	clientTransactions | clientSecureConn | clientMultiResults | clientPluginAuth |
+	clientMultiFactorAuthentication
+
+/*
+Authentication factors an account can require, as of MySQL 8.0.27
+*/
+const maxAuthFactors = 3
+
+/*
+Session is an authenticated connection to a server
+*/
+type Session struct {
+	Target    Target
+	Handshake *InitialHandshakePacket
+	User      string
+	// Client plugin each authentication factor was answered with, in order
+	Factors []string
+	conn    net.Conn
+	// Set once the connection failed mid-command and can't be reused
+	broken bool
+}
+
+/*
+Close sends COM_QUIT, when the connection is still healthy, and closes it
+*/
+func (s *Session) Close() error {
+	if !s.broken {
+		s.writeCommand(comQuit, nil)
+	}
+	return s.conn.Close()
+}
+
+/*
+scrambleNativePassword computes the mysql_native_password auth response
+
+	SHA1(password) XOR SHA1(salt + SHA1(SHA1(password)))
+*/
+func scrambleNativePassword(salt []byte, password string) []byte {
+	if password == "" {
+		return nil
+	}
+
+	stage1 := sha1.Sum([]byte(password))
+	stage2 := sha1.Sum(stage1[:])
+
+	h := sha1.New()
+	h.Write(salt[:20])
+	h.Write(stage2[:])
+	scramble := h.Sum(nil)
+
+	for i := range scramble {
+		scramble[i] ^= stage1[i]
+	}
+	return scramble
+}
+
+/*
+scrambleCachingSha2Password computes the caching_sha2_password fast auth response
+
+	SHA256(password) XOR SHA256(SHA256(SHA256(password)) + salt)
+*/
+func scrambleCachingSha2Password(salt []byte, password string) []byte {
+	if password == "" {
+		return nil
+	}
+
+	stage1 := sha256.Sum256([]byte(password))
+	stage2 := sha256.Sum256(stage1[:])
+
+	h := sha256.New()
+	h.Write(stage2[:])
+	h.Write(salt[:20])
+	scramble := h.Sum(nil)
+
+	for i := range scramble {
+		scramble[i] ^= stage1[i]
+	}
+	return scramble
+}
+
+/*
+UnsupportedPluginError is returned when the server asks for an
+authentication plugin the client can't answer. Data is what the server
+sent along with its auth switch request, if it was one.
+*/
+type UnsupportedPluginError struct {
+	Plugin string
+	Data   []byte
+}
+
+func (e *UnsupportedPluginError) Error() string {
+	return fmt.Sprintf("Unsupported auth plugin %q", e.Plugin)
+}
+
+/*
+FactorRequiredError is returned when the account passed the factors
+given and the server asks for one more, Factor counting from 1, that
+has no password or a plugin the client can't answer. Passed holds the
+client plugins of the factors before it, Data what the server sent
+with the request.
+*/
+type FactorRequiredError struct {
+	Factor int
+	Plugin string
+	Passed []string
+	Data   []byte
+}
+
+/*
+answerable tells whether the client can answer the plugin with a password
+*/
+func answerable(plugin string) bool {
+	return plugin == nativePasswordPlugin || plugin == cachingSha2PasswordPlugin
+}
+
+func (e *FactorRequiredError) Error() string {
+	return fmt.Sprintf("Authentication factor %d (%s) is required, passed %s", e.Factor, e.Plugin, strings.Join(e.Passed, ", "))
+}
+
+/*
+authResponse computes the response for the given plugin
+*/
+func authResponse(plugin string, salt []byte, password string) ([]byte, error) {
+	if password != "" && len(salt) < 20 {
+		return nil, errors.New("Auth plugin data too short")
+	}
+
+	switch plugin {
+	case nativePasswordPlugin:
+		return scrambleNativePassword(salt, password), nil
+	case cachingSha2PasswordPlugin:
+		return scrambleCachingSha2Password(salt, password), nil
+	}
+	return nil, &UnsupportedPluginError{Plugin: plugin}
+}
+
+/*
+handshakeResponse builds a HandshakeResponse41 payload
+
+	int<4>       client capabilities
+	int<4>       max packet size
+	int<1>       character set
+	string[23]   filler
+	string[NUL]  username
+	int<1>       length of auth response
+	string[n]    auth response
+	string[NUL]  client plugin name
+*/
+func handshakeResponse(packet *InitialHandshakePacket, user string, auth []byte, plugin string) []byte {
+	buf := make([]byte, 32)
+	binary.LittleEndian.PutUint32(buf[0:4], uint32(clientCapabilities))
+	binary.LittleEndian.PutUint32(buf[4:8], clientMaxPacketSize)
+	buf[8] = packet.CharacterSet
+
+	buf = appendNulString(buf, user)
+	buf = append(buf, byte(len(auth)))
+	buf = append(buf, auth...)
+	return appendNulString(buf, plugin)
+}
+
+/*
+Server errors telling the account's password has expired: at login when
+the client doesn't send clientCanHandleExpiredPasswords, or on every
+statement of the sandbox mode the server lets the client into otherwise
+*/
+const (
+	erMustChangePassword      = 1820
+	erMustChangePasswordLogin = 1862
+)
+
+/*
+passwordExpired tells whether err is the server refusing to go on until
+the password is changed, which means the password itself was right
+*/
+func passwordExpired(err error) bool {
+	var serverErr *ServerError
+	if !errors.As(err, &serverErr) {
+		return false
+	}
+	return serverErr.Code == erMustChangePassword || serverErr.Code == erMustChangePasswordLogin
+}
+
+/*
+login connects to the target and authenticates as user, with the
+passwords of the second and third factors when the account has them
+*/
+func login(target Target, user, password string, factors ...string) (*Session, error) {
+	conn, packet, _, err := openHandshake(target)
+	if err != nil {
+		return nil, err
+	}
+
+	session := &Session{Target: target, Handshake: packet, User: user, conn: conn}
+	if err := session.authenticate(append([]string{password}, factors...)); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return session, nil
+}
+
+/*
+States of the client after it sent its handshake response
+*/
+type authState int
+
+const (
+	// Waiting for OK, ERR, an auth switch or more data
+	authAwaitReply authState = iota
+	// Switched plugin once, a second switch is not allowed
+	authAwaitSwitchedReply
+	// caching_sha2_password asked for the server's RSA public key
+	authAwaitPublicKey
+	// caching_sha2_password fast auth succeeded or the password was sent
+	// for full auth, only OK or ERR may follow
+	authAwaitOK
+	authDone
+)
+
+/*
+caching_sha2_password AuthMoreData statuses, and the client's request
+for the server's public key
+*/
+const (
+	cachingSha2FastAuthSuccess  = 0x03
+	cachingSha2FullAuthNeeded   = 0x04
+	cachingSha2PublicKeyRequest = 0x02
+)
+
+/*
+fullAuthPassword answers caching_sha2_password full authentication,
+when the server has no cached hash of the account to check a scramble
+against: the password in clear over TLS, else the password XORed with
+the salt, encrypted with the server's RSA public key (OAEP with SHA-1)
+
+	string[NUL]  password
+*/
+func fullAuthPassword(password string, salt, publicKey []byte) ([]byte, error) {
+	clear := append([]byte(password), 0)
+	if publicKey == nil {
+		return clear, nil
+	}
+
+	if len(salt) < 20 {
+		return nil, errors.New("Auth plugin data too short")
+	}
+	block, _ := pem.Decode(publicKey)
+	if block == nil {
+		return nil, errors.New("Server sent no RSA public key")
+	}
+	parsed, err := x509.ParsePKIXPublicKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("Invalid server public key: %s", err.Error())
+	}
+	key, ok := parsed.(*rsa.PublicKey)
+	if !ok {
+		return nil, errors.New("Server public key is not an RSA key")
+	}
+	for i := range clear {
+		clear[i] ^= salt[i%20]
+	}
+	return rsa.EncryptOAEP(sha1.New(), rand.Reader, key, clear, nil)
+}
+
+/*
+isTLS tells whether the connection is encrypted, so the password may
+go in clear
+*/
+func isTLS(conn net.Conn) bool {
+	_, ok := conn.(*tls.Conn)
+	return ok
+}
+
+/*
+authenticate answers the greeting and follows the server through auth
+switches, further factors, with a password each, and caching_sha2_password
+full authentication, until it sends OK or ERR
+*/
+func (s *Session) authenticate(passwords []string) error {
+	password := passwords[0]
+	plugin := string(s.Handshake.AuthPluginName)
+	salt := s.Handshake.AuthPluginData
+	if !answerable(plugin) {
+		// Answer with something we understand and let the server switch us
+		plugin = cachingSha2PasswordPlugin
+	}
+
+	auth, err := authResponse(plugin, salt, password)
+	if err != nil {
+		return err
+	}
+	s.Factors = []string{plugin}
+
+	seq := s.Handshake.header.SequenceId + 1
+	if err := writePacket(s.conn, seq, handshakeResponse(s.Handshake, s.User, auth, plugin)); err != nil {
+		return err
+	}
+
+	state := authAwaitReply
+	for state != authDone {
+		seq, payload, err := readPacket(s.conn)
+		if err != nil {
+			return err
+		}
+		reply, err := decodeAuthReply(payload)
+		if err != nil {
+			return err
+		}
+
+		switch reply := reply.(type) {
+		case *OKPacket:
+			state = authDone
+		case *ServerError:
+			return reply
+		case *AuthSwitchRequest:
+			if state != authAwaitReply {
+				return errors.New("Unexpected auth switch request")
+			}
+			salt = reply.Data
+			auth, err := authResponse(reply.Plugin, reply.Data, password)
+			if unsupported, ok := err.(*UnsupportedPluginError); ok {
+				unsupported.Data = reply.Data
+			}
+			if err != nil {
+				return err
+			}
+			if err := writePacket(s.conn, seq+1, auth); err != nil {
+				return err
+			}
+			s.Factors[len(s.Factors)-1] = reply.Plugin
+			state = authAwaitSwitchedReply
+		case *AuthNextFactor:
+			factor := len(s.Factors) + 1
+			if factor > maxAuthFactors {
+				return errors.New("Unexpected auth next factor packet")
+			}
+			if factor > len(passwords) || passwords[factor-1] == "" || !answerable(reply.Plugin) {
+				return &FactorRequiredError{Factor: factor, Plugin: reply.Plugin, Passed: s.Factors, Data: reply.Data}
+			}
+			password, salt = passwords[factor-1], reply.Data
+			auth, err := authResponse(reply.Plugin, reply.Data, password)
+			if err != nil {
+				return err
+			}
+			if err := writePacket(s.conn, seq+1, auth); err != nil {
+				return err
+			}
+			s.Factors = append(s.Factors, reply.Plugin)
+			// A factor can't switch plugins, it is the one the server asked for
+			state = authAwaitSwitchedReply
+		case *AuthMoreData:
+			if state == authAwaitOK || len(reply.Data) == 0 {
+				return errors.New("Unexpected auth more data packet")
+			}
+			var answer []byte
+			switch {
+			case state == authAwaitPublicKey:
+				if answer, err = fullAuthPassword(password, salt, reply.Data); err != nil {
+					return err
+				}
+				state = authAwaitOK
+			case reply.Data[0] == cachingSha2FastAuthSuccess:
+				state = authAwaitOK
+				continue
+			case reply.Data[0] != cachingSha2FullAuthNeeded:
+				return fmt.Errorf("Unknown caching_sha2_password status 0x%02x", reply.Data[0])
+			case isTLS(s.conn):
+				answer, _ = fullAuthPassword(password, salt, nil)
+				state = authAwaitOK
+			default:
+				answer = []byte{cachingSha2PublicKeyRequest}
+				state = authAwaitPublicKey
+			}
+			if err := writePacket(s.conn, seq+1, answer); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+)
+
+/*
+Command bytes sent by the client to start a command phase exchange
+*/
+const (
+	comQuit       = 0x01
+	comQuery      = 0x03
+	comStatistics = 0x09
+	comPing       = 0x0e
+)
+
+/*
+errSessionBroken is returned by commands on a session whose connection failed
+*/
+var errSessionBroken = errors.New("Session connection is broken")
+
+/*
+writeCommand starts a new command, which always resets the sequence id
+*/
+func (s *Session) writeCommand(command byte, arg []byte) error {
+	if s.broken {
+		return errSessionBroken
+	}
+
+	payload := append([]byte{command}, arg...)
+	if err := writePacket(s.conn, 0, payload); err != nil {
+		s.broken = true
+		return err
+	}
+	return nil
+}
+
+/*
+readReply reads the next packet of a command's response
+*/
+func (s *Session) readReply() ([]byte, error) {
+	_, payload, err := readPacket(s.conn)
+	if err != nil {
+		s.broken = true
+		return nil, err
+	}
+	if len(payload) == 0 {
+		s.broken = true
+		return nil, errors.New("Empty reply packet")
+	}
+	return payload, nil
+}
+
+/*
+Ping sends COM_PING, checking the session is still usable
+*/
+func (s *Session) Ping() error {
+	if err := s.writeCommand(comPing, nil); err != nil {
+		return err
+	}
+
+	payload, err := s.readReply()
+	if err != nil {
+		return err
+	}
+
+	switch payload[0] {
+	case okHeader:
+		_, err := decodeOK(payload)
+		return err
+	case errHeader:
+		return decodeServerError(payload)
+	}
+	return fmt.Errorf("Unexpected reply 0x%02x to COM_PING", payload[0])
+}
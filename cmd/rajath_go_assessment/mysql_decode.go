@@ -1,12 +1,13 @@
 package main
 
 import (
-	"bytes"
 	"encoding/binary"
 	"errors"
 	"fmt"
+	"io"
 	"net"
 	"strings"
+	"time"
 )
 
 /*
@@ -31,7 +32,64 @@ type InitialHandshakePacket struct {
 	StatusFlags       uint16
 	AuthPluginDataLen uint8
 	AuthPluginName    []byte
-	header            *PacketHeader
+	// Legacy encodings the decoder accepted
+	Warnings []DecodeWarning
+	header   *PacketHeader
+	// The packet as received, header included, and where each field sits in it
+	raw    []byte
+	fields []PacketField
+}
+
+/*
+DecodeWarning notes a greeting the decoder accepted despite a quirk of
+old servers, and what the quirk says about the server's version
+*/
+type DecodeWarning struct {
+	Code   string
+	Field  string
+	Detail string
+	// Releases that fixed the quirk, one per series, so the server is
+	// older than the one of its series
+	FixedIn []string
+}
+
+func (w DecodeWarning) String() string {
+	return fmt.Sprintf("%s: %s (fixed in %s)", w.Code, w.Detail, strings.Join(w.FixedIn, " and "))
+}
+
+/*
+contradicts tells whether the version is one the quirk was already
+fixed in: at or past the fix of its series, or newer than every fix
+*/
+func (w DecodeWarning) contradicts(version string) bool {
+	major, minor, ok := serverRelease(version)
+	if !ok || len(w.FixedIn) == 0 {
+		return false
+	}
+	if strings.Contains(version, "MariaDB") {
+		version = strings.TrimPrefix(version, "5.5.5-")
+	}
+	for _, fix := range w.FixedIn {
+		if fixMajor, fixMinor, _ := serverRelease(fix); fixMajor == major && fixMinor == minor {
+			return compareVersions(version, fix) >= 0
+		}
+	}
+	return compareVersions(version, w.FixedIn[len(w.FixedIn)-1]) > 0
+}
+
+/*
+Bug#59453: servers before 5.5.10 and 5.6.2 send the auth plugin name
+without its terminating NUL
+*/
+const warnPluginNameUnterminated = "auth-plugin-name-unterminated"
+
+/*
+PacketField is where a decoded field sits in the raw packet
+*/
+type PacketField struct {
+	Name   string
+	Offset int
+	Length int
 }
 
 /*
@@ -39,95 +97,229 @@ Decode decodes the first packet received from the MySQl Server
 It's assumed to be a handshake packet
 */
 func (r *InitialHandshakePacket) Decode(conn net.Conn) error {
-	data := make([]byte, 1024)
-	_, err := conn.Read(data)
+	return r.decodeWithin(conn, decodeTimeouts.read, decodeTimeouts.total)
+}
+
+/*
+decodeWithin decodes the handshake, failing when a read takes longer
+than read or the whole packet longer than total
+*/
+func (r *InitialHandshakePacket) decodeWithin(conn net.Conn, read, total time.Duration) error {
+	reader := newDeadlineReader(conn, read, total)
+	defer reader.done()
+
+	data := make([]byte, 4)
+	if _, err := io.ReadFull(reader, data); err != nil {
+		return err
+	}
+	if _, err := r.decodeHeader(data, 0); err != nil {
+		return err
+	}
+
+	data = append(data, make([]byte, r.header.Length)...)
+	n, err := io.ReadFull(reader, data[4:])
+	kind := "Handshake"
+	if n > 0 && data[4] == 0xff {
+		kind = "ERR"
+	}
+	tracer.packet(conn, false, int(r.header.SequenceId), int(r.header.Length), kind, data[4:4+n])
 	if err != nil {
 		return err
 	}
+	return r.decodePacket(data)
+}
+
+/*
+decodePacket decodes a whole packet, header included. Each step decodes
+a group of fields starting at the given position and returns how many
+bytes it used.
+*/
+func (r *InitialHandshakePacket) decodePacket(data []byte) error {
+	r.raw = data
+	r.fields = nil
+
+	steps := []func(data []byte, position int) (int, error){
+		r.decodeHeader,
+		r.decodeVersion,
+		r.decodeAuthData,
+		r.decodeCapabilities,
+		r.decodeTrailer,
+	}
+	position := 0
+	for _, step := range steps {
+		n, err := step(data, position)
+		if err != nil {
+			return err
+		}
+		position += n
+	}
+	return nil
+}
+
+/*
+errHandshakeTruncated is returned when a field runs past the end of the packet
+*/
+var errHandshakeTruncated = errors.New("Handshake packet is truncated")
+
+/*
+field records a field of the given length at position, failing when the
+packet is too short to hold it
+*/
+func (r *InitialHandshakePacket) field(data []byte, name string, position, length int) ([]byte, error) {
+	if position+length > len(data) {
+		return nil, errHandshakeTruncated
+	}
+	r.fields = append(r.fields, PacketField{Name: name, Offset: position, Length: length})
+	return data[position : position+length], nil
+}
+
+/*
+decodeHeader decodes the packet header
+
+	int<3>	payload length
+	int<1>	sequence id
+*/
+func (r *InitialHandshakePacket) decodeHeader(data []byte, position int) (int, error) {
+	r.fields = nil
+	ln, err := r.field(data, "payload length", position, 3)
+	if err != nil {
+		return 0, err
+	}
+	seq, err := r.field(data, "sequence id", position+3, 1)
+	if err != nil {
+		return 0, err
+	}
 
 	header := &PacketHeader{}
-	ln := []byte{data[0], data[1], data[2], 0x00}
-	header.Length = binary.LittleEndian.Uint32(ln)
+	header.Length = binary.LittleEndian.Uint32([]byte{ln[0], ln[1], ln[2], 0x00})
 	// Single byte integer is the same in BigEndian and LittleEndian
-	header.SequenceId = data[3]
+	header.SequenceId = seq[0]
 
 	// Header Sanity check
-	if header.Length >= 1024 {
-		return errors.New("Header sanity check failed!")
+	if header.Length > maxHandshakeSize {
+		return 0, packetTooLarge(header.Length, maxHandshakeSize)
 	}
-
 	r.header = header
-	/**
-	Assign payload only data to new var just for convenience
-	*/
-	payload := data[4 : header.Length+4]
-	position := 0
-	/**
-	As defined in the documentation, this value is alway 10 (0x00 in hex)
-	1	[0a] protocol version
-	*/
-	r.ProtocolVersion = payload[0]
+	return 4, nil
+}
+
+/*
+decodeVersion decodes the protocol version, server version and
+connection id. Servers refusing the connection send an error instead.
+
+	int<1>	protocol version, always 10 (0x0a)
+	string[NUL]	server version
+	int<4>	connection id
+*/
+func (r *InitialHandshakePacket) decodeVersion(data []byte, position int) (int, error) {
+	start := position
+	version, err := r.field(data, "protocol version", position, 1)
+	if err != nil {
+		return 0, err
+	}
+	r.ProtocolVersion = version[0]
 	if r.ProtocolVersion != 0x0a {
+		return 0, r.refusal(data)
+	}
+	position++
 
-		// This is not the best way but appears to work for this POC.
-		// Hopefully this message has not been localized
-		// Find index right of first terminal character (0x00)
-		termIndex := bytes.IndexByte(data, byte(0x00)) + 1
-		s := string(payload[termIndex:header.Length])
-		if strings.Contains(s, "is not allowed to connect to this MySQL server") {
-			return errors.New(s)
-		}
+	version, n, err := readNulString(data[position:])
+	if err != nil {
+		return 0, errHandshakeTruncated
+	}
+	if r.ServerVersion, err = r.field(data, "server version", position, len(version)); err != nil {
+		return 0, err
+	}
+	position += n
 
-		if r.ProtocolVersion == 0x09 {
-			return errors.New("Version 9 is not yet supported!")
-		}
+	connectionId, err := r.field(data, "connection id", position, 4)
+	if err != nil {
+		return 0, err
+	}
+	r.ConnectionId = binary.LittleEndian.Uint32(connectionId)
+	position += 4
+
+	return position - start, nil
+}
 
-		return errors.New("Only version 10 is supported. Unknown procotcol version!")
+/*
+refusal explains a packet that isn't a version 10 greeting
+*/
+func (r *InitialHandshakePacket) refusal(data []byte) error {
+	// The server sends an ERR packet instead of the greeting when it refuses
+	// us, classified by its error code as the message may be translated
+	if r.ProtocolVersion == 0xff {
+		return decodeServerError(data[4:])
 	}
 
-	position += 1
+	if r.ProtocolVersion == 0x09 {
+		return errors.New("Version 9 is not yet supported!")
+	}
 
-	/*
-		Extract server version, by finding the terminal character (0x00) index,
-		and extracting the data in between
-		string[NUL]    server version
-	*/
-	index := bytes.IndexByte(payload, byte(0x00))
-	r.ServerVersion = payload[position:index]
-	position = index + 1
+	return errors.New("Only version 10 is supported. Unknown procotcol version!")
+}
 
-	connectionId := payload[position : position+4]
-	id := binary.LittleEndian.Uint32(connectionId)
-	r.ConnectionId = id
-	position += 4
+/*
+decodeAuthData decodes the first part of the auth-plugin-data and the filler
 
+	string[8]	auth-plugin-data-part-1
+	int<1>	filler, always 0x00
+*/
+func (r *InitialHandshakePacket) decodeAuthData(data []byte, position int) (int, error) {
+	part1, err := r.field(data, "auth-plugin-data-part-1", position, 8)
+	if err != nil {
+		return 0, err
+	}
 	/*
 		The auth-plugin-data is the concatenation of strings
 		auth-plugin-data-part-1 and auth-plugin-data-part-2.
 	*/
 	r.AuthPluginData = make([]byte, 8)
-	copy(r.AuthPluginData, payload[position:position+8])
+	copy(r.AuthPluginData, part1)
 
-	position += 8
-
-	r.Filler = payload[position]
+	filler, err := r.field(data, "filler", position+8, 1)
+	if err != nil {
+		return 0, err
+	}
+	r.Filler = filler[0]
 	if r.Filler != 0x00 {
-		return errors.New("Unable to decode filler value")
+		return 0, errors.New("Unable to decode filler value")
 	}
+	return 9, nil
+}
 
-	position += 1
-
-	capabilitiesFlags1 := payload[position : position+2]
-	position += 2
-
-	r.CharacterSet = payload[position]
-	position += 1
+/*
+decodeCapabilities decodes the capability flags, character set and
+status flags, the length of the auth-plugin-data and the reserved bytes
+
+	int<2>	capability flags (lower 2 bytes)
+	int<1>	character set
+	int<2>	status flags
+	int<2>	capability flags (upper 2 bytes)
+	int<1>	length of auth-plugin-data, or 0x00
+	string[10]	reserved (all [00])
+*/
+func (r *InitialHandshakePacket) decodeCapabilities(data []byte, position int) (int, error) {
+	capabilitiesFlags1, err := r.field(data, "capability flags (lower)", position, 2)
+	if err != nil {
+		return 0, err
+	}
+	charset, err := r.field(data, "character set", position+2, 1)
+	if err != nil {
+		return 0, err
+	}
+	r.CharacterSet = charset[0]
 
-	r.StatusFlags = binary.LittleEndian.Uint16(payload[position : position+2])
-	position += 2
+	status, err := r.field(data, "status flags", position+3, 2)
+	if err != nil {
+		return 0, err
+	}
+	r.StatusFlags = binary.LittleEndian.Uint16(status)
 
-	capabilityFlags2 := payload[position : position+2]
-	position += 2
+	capabilityFlags2, err := r.field(data, "capability flags (upper)", position+5, 2)
+	if err != nil {
+		return 0, err
+	}
 
 	/*
 		Reconstruct 32 bit integer from two 16 bit integers.
@@ -139,45 +331,113 @@ func (r *InitialHandshakePacket) Decode(conn net.Conn) error {
 
 	r.CapabilitiesFlags = CapabilityFlag(cap)
 
+	authDataLen, err := r.field(data, "auth-plugin-data length", position+7, 1)
+	if err != nil {
+		return 0, err
+	}
 	if r.CapabilitiesFlags&clientPluginAuth != 0 {
-		r.AuthPluginDataLen = payload[position]
+		r.AuthPluginDataLen = authDataLen[0]
 		if r.AuthPluginDataLen == 0 {
-			return errors.New("Wrong auth plugin data len")
+			return 0, errors.New("Wrong auth plugin data len")
 		}
 	}
 
-	/*
-		Skip reserved bytes
-		string[10]     reserved (all [00])
-	*/
-	position += 1 + 10
+	if _, err := r.field(data, "reserved", position+8, 10); err != nil {
+		return 0, err
+	}
+	return 18, nil
+}
+
+/*
+decodeTrailer decodes the second part of the auth-plugin-data and the
+auth plugin name
+
+	string[$len]	auth-plugin-data-part-2, $len=MAX(13, length of auth-plugin-data - 8)
+	string[NUL]	auth-plugin name
+*/
+func (r *InitialHandshakePacket) decodeTrailer(data []byte, position int) (int, error) {
+	start := position
 
 	/**
 	This flag tell us that the client should hash the password using algorithm described here:
 	https://dev.mysql.com/doc/internals/en/secure-password-authentication.html#packet-Authentication::Native41
 	*/
 	if r.CapabilitiesFlags&clientSecureConn != 0 {
-		/*
-			The auth-plugin-data is the concatenation of strings auth-plugin-data-part-1 and auth-plugin-data-part-2.
-		*/
-		end := position + Max(13, int(r.AuthPluginDataLen)-8)
-		r.AuthPluginData = append(r.AuthPluginData, payload[position:end]...)
-		position = end
+		length := Max(13, int(r.AuthPluginDataLen)-8)
+		part2, err := r.field(data, "auth-plugin-data-part-2", position, length)
+		if err != nil {
+			return 0, err
+		}
+		r.AuthPluginData = append(r.AuthPluginData, part2...)
+		position += length
 	}
 
-	index = bytes.IndexByte(payload[position:], byte(0x00))
-
 	/*
 		Due to Bug#59453 the auth-plugin-name is missing the terminating NUL-char in versions prior to 5.5.10 and 5.6.2.
 		We know the length of the payload, so if there is no NUL-char, just read all the data until the end
 	*/
-	if index != -1 {
-		r.AuthPluginName = payload[position : position+index]
-	} else {
-		r.AuthPluginName = payload[position:]
+	value, consumed, err := readNulString(data[position:])
+	if err != nil {
+		value, consumed = readEOFString(data[position:])
+		if len(value) > 0 {
+			r.Warnings = append(r.Warnings, DecodeWarning{
+				Code:    warnPluginNameUnterminated,
+				Field:   "auth-plugin name",
+				Detail:  "the auth plugin name ends with the packet instead of a NUL, as sent before Bug#59453 was fixed",
+				FixedIn: []string{"5.5.10", "5.6.2"},
+			})
+		}
+	}
+	name, err := r.field(data, "auth-plugin name", position, len(value))
+	if err != nil {
+		return 0, err
 	}
+	r.AuthPluginName = name
+	position += consumed
 
-	return nil
+	return position - start, nil
+}
+
+/*
+Hexdump shows the raw packet one field per line, with each field's
+offset and name. Long fields wrap every 16 bytes.
+*/
+func (r *InitialHandshakePacket) Hexdump() string {
+	var lines []string
+	for _, f := range r.fields {
+		value := r.raw[f.Offset : f.Offset+f.Length]
+		for start := 0; start == 0 || start < len(value); start += 16 {
+			end := start + 16
+			if end > len(value) {
+				end = len(value)
+			}
+			name := ""
+			if start == 0 {
+				name = f.Name
+			}
+			line := fmt.Sprintf("%04x  %-47s  %s", f.Offset+start, fmt.Sprintf("% x", value[start:end]), name)
+			lines = append(lines, strings.TrimRight(line, " "))
+		}
+	}
+	return strings.Join(lines, "\n")
+}
+
+/*
+withoutSalt returns a copy of the packet with the auth-plugin-data
+removed, and zeroed in the raw packet
+*/
+func (r InitialHandshakePacket) withoutSalt() *InitialHandshakePacket {
+	r.AuthPluginData = nil
+	if r.raw != nil {
+		raw := append([]byte(nil), r.raw...)
+		for _, f := range r.fields {
+			if strings.HasPrefix(f.Name, "auth-plugin-data-part-") {
+				copy(raw[f.Offset:f.Offset+f.Length], make([]byte, f.Length))
+			}
+		}
+		r.raw = raw
+	}
+	return &r
 }
 
 type CapabilityFlag uint32
@@ -242,6 +502,16 @@ const (
 	clientCanHandleExpiredPasswords
 	clientSessionTrack
 	clientDeprecateEOF
+	// Added by MySQL 8.0: 8.0.3, 8.0.18, 8.0.23 and 8.0.27
+	clientOptionalResultsetMetadata
+	clientZstdCompressionAlgorithm
+	clientQueryAttributes
+	clientMultiFactorAuthentication
+	// Reserved to extend the flags past 32 bits, so far never set
+	clientCapabilityExtension
+	// Client side flags, which servers advertise all the same
+	clientSSLVerifyServerCert
+	clientRememberOptions
 )
 
 var flags = map[CapabilityFlag]string{
@@ -270,6 +540,89 @@ var flags = map[CapabilityFlag]string{
 	clientCanHandleExpiredPasswords:  "clientCanHandleExpiredPasswords",
 	clientSessionTrack:               "clientSessionTrack",
 	clientDeprecateEOF:               "clientDeprecateEOF",
+	clientOptionalResultsetMetadata:  "clientOptionalResultsetMetadata",
+	clientZstdCompressionAlgorithm:   "clientZstdCompressionAlgorithm",
+	clientQueryAttributes:            "clientQueryAttributes",
+	clientMultiFactorAuthentication:  "clientMultiFactorAuthentication",
+	clientCapabilityExtension:        "clientCapabilityExtension",
+	clientSSLVerifyServerCert:        "clientSSLVerifyServerCert",
+	clientRememberOptions:            "clientRememberOptions",
+}
+
+/*
+Names returns the names of the known flags set, lowest bit first
+*/
+func (r CapabilityFlag) Names() []string {
+	var names []string
+	for bit := CapabilityFlag(1); bit != 0; bit <<= 1 {
+		if name, ok := flags[bit]; ok && r.Has(bit) {
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+/*
+MariaDBCapabilityFlag holds MariaDB's own capabilities, bits 32 and up
+of its 64 bit capabilities. MySQL has named all 32 bits of its flags, so
+these are where servers newer than the scanner add features.
+*/
+type MariaDBCapabilityFlag uint32
+
+const (
+	mariaDBClientProgress MariaDBCapabilityFlag = 1 << iota
+	// Once COM_MULTI, reserved since 10.6
+	mariaDBClientComMulti
+	mariaDBClientStmtBulkOperations
+	mariaDBClientExtendedMetadata
+	mariaDBClientCacheMetadata
+	mariaDBClientBulkUnitResults
+)
+
+var mariaDBFlags = map[MariaDBCapabilityFlag]string{
+	mariaDBClientProgress:           "mariaDBClientProgress",
+	mariaDBClientComMulti:           "mariaDBClientComMulti",
+	mariaDBClientStmtBulkOperations: "mariaDBClientStmtBulkOperations",
+	mariaDBClientExtendedMetadata:   "mariaDBClientExtendedMetadata",
+	mariaDBClientCacheMetadata:      "mariaDBClientCacheMetadata",
+	mariaDBClientBulkUnitResults:    "mariaDBClientBulkUnitResults",
+}
+
+/*
+Names returns the names of the known flags set, lowest bit first
+*/
+func (r MariaDBCapabilityFlag) Names() []string {
+	var names []string
+	for bit := MariaDBCapabilityFlag(1); bit != 0; bit <<= 1 {
+		if name, ok := mariaDBFlags[bit]; ok && r&bit != 0 {
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+/*
+Unknown returns the bits set that aren't in mariaDBFlags, server
+features newer than the scanner
+*/
+func (r MariaDBCapabilityFlag) Unknown() MariaDBCapabilityFlag {
+	for bit := range mariaDBFlags {
+		r &^= bit
+	}
+	return r
+}
+
+/*
+MariaDBCapabilities returns the capabilities MariaDB keeps in the last 4
+reserved bytes, which it only does when it leaves clientLongPassword
+unset. They are zero for other servers.
+*/
+func (r *InitialHandshakePacket) MariaDBCapabilities() MariaDBCapabilityFlag {
+	reserved, ok := r.rawField("reserved")
+	if !ok || !strings.Contains(string(r.ServerVersion), "MariaDB") || r.CapabilitiesFlags.Has(clientLongPassword) {
+		return 0
+	}
+	return MariaDBCapabilityFlag(binary.LittleEndian.Uint32(reserved[6:]))
 }
 
 func Max(x, y int) int {
@@ -279,18 +632,79 @@ func Max(x, y int) int {
 	return y
 }
 
-func (packet InitialHandshakePacket) GetPacketInfo() string {
+/*
+HandshakeInfo is what the initial handshake tells about the server, for
+callers that need its fields rather than the printed summary
+*/
+type HandshakeInfo struct {
+	ProtocolVersion   uint8
+	ServerVersion     string
+	ConnectionID      uint32
+	AuthPluginDataLen uint8
+	AuthPluginName    string
+	StatusFlags       uint16
+	Capabilities      CapabilityFlag
+	// MariaDB's own, beyond the 32 bits of Capabilities
+	MariaDBCapabilities MariaDBCapabilityFlag
+	CharacterSet        uint8
+}
+
+/*
+Info returns the handshake's fields
+*/
+func (packet InitialHandshakePacket) Info() HandshakeInfo {
+	return HandshakeInfo{
+		ProtocolVersion:     packet.ProtocolVersion,
+		ServerVersion:       string(packet.ServerVersion),
+		ConnectionID:        packet.ConnectionId,
+		AuthPluginDataLen:   packet.AuthPluginDataLen,
+		AuthPluginName:      string(packet.AuthPluginName),
+		StatusFlags:         packet.StatusFlags,
+		Capabilities:        packet.CapabilitiesFlags,
+		MariaDBCapabilities: packet.MariaDBCapabilities(),
+		CharacterSet:        packet.CharacterSet,
+	}
+}
+
+/*
+v1 formats the handshake as -compat v1 prints it, the fields of the
+greeting without the capability names added since
+*/
+func (info HandshakeInfo) v1() string {
+	return strings.Join([]string{
+		fmt.Sprintf("Protocol version: %d", info.ProtocolVersion),
+		fmt.Sprintf("Server version: %s", info.ServerVersion),
+		fmt.Sprintf("Connection ID: %d", info.ConnectionID),
+		fmt.Sprintf("Auth Plugin Data Len: %d", info.AuthPluginDataLen),
+		fmt.Sprintf("Authentication plugin name: %s", info.AuthPluginName),
+		fmt.Sprintf("Status flags: %d", info.StatusFlags),
+		fmt.Sprintf("Capability flag: %d", uint32(info.Capabilities)),
+		fmt.Sprintf("Character set: %d", info.CharacterSet),
+	}, "\n")
+}
+
+/*
+String formats the handshake one field per line, as printed for a target
+*/
+func (info HandshakeInfo) String() string {
 
 	var packetInfo []string
 
-	packetInfo = append(packetInfo, fmt.Sprintf("Protocol version: %d", packet.ProtocolVersion))
-	packetInfo = append(packetInfo, fmt.Sprintf("Server version: %s", packet.ServerVersion))
-	packetInfo = append(packetInfo, fmt.Sprintf("Connection ID: %d", packet.ConnectionId))
-	packetInfo = append(packetInfo, fmt.Sprintf("Auth Plugin Data Len: %d", packet.AuthPluginDataLen))
-	packetInfo = append(packetInfo, fmt.Sprintf("Authentication plugin name: %s", packet.AuthPluginName))
-	packetInfo = append(packetInfo, fmt.Sprintf("Status flags: %d", packet.StatusFlags))
-	packetInfo = append(packetInfo, fmt.Sprintf("Capability flag: %d", packet.CapabilitiesFlags))
-	packetInfo = append(packetInfo, fmt.Sprintf("Character set: %d", packet.CharacterSet))
+	packetInfo = append(packetInfo, fmt.Sprintf(tr("Protocol version: %d"), info.ProtocolVersion))
+	packetInfo = append(packetInfo, fmt.Sprintf(tr("Server version: %s"), info.ServerVersion))
+	packetInfo = append(packetInfo, fmt.Sprintf(tr("Connection ID: %d"), info.ConnectionID))
+	packetInfo = append(packetInfo, fmt.Sprintf(tr("Auth Plugin Data Len: %d"), info.AuthPluginDataLen))
+	packetInfo = append(packetInfo, fmt.Sprintf(tr("Authentication plugin name: %s"), info.AuthPluginName))
+	packetInfo = append(packetInfo, fmt.Sprintf(tr("Status flags: %d"), info.StatusFlags))
+	packetInfo = append(packetInfo, fmt.Sprintf(tr("Capability flag: %d"), uint32(info.Capabilities)))
+	packetInfo = append(packetInfo, fmt.Sprintf(tr("Capabilities: %s"), strings.Join(info.Capabilities.Names(), ", ")))
+	if info.MariaDBCapabilities != 0 {
+		packetInfo = append(packetInfo, fmt.Sprintf(tr("MariaDB capabilities: %s"), strings.Join(info.MariaDBCapabilities.Names(), ", ")))
+	}
+	if unknown := info.MariaDBCapabilities.Unknown(); unknown != 0 {
+		packetInfo = append(packetInfo, fmt.Sprintf(tr("Unknown capability bits: 0x%08x"), uint32(unknown)))
+	}
+	packetInfo = append(packetInfo, fmt.Sprintf(tr("Character set: %d"), info.CharacterSet))
 
 	return strings.Join(packetInfo, "\n")
 }
@@ -0,0 +1,233 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestDecodeSteps(t *testing.T) {
+	data := greetingBytes(t)
+	packet := &InitialHandshakePacket{raw: data}
+	steps := []struct {
+		name     string
+		step     func(data []byte, position int) (int, error)
+		consumed int
+	}{
+		{"header", packet.decodeHeader, 4},
+		{"version", packet.decodeVersion, 12},
+		{"auth data", packet.decodeAuthData, 9},
+		{"capabilities", packet.decodeCapabilities, 18},
+		{"trailer", packet.decodeTrailer, 35},
+	}
+	position := 0
+	for _, step := range steps {
+		n, err := step.step(data, position)
+		if err != nil {
+			t.Fatalf("%s: %v", step.name, err)
+		}
+		if n != step.consumed {
+			t.Errorf("%s consumed %d bytes, want %d", step.name, n, step.consumed)
+		}
+		position += n
+	}
+	if position != len(data) {
+		t.Errorf("decoded %d of %d bytes", position, len(data))
+	}
+}
+
+func TestDecodePacketFields(t *testing.T) {
+	packet := &InitialHandshakePacket{}
+	if err := packet.decodePacket(greetingBytes(t)); err != nil {
+		t.Fatal(err)
+	}
+
+	want := []PacketField{
+		{"payload length", 0, 3},
+		{"sequence id", 3, 1},
+		{"protocol version", 4, 1},
+		{"server version", 5, 6},
+		{"connection id", 12, 4},
+		{"auth-plugin-data-part-1", 16, 8},
+		{"filler", 24, 1},
+		{"capability flags (lower)", 25, 2},
+		{"character set", 27, 1},
+		{"status flags", 28, 2},
+		{"capability flags (upper)", 30, 2},
+		{"auth-plugin-data length", 32, 1},
+		{"reserved", 33, 10},
+		{"auth-plugin-data-part-2", 43, 13},
+		{"auth-plugin name", 56, 21},
+	}
+	if len(packet.fields) != len(want) {
+		t.Fatalf("got %d fields, want %d: %v", len(packet.fields), len(want), packet.fields)
+	}
+	for i, field := range packet.fields {
+		if field != want[i] {
+			t.Errorf("field %d is %+v, want %+v", i, field, want[i])
+		}
+	}
+
+	if packet.header.Length != 74 || packet.header.SequenceId != 0 {
+		t.Errorf("header %+v", packet.header)
+	}
+	if string(packet.ServerVersion) != "8.0.32" || packet.ConnectionId != 335 {
+		t.Errorf("server version %q connection id %d", packet.ServerVersion, packet.ConnectionId)
+	}
+	if packet.AuthPluginDataLen != 21 || len(packet.AuthPluginData) != 21 {
+		t.Errorf("auth plugin data length %d, got %d bytes", packet.AuthPluginDataLen, len(packet.AuthPluginData))
+	}
+	if string(packet.AuthPluginName) != nativePasswordPlugin {
+		t.Errorf("auth plugin %q", packet.AuthPluginName)
+	}
+	if packet.CapabilitiesFlags != 4294965247 || packet.StatusFlags != 2 || packet.CharacterSet != 255 {
+		t.Errorf("capabilities %d status %d character set %d", packet.CapabilitiesFlags, packet.StatusFlags, packet.CharacterSet)
+	}
+	if len(packet.Warnings) != 0 {
+		t.Errorf("unexpected warnings %v", packet.Warnings)
+	}
+}
+
+func TestDecodePacketTruncated(t *testing.T) {
+	data := greetingBytes(t)
+	// Cut in the header, version, connection id, auth data, capabilities,
+	// reserved bytes and auth-plugin-data-part-2
+	for _, cut := range []int{2, 8, 14, 20, 26, 31, 40, 50} {
+		packet := &InitialHandshakePacket{}
+		if err := packet.decodePacket(data[:cut]); !errors.Is(err, errHandshakeTruncated) {
+			t.Errorf("cut at %d: got %v, want %v", cut, err, errHandshakeTruncated)
+		}
+	}
+}
+
+func TestDecodePacketUnterminatedPluginName(t *testing.T) {
+	data := greetingBytes(t)
+	packet := &InitialHandshakePacket{}
+	if err := packet.decodePacket(data[:len(data)-1]); err != nil {
+		t.Fatal(err)
+	}
+	if string(packet.AuthPluginName) != nativePasswordPlugin {
+		t.Errorf("auth plugin %q", packet.AuthPluginName)
+	}
+	if len(packet.Warnings) != 1 || packet.Warnings[0].Code != warnPluginNameUnterminated {
+		t.Errorf("warnings %v, want %s", packet.Warnings, warnPluginNameUnterminated)
+	}
+}
+
+func TestDecodePacketRejected(t *testing.T) {
+	tests := []struct {
+		name  string
+		patch func(data []byte) []byte
+		err   string
+	}{
+		{"version 9", func(data []byte) []byte { data[4] = 0x09; return data }, "Version 9 is not yet supported!"},
+		{"unknown version", func(data []byte) []byte { data[4] = 0x0b; return data }, "Only version 10 is supported. Unknown procotcol version!"},
+		{"filler", func(data []byte) []byte { data[24] = 0x01; return data }, "Unable to decode filler value"},
+		{"no auth data length", func(data []byte) []byte { data[32] = 0x00; return data }, "Wrong auth plugin data len"},
+		{"too large", func(data []byte) []byte { data[2] = 0xff; return data }, "too large"},
+		{"refused", func([]byte) []byte {
+			payload := append([]byte{0xff, 0x10, 0x04}, "Too many connections"...)
+			return append([]byte{byte(len(payload)), 0, 0, 0}, payload...)
+		}, "ERROR 1040: Too many connections"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			packet := &InitialHandshakePacket{}
+			err := packet.decodePacket(test.patch(greetingBytes(t)))
+			if err == nil || !strings.Contains(err.Error(), test.err) {
+				t.Errorf("got %v, want %q", err, test.err)
+			}
+		})
+	}
+}
+
+func TestHexdumpAnnotatesFields(t *testing.T) {
+	packet := &InitialHandshakePacket{}
+	if err := packet.decodePacket(greetingBytes(t)); err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(packet.Hexdump(), "\n")
+	// One line a field, the 21 byte plugin name wraps once
+	if len(lines) != len(packet.fields)+1 {
+		t.Fatalf("got %d lines for %d fields:\n%s", len(lines), len(packet.fields), packet.Hexdump())
+	}
+	if !strings.HasPrefix(lines[0], "0000  4a 00 00 ") || !strings.HasSuffix(lines[0], "payload length") {
+		t.Errorf("first line %q", lines[0])
+	}
+	if !strings.HasPrefix(lines[len(lines)-1], "0048  ") {
+		t.Errorf("wrapped line %q doesn't start at 0x48", lines[len(lines)-1])
+	}
+}
+
+/*
+mariaDBGreeting builds a MariaDB 10.6 greeting with the given capability
+flags and MariaDB's own in the last 4 reserved bytes
+*/
+func mariaDBGreeting(capabilities CapabilityFlag, extended uint32) []byte {
+	payload := appendNulString([]byte{10}, "5.5.5-10.6.12-MariaDB")
+	payload = append(payload, 0x2a, 0, 0, 0)
+	payload = append(payload, "saltsalt"...)
+	payload = append(payload, 0, byte(capabilities), byte(capabilities>>8), 45, 2, 0, byte(capabilities>>16), byte(capabilities>>24), 21)
+	payload = append(payload, 0, 0, 0, 0, 0, 0, byte(extended), byte(extended>>8), byte(extended>>16), byte(extended>>24))
+	payload = appendNulString(append(payload, "saltsaltsalt"...), nativePasswordPlugin)
+	return append([]byte{byte(len(payload)), 0, 0, 0}, payload...)
+}
+
+func TestMariaDBCapabilities(t *testing.T) {
+	// Without clientLongPassword, as MariaDB sends it
+	capabilities := clientProtocol41 | clientSecureConn | clientPluginAuth
+	tests := []struct {
+		name         string
+		capabilities CapabilityFlag
+		extended     uint32
+		names        []string
+		unknown      MariaDBCapabilityFlag
+	}{
+		{"named", capabilities, 0x1d, []string{"mariaDBClientProgress", "mariaDBClientStmtBulkOperations", "mariaDBClientExtendedMetadata", "mariaDBClientCacheMetadata"}, 0},
+		{"newer", capabilities, 0x80000005, []string{"mariaDBClientProgress", "mariaDBClientStmtBulkOperations"}, 0x80000000},
+		// With clientLongPassword the bytes are reserved, as for MySQL
+		{"MySQL compatible", capabilities | clientLongPassword, 0x1d, nil, 0},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			packet := &InitialHandshakePacket{}
+			if err := packet.decodePacket(mariaDBGreeting(test.capabilities, test.extended)); err != nil {
+				t.Fatal(err)
+			}
+			info := packet.Info()
+			if names := info.MariaDBCapabilities.Names(); strings.Join(names, ",") != strings.Join(test.names, ",") {
+				t.Errorf("names %v, want %v", names, test.names)
+			}
+			if unknown := info.MariaDBCapabilities.Unknown(); unknown != test.unknown {
+				t.Errorf("unknown bits 0x%08x, want 0x%08x", unknown, test.unknown)
+			}
+
+			record := newJSONRecord(&ScanResult{Target: Target{Host: "maria", Port: 3306}, Status: StatusMySQL, Handshake: packet})
+			want := ""
+			if test.unknown != 0 {
+				want = fmt.Sprintf("0x%08x", uint32(test.unknown))
+			}
+			if record.Handshake.UnknownBits != want {
+				t.Errorf("unknown_bits %q, want %q", record.Handshake.UnknownBits, want)
+			}
+			if want != "" && !strings.Contains(info.String(), "Unknown capability bits: "+want) {
+				t.Errorf("text output doesn't show the unknown bits:\n%s", info)
+			}
+		})
+	}
+}
+
+func TestMySQLHasNoUnknownBits(t *testing.T) {
+	packet := &InitialHandshakePacket{}
+	if err := packet.decodePacket(greetingBytes(t)); err != nil {
+		t.Fatal(err)
+	}
+	// Every bit of the 32 is set but clientSSL, and named
+	if caps := packet.Info().MariaDBCapabilities; caps != 0 {
+		t.Errorf("MariaDB capabilities 0x%08x from MySQL", caps)
+	}
+	if text := packet.Info().String(); strings.Contains(text, "Unknown capability bits") || strings.Contains(text, "MariaDB capabilities") {
+		t.Errorf("unexpected capability lines:\n%s", text)
+	}
+}
@@ -0,0 +1,131 @@
+package main
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+)
+
+/*
+Maximum packet size announced to the server in client packets
+*/
+const clientMaxPacketSize = 1 << 24
+
+/*
+Largest packet payload accepted after the greeting. Authentication
+packets are tiny, this only guards against garbage lengths.
+*/
+const maxPacketSize = 1 << 20
+
+/*
+Largest greeting payload accepted, set by -max-handshake-size. Real
+greetings are around a hundred bytes, but long version strings and
+plugin names can take them past 1KB.
+*/
+const defaultMaxHandshakeSize = 16 << 10
+
+var maxHandshakeSize uint32 = defaultMaxHandshakeSize
+
+/*
+ErrPacketTooLarge is returned when a packet header announces a payload
+larger than the scanner accepts
+*/
+var ErrPacketTooLarge = errors.New("Packet too large")
+
+func packetTooLarge(length, limit uint32) error {
+	return fmt.Errorf("%w: %d bytes announced, at most %d accepted", ErrPacketTooLarge, length, limit)
+}
+
+/*
+readPacket reads a single packet, returning its sequence id and payload
+*/
+func readPacket(r io.Reader) (uint8, []byte, error) {
+	header := make([]byte, 4)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return 0, nil, err
+	}
+
+	length := binary.LittleEndian.Uint32([]byte{header[0], header[1], header[2], 0x00})
+	if length > maxPacketSize {
+		return 0, nil, packetTooLarge(length, maxPacketSize)
+	}
+
+	payload := make([]byte, length)
+	if n, err := io.ReadFull(r, payload); err != nil {
+		tracer.packet(r, false, int(header[3]), int(length), "truncated", payload[:n])
+		return 0, nil, err
+	}
+	tracer.packet(r, false, int(header[3]), int(length), classicPacketType(false, header[3], payload), payload)
+	return header[3], payload, nil
+}
+
+/*
+ServerError is an ERR packet sent by the server
+*/
+type ServerError struct {
+	Code     uint16
+	SQLState string
+	Message  string
+}
+
+func (e *ServerError) Error() string {
+	if e.SQLState == "" {
+		return fmt.Sprintf("ERROR %d: %s", e.Code, e.Message)
+	}
+	return fmt.Sprintf("ERROR %d (%s): %s", e.Code, e.SQLState, e.Message)
+}
+
+/*
+decodeServerError decodes an ERR packet payload
+
+	int<1>       header 0xff
+	int<2>       error code
+	string[1]    sql state marker '#'
+	string[5]    sql state
+	string<EOF>  error message
+*/
+func decodeServerError(payload []byte) *ServerError {
+	e := &ServerError{}
+	if len(payload) < 3 {
+		e.Message = "Truncated ERR packet"
+		return e
+	}
+
+	e.Code = binary.LittleEndian.Uint16(payload[1:3])
+	rest := payload[3:]
+	if len(rest) >= 6 && rest[0] == '#' {
+		e.SQLState = string(rest[1:6])
+		rest = rest[6:]
+	}
+	e.Message = string(rest)
+	return e
+}
+
+/*
+writePacket frames payload with a packet header and writes it
+*/
+func writePacket(w io.Writer, sequenceId uint8, payload []byte) error {
+	header := make([]byte, 4)
+	binary.LittleEndian.PutUint32(header, uint32(len(payload)))
+	header[3] = sequenceId
+
+	tracer.packet(w, true, int(sequenceId), len(payload), classicPacketType(true, sequenceId, payload), payload)
+	_, err := w.Write(append(header, payload...))
+	return err
+}
+
+/*
+SSLRequest asks the server to switch the connection to TLS.
+It is a truncated handshake response carrying only the client
+capabilities, max packet size and character set.
+*/
+func sslRequest(characterSet uint8) []byte {
+	payload := make([]byte, 32)
+	caps := clientSSL | clientProtocol41 | clientSecureConn | clientLongPassword | clientPluginAuth
+	binary.LittleEndian.PutUint32(payload[0:4], uint32(caps))
+	binary.LittleEndian.PutUint32(payload[4:8], clientMaxPacketSize)
+	payload[8] = characterSet
+	// 23 bytes of filler follow, already zero
+	return payload
+}
@@ -0,0 +1,243 @@
+package main
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+)
+
+/*
+isEOFPacket tells an EOF packet apart from a row starting with a long
+length encoded integer
+*/
+func isEOFPacket(payload []byte) bool {
+	return payload[0] == 0xfe && len(payload) < 9
+}
+
+/*
+Most columns a table or result set can have
+*/
+const maxColumns = 4096
+
+/*
+Server status flag telling more result sets follow
+*/
+const serverMoreResultsExists = 0x0008
+
+/*
+ColumnDefinition describes a column of a result set
+
+	string<lenenc>  catalog, always "def"
+	string<lenenc>  schema
+	string<lenenc>  table
+	string<lenenc>  org_table
+	string<lenenc>  name
+	string<lenenc>  org_name
+	int<lenenc>     length of the fixed length fields, always 0x0c
+	int<2>          character set
+	int<4>          column length
+	int<1>          type
+	int<2>          flags
+	int<1>          decimals
+*/
+type ColumnDefinition struct {
+	Schema   string
+	Table    string
+	OrgTable string
+	Name     string
+	OrgName  string
+	Charset  uint16
+	Length   uint32
+	Type     uint8
+	Flags    uint16
+	Decimals uint8
+}
+
+func decodeColumnDefinition(payload []byte) (*ColumnDefinition, error) {
+	var strs [6]string
+	pos := 0
+	for i := range strs {
+		value, n, _, err := readLenEncString(payload[pos:])
+		if err != nil {
+			return nil, err
+		}
+		strs[i] = string(value)
+		pos += n
+	}
+
+	fixed, n, _, err := readLenEnc(payload[pos:])
+	if err != nil {
+		return nil, err
+	}
+	pos += n
+	if fixed < 0x0c || len(payload) < pos+0x0c {
+		return nil, errors.New("Truncated column definition")
+	}
+
+	return &ColumnDefinition{
+		Schema:   strs[1],
+		Table:    strs[2],
+		OrgTable: strs[3],
+		Name:     strs[4],
+		OrgName:  strs[5],
+		Charset:  binary.LittleEndian.Uint16(payload[pos : pos+2]),
+		Length:   binary.LittleEndian.Uint32(payload[pos+2 : pos+6]),
+		Type:     payload[pos+6],
+		Flags:    binary.LittleEndian.Uint16(payload[pos+7 : pos+9]),
+		Decimals: payload[pos+9],
+	}, nil
+}
+
+/*
+decodeEOF decodes an EOF packet payload
+
+	int<1>  header 0xfe
+	int<2>  warnings
+	int<2>  status flags
+*/
+func decodeEOF(payload []byte) (warnings, status uint16) {
+	if len(payload) >= 5 {
+		warnings = binary.LittleEndian.Uint16(payload[1:3])
+		status = binary.LittleEndian.Uint16(payload[3:5])
+	}
+	return warnings, status
+}
+
+/*
+ResultSet is the text protocol answer to COM_QUERY. Statements without
+a result set only fill OK. NULL values in rows are nil.
+*/
+type ResultSet struct {
+	Columns []*ColumnDefinition
+	Rows    [][][]byte
+	OK      *OKPacket
+	// Status flags and warnings from the packet ending the result
+	Status   uint16
+	Warnings uint16
+}
+
+/*
+QueryResultSet runs a statement with COM_QUERY and decodes its text
+result set. Only the first result set is returned when a statement
+produces several, the rest are read and dropped to keep the session in step.
+*/
+func (s *Session) QueryResultSet(sql string) (*ResultSet, error) {
+	if err := s.writeCommand(comQuery, []byte(sql)); err != nil {
+		return nil, err
+	}
+
+	result, err := s.readResultSet()
+	if err != nil {
+		return nil, err
+	}
+	for more := result.Status&serverMoreResultsExists != 0; more; {
+		next, err := s.readResultSet()
+		if err != nil {
+			return nil, err
+		}
+		more = next.Status&serverMoreResultsExists != 0
+	}
+	return result, nil
+}
+
+/*
+readResultSet reads one result set, or the OK or ERR packet sent in its place
+
+	int<lenenc>         column count
+	ColumnDefinition    column count times
+	EOF
+	row                 one string<lenenc> per column, 0xfb for NULL
+	EOF or ERR
+*/
+func (s *Session) readResultSet() (*ResultSet, error) {
+	payload, err := s.readReply()
+	if err != nil {
+		return nil, err
+	}
+	switch payload[0] {
+	case okHeader:
+		ok, err := decodeOK(payload)
+		if err != nil {
+			s.broken = true
+			return nil, err
+		}
+		return &ResultSet{OK: ok, Status: ok.StatusFlags, Warnings: ok.Warnings}, nil
+	case errHeader:
+		return nil, decodeServerError(payload)
+	}
+
+	count, _, _, err := readLenEnc(payload)
+	if err == nil && (count == 0 || count > maxColumns) {
+		err = fmt.Errorf("Invalid column count %d", count)
+	}
+	if err != nil {
+		s.broken = true
+		return nil, err
+	}
+
+	result := &ResultSet{Columns: make([]*ColumnDefinition, 0, count)}
+	for i := uint64(0); i < count; i++ {
+		payload, err := s.readReply()
+		if err != nil {
+			return nil, err
+		}
+		column, err := decodeColumnDefinition(payload)
+		if err != nil {
+			s.broken = true
+			return nil, err
+		}
+		result.Columns = append(result.Columns, column)
+	}
+
+	if payload, err = s.readReply(); err != nil {
+		return nil, err
+	}
+	if !isEOFPacket(payload) {
+		s.broken = true
+		return nil, fmt.Errorf("Expected EOF after column definitions, got 0x%02x", payload[0])
+	}
+
+	for {
+		payload, err := s.readReply()
+		if err != nil {
+			return nil, err
+		}
+		if isEOFPacket(payload) {
+			result.Warnings, result.Status = decodeEOF(payload)
+			return result, nil
+		}
+		if payload[0] == errHeader {
+			return nil, decodeServerError(payload)
+		}
+
+		row := make([][]byte, 0, count)
+		pos := 0
+		for i := uint64(0); i < count; i++ {
+			value, n, _, err := readLenEncString(payload[pos:])
+			if err != nil {
+				s.broken = true
+				return nil, err
+			}
+			row = append(row, value)
+			pos += n
+		}
+		result.Rows = append(result.Rows, row)
+	}
+}
+
+/*
+Query runs a statement with COM_QUERY and returns the column names and
+rows of its text result set. NULL values are returned as nil.
+*/
+func (s *Session) Query(sql string) ([]string, [][][]byte, error) {
+	result, err := s.QueryResultSet(sql)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var columns []string
+	for _, column := range result.Columns {
+		columns = append(columns, column.Name)
+	}
+	return columns, result.Rows, nil
+}
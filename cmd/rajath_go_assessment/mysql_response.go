@@ -0,0 +1,178 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+)
+
+/*
+First payload byte of the packets a server may answer a handshake
+response or a command with
+*/
+const (
+	okHeader             = 0x00
+	authMoreDataHeader   = 0x01
+	authNextFactorHeader = 0x02
+	authSwitchHeader     = 0xfe
+	errHeader            = 0xff
+)
+
+/*
+OKPacket tells the client a command or the authentication succeeded
+*/
+type OKPacket struct {
+	AffectedRows uint64
+	LastInsertID uint64
+	StatusFlags  uint16
+	Warnings     uint16
+	Info         string
+}
+
+/*
+decodeOK decodes an OK packet payload, as sent without clientSessionTrack
+
+	int<1>       header 0x00
+	int<lenenc>  affected rows
+	int<lenenc>  last insert id
+	int<2>       status flags
+	int<2>       warnings
+	string<EOF>  info
+*/
+func decodeOK(payload []byte) (*OKPacket, error) {
+	if len(payload) == 0 || payload[0] != okHeader {
+		return nil, errors.New("Not an OK packet")
+	}
+
+	ok := &OKPacket{}
+	pos := 1
+	var n int
+	var err error
+	if ok.AffectedRows, n, _, err = readLenEnc(payload[pos:]); err != nil {
+		return nil, err
+	}
+	pos += n
+	if ok.LastInsertID, n, _, err = readLenEnc(payload[pos:]); err != nil {
+		return nil, err
+	}
+	pos += n
+
+	// Servers older than 4.1 stop here
+	if len(payload) >= pos+4 {
+		ok.StatusFlags = binary.LittleEndian.Uint16(payload[pos : pos+2])
+		ok.Warnings = binary.LittleEndian.Uint16(payload[pos+2 : pos+4])
+		info, _ := readEOFString(payload[pos+4:])
+		ok.Info = string(info)
+	}
+	return ok, nil
+}
+
+/*
+AuthSwitchRequest asks the client to authenticate again with another plugin
+*/
+type AuthSwitchRequest struct {
+	Plugin string
+	Data   []byte
+}
+
+/*
+decodeAuthSwitch decodes an AuthSwitchRequest payload
+
+	int<1>       header 0xfe
+	string[NUL]  plugin name
+	string[EOF]  auth plugin data
+*/
+func decodeAuthSwitch(payload []byte) (*AuthSwitchRequest, error) {
+	if len(payload) == 0 || payload[0] != authSwitchHeader {
+		return nil, errors.New("Not an auth switch request")
+	}
+
+	plugin, n, err := readNulString(payload[1:])
+	if err != nil {
+		return nil, errors.New("Malformed auth switch request")
+	}
+	data, _ := readEOFString(payload[1+n:])
+	return &AuthSwitchRequest{
+		Plugin: string(plugin),
+		Data:   bytes.TrimRight(data, "\x00"),
+	}, nil
+}
+
+/*
+AuthMoreData carries plugin specific data during authentication
+*/
+type AuthMoreData struct {
+	Data []byte
+}
+
+/*
+decodeAuthMoreData decodes an AuthMoreData payload
+
+	int<1>       header 0x01
+	string[EOF]  plugin data
+*/
+func decodeAuthMoreData(payload []byte) (*AuthMoreData, error) {
+	if len(payload) == 0 || payload[0] != authMoreDataHeader {
+		return nil, errors.New("Not an auth more data packet")
+	}
+	data, _ := readEOFString(payload[1:])
+	return &AuthMoreData{Data: data}, nil
+}
+
+/*
+AuthNextFactor asks the client, once a factor passed, to authenticate
+with the next one of a multi-factor account (MySQL 8.0.27 and later)
+*/
+type AuthNextFactor struct {
+	Plugin string
+	Data   []byte
+}
+
+/*
+decodeAuthNextFactor decodes an AuthNextFactor payload
+
+	int<1>       header 0x02
+	string[NUL]  plugin name
+	string[EOF]  auth plugin data
+*/
+func decodeAuthNextFactor(payload []byte) (*AuthNextFactor, error) {
+	if len(payload) == 0 || payload[0] != authNextFactorHeader {
+		return nil, errors.New("Not an auth next factor packet")
+	}
+
+	plugin, n, err := readNulString(payload[1:])
+	if err != nil {
+		return nil, errors.New("Malformed auth next factor packet")
+	}
+	data, _ := readEOFString(payload[1+n:])
+	return &AuthNextFactor{
+		Plugin: string(plugin),
+		Data:   bytes.TrimRight(data, "\x00"),
+	}, nil
+}
+
+/*
+decodeAuthReply decodes a packet received during authentication into
+an *OKPacket, *ServerError, *AuthSwitchRequest, *AuthMoreData or
+*AuthNextFactor
+*/
+func decodeAuthReply(payload []byte) (interface{}, error) {
+	if len(payload) == 0 {
+		return nil, errors.New("Empty packet during authentication")
+	}
+
+	switch payload[0] {
+	case okHeader:
+		return decodeOK(payload)
+	case errHeader:
+		return decodeServerError(payload), nil
+	case authSwitchHeader:
+		return decodeAuthSwitch(payload)
+	case authMoreDataHeader:
+		return decodeAuthMoreData(payload)
+	case authNextFactorHeader:
+		return decodeAuthNextFactor(payload)
+	}
+	return nil, fmt.Errorf("Unexpected packet 0x%02x during authentication", payload[0])
+}
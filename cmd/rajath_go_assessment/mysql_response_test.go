@@ -0,0 +1,341 @@
+package main
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha1"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"io"
+	"net"
+	"sync"
+	"testing"
+)
+
+func TestDecodeOK(t *testing.T) {
+	tests := []struct {
+		name    string
+		payload []byte
+		want    *OKPacket
+		err     bool
+	}{
+		{"minimal", []byte{0x00, 0x00, 0x00, 0x02, 0x00, 0x00, 0x00}, &OKPacket{StatusFlags: 2}, false},
+		{"with info", append([]byte{0x00, 0x01, 0xfc, 0x00, 0x01, 0x02, 0x00, 0x03, 0x00}, "Rows matched"...), &OKPacket{AffectedRows: 1, LastInsertID: 0x100, StatusFlags: 2, Warnings: 3, Info: "Rows matched"}, false},
+		{"before 4.1", []byte{0x00, 0x05, 0x00}, &OKPacket{AffectedRows: 5}, false},
+		{"truncated lenenc", []byte{0x00, 0xfc, 0x01}, nil, true},
+		{"not OK", []byte{0xff, 0x00}, nil, true},
+		{"empty", nil, nil, true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			ok, err := decodeOK(test.payload)
+			if (err != nil) != test.err {
+				t.Fatalf("err = %v, want error %v", err, test.err)
+			}
+			if test.want != nil && *ok != *test.want {
+				t.Errorf("got %+v, want %+v", ok, test.want)
+			}
+		})
+	}
+}
+
+func TestDecodeServerError(t *testing.T) {
+	tests := []struct {
+		name    string
+		payload []byte
+		want    ServerError
+	}{
+		{"with state", append([]byte{0xff, 0x15, 0x04, '#', '2', '8', '0', '0', '0'}, "Access denied"...), ServerError{1045, "28000", "Access denied"}},
+		{"without state", append([]byte{0xff, 0x10, 0x04}, "Too many connections"...), ServerError{1040, "", "Too many connections"}},
+		{"truncated", []byte{0xff, 0x15}, ServerError{0, "", "Truncated ERR packet"}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := decodeServerError(test.payload); *got != test.want {
+				t.Errorf("got %+v, want %+v", got, test.want)
+			}
+		})
+	}
+}
+
+func TestDecodeAuthReply(t *testing.T) {
+	salt := bytes.Repeat([]byte{'s'}, 20)
+	tests := []struct {
+		name    string
+		payload []byte
+		check   func(reply interface{}) bool
+		err     bool
+	}{
+		{"OK", okPayload(), func(r interface{}) bool { _, ok := r.(*OKPacket); return ok }, false},
+		{"ERR", errPayload(), func(r interface{}) bool { e, ok := r.(*ServerError); return ok && e.Code == 1045 }, false},
+		{"switch", switchPayload(nativePasswordPlugin, salt), func(r interface{}) bool {
+			s, ok := r.(*AuthSwitchRequest)
+			return ok && s.Plugin == nativePasswordPlugin && bytes.Equal(s.Data, salt)
+		}, false},
+		{"switch without data", []byte("\xfemysql_old_password\x00"), func(r interface{}) bool {
+			s, ok := r.(*AuthSwitchRequest)
+			return ok && s.Plugin == "mysql_old_password" && len(s.Data) == 0
+		}, false},
+		{"malformed switch", []byte("\xfemysql_native_password"), nil, true},
+		{"more data", []byte{0x01, cachingSha2FastAuthSuccess}, func(r interface{}) bool {
+			m, ok := r.(*AuthMoreData)
+			return ok && bytes.Equal(m.Data, []byte{cachingSha2FastAuthSuccess})
+		}, false},
+		{"next factor", nextFactorPayload(cachingSha2PasswordPlugin, salt), func(r interface{}) bool {
+			n, ok := r.(*AuthNextFactor)
+			return ok && n.Plugin == cachingSha2PasswordPlugin && bytes.Equal(n.Data, salt)
+		}, false},
+		{"malformed next factor", []byte("\x02auth_socket"), nil, true},
+		{"unexpected", []byte{0x03}, nil, true},
+		{"empty", nil, nil, true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			reply, err := decodeAuthReply(test.payload)
+			if (err != nil) != test.err {
+				t.Fatalf("err = %v, want error %v", err, test.err)
+			}
+			if test.check != nil && !test.check(reply) {
+				t.Errorf("unexpected reply %#v", reply)
+			}
+		})
+	}
+}
+
+func okPayload() []byte {
+	return []byte{okHeader, 0x00, 0x00, 0x02, 0x00, 0x00, 0x00}
+}
+
+func errPayload() []byte {
+	return append([]byte{errHeader, 0x15, 0x04, '#', '2', '8', '0', '0', '0'}, "Access denied"...)
+}
+
+func switchPayload(plugin string, salt []byte) []byte {
+	payload := appendNulString([]byte{authSwitchHeader}, plugin)
+	return append(append(payload, salt...), 0x00)
+}
+
+func nextFactorPayload(plugin string, salt []byte) []byte {
+	payload := appendNulString([]byte{authNextFactorHeader}, plugin)
+	return append(append(payload, salt...), 0x00)
+}
+
+/*
+mockAuthServer greets, reads the handshake response and sends the
+replies in turn, reading the client's answer after each auth switch or
+next factor request. What the client sent is kept in received.
+*/
+type mockAuthServer struct {
+	greeting []byte
+	replies  [][]byte
+
+	mu       sync.Mutex
+	received [][]byte
+}
+
+func (m *mockAuthServer) serve(conn net.Conn) {
+	if _, err := conn.Write(m.greeting); err != nil {
+		return
+	}
+	seq, payload, err := readPacket(conn)
+	if err != nil {
+		return
+	}
+	m.record(payload)
+	for _, reply := range m.replies {
+		seq++
+		if err := writePacket(conn, seq, reply); err != nil {
+			return
+		}
+		// Everything but fast auth success waits for an answer
+		if reply[0] == authSwitchHeader || reply[0] == authNextFactorHeader || reply[0] == authMoreDataHeader && reply[1] != cachingSha2FastAuthSuccess {
+			if seq, payload, err = readPacket(conn); err != nil {
+				return
+			}
+			m.record(payload)
+		}
+	}
+	io.Copy(io.Discard, conn)
+}
+
+func (m *mockAuthServer) record(payload []byte) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.received = append(m.received, payload)
+}
+
+func (m *mockAuthServer) answers() [][]byte {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return append([][]byte(nil), m.received...)
+}
+
+func TestLoginStateMachine(t *testing.T) {
+	greeting := greetingBytes(t)
+	packet := &InitialHandshakePacket{}
+	if err := packet.decodePacket(greeting); err != nil {
+		t.Fatal(err)
+	}
+	salt := packet.AuthPluginData[:20]
+	other := bytes.Repeat([]byte{'o'}, 20)
+
+	tests := []struct {
+		name      string
+		replies   [][]byte
+		factors   []string
+		wantErr   func(err error) bool
+		plugins   []string
+		responses [][]byte
+	}{
+		{
+			name:      "native OK",
+			replies:   [][]byte{okPayload()},
+			plugins:   []string{nativePasswordPlugin},
+			responses: [][]byte{scrambleNativePassword(salt, "pw")},
+		},
+		{
+			name:    "wrong password",
+			replies: [][]byte{errPayload()},
+			wantErr: func(err error) bool {
+				var serverErr *ServerError
+				return errors.As(err, &serverErr) && serverErr.Code == 1045
+			},
+		},
+		{
+			name:      "switch to caching_sha2_password, fast auth",
+			replies:   [][]byte{switchPayload(cachingSha2PasswordPlugin, other), {authMoreDataHeader, cachingSha2FastAuthSuccess}, okPayload()},
+			plugins:   []string{cachingSha2PasswordPlugin},
+			responses: [][]byte{scrambleNativePassword(salt, "pw"), scrambleCachingSha2Password(other, "pw")},
+		},
+		{
+			name:    "full auth with a bad public key",
+			replies: [][]byte{switchPayload(cachingSha2PasswordPlugin, other), {authMoreDataHeader, cachingSha2FullAuthNeeded}, append([]byte{authMoreDataHeader}, "not a key"...)},
+			wantErr: func(err error) bool { return err != nil && err.Error() == "Server sent no RSA public key" },
+		},
+		{
+			name:    "second switch",
+			replies: [][]byte{switchPayload(cachingSha2PasswordPlugin, other), switchPayload(nativePasswordPlugin, other)},
+			wantErr: func(err error) bool { return err != nil && err.Error() == "Unexpected auth switch request" },
+		},
+		{
+			name:    "more data after fast auth",
+			replies: [][]byte{switchPayload(cachingSha2PasswordPlugin, other), {authMoreDataHeader, cachingSha2FastAuthSuccess}, {authMoreDataHeader, cachingSha2FastAuthSuccess}},
+			wantErr: func(err error) bool { return err != nil && err.Error() == "Unexpected auth more data packet" },
+		},
+		{
+			name:    "unsupported plugin",
+			replies: [][]byte{switchPayload("auth_pam", other)},
+			wantErr: func(err error) bool {
+				var unsupported *UnsupportedPluginError
+				return errors.As(err, &unsupported) && unsupported.Plugin == "auth_pam" && bytes.Equal(unsupported.Data, other)
+			},
+		},
+		{
+			name:      "second factor",
+			replies:   [][]byte{nextFactorPayload(cachingSha2PasswordPlugin, other), okPayload()},
+			factors:   []string{"pw2"},
+			plugins:   []string{nativePasswordPlugin, cachingSha2PasswordPlugin},
+			responses: [][]byte{scrambleNativePassword(salt, "pw"), scrambleCachingSha2Password(other, "pw2")},
+		},
+		{
+			name:    "second factor without a password",
+			replies: [][]byte{nextFactorPayload(cachingSha2PasswordPlugin, other)},
+			wantErr: func(err error) bool {
+				var required *FactorRequiredError
+				return errors.As(err, &required) && required.Factor == 2 && required.Plugin == cachingSha2PasswordPlugin
+			},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			_, network := useFakes(t)
+			server := &mockAuthServer{greeting: greeting, replies: test.replies}
+			network.Serve("auth:3306", server.serve)
+
+			session, err := login(Target{Host: "auth", Port: 3306}, "scan", "pw", test.factors...)
+			if test.wantErr != nil {
+				if !test.wantErr(err) {
+					t.Fatalf("unexpected error %v", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			session.Close()
+
+			if len(session.Factors) != len(test.plugins) {
+				t.Fatalf("factors %v, want %v", session.Factors, test.plugins)
+			}
+			for i := range test.plugins {
+				if session.Factors[i] != test.plugins[i] {
+					t.Errorf("factors %v, want %v", session.Factors, test.plugins)
+				}
+			}
+
+			answers := server.answers()
+			if len(answers) != len(test.responses) {
+				t.Fatalf("server got %d packets, want %d", len(answers), len(test.responses))
+			}
+			// The handshake response carries its auth response after the
+			// user name and its length
+			first := answers[0][32+len("scan\x00")+1:]
+			if !bytes.HasPrefix(first, test.responses[0]) {
+				t.Errorf("handshake response auth %x, want %x", first, test.responses[0])
+			}
+			for i := 1; i < len(answers); i++ {
+				if !bytes.Equal(answers[i], test.responses[i]) {
+					t.Errorf("answer %d is %x, want %x", i, answers[i], test.responses[i])
+				}
+			}
+		})
+	}
+}
+
+func TestFullAuthentication(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	der, err := x509.MarshalPKIXPublicKey(&key.PublicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	publicKey := pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der})
+
+	other := bytes.Repeat([]byte{'o'}, 20)
+	_, network := useFakes(t)
+	server := &mockAuthServer{greeting: greetingBytes(t), replies: [][]byte{
+		switchPayload(cachingSha2PasswordPlugin, other),
+		{authMoreDataHeader, cachingSha2FullAuthNeeded},
+		append([]byte{authMoreDataHeader}, publicKey...),
+		okPayload(),
+	}}
+	network.Serve("full-auth:3306", server.serve)
+
+	session, err := login(Target{Host: "full-auth", Port: 3306}, "scan", "s3cr3t!")
+	if err != nil {
+		t.Fatal(err)
+	}
+	session.Close()
+
+	answers := server.answers()
+	if len(answers) != 4 {
+		t.Fatalf("server got %d packets, want the response, switch answer, key request and password", len(answers))
+	}
+	if !bytes.Equal(answers[2], []byte{cachingSha2PublicKeyRequest}) {
+		t.Errorf("asked for the key with %x", answers[2])
+	}
+	clear, err := rsa.DecryptOAEP(sha1.New(), nil, key, answers[3], nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := range clear {
+		clear[i] ^= other[i%20]
+	}
+	if string(clear) != "s3cr3t!\x00" {
+		t.Errorf("server decrypted %q", clear)
+	}
+}
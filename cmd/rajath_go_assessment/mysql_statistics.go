@@ -0,0 +1,77 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+/*
+ServerStatistics is the reply to COM_STATISTICS, a one line summary like
+
+	Uptime: 3600  Threads: 2  Questions: 15  Slow queries: 1  Opens: 120 ...
+*/
+type ServerStatistics struct {
+	Uptime              time.Duration
+	Threads             uint64
+	Questions           uint64
+	SlowQueries         uint64
+	QueriesPerSecondAvg float64
+	// Every field as sent by the server
+	Fields map[string]string
+}
+
+func (s *ServerStatistics) String() string {
+	return fmt.Sprintf("uptime %s, threads %d, questions %d, slow queries %d, queries per second avg %.3f",
+		s.Uptime, s.Threads, s.Questions, s.SlowQueries, s.QueriesPerSecondAvg)
+}
+
+/*
+parseStatistics parses the COM_STATISTICS text. Fields are separated by
+two spaces, names and values by ": ".
+*/
+func parseStatistics(text string) *ServerStatistics {
+	s := &ServerStatistics{Fields: make(map[string]string)}
+
+	for _, field := range strings.Split(text, "  ") {
+		name, value, ok := strings.Cut(strings.TrimSpace(field), ": ")
+		if !ok {
+			continue
+		}
+		s.Fields[name] = value
+
+		n, _ := strconv.ParseUint(value, 10, 64)
+		switch name {
+		case "Uptime":
+			s.Uptime = time.Duration(n) * time.Second
+		case "Threads":
+			s.Threads = n
+		case "Questions":
+			s.Questions = n
+		case "Slow queries":
+			s.SlowQueries = n
+		case "Queries per second avg":
+			s.QueriesPerSecondAvg, _ = strconv.ParseFloat(value, 64)
+		}
+	}
+	return s
+}
+
+/*
+Statistics sends COM_STATISTICS and parses the reply
+*/
+func (s *Session) Statistics() (*ServerStatistics, error) {
+	if err := s.writeCommand(comStatistics, nil); err != nil {
+		return nil, err
+	}
+
+	payload, err := s.readReply()
+	if err != nil {
+		return nil, err
+	}
+	if payload[0] == 0xff {
+		return nil, decodeServerError(payload)
+	}
+	return parseStatistics(string(payload)), nil
+}
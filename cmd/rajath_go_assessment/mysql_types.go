@@ -0,0 +1,86 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+)
+
+/*
+Helpers for the basic data types of the MySQL protocol. Each reads its
+value at the start of data and returns it with the number of bytes used.
+*/
+
+/*
+readLenEnc reads a length encoded integer at the start of data and
+returns it with the number of bytes used. 0xfb (NULL in rows) is
+reported with null set.
+*/
+func readLenEnc(data []byte) (value uint64, n int, null bool, err error) {
+	if len(data) == 0 {
+		return 0, 0, false, errors.New("Truncated length encoded integer")
+	}
+
+	switch data[0] {
+	case 0xfb:
+		return 0, 1, true, nil
+	case 0xfc:
+		n = 3
+	case 0xfd:
+		n = 4
+	case 0xfe:
+		n = 9
+	default:
+		return uint64(data[0]), 1, false, nil
+	}
+
+	if len(data) < n {
+		return 0, 0, false, errors.New("Truncated length encoded integer")
+	}
+	buf := make([]byte, 8)
+	copy(buf, data[1:n])
+	return binary.LittleEndian.Uint64(buf), n, false, nil
+}
+
+/*
+readLenEncString reads a length encoded string at the start of data
+
+	int<lenenc>  length
+	string[len]  value
+*/
+func readLenEncString(data []byte) (s []byte, n int, null bool, err error) {
+	length, n, null, err := readLenEnc(data)
+	if err != nil || null {
+		return nil, n, null, err
+	}
+	if uint64(len(data)-n) < length {
+		return nil, 0, false, errors.New("Truncated length encoded string")
+	}
+	return data[n : n+int(length)], n + int(length), false, nil
+}
+
+/*
+readNulString reads a string terminated by a NUL byte. The NUL is
+counted in the bytes used but not returned.
+*/
+func readNulString(data []byte) (s []byte, n int, err error) {
+	end := bytes.IndexByte(data, 0x00)
+	if end == -1 {
+		return nil, 0, errors.New("Unterminated string")
+	}
+	return data[:end], end + 1, nil
+}
+
+/*
+appendNulString appends s and its terminating NUL to buf
+*/
+func appendNulString(buf []byte, s string) []byte {
+	return append(append(buf, s...), 0x00)
+}
+
+/*
+readEOFString reads a string running to the end of the packet
+*/
+func readEOFString(data []byte) (s []byte, n int) {
+	return data, len(data)
+}
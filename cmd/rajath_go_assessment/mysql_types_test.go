@@ -0,0 +1,131 @@
+package main
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestReadLenEnc(t *testing.T) {
+	tests := []struct {
+		name  string
+		data  []byte
+		value uint64
+		n     int
+		null  bool
+		err   bool
+	}{
+		{"empty", nil, 0, 0, false, true},
+		{"zero", []byte{0x00}, 0, 1, false, false},
+		{"largest one byte", []byte{0xfa, 0xff}, 0xfa, 1, false, false},
+		{"null", []byte{0xfb}, 0, 1, true, false},
+		{"two bytes", []byte{0xfc, 0xfb, 0x00}, 0xfb, 3, false, false},
+		{"two bytes largest", []byte{0xfc, 0xff, 0xff}, 0xffff, 3, false, false},
+		{"two bytes truncated", []byte{0xfc, 0x01}, 0, 0, false, true},
+		{"three bytes", []byte{0xfd, 0x00, 0x00, 0x01}, 0x010000, 4, false, false},
+		{"three bytes truncated", []byte{0xfd, 0x00, 0x00}, 0, 0, false, true},
+		{"eight bytes", []byte{0xfe, 0x01, 0, 0, 0, 0, 0, 0, 0x80}, 0x8000000000000001, 9, false, false},
+		{"eight bytes truncated", []byte{0xfe, 0x01, 0, 0, 0, 0, 0, 0}, 0, 0, false, true},
+		{"trailing bytes ignored", []byte{0xfc, 0x02, 0x01, 0xaa}, 0x0102, 3, false, false},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			value, n, null, err := readLenEnc(test.data)
+			if (err != nil) != test.err {
+				t.Fatalf("err = %v, want error %v", err, test.err)
+			}
+			if value != test.value || n != test.n || null != test.null {
+				t.Errorf("got (%#x, %d, %v), want (%#x, %d, %v)", value, n, null, test.value, test.n, test.null)
+			}
+		})
+	}
+}
+
+func TestReadLenEncString(t *testing.T) {
+	long := bytes.Repeat([]byte{'a'}, 0xfb)
+	tests := []struct {
+		name string
+		data []byte
+		s    []byte
+		n    int
+		null bool
+		err  bool
+	}{
+		{"empty input", nil, nil, 0, false, true},
+		{"empty string", []byte{0x00}, []byte{}, 1, false, false},
+		{"short", []byte{0x03, 'a', 'b', 'c', 'd'}, []byte("abc"), 4, false, false},
+		{"null", []byte{0xfb, 'a'}, nil, 1, true, false},
+		{"two byte length", append([]byte{0xfc, 0xfb, 0x00}, long...), long, 3 + 0xfb, false, false},
+		{"truncated string", []byte{0x05, 'a', 'b'}, nil, 0, false, true},
+		{"truncated length", []byte{0xfd, 0x01}, nil, 0, false, true},
+		{"overlong", []byte{0xfe, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 'a'}, nil, 0, false, true},
+		{"length past int32", []byte{0xfe, 0x00, 0x00, 0x00, 0x80, 0x00, 0x00, 0x00, 0x00}, nil, 0, false, true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			s, n, null, err := readLenEncString(test.data)
+			if (err != nil) != test.err {
+				t.Fatalf("err = %v, want error %v", err, test.err)
+			}
+			if !bytes.Equal(s, test.s) || n != test.n || null != test.null {
+				t.Errorf("got (%q, %d, %v), want (%q, %d, %v)", s, n, null, test.s, test.n, test.null)
+			}
+		})
+	}
+}
+
+func TestReadNulString(t *testing.T) {
+	tests := []struct {
+		name string
+		data []byte
+		s    []byte
+		n    int
+		err  bool
+	}{
+		{"empty input", nil, nil, 0, true},
+		{"empty string", []byte{0x00, 'x'}, []byte{}, 1, false},
+		{"string", []byte("8.0.32\x00rest"), []byte("8.0.32"), 7, false},
+		{"first NUL only", []byte("a\x00b\x00"), []byte("a"), 2, false},
+		{"unterminated", []byte("mysql_native_password"), nil, 0, true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			s, n, err := readNulString(test.data)
+			if (err != nil) != test.err {
+				t.Fatalf("err = %v, want error %v", err, test.err)
+			}
+			if !bytes.Equal(s, test.s) || n != test.n {
+				t.Errorf("got (%q, %d), want (%q, %d)", s, n, test.s, test.n)
+			}
+		})
+	}
+}
+
+func TestAppendNulString(t *testing.T) {
+	buf := appendNulString([]byte{0x01}, "root")
+	if want := []byte("\x01root\x00"); !bytes.Equal(buf, want) {
+		t.Errorf("got %q, want %q", buf, want)
+	}
+	s, n, err := readNulString(buf[1:])
+	if err != nil || string(s) != "root" || n != 5 {
+		t.Errorf("round trip got (%q, %d, %v)", s, n, err)
+	}
+}
+
+func TestReadEOFString(t *testing.T) {
+	tests := []struct {
+		name string
+		data []byte
+	}{
+		{"empty", []byte{}},
+		{"with NUL", []byte("a\x00b")},
+		{"text", []byte("caching_sha2_password")},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			s, n := readEOFString(test.data)
+			if !bytes.Equal(s, test.data) || n != len(test.data) {
+				t.Errorf("got (%q, %d), want (%q, %d)", s, n, test.data, len(test.data))
+			}
+		})
+	}
+}
@@ -0,0 +1,358 @@
+package main
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"sort"
+	"strings"
+)
+
+/*
+X Protocol message types used during the capabilities exchange.
+Every message is framed as a 4 byte little endian length (covering the
+type byte and payload), a 1 byte type and a protobuf encoded payload.
+*/
+const (
+	mysqlxClientConCapabilitiesGet = 1
+
+	mysqlxServerOk               = 0
+	mysqlxServerError            = 1
+	mysqlxServerConnCapabilities = 2
+	mysqlxServerNotice           = 11
+)
+
+/*
+X Protocol frames are small during the greeting, anything bigger is
+not an X Protocol server
+*/
+const mysqlxMaxFrameSize = 64 * 1024
+
+/*
+XCapabilities represents the capabilities advertised by a MySQL X Protocol server
+*/
+type XCapabilities struct {
+	// Every capability rendered as a string, keyed by name
+	Capabilities   map[string]string
+	TLS            bool
+	AuthMechanisms []string
+	NodeType       string
+	// Whether the server sent a notice before we said anything
+	SentHello bool
+}
+
+/*
+Decode asks the server for its capabilities and decodes the reply
+*/
+func (r *XCapabilities) Decode(conn net.Conn) error {
+	// Empty CapabilitiesGet message: length 1 covering just the type byte
+	request := []byte{0x01, 0x00, 0x00, 0x00, mysqlxClientConCapabilitiesGet}
+	tracer.packet(conn, true, -1, 1, "CapabilitiesGet", request[4:])
+	if _, err := conn.Write(request); err != nil {
+		return err
+	}
+
+	for {
+		msgType, payload, err := readXFrame(conn)
+		if err != nil {
+			return err
+		}
+
+		switch msgType {
+		case mysqlxServerNotice:
+			r.SentHello = true
+		case mysqlxServerError:
+			return decodeXError(payload)
+		case mysqlxServerConnCapabilities:
+			return r.decodeCapabilities(payload)
+		case 0x0a:
+			// A classic protocol handshake starts with protocol version 10 right after its header
+			return errors.New("Target speaks the classic MySQL protocol, not X Protocol")
+		default:
+			return fmt.Errorf("Unexpected X Protocol message type %d", msgType)
+		}
+	}
+}
+
+func readXFrame(r io.Reader) (uint8, []byte, error) {
+	header := make([]byte, 5)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return 0, nil, err
+	}
+
+	length := binary.LittleEndian.Uint32(header[0:4])
+	if length == 0 || length > mysqlxMaxFrameSize {
+		return 0, nil, errors.New("X Protocol frame sanity check failed!")
+	}
+
+	payload := make([]byte, length-1)
+	n, err := io.ReadFull(r, payload)
+	kind, ok := xMessageNames[header[4]]
+	if !ok {
+		kind = fmt.Sprintf("message %d", header[4])
+	}
+	// The type byte counts in the frame length, show it with the payload
+	tracer.packet(r, false, -1, int(length), kind, append(header[4:5], payload[:n]...))
+	if err != nil {
+		return 0, nil, err
+	}
+	return header[4], payload, nil
+}
+
+/*
+Mysqlx.Error: severity = 1, code = 2, msg = 3, sql_state = 4
+*/
+func decodeXError(payload []byte) error {
+	var code uint64
+	var msg, state string
+	err := walkProto(payload, func(field int, wire int, varint uint64, data []byte) error {
+		switch field {
+		case 2:
+			code = varint
+		case 3:
+			msg = string(data)
+		case 4:
+			state = string(data)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	return fmt.Errorf("X Protocol error %d (%s): %s", code, state, msg)
+}
+
+/*
+Mysqlx.Connection.Capabilities: repeated Capability capabilities = 1
+Mysqlx.Connection.Capability: name = 1, Any value = 2
+*/
+func (r *XCapabilities) decodeCapabilities(payload []byte) error {
+	r.Capabilities = make(map[string]string)
+
+	return walkProto(payload, func(field int, wire int, varint uint64, data []byte) error {
+		if field != 1 {
+			return nil
+		}
+
+		var name string
+		var value xValue
+		err := walkProto(data, func(field int, wire int, varint uint64, data []byte) error {
+			switch field {
+			case 1:
+				name = string(data)
+			case 2:
+				var err error
+				value, err = decodeXAny(data)
+				return err
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+
+		r.Capabilities[name] = value.String()
+		switch name {
+		case "tls":
+			r.TLS = value.scalar == "true"
+		case "authentication.mechanisms":
+			for _, v := range value.array {
+				r.AuthMechanisms = append(r.AuthMechanisms, v.String())
+			}
+		case "node_type":
+			r.NodeType = value.String()
+		}
+		return nil
+	})
+}
+
+/*
+xValue is a decoded Mysqlx.Datatypes.Any, which is either a scalar, an
+object or an array
+*/
+type xValue struct {
+	scalar string
+	object map[string]xValue
+	array  []xValue
+}
+
+func (v xValue) String() string {
+	switch {
+	case v.object != nil:
+		var keys []string
+		for k := range v.object {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		var parts []string
+		for _, k := range keys {
+			parts = append(parts, fmt.Sprintf("%s: %s", k, v.object[k]))
+		}
+		return "{" + strings.Join(parts, ", ") + "}"
+	case v.array != nil:
+		var parts []string
+		for _, e := range v.array {
+			parts = append(parts, e.String())
+		}
+		return "[" + strings.Join(parts, ", ") + "]"
+	}
+	return v.scalar
+}
+
+/*
+Mysqlx.Datatypes.Any: type = 1, Scalar scalar = 2, Object obj = 3, Array array = 4
+*/
+func decodeXAny(data []byte) (xValue, error) {
+	var v xValue
+	err := walkProto(data, func(field int, wire int, varint uint64, data []byte) error {
+		var err error
+		switch field {
+		case 2:
+			v.scalar, err = decodeXScalar(data)
+		case 3:
+			v.object = make(map[string]xValue)
+			err = walkProto(data, func(field int, wire int, varint uint64, data []byte) error {
+				if field != 1 {
+					return nil
+				}
+				var key string
+				var value xValue
+				err := walkProto(data, func(field int, wire int, varint uint64, data []byte) error {
+					var err error
+					switch field {
+					case 1:
+						key = string(data)
+					case 2:
+						value, err = decodeXAny(data)
+					}
+					return err
+				})
+				v.object[key] = value
+				return err
+			})
+		case 4:
+			v.array = []xValue{}
+			err = walkProto(data, func(field int, wire int, varint uint64, data []byte) error {
+				if field != 1 {
+					return nil
+				}
+				element, err := decodeXAny(data)
+				v.array = append(v.array, element)
+				return err
+			})
+		}
+		return err
+	})
+	return v, err
+}
+
+/*
+Mysqlx.Datatypes.Scalar holds one of: v_signed_int = 2, v_unsigned_int = 3,
+v_octets = 5, v_double = 6, v_float = 7, v_bool = 8, v_string = 9
+*/
+func decodeXScalar(data []byte) (string, error) {
+	var s string
+	err := walkProto(data, func(field int, wire int, varint uint64, data []byte) error {
+		switch field {
+		case 2:
+			// zigzag encoded sint64
+			s = fmt.Sprintf("%d", int64(varint>>1)^-int64(varint&1))
+		case 3:
+			s = fmt.Sprintf("%d", varint)
+		case 5, 9:
+			// Octets and String both keep their bytes in field 1
+			return walkProto(data, func(field int, wire int, varint uint64, data []byte) error {
+				if field == 1 {
+					s = string(data)
+				}
+				return nil
+			})
+		case 8:
+			s = fmt.Sprintf("%t", varint != 0)
+		}
+		return nil
+	})
+	return s, err
+}
+
+/*
+walkProto calls fn for every field of a protobuf message. Varint
+fields are passed in varint, length delimited fields in data, and
+fixed width fields are skipped.
+*/
+func walkProto(msg []byte, fn func(field int, wire int, varint uint64, data []byte) error) error {
+	for len(msg) > 0 {
+		key, n := binary.Uvarint(msg)
+		if n <= 0 {
+			return errors.New("Malformed protobuf field key")
+		}
+		msg = msg[n:]
+		field, wire := int(key>>3), int(key&0x7)
+
+		var varint uint64
+		var data []byte
+		switch wire {
+		case 0:
+			varint, n = binary.Uvarint(msg)
+			if n <= 0 {
+				return errors.New("Malformed protobuf varint")
+			}
+			msg = msg[n:]
+		case 1:
+			if len(msg) < 8 {
+				return errors.New("Truncated protobuf fixed64")
+			}
+			msg = msg[8:]
+			continue
+		case 2:
+			length, n := binary.Uvarint(msg)
+			if n <= 0 || uint64(len(msg)-n) < length {
+				return errors.New("Truncated protobuf length delimited field")
+			}
+			data = msg[n : n+int(length)]
+			msg = msg[n+int(length):]
+		case 5:
+			if len(msg) < 4 {
+				return errors.New("Truncated protobuf fixed32")
+			}
+			msg = msg[4:]
+			continue
+		default:
+			return fmt.Errorf("Unsupported protobuf wire type %d", wire)
+		}
+
+		if err := fn(field, wire, varint, data); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+/*
+String formats the capabilities one per line, as printed for a target.
+The fields themselves are exported for callers that need them.
+*/
+func (r XCapabilities) String() string {
+
+	var packetInfo []string
+
+	packetInfo = append(packetInfo, tr("Protocol: X Protocol"))
+	packetInfo = append(packetInfo, fmt.Sprintf(tr("TLS: %t"), r.TLS))
+	packetInfo = append(packetInfo, fmt.Sprintf(tr("Authentication mechanisms: %s"), strings.Join(r.AuthMechanisms, ", ")))
+	if r.NodeType != "" {
+		packetInfo = append(packetInfo, fmt.Sprintf(tr("Node type: %s"), r.NodeType))
+	}
+
+	var names []string
+	for name := range r.Capabilities {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		packetInfo = append(packetInfo, fmt.Sprintf(tr("Capability %s: %s"), name, r.Capabilities[name]))
+	}
+
+	return strings.Join(packetInfo, "\n")
+}
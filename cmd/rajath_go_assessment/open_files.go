@@ -0,0 +1,133 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"syscall"
+)
+
+/*
+File descriptors kept aside for what isn't a target connection: the
+standard streams, the store, the metrics file, the webhook and the
+jump hosts
+*/
+const reservedFiles = 64
+
+/*
+File descriptors a worker can hold at once: the probe connection, and
+another one some checks open while it is kept
+*/
+const filesPerWorker = 2
+
+/*
+fileBudget caps the workers at what the open file limit allows, so a
+scan slows down instead of failing targets with "too many open files"
+halfway through. The limit is the process one (ulimit -n), or
+-max-open-files when lower.
+*/
+type fileBudget struct {
+	// -max-open-files, 0 to only follow the process limit
+	max int
+	// Process limit, 0 where there is none to read
+	limit int
+
+	mu      sync.Mutex
+	lowered map[string]bool
+	once    sync.Once
+}
+
+var openFiles = &fileBudget{lowered: make(map[string]bool)}
+
+/*
+init reads the process limit, raising it first when it can't fit the
+workers, and says so when it is lower than the one asked for with
+-max-open-files
+*/
+func (b *fileBudget) init(max int, workers int) error {
+	if max < 0 {
+		return errors.New("-max-open-files can't be negative")
+	}
+	b.max = max
+	b.limit = openFileLimit()
+
+	wanted := workers*filesPerWorker + reservedFiles
+	if b.max > 0 && wanted > b.max {
+		wanted = b.max
+	}
+	if b.limit > 0 && wanted > b.limit {
+		previous := b.limit
+		limit, err := raiseOpenFileLimit(wanted)
+		if limit > 0 {
+			b.limit = limit
+		}
+		switch {
+		case b.limit >= wanted:
+			log.Printf("Raised the open file limit from %d to %d for %d workers\n", previous, b.limit, workers)
+		case err != nil:
+			log.Printf("Can't raise the open file limit of %d to %d for %d workers: %s\n", b.limit, wanted, workers, err.Error())
+		default:
+			log.Printf("Raised the open file limit from %d to %d, the hard limit, short of %d for %d workers\n", previous, b.limit, wanted, workers)
+		}
+	}
+	if b.max > 0 && b.limit > 0 && b.max > b.limit {
+		log.Printf("-max-open-files %d is over the process limit of %d open files, which is the real cap: raise ulimit -n\n", b.max, b.limit)
+	}
+	return nil
+}
+
+/*
+cap returns the open file limit scans are held to and what sets it,
+0 when there is none
+*/
+func (b *fileBudget) cap() (int, string) {
+	if b.max > 0 && (b.limit == 0 || b.max <= b.limit) {
+		return b.max, "-max-open-files"
+	}
+	return b.limit, "the process limit (ulimit -n)"
+}
+
+/*
+workers returns how many of the wanted workers fit in the budget,
+logging once for each kind of worker when that is fewer
+*/
+func (b *fileBudget) workers(what string, wanted int) int {
+	limit, by := b.cap()
+	if limit == 0 {
+		return wanted
+	}
+	allowed := (limit - reservedFiles) / filesPerWorker
+	if allowed < 1 {
+		allowed = 1
+	}
+	if wanted <= allowed {
+		return wanted
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if !b.lowered[what] {
+		b.lowered[what] = true
+		log.Printf("Running %d %s workers instead of %d, as %s allows %d open files\n", allowed, what, wanted, by, limit)
+	}
+	return allowed
+}
+
+/*
+exhausted tells, once, that the process ran out of file descriptors
+anyway, say because of other connections than the scan's
+*/
+func (b *fileBudget) exhausted(err error) {
+	if !errors.Is(err, syscall.EMFILE) && !errors.Is(err, syscall.ENFILE) {
+		return
+	}
+	b.once.Do(func() {
+		limit, by := b.cap()
+		hint := "lower -workers"
+		if limit > 0 {
+			hint = fmt.Sprintf("%s allows %d open files, lower -workers or -max-open-files", by, limit)
+		}
+		log.Printf("Out of file descriptors, targets are failing: %s\n", hint)
+	})
+}
@@ -0,0 +1,43 @@
+//go:build !windows
+
+package main
+
+import (
+	"math"
+	"syscall"
+)
+
+/*
+openFileLimit returns the process limit on open files, which Go raises
+from the soft to the hard limit at start up, or 0 when unlimited
+*/
+func openFileLimit() int {
+	var limit syscall.Rlimit
+	if err := syscall.Getrlimit(syscall.RLIMIT_NOFILE, &limit); err != nil || limit.Cur > math.MaxInt32 {
+		return 0
+	}
+	return int(limit.Cur)
+}
+
+/*
+raiseOpenFileLimit raises the soft limit on open files to wanted, or to
+the hard limit when that is lower, and returns the limit it got. The
+hard limit is left alone even when privileged: it is the admin's cap on
+the box, for ulimit -Hn to raise.
+*/
+func raiseOpenFileLimit(wanted int) (int, error) {
+	var limit syscall.Rlimit
+	if err := syscall.Getrlimit(syscall.RLIMIT_NOFILE, &limit); err != nil {
+		return 0, err
+	}
+	raised := limit
+	raised.Cur = uint64(wanted)
+	if raised.Cur > limit.Max {
+		raised.Cur = limit.Max
+	}
+	if raised.Cur <= limit.Cur {
+		return openFileLimit(), nil
+	}
+	err := syscall.Setrlimit(syscall.RLIMIT_NOFILE, &raised)
+	return openFileLimit(), err
+}
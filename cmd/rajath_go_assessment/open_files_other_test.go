@@ -0,0 +1,34 @@
+//go:build !windows
+
+package main
+
+import (
+	"syscall"
+	"testing"
+)
+
+func TestRaiseKeepsHardLimit(t *testing.T) {
+	var before syscall.Rlimit
+	if err := syscall.Getrlimit(syscall.RLIMIT_NOFILE, &before); err != nil {
+		t.Fatal(err)
+	}
+	if before.Max > 1<<30 {
+		t.Skipf("hard limit %d too high to ask past", before.Max)
+	}
+	t.Cleanup(func() { syscall.Setrlimit(syscall.RLIMIT_NOFILE, &before) })
+
+	limit, err := raiseOpenFileLimit(int(before.Max) + 1000)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var after syscall.Rlimit
+	if err := syscall.Getrlimit(syscall.RLIMIT_NOFILE, &after); err != nil {
+		t.Fatal(err)
+	}
+	if after.Max != before.Max {
+		t.Errorf("hard limit went from %d to %d", before.Max, after.Max)
+	}
+	if uint64(limit) != before.Max || after.Cur != before.Max {
+		t.Errorf("got soft limit %d (%d), want the hard limit %d", after.Cur, limit, before.Max)
+	}
+}
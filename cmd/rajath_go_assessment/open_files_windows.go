@@ -0,0 +1,15 @@
+//go:build windows
+
+package main
+
+/*
+openFileLimit returns 0, Windows has no per-process limit on sockets
+to read
+*/
+func openFileLimit() int {
+	return 0
+}
+
+func raiseOpenFileLimit(wanted int) (int, error) {
+	return 0, nil
+}
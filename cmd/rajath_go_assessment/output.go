@@ -0,0 +1,73 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+)
+
+/*
+Output formats selected with -output. Text is printed as each target
+is scanned, the others are written once every target has been scanned.
+*/
+const (
+	OutputText  = "text"
+	OutputSARIF = "sarif"
+	OutputJUnit = "junit"
+	OutputJSON  = "json"
+	// Every field of each greeting, for studying the protocol
+	OutputDissect = "dissect"
+)
+
+/*
+Text output formats -compat pins, so scripts parsing the text output
+keep working while the default one changes. Each is the default of the
+release it was named in; the README lists what changed since.
+*/
+const TextCompatV1 = "v1"
+
+var textFormats = map[string]bool{
+	TextCompatV1: true,
+}
+
+/*
+Text output format pinned with -compat, empty for the default one
+*/
+var textCompat string
+
+var reportWriters = map[string]func(w io.Writer, results []*ScanResult) error{
+	OutputSARIF:   writeSARIF,
+	OutputJUnit:   writeJUnit,
+	OutputJSON:    writeJSON,
+	OutputDissect: writeDissection,
+}
+
+/*
+writeReport writes the redacted results of a scan in the given format,
+and signs exactly the bytes written when a signing key was given
+*/
+func writeReport(format string, w io.Writer, results []*ScanResult) error {
+	write, ok := reportWriters[format]
+	if !ok {
+		return fmt.Errorf("Unknown output format %q", format)
+	}
+	results = filter.apply(results)
+	redactedResults := make([]*ScanResult, len(results))
+	for i, result := range results {
+		redactedResults[i] = redactor.apply(result)
+	}
+	results = redactedResults
+
+	if signer == nil {
+		return write(w, results)
+	}
+
+	var report bytes.Buffer
+	if err := write(&report, results); err != nil {
+		return err
+	}
+	if _, err := w.Write(report.Bytes()); err != nil {
+		return err
+	}
+	return signer.sign(report.Bytes())
+}
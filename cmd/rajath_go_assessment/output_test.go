@@ -0,0 +1,87 @@
+package main
+
+import (
+	"encoding/hex"
+	"errors"
+	"io"
+	"log"
+	"os"
+	"testing"
+	"time"
+)
+
+/*
+Greeting of a MySQL 8.0.32 server, as captured from the mock the golden
+file was printed against by the baseline release
+*/
+const goldenGreeting = "4a0000000a382e302e3332004f0100002c53477d3a787c3300fff7ff0200ffff15000000000000000000002f7d7179253b3f6425242e55006d7973716c5f6e61746976655f70617373776f726400"
+
+/*
+captureStdout returns what fn prints to stdout
+*/
+func captureStdout(t *testing.T, fn func()) string {
+	t.Helper()
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	stdout := os.Stdout
+	os.Stdout = w
+	defer func() { os.Stdout = stdout }()
+
+	done := make(chan []byte)
+	go func() {
+		out, _ := io.ReadAll(r)
+		done <- out
+	}()
+	fn()
+	w.Close()
+	return string(<-done)
+}
+
+func TestCompatV1Golden(t *testing.T) {
+	data, err := hex.DecodeString(goldenGreeting)
+	if err != nil {
+		t.Fatal(err)
+	}
+	packet := &InitialHandshakePacket{}
+	if err := packet.decodePacket(data); err != nil {
+		t.Fatal(err)
+	}
+	now := time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)
+	open := &ScanResult{
+		Target:    Target{Host: "127.0.0.1", Port: 13306},
+		Status:    StatusMySQL,
+		Handshake: packet,
+		StartedAt: now,
+		EndedAt:   now.Add(time.Millisecond),
+		Tags:      Tags{"env": "prod"},
+		Findings:  []Finding{{RuleID: "MYSQL-TEST", Severity: SeverityInfo, Title: "Not printed by v1"}},
+	}
+	closed := &ScanResult{
+		Target: Target{Host: "127.0.0.1", Port: 1},
+		Status: StatusClosed,
+		Err:    errors.New("connection refused"),
+		// Findings of unreachable targets aren't printed by v1 either
+		Findings: []Finding{{RuleID: "MYSQL-TEST", Severity: SeverityInfo, Title: "Not printed by v1"}},
+	}
+
+	textCompat = TextCompatV1
+	log.SetOutput(io.Discard)
+	defer func() {
+		textCompat = ""
+		log.SetOutput(os.Stderr)
+	}()
+	got := captureStdout(t, func() {
+		printResult(open)
+		printResult(closed)
+	})
+
+	want, err := os.ReadFile("testdata/compat_v1.golden")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got != string(want) {
+		t.Errorf("-compat v1 output differs from the baseline\ngot:\n%s\nwant:\n%s", got, want)
+	}
+}
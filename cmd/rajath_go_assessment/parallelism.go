@@ -0,0 +1,86 @@
+package main
+
+import (
+	"net"
+	"sync"
+)
+
+/*
+parallelismLimiter caps how many targets on the same host, or in the
+same /24 (/64 for IPv6), are scanned at once, whatever the number of
+workers. Names aren't resolved, so only IP targets share a subnet.
+*/
+type parallelismLimiter struct {
+	// 0 for no cap
+	perHost   int
+	perSubnet int
+
+	mu      sync.Mutex
+	changed *sync.Cond
+	hosts   map[string]int
+	subnets map[string]int
+}
+
+var parallelism = newParallelismLimiter()
+
+func newParallelismLimiter() *parallelismLimiter {
+	l := &parallelismLimiter{hosts: make(map[string]int), subnets: make(map[string]int)}
+	l.changed = sync.NewCond(&l.mu)
+	return l
+}
+
+/*
+subnet returns the /24 or /64 the host is in, or an empty string for
+names and named pipes
+*/
+func subnet(host string) string {
+	ip := net.ParseIP(host)
+	if ip == nil {
+		return ""
+	}
+	if ip4 := ip.To4(); ip4 != nil {
+		return ip4.Mask(net.CIDRMask(24, 32)).String() + "/24"
+	}
+	return ip.Mask(net.CIDRMask(64, 128)).String() + "/64"
+}
+
+/*
+acquire waits until the target's host and subnet are both under their
+caps and takes a slot of each. The returned function gives them back.
+*/
+func (l *parallelismLimiter) acquire(target Target) func() {
+	if target.Pipe != "" || l.perHost == 0 && l.perSubnet == 0 {
+		return func() {}
+	}
+	host, block := target.Host, subnet(target.Host)
+
+	l.mu.Lock()
+	for l.full(l.hosts, host, l.perHost) || block != "" && l.full(l.subnets, block, l.perSubnet) {
+		l.changed.Wait()
+	}
+	l.hosts[host]++
+	if block != "" {
+		l.subnets[block]++
+	}
+	l.mu.Unlock()
+
+	return func() {
+		l.mu.Lock()
+		defer l.mu.Unlock()
+		l.release(l.hosts, host)
+		if block != "" {
+			l.release(l.subnets, block)
+		}
+		l.changed.Broadcast()
+	}
+}
+
+func (l *parallelismLimiter) full(counts map[string]int, key string, limit int) bool {
+	return limit > 0 && counts[key] >= limit
+}
+
+func (l *parallelismLimiter) release(counts map[string]int, key string) {
+	if counts[key]--; counts[key] == 0 {
+		delete(counts, key)
+	}
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"context"
+	"io"
+	"net"
+	"sync"
+	"syscall"
+)
+
+/*
+Dialer opens the TCP connections to targets. The scanner dials through
+targetDialer, a net.Dialer unless a harness swaps in a PipeNetwork;
+-ssh and proxy_chain hops have dialers of their own.
+*/
+type Dialer interface {
+	DialContext(ctx context.Context, network, address string) (net.Conn, error)
+}
+
+var targetDialer Dialer = &net.Dialer{}
+
+/*
+PipeNetwork is an in-memory network for running scans without sockets:
+each address is served by a function given the server end of a
+net.Pipe, which can greet, stall or hang up to exercise timeouts and
+retries. Addresses nothing serves refuse connections.
+*/
+type PipeNetwork struct {
+	mu      sync.Mutex
+	servers map[string]func(conn net.Conn)
+	dials   map[string]int
+	// Addresses whose dials hang until given up on, as when SYNs are dropped
+	stalled map[string]bool
+}
+
+func NewPipeNetwork() *PipeNetwork {
+	return &PipeNetwork{servers: make(map[string]func(net.Conn)), dials: make(map[string]int), stalled: make(map[string]bool)}
+}
+
+/*
+Serve has serve answer every connection to address, a host:port
+*/
+func (n *PipeNetwork) Serve(address string, serve func(conn net.Conn)) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.servers[address] = serve
+}
+
+/*
+Stall has dials to address hang until their context is done
+*/
+func (n *PipeNetwork) Stall(address string) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.stalled[address] = true
+}
+
+func (n *PipeNetwork) DialContext(ctx context.Context, network, address string) (net.Conn, error) {
+	n.mu.Lock()
+	serve, ok := n.servers[address]
+	n.dials[address]++
+	stalled := n.stalled[address]
+	n.mu.Unlock()
+	if stalled {
+		<-ctx.Done()
+		return nil, &net.OpError{Op: "dial", Net: network, Err: ctx.Err()}
+	}
+	if !ok {
+		return nil, &net.OpError{Op: "dial", Net: network, Err: syscall.ECONNREFUSED}
+	}
+
+	client, server := net.Pipe()
+	go func() {
+		defer server.Close()
+		serve(server)
+	}()
+	return client, nil
+}
+
+/*
+Dials returns how many connections were made to address
+*/
+func (n *PipeNetwork) Dials(address string) int {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	return n.dials[address]
+}
+
+/*
+serveGreeting answers with the given bytes, a greeting recorded with
+-record say, then reads whatever the client sends until it hangs up
+*/
+func serveGreeting(greeting []byte) func(net.Conn) {
+	return func(conn net.Conn) {
+		if _, err := conn.Write(greeting); err != nil {
+			return
+		}
+		io.Copy(io.Discard, conn)
+	}
+}
@@ -0,0 +1,248 @@
+package main
+
+import (
+	"context"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+)
+
+/*
+Results and targets held between two stages of the scan pipeline
+before the earlier stage waits for the later one
+*/
+const pipelineBuffer = 256
+
+/*
+pipelineStages holds the workers of the scan stages besides probing,
+set with -resolve-workers and -enrich-workers. 0 runs as many as probe.
+*/
+type pipelineStages struct {
+	resolve int
+	enrich  int
+}
+
+var stages = &pipelineStages{}
+
+func (s *pipelineStages) workers(stage, probing int) int {
+	if stage > 0 {
+		return stage
+	}
+	return probing
+}
+
+/*
+runStage starts a stage's workers, each given its number, and returns
+what to wait on for them to be done
+*/
+func runStage(workers int, work func(i int)) *sync.WaitGroup {
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			work(i)
+		}(i)
+	}
+	return &wg
+}
+
+/*
+hostResolver looks up the host names of targets ahead of the probes,
+in the resolve stage, so a slow DNS server doesn't keep probe workers
+waiting. A name is looked up once for all its targets in the pipeline
+and kept until the last of them is probed.
+*/
+type hostResolver struct {
+	mu    sync.Mutex
+	hosts map[string]*resolvedHost
+}
+
+type resolvedHost struct {
+	addrs []string
+	// Targets of the host not probed yet
+	targets int
+}
+
+var resolver = &hostResolver{hosts: make(map[string]*resolvedHost)}
+
+/*
+resolve looks the target's host name up. IPs, named pipes and targets
+reached through -ssh or a proxy_chain, whose names the last hop
+resolves, are left alone, as are names that don't resolve: the dial
+reports those.
+*/
+func (r *hostResolver) resolve(target Target) {
+	if target.Pipe != "" || chain.dialer != nil || net.ParseIP(target.Host) != nil {
+		return
+	}
+	r.mu.Lock()
+	host, ok := r.hosts[target.Host]
+	if ok {
+		host.targets++
+		r.mu.Unlock()
+		return
+	}
+	host = &resolvedHost{targets: 1}
+	r.hosts[target.Host] = host
+	r.mu.Unlock()
+
+	addrs, err := net.LookupHost(target.Host)
+	if err != nil {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	host.addrs = addrs
+}
+
+/*
+forget drops the target's name once its last target is probed
+*/
+func (r *hostResolver) forget(target Target) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if host, ok := r.hosts[target.Host]; ok {
+		if host.targets--; host.targets == 0 {
+			delete(r.hosts, target.Host)
+		}
+	}
+}
+
+/*
+Limits used by dial: how long connecting to a target may take, shared
+between the addresses its name resolved to as net.Dialer does, and how
+long those of one family are tried before the other family joins in,
+net.Dialer's Happy Eyeballs fallback delay
+*/
+var dialTimeouts = struct {
+	connect  time.Duration
+	fallback time.Duration
+}{10 * time.Second, 300 * time.Millisecond}
+
+/*
+dial connects to the target over TCP, trying the addresses its name
+resolved to as net.Dialer would, or letting the dialer resolve it when
+it wasn't
+*/
+func (r *hostResolver) dial(target Target) (net.Conn, error) {
+	var addrs []string
+	r.mu.Lock()
+	if host, ok := r.hosts[target.Host]; ok {
+		addrs = host.addrs
+	}
+	r.mu.Unlock()
+
+	ctx, cancel := context.WithTimeout(context.Background(), dialTimeouts.connect)
+	defer cancel()
+	if len(addrs) == 0 {
+		return targetDialer.DialContext(ctx, "tcp", target.Address())
+	}
+	port := strconv.Itoa(target.Port)
+	primaries, fallbacks := splitFamilies(addrs)
+	if len(fallbacks) == 0 {
+		return dialSerial(ctx, primaries, port)
+	}
+
+	// The family of the first address goes first, the other joins in
+	// once it fails or is slow, and the first connection wins
+	type dialed struct {
+		conn    net.Conn
+		err     error
+		primary bool
+	}
+	results := make(chan dialed, 2)
+	racing, cancelRace := context.WithCancel(ctx)
+	defer cancelRace()
+	start := func(addrs []string, primary bool) {
+		go func() {
+			conn, err := dialSerial(racing, addrs, port)
+			results <- dialed{conn, err, primary}
+		}()
+	}
+	start(primaries, true)
+	fallback := time.NewTimer(dialTimeouts.fallback)
+	defer fallback.Stop()
+
+	var firstErr error
+	started, done := 1, 0
+	for {
+		select {
+		case <-fallback.C:
+			if started == 1 {
+				started++
+				start(fallbacks, false)
+			}
+		case d := <-results:
+			done++
+			if d.err == nil {
+				// Hang up the loser, should it connect after all
+				if started > done {
+					go func() {
+						if late := <-results; late.err == nil {
+							late.conn.Close()
+						}
+					}()
+				}
+				return d.conn, nil
+			}
+			if firstErr == nil || d.primary {
+				firstErr = d.err
+			}
+			if started == 1 {
+				fallback.Stop()
+				started++
+				start(fallbacks, false)
+			}
+			if done == started {
+				return nil, firstErr
+			}
+		}
+	}
+}
+
+/*
+splitFamilies splits the addresses into those of the first one's
+family and the others
+*/
+func splitFamilies(addrs []string) (primaries, fallbacks []string) {
+	ipv4 := func(addr string) bool {
+		ip := net.ParseIP(addr)
+		return ip != nil && ip.To4() != nil
+	}
+	first := ipv4(addrs[0])
+	for _, addr := range addrs {
+		if ipv4(addr) == first {
+			primaries = append(primaries, addr)
+		} else {
+			fallbacks = append(fallbacks, addr)
+		}
+	}
+	return primaries, fallbacks
+}
+
+/*
+dialSerial tries the addresses in turn, each given an equal share of
+the time left so one that drops SYNs doesn't use it all up
+*/
+func dialSerial(ctx context.Context, addrs []string, port string) (net.Conn, error) {
+	var err error
+	for i, addr := range addrs {
+		attempt, cancel := ctx, context.CancelFunc(func() {})
+		if deadline, ok := ctx.Deadline(); ok {
+			share := time.Until(deadline) / time.Duration(len(addrs)-i)
+			attempt, cancel = context.WithTimeout(ctx, share)
+		}
+		var conn net.Conn
+		conn, err = targetDialer.DialContext(attempt, "tcp", net.JoinHostPort(addr, port))
+		cancel()
+		if err == nil {
+			return conn, nil
+		}
+		if ctx.Err() != nil {
+			break
+		}
+	}
+	return nil, err
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+/*
+useResolved has the host resolve to the addresses for the test, as the
+resolve stage would, with shorter dial limits
+*/
+func useResolved(t *testing.T, host string, addrs ...string) {
+	t.Helper()
+	previous := dialTimeouts
+	dialTimeouts.connect, dialTimeouts.fallback = 200*time.Millisecond, 20*time.Millisecond
+	resolver.mu.Lock()
+	resolver.hosts[host] = &resolvedHost{addrs: addrs, targets: 1}
+	resolver.mu.Unlock()
+	t.Cleanup(func() {
+		dialTimeouts = previous
+		resolver.mu.Lock()
+		delete(resolver.hosts, host)
+		resolver.mu.Unlock()
+	})
+}
+
+func TestDialFallsBackToOtherFamily(t *testing.T) {
+	_, network := useFakes(t)
+	useResolved(t, "dual.example.com", "2001:db8::5", "10.0.0.5")
+	target := Target{Host: "dual.example.com", Port: 3306, Protocol: ProtocolMySQL}
+	network.Stall("[2001:db8::5]:3306")
+	network.Serve("10.0.0.5:3306", serveGreeting(greetingBytes(t)))
+
+	start := time.Now()
+	conn, err := resolver.dial(target)
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn.Close()
+	if took := time.Since(start); took >= dialTimeouts.connect {
+		t.Errorf("IPv4 connected after %s, want once the IPv6 address was slow", took)
+	}
+}
+
+func TestDialTimesOut(t *testing.T) {
+	_, network := useFakes(t)
+	useResolved(t, "stalled.example.com", "10.0.0.6", "10.0.0.7")
+	target := Target{Host: "stalled.example.com", Port: 3306, Protocol: ProtocolMySQL}
+	network.Stall("10.0.0.6:3306")
+	network.Stall("10.0.0.7:3306")
+
+	start := time.Now()
+	_, err := resolver.dial(target)
+	if err == nil || !strings.Contains(err.Error(), "deadline exceeded") {
+		t.Errorf("got %v, want the dial to time out", err)
+	}
+	if took := time.Since(start); took > time.Second {
+		t.Errorf("the dial gave up after %s", took)
+	}
+	// The second address gets its share of the time
+	if dials := network.Dials("10.0.0.7:3306"); dials != 1 {
+		t.Errorf("second address dialed %d times", dials)
+	}
+}
@@ -0,0 +1,348 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+
+	"gopkg.in/yaml.v3"
+)
+
+/*
+Kinds of stages a scan plan is made of
+*/
+const (
+	// Narrows the targets down to the hosts and ports that answer
+	StageDiscover = "discover"
+	// Probes the targets without credentials
+	StageFingerprint = "fingerprint"
+	// Probes the targets again with credentials, for the credentialed checks
+	StageAudit = "audit"
+)
+
+/*
+ScanPlan is a declarative scan given with -plan: stages run in order,
+each on the targets the previous ones let through
+*/
+type ScanPlan struct {
+	Stages []PlanStage `yaml:"stages"`
+	// Hops the targets are connected through, as with -ssh
+	ProxyChain []ProxyHop `yaml:"proxy_chain"`
+}
+
+/*
+PlanStage is one stage of a scan plan. Discover stages take tool, rate
+and alive_check, scan stages the rest.
+*/
+type PlanStage struct {
+	Name string `yaml:"name"`
+	Kind string `yaml:"kind"`
+	// Targets scanned at once, -workers by default
+	Workers int `yaml:"workers"`
+	// Which targets of the previous scan stage this one scans
+	Gate PlanGate `yaml:"gate"`
+
+	// masscan or zmap, and its packets per second
+	Tool       string `yaml:"tool"`
+	Rate       int    `yaml:"rate"`
+	AliveCheck string `yaml:"alive_check"`
+
+	// passive, active or intrusive, -passive and -intrusive by default
+	Tier            string   `yaml:"tier"`
+	TLSCert         bool     `yaml:"tls_cert"`
+	User            string   `yaml:"user"`
+	Packs           []string `yaml:"packs"`
+	Statistics      bool     `yaml:"statistics"`
+	SchemaInventory bool     `yaml:"schema_inventory"`
+	Processlist     bool     `yaml:"processlist"`
+}
+
+/*
+PlanGate lets a target into a scan stage on the result of the previous
+one. Without a status, only targets that sent a handshake pass.
+*/
+type PlanGate struct {
+	Status []string `yaml:"status"`
+	// Version prefixes, such as 5.7
+	Version []string `yaml:"version"`
+	// Lowest severity of a finding the target must have
+	Severity string `yaml:"severity"`
+
+	severity *Severity
+}
+
+/*
+loadScanPlan reads and checks a YAML scan plan, such as
+
+	stages:
+	  - name: ports
+	    kind: discover
+	    tool: masscan
+	  - name: banners
+	    kind: fingerprint
+	    workers: 64
+	  - name: audit
+	    kind: audit
+	    workers: 4
+	    user: auditor
+	    gate: {version: ["5.7"]}
+
+and the proxy_chain the targets are connected through, see
+checkProxyHops
+*/
+func loadScanPlan(path string) (*ScanPlan, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	plan := &ScanPlan{}
+	if err := yaml.Unmarshal(data, plan); err != nil {
+		return nil, fmt.Errorf("%s: %s", path, err.Error())
+	}
+	if err := plan.check(); err != nil {
+		return nil, fmt.Errorf("%s: %s", path, err.Error())
+	}
+	return plan, nil
+}
+
+func (p *ScanPlan) check() error {
+	scans := 0
+	for i := range p.Stages {
+		stage := &p.Stages[i]
+		if stage.Name == "" {
+			stage.Name = fmt.Sprintf("%s %d", stage.Kind, i+1)
+		}
+		if stage.Workers < 0 {
+			return fmt.Errorf("Stage %s: workers can't be negative", stage.Name)
+		}
+		switch stage.Kind {
+		case StageDiscover:
+			if scans > 0 {
+				return fmt.Errorf("Stage %s: discover stages must come before the scan stages", stage.Name)
+			}
+			if stage.Tool == "" && stage.AliveCheck == "" {
+				return fmt.Errorf("Stage %s: discover stages need a tool or an alive_check", stage.Name)
+			}
+		case StageFingerprint, StageAudit:
+			scans++
+			if _, err := parseTier(stage.Tier); stage.Tier != "" && err != nil {
+				return fmt.Errorf("Stage %s: %s", stage.Name, err.Error())
+			}
+			if _, err := parsePacks(strings.Join(stage.Packs, ",")); err != nil {
+				return fmt.Errorf("Stage %s: %s", stage.Name, err.Error())
+			}
+			if stage.Gate.Severity != "" {
+				severity, err := parseSeverity(stage.Gate.Severity)
+				if err != nil {
+					return fmt.Errorf("Stage %s: %s", stage.Name, err.Error())
+				}
+				stage.Gate.severity = &severity
+			}
+		default:
+			return fmt.Errorf("Stage %s: unknown kind %q, expected discover, fingerprint or audit", stage.Name, stage.Kind)
+		}
+	}
+	if scans == 0 {
+		return errors.New("The plan has no fingerprint or audit stage")
+	}
+	return checkProxyHops(p.ProxyChain)
+}
+
+/*
+discovers tells whether the plan has a port scanner stage, which makes
+range targets possible
+*/
+func (p *ScanPlan) discovers() bool {
+	if p == nil {
+		return false
+	}
+	for _, stage := range p.Stages {
+		if stage.Kind == StageDiscover && stage.Tool != "" {
+			return true
+		}
+	}
+	return false
+}
+
+/*
+parseTier returns the tier with the given name
+*/
+func parseTier(name string) (Tier, error) {
+	for tier, n := range tierNames {
+		if strings.EqualFold(n, name) {
+			return tier, nil
+		}
+	}
+	return 0, fmt.Errorf("Unknown tier %q", name)
+}
+
+/*
+pass tells whether the previous stage's result lets the target in
+*/
+func (g PlanGate) pass(result *ScanResult) bool {
+	if len(g.Status) == 0 && result.Failed() {
+		return false
+	}
+	if len(g.Status) > 0 && !containsString(g.Status, result.Status) {
+		return false
+	}
+	if len(g.Version) > 0 {
+		matched := false
+		for _, prefix := range g.Version {
+			matched = matched || strings.HasPrefix(result.Version(), prefix)
+		}
+		if !matched {
+			return false
+		}
+	}
+	if g.severity != nil {
+		for _, finding := range result.Findings {
+			if finding.Severity >= *g.severity {
+				return true
+			}
+		}
+		return false
+	}
+	return true
+}
+
+func containsString(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
+
+/*
+options derives the stage's scan options from the command line's
+*/
+func (s PlanStage) options(opts ScanOptions) ScanOptions {
+	if s.Workers > 0 {
+		opts.Workers = s.Workers
+	}
+	if s.Tier != "" {
+		opts.Tier, _ = parseTier(s.Tier)
+	}
+	opts.TLSCert = opts.TLSCert || s.TLSCert
+	// A cached result of an earlier stage would stand in for this one
+	opts.Banners = nil
+	if s.Kind == StageFingerprint {
+		opts.User = ""
+		opts.Statistics = false
+		opts.SchemaInventory = false
+		opts.Processlist = false
+		return opts
+	}
+	if s.User != "" {
+		opts.User = s.User
+	}
+	opts.Statistics = opts.Statistics || s.Statistics
+	opts.SchemaInventory = opts.SchemaInventory || s.SchemaInventory
+	opts.Processlist = opts.Processlist || s.Processlist
+	if len(s.Packs) > 0 {
+		opts.Packs, _ = parsePacks(strings.Join(s.Packs, ","))
+	}
+	return opts
+}
+
+/*
+run executes the plan on the targets. Each target is reported once,
+with the result of the last scan stage it got into; targets whose
+results a -script dropped aren't reported. Listeners -follow-listeners
+added are reported after the targets, in the order of their labels.
+*/
+func (p *ScanPlan) run(targets []Target, opts ScanOptions, report func(*ScanResult)) error {
+	// Find out before any stage runs that a later one can't
+	for _, stage := range p.Stages {
+		switch {
+		case stage.Kind == StageAudit && stage.options(opts).User == "":
+			return fmt.Errorf("Stage %s: audit stages need a user, in the plan or with -user", stage.Name)
+		case stage.Kind == StageDiscover && chain.dialer != nil:
+			return fmt.Errorf("Stage %s: discover stages connect from this machine and can't be used with -ssh or a proxy_chain", stage.Name)
+		}
+	}
+
+	results := make(map[Target]*ScanResult)
+	for _, stage := range p.Stages {
+		if stage.Kind == StageDiscover {
+			var err error
+			if targets, err = stage.discover(targets); err != nil {
+				return fmt.Errorf("Stage %s: %s", stage.Name, err.Error())
+			}
+			continue
+		}
+
+		var due []Target
+		for _, target := range targets {
+			if previous, ok := results[target]; ok && !stage.Gate.pass(previous) {
+				// Held back, so the previous stage's result is final
+				report(previous)
+				delete(results, target)
+				continue
+			}
+			due = append(due, target)
+		}
+		log.Printf("Stage %s: scanning %d of %d targets\n", stage.Name, len(due), len(targets))
+		targets = due
+
+		stageOpts := stage.options(opts)
+		var mu sync.Mutex
+		scanAll(targets, stageOpts, stageOpts.Workers, func(result *ScanResult) {
+			mu.Lock()
+			defer mu.Unlock()
+			results[result.Target] = result
+		})
+	}
+	for _, target := range targets {
+		if result, ok := results[target]; ok {
+			report(result)
+			delete(results, target)
+		}
+	}
+	listeners := make([]*ScanResult, 0, len(results))
+	for _, result := range results {
+		listeners = append(listeners, result)
+	}
+	sort.Slice(listeners, func(i, j int) bool {
+		return listeners[i].Target.Label() < listeners[j].Target.Label()
+	})
+	for _, result := range listeners {
+		report(result)
+	}
+	return nil
+}
+
+/*
+discover runs the stage's port scanner and liveness check
+*/
+func (s PlanStage) discover(targets []Target) ([]Target, error) {
+	all := len(targets)
+	if s.Tool != "" {
+		rate := s.Rate
+		if rate == 0 {
+			rate = defaultDiscoverRate
+		}
+		d, err := newDiscoverer(s.Tool, rate)
+		if err != nil {
+			return nil, err
+		}
+		if targets, err = d.discover(targets); err != nil {
+			return nil, err
+		}
+	}
+	if s.AliveCheck != "" {
+		check, err := parseLivenessCheck(s.AliveCheck, defaultLivenessTimeout)
+		if err != nil {
+			return nil, err
+		}
+		targets, _ = check.prune(targets)
+	}
+	log.Printf("Stage %s: %d targets to probe out of %d given\n", s.Name, len(targets), all)
+	return targets, nil
+}
@@ -0,0 +1,133 @@
+package main
+
+import (
+	"sync"
+	"time"
+)
+
+/*
+Pool defaults, kept small on purpose: the scanner should hold as few
+of a server's connection slots as possible
+*/
+const (
+	defaultPoolMaxIdle     = 2
+	defaultPoolIdleTimeout = 5 * time.Minute
+)
+
+type poolKey struct {
+	target Target
+	user   string
+}
+
+type pooledSession struct {
+	session  *Session
+	lastUsed time.Time
+}
+
+/*
+sessionPool keeps authenticated sessions between watch rounds, keyed
+by target and user, so recurring credentialed checks don't log in again
+every interval. Sessions idle for longer than idleTimeout are closed
+and every session is pinged before it is handed out again.
+*/
+type sessionPool struct {
+	mu          sync.Mutex
+	idle        map[poolKey][]pooledSession
+	maxIdle     int
+	idleTimeout time.Duration
+}
+
+func newSessionPool(maxIdle int, idleTimeout time.Duration) *sessionPool {
+	return &sessionPool{
+		idle:        make(map[poolKey][]pooledSession),
+		maxIdle:     maxIdle,
+		idleTimeout: idleTimeout,
+	}
+}
+
+/*
+get returns a healthy pooled session, or logs in when there is none
+*/
+func (p *sessionPool) get(target Target, user, password string, factors ...string) (*Session, error) {
+	key := poolKey{target: target, user: user}
+
+	for {
+		p.mu.Lock()
+		sessions := p.idle[key]
+		if len(sessions) == 0 {
+			p.mu.Unlock()
+			break
+		}
+		pooled := sessions[len(sessions)-1]
+		p.idle[key] = sessions[:len(sessions)-1]
+		p.mu.Unlock()
+
+		if clock.Now().Sub(pooled.lastUsed) > p.idleTimeout || pooled.session.Ping() != nil {
+			pooled.session.Close()
+			continue
+		}
+		return pooled.session, nil
+	}
+
+	return login(target, user, password, factors...)
+}
+
+/*
+put hands a session back to the pool, closing it when it is broken or
+the pool is full
+*/
+func (p *sessionPool) put(session *Session) {
+	if session.broken {
+		session.Close()
+		return
+	}
+
+	key := poolKey{target: session.Target, user: session.User}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if len(p.idle[key]) >= p.maxIdle {
+		session.Close()
+		return
+	}
+	p.idle[key] = append(p.idle[key], pooledSession{session: session, lastUsed: clock.Now()})
+}
+
+/*
+reap closes every session that has been idle for too long
+*/
+func (p *sessionPool) reap() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	for key, sessions := range p.idle {
+		kept := sessions[:0]
+		for _, pooled := range sessions {
+			if clock.Now().Sub(pooled.lastUsed) > p.idleTimeout {
+				pooled.session.Close()
+				continue
+			}
+			kept = append(kept, pooled)
+		}
+		if len(kept) == 0 {
+			delete(p.idle, key)
+			continue
+		}
+		p.idle[key] = kept
+	}
+}
+
+/*
+closeAll closes every pooled session
+*/
+func (p *sessionPool) closeAll() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	for key, sessions := range p.idle {
+		for _, pooled := range sessions {
+			pooled.session.Close()
+		}
+		delete(p.idle, key)
+	}
+}
@@ -0,0 +1,153 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+func init() {
+	registerCheck(Check{
+		ID:          "MYSQL-PROCESSLIST",
+		Description: "Samples the processlist for the source addresses connected to the server, when asked to",
+		Tier:        TierActive,
+		Run:         checkProcesslist,
+	})
+}
+
+/*
+ConnectedSource is an address sessions of the server were connected
+from when the processlist was sampled
+*/
+type ConnectedSource struct {
+	// IP address, or localhost for socket and named pipe sessions
+	Address  string
+	Sessions int
+	// Accounts the sessions are logged in as, sorted
+	Users []string
+}
+
+func (s ConnectedSource) String() string {
+	sessions := "sessions"
+	if s.Sessions == 1 {
+		sessions = "session"
+	}
+	return fmt.Sprintf("%s (%d %s as %s)", s.Address, s.Sessions, sessions, strings.Join(s.Users, ", "))
+}
+
+/*
+Sessions of the server without their statements, which may carry
+passwords (ALTER USER ... IDENTIFIED BY) and would otherwise be sent to
+the scanner and kept in its -record and -evidence-dir captures
+*/
+const processlistQuery = "SELECT ID, USER, HOST, COMMAND FROM information_schema.PROCESSLIST"
+
+/*
+queryProcesslist groups the sessions of the processlist by the address
+they connected from, leaving out the scanner's own, given by its
+connection ID, and the server's background threads, which have no host
+or, like the event scheduler, run the Daemon command. Only the Id, User,
+Host and Command columns are selected, so statements in Info stay on
+the server. Servers that refuse the query are sampled with SHOW
+PROCESSLIST instead, which sends the start of every statement and of
+which only the same columns are read. Without PROCESS the account only
+sees its own sessions.
+*/
+func queryProcesslist(s QueryRunner, own uint32) ([]ConnectedSource, error) {
+	columns, rows, err := s.Query(processlistQuery)
+	if err != nil {
+		var serverErr *ServerError
+		if !errors.As(err, &serverErr) {
+			return nil, err
+		}
+		if columns, rows, err = s.Query("SHOW PROCESSLIST"); err != nil {
+			return nil, err
+		}
+	}
+	index := make(map[string]int)
+	for i, column := range columns {
+		index[strings.ToLower(column)] = i
+	}
+	id, okID := index["id"]
+	user, okUser := index["user"]
+	host, okHost := index["host"]
+	if !okID || !okUser || !okHost {
+		return nil, fmt.Errorf("Unexpected processlist columns %s", strings.Join(columns, ", "))
+	}
+	command, okCommand := index["command"]
+
+	byAddress := make(map[string]*ConnectedSource)
+	users := make(map[string]map[string]bool)
+	for _, row := range rows {
+		if len(row) != len(columns) || row[host] == nil || len(row[host]) == 0 {
+			continue
+		}
+		if okCommand && string(row[command]) == "Daemon" {
+			continue
+		}
+		if sessionID, err := strconv.ParseUint(string(row[id]), 10, 32); err == nil && uint32(sessionID) == own {
+			continue
+		}
+		address := string(row[host])
+		if h, _, err := net.SplitHostPort(address); err == nil {
+			address = h
+		}
+		source, ok := byAddress[address]
+		if !ok {
+			source = &ConnectedSource{Address: address}
+			byAddress[address] = source
+			users[address] = make(map[string]bool)
+		}
+		source.Sessions++
+		users[address][string(row[user])] = true
+	}
+
+	sources := make([]ConnectedSource, 0, len(byAddress))
+	for address, source := range byAddress {
+		for u := range users[address] {
+			source.Users = append(source.Users, u)
+		}
+		sort.Strings(source.Users)
+		sources = append(sources, *source)
+	}
+	// Most sessions first, the addresses most of the exposure goes through
+	sort.Slice(sources, func(i, j int) bool {
+		if sources[i].Sessions != sources[j].Sessions {
+			return sources[i].Sessions > sources[j].Sessions
+		}
+		return sources[i].Address < sources[j].Address
+	})
+	return sources, nil
+}
+
+/*
+checkProcesslist records the connected sources in the result when
+asked to with -processlist. It produces no findings of its own.
+*/
+func checkProcesslist(ctx *CheckContext) []Finding {
+	if !ctx.Options.Processlist || ctx.Result == nil {
+		return nil
+	}
+
+	session, err := ctx.Queries()
+	if err != nil {
+		return nil
+	}
+	// The statement's own session, whichever query driver runs it
+	_, rows, err := session.Query("SELECT CONNECTION_ID() AS connection_id")
+	if err != nil || len(rows) != 1 || len(rows[0]) != 1 {
+		return nil
+	}
+	own, err := strconv.ParseUint(string(rows[0][0]), 10, 32)
+	if err != nil {
+		return nil
+	}
+
+	if sources, err := queryProcesslist(session, uint32(own)); err == nil {
+		ctx.Result.Processlist = sources
+	}
+	return nil
+}
@@ -0,0 +1,46 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestProcesslistLeavesStatementsOnServer(t *testing.T) {
+	rows := func(cells ...string) [][][]byte {
+		var row [][]byte
+		for _, cell := range cells {
+			row = append(row, []byte(cell))
+		}
+		return [][][]byte{row}
+	}
+	want := []ConnectedSource{{Address: "10.0.0.9", Sessions: 1, Users: []string{"app"}}}
+
+	var queries []string
+	selected := queryFunc(func(sql string) ([]string, [][][]byte, error) {
+		queries = append(queries, sql)
+		return []string{"ID", "USER", "HOST", "COMMAND"}, rows("12", "app", "10.0.0.9:51234", "Query"), nil
+	})
+	sources, err := queryProcesslist(selected, 7)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(sources, want) || !reflect.DeepEqual(queries, []string{processlistQuery}) {
+		t.Errorf("got %v with queries %q", sources, queries)
+	}
+
+	queries = nil
+	refused := queryFunc(func(sql string) ([]string, [][][]byte, error) {
+		queries = append(queries, sql)
+		if sql == processlistQuery {
+			return nil, nil, &ServerError{Code: 1109, Message: "Unknown table 'PROCESSLIST' in information_schema"}
+		}
+		return []string{"Id", "User", "Host", "db", "Command", "Time", "State", "Info"},
+			rows("12", "app", "10.0.0.9:51234", "shop", "Query", "0", "", "ALTER USER app IDENTIFIED BY 'secret'"), nil
+	})
+	if sources, err = queryProcesslist(refused, 7); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(sources, want) || len(queries) != 2 || queries[1] != "SHOW PROCESSLIST" {
+		t.Errorf("got %v with queries %q", sources, queries)
+	}
+}
@@ -0,0 +1,163 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"os"
+	"time"
+
+	"golang.org/x/net/proxy"
+)
+
+/*
+Transports a proxy_chain hop of the scan plan can be
+*/
+const (
+	HopSSH    = "ssh"
+	HopSOCKS5 = "socks5"
+)
+
+/*
+Environment variable holding the password of SOCKS5 hops that don't
+name their own, kept out of the plan like the SSH key passphrase
+*/
+const socksPasswordEnv = "RAJATH_SOCKS_PASSWORD"
+
+/*
+How long connecting to the first hop, logging in to SSH hops, and
+connecting to a target through the chain may take
+*/
+const hopConnectTimeout = 10 * time.Second
+
+/*
+ProxyHop is one transport of a proxy chain. SSH hops take the -ssh-*
+flags for the settings they leave out.
+*/
+type ProxyHop struct {
+	Type string `yaml:"type"`
+	// user@host[:port] for SSH, host:port for SOCKS5
+	Address string `yaml:"address"`
+	// SOCKS5 username, and the environment variable holding its password
+	User        string `yaml:"user"`
+	PasswordEnv string `yaml:"password_env"`
+	// SSH key, agent and host key settings
+	Key        string `yaml:"key"`
+	Agent      string `yaml:"agent"`
+	KnownHosts string `yaml:"known_hosts"`
+	HostKeys   string `yaml:"host_keys"`
+}
+
+/*
+checkProxyHops checks the proxy_chain of a scan plan, the first hop
+connected to from this machine and each next one from the previous,
+such as
+
+	proxy_chain:
+	  - type: ssh
+	    address: scanner@bastion.example.com
+	  - type: socks5
+	    address: 10.20.0.5:1080
+	    user: scan
+*/
+func checkProxyHops(hops []ProxyHop) error {
+	for i, hop := range hops {
+		if hop.Address == "" || hop.Type != HopSSH && hop.Type != HopSOCKS5 {
+			return fmt.Errorf("Proxy hop %d needs an address and a type, ssh or socks5", i+1)
+		}
+		if hop.Type == HopSOCKS5 && hop.User != "" && hop.password() == "" {
+			return fmt.Errorf("Proxy hop %d: set %s for the SOCKS5 password of %s", i+1, hop.passwordEnv(), hop.User)
+		}
+	}
+	return nil
+}
+
+func (h ProxyHop) passwordEnv() string {
+	if h.PasswordEnv != "" {
+		return h.PasswordEnv
+	}
+	return socksPasswordEnv
+}
+
+func (h ProxyHop) password() string {
+	return os.Getenv(h.passwordEnv())
+}
+
+/*
+proxyChain dials targets through a chain of transports, set up by -ssh
+or the scan plan's proxy_chain; without one targets are dialed directly
+*/
+type proxyChain struct {
+	dialer  proxy.Dialer
+	tunnels []*sshTunnel
+}
+
+var chain = &proxyChain{}
+
+/*
+build composes the hops, connecting the SSH ones in order. SOCKS5 hops
+connect lazily, on every dial.
+*/
+func (c *proxyChain) build(hops []ProxyHop) error {
+	var forward proxy.Dialer
+	for _, hop := range hops {
+		switch hop.Type {
+		case HopSSH:
+			tunnel := sshDefaults
+			if hop.Key != "" {
+				tunnel.keyPath = hop.Key
+			}
+			if hop.Agent != "" {
+				tunnel.agent = hop.Agent
+			}
+			if hop.KnownHosts != "" {
+				tunnel.knownHosts = hop.KnownHosts
+			}
+			if hop.HostKeys != "" {
+				tunnel.hostKeys = hop.HostKeys
+			}
+			if err := tunnel.connect(hop.Address, forward); err != nil {
+				c.close()
+				return err
+			}
+			c.tunnels = append(c.tunnels, &tunnel)
+			forward = &tunnel
+		case HopSOCKS5:
+			var auth *proxy.Auth
+			if hop.User != "" {
+				auth = &proxy.Auth{User: hop.User, Password: hop.password()}
+			}
+			if forward == nil {
+				forward = &net.Dialer{Timeout: hopConnectTimeout}
+			}
+			dialer, err := proxy.SOCKS5("tcp", hop.Address, auth, forward)
+			if err != nil {
+				c.close()
+				return fmt.Errorf("SOCKS5 proxy %s: %s", hop.Address, err.Error())
+			}
+			forward = dialer
+		}
+	}
+	c.dialer = forward
+	return nil
+}
+
+/*
+dial connects to address through the chain, giving up after
+hopConnectTimeout so a stalled hop can't hold a worker
+*/
+func (c *proxyChain) dial(network, address string) (net.Conn, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), hopConnectTimeout)
+	defer cancel()
+	return c.dialer.(proxy.ContextDialer).DialContext(ctx, network, address)
+}
+
+/*
+close logs out of the SSH hops, the last one first
+*/
+func (c *proxyChain) close() {
+	for i := len(c.tunnels) - 1; i >= 0; i-- {
+		c.tunnels[i].close()
+	}
+	c.tunnels = nil
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"context"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/net/proxy"
+)
+
+const chainPlan = `
+proxy_chain:
+  - type: ssh
+    address: scanner@bastion.example.com
+    host_keys: accept-new
+  - type: socks5
+    address: 10.20.0.5:1080
+    user: scan
+    password_env: TEST_SOCKS_PASSWORD
+stages:
+  - kind: fingerprint
+`
+
+func writePlan(t *testing.T, plan string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "plan.yaml")
+	if err := os.WriteFile(path, []byte(plan), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestPlanProxyChain(t *testing.T) {
+	path := writePlan(t, chainPlan)
+
+	t.Setenv("TEST_SOCKS_PASSWORD", "")
+	if _, err := loadScanPlan(path); err == nil || !strings.Contains(err.Error(), "TEST_SOCKS_PASSWORD") {
+		t.Errorf("got %v, want the password's variable asked for", err)
+	}
+
+	t.Setenv("TEST_SOCKS_PASSWORD", "secret")
+	plan, err := loadScanPlan(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []ProxyHop{
+		{Type: HopSSH, Address: "scanner@bastion.example.com", HostKeys: HostKeysAcceptNew},
+		{Type: HopSOCKS5, Address: "10.20.0.5:1080", User: "scan", PasswordEnv: "TEST_SOCKS_PASSWORD"},
+	}
+	if len(plan.ProxyChain) != len(want) {
+		t.Fatalf("got hops %+v, want %+v", plan.ProxyChain, want)
+	}
+	for i := range want {
+		if plan.ProxyChain[i] != want[i] {
+			t.Errorf("hop %d is %+v, want %+v", i+1, plan.ProxyChain[i], want[i])
+		}
+	}
+	if password := plan.ProxyChain[1].password(); password != "secret" {
+		t.Errorf("SOCKS5 password %q", password)
+	}
+
+	if _, err := loadScanPlan(writePlan(t, "proxy_chain: [{type: http, address: proxy:3128}]\nstages: [{kind: fingerprint}]\n")); err == nil {
+		t.Error("an http hop was accepted")
+	}
+}
+
+func TestSOCKS5HopTimesOut(t *testing.T) {
+	// A SOCKS5 proxy that accepts connections and never answers
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			defer conn.Close()
+		}
+	}()
+
+	c := &proxyChain{}
+	if err := c.build([]ProxyHop{{Type: HopSOCKS5, Address: listener.Addr().String()}}); err != nil {
+		t.Fatal(err)
+	}
+	defer c.close()
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	if _, err := c.dialer.(proxy.ContextDialer).DialContext(ctx, "tcp", "db1:3306"); err == nil {
+		t.Error("the stalled proxy connected")
+	}
+	if took := time.Since(start); took > time.Second {
+		t.Errorf("the dial gave up after %s", took)
+	}
+}
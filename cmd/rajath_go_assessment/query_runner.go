@@ -0,0 +1,157 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"sync"
+
+	"github.com/go-sql-driver/mysql"
+)
+
+/*
+QueryRunner runs statements for the credentialed checks. The scanner's
+own Session is one; with -query-driver sql the statements go through
+database/sql and go-sql-driver/mysql instead. Fingerprinting always
+uses the raw protocol.
+*/
+type QueryRunner interface {
+	// Query returns the column names and rows of the statement's
+	// result set, NULL values as nil
+	Query(sql string) ([]string, [][][]byte, error)
+}
+
+/*
+Query drivers that can be selected with -query-driver
+*/
+const (
+	QueryDriverRaw = "raw"
+	QueryDriverSQL = "sql"
+)
+
+var queryDrivers = map[string]bool{
+	QueryDriverRaw: true,
+	QueryDriverSQL: true,
+}
+
+/*
+The driver dials through dialTarget, so its connections are metered
+and respect paused hosts like the scanner's own. Targets are looked up
+by the address the driver is given.
+*/
+const sqlDialNetwork = "rajath"
+
+var sqlTargets sync.Map
+
+func init() {
+	// Errors are reported with the findings, the driver's own log would only repeat them
+	mysql.SetLogger(log.New(io.Discard, "", 0))
+	mysql.RegisterDialContext(sqlDialNetwork, func(ctx context.Context, addr string) (net.Conn, error) {
+		value, ok := sqlTargets.Load(addr)
+		if !ok {
+			return nil, fmt.Errorf("Unknown target %s", addr)
+		}
+		target := value.(Target)
+		if err := saturation.allow(target.Host); err != nil {
+			return nil, err
+		}
+		return dialTarget(target)
+	})
+}
+
+/*
+sqlRunner runs statements over a single database/sql connection
+*/
+type sqlRunner struct {
+	db *sql.DB
+}
+
+/*
+openSQLRunner logs in to the target with go-sql-driver/mysql
+*/
+func openSQLRunner(target Target, user, password string) (*sqlRunner, error) {
+	if target.Pipe != "" {
+		return nil, fmt.Errorf("The %s query driver can't connect to named pipes", QueryDriverSQL)
+	}
+	sqlTargets.Store(target.Address(), target)
+
+	cfg := mysql.NewConfig()
+	cfg.User = user
+	cfg.Passwd = password
+	cfg.Net = sqlDialNetwork
+	cfg.Addr = target.Address()
+	cfg.Timeout = decodeTimeouts.total
+
+	connector, err := mysql.NewConnector(cfg)
+	if err != nil {
+		return nil, err
+	}
+	db := sql.OpenDB(connector)
+	db.SetMaxOpenConns(1)
+	if err := db.Ping(); err != nil {
+		db.Close()
+		err = fromDriverError(err)
+		saturation.observe(target.Host, err)
+		return nil, err
+	}
+	return &sqlRunner{db: db}, nil
+}
+
+func (r *sqlRunner) Query(query string) ([]string, [][][]byte, error) {
+	rows, err := r.db.Query(query)
+	if err != nil {
+		return nil, nil, fromDriverError(err)
+	}
+	defer rows.Close()
+
+	columns, err := rows.Columns()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	values := make([]sql.RawBytes, len(columns))
+	dest := make([]interface{}, len(columns))
+	for i := range values {
+		dest[i] = &values[i]
+	}
+
+	var result [][][]byte
+	for rows.Next() {
+		if err := rows.Scan(dest...); err != nil {
+			return nil, nil, err
+		}
+		// RawBytes are only valid until the next row, copy them out
+		row := make([][]byte, len(values))
+		for i, value := range values {
+			if value != nil {
+				row[i] = append([]byte{}, value...)
+			}
+		}
+		result = append(result, row)
+	}
+	return columns, result, rows.Err()
+}
+
+/*
+fromDriverError turns the driver's server errors into ServerError, so
+both drivers report them the same way
+*/
+func fromDriverError(err error) error {
+	var driverErr *mysql.MySQLError
+	if !errors.As(err, &driverErr) {
+		return err
+	}
+	sqlState := string(driverErr.SQLState[:])
+	if driverErr.SQLState == [5]byte{} {
+		sqlState = ""
+	}
+	return &ServerError{Code: driverErr.Number, SQLState: sqlState, Message: driverErr.Message}
+}
+
+func (r *sqlRunner) Close() error {
+	return r.db.Close()
+}
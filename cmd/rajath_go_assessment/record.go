@@ -0,0 +1,281 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/fs"
+	"log"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+/*
+Extension of recorded server byte streams, after the protocol
+*/
+const recordingExt = ".bin"
+
+/*
+streamRecorder saves what servers send on every connection when -record
+is given, one file per connection under a directory per target:
+dir/host_port/001-mysql.bin. With -redact the directory is named after
+the redacted target and the bytes are masked as in redactor.raw.
+Recordings are replayed with the replay subcommand.
+*/
+type streamRecorder struct {
+	dir string
+
+	mu    sync.Mutex
+	count map[string]int
+}
+
+var recorder = &streamRecorder{count: make(map[string]int)}
+
+/*
+recordingName turns a target label into a directory name
+*/
+func recordingName(label string) string {
+	return strings.NewReplacer(":", "_", "/", "_", "\\", "_", " ", "_", "[", "", "]", "").Replace(label)
+}
+
+/*
+wrap returns conn saving everything read from it, or conn itself when
+nothing is recorded
+*/
+func (r *streamRecorder) wrap(target Target, conn net.Conn) net.Conn {
+	if r.dir == "" {
+		return conn
+	}
+	// Keyed when IPs are masked, so targets differing in the masked part
+	// don't share a directory
+	name := recordingName(redactor.storeKey(target))
+
+	r.mu.Lock()
+	r.count[name]++
+	n := r.count[name]
+	r.mu.Unlock()
+
+	path := filepath.Join(r.dir, name, fmt.Sprintf("%03d-%s%s", n, target.Protocol, recordingExt))
+	return &recordingConn{Conn: conn, target: target, path: path}
+}
+
+/*
+recordingConn keeps the bytes read from the connection and saves them
+when it is closed, as the whole stream is needed to mask it. Silent
+connections leave nothing behind. A recording that can't be written is
+logged and dropped, the scan goes on without it.
+*/
+type recordingConn struct {
+	net.Conn
+	target Target
+	path   string
+	data   []byte
+}
+
+func (c *recordingConn) Read(p []byte) (int, error) {
+	n, err := c.Conn.Read(p)
+	c.data = append(c.data, p[:n]...)
+	return n, err
+}
+
+func (c *recordingConn) save() error {
+	if err := os.MkdirAll(filepath.Dir(c.path), 0700); err != nil {
+		return err
+	}
+	// Recordings can hold query results, keep them private
+	file, err := os.OpenFile(c.path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		return err
+	}
+	if _, err := file.Write(redactor.raw(c.data)); err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
+
+func (c *recordingConn) Close() error {
+	if len(c.data) > 0 {
+		if err := c.save(); err != nil {
+			log.Printf("Not recording %s: %s\n", redactor.target(c.target), err.Error())
+		}
+		c.data = nil
+	}
+	return c.Conn.Close()
+}
+
+/*
+replayConn serves a recorded stream as a connection: reads come from
+the recording and writes are discarded, so decoders run unchanged
+*/
+type replayConn struct {
+	*bytes.Reader
+	path string
+}
+
+type replayAddr string
+
+func (a replayAddr) Network() string { return "replay" }
+func (a replayAddr) String() string  { return string(a) }
+
+func (c *replayConn) Write(p []byte) (int, error)        { return len(p), nil }
+func (c *replayConn) Close() error                       { return nil }
+func (c *replayConn) LocalAddr() net.Addr                { return replayAddr("replay") }
+func (c *replayConn) RemoteAddr() net.Addr               { return replayAddr(c.path) }
+func (c *replayConn) SetDeadline(t time.Time) error      { return nil }
+func (c *replayConn) SetReadDeadline(t time.Time) error  { return nil }
+func (c *replayConn) SetWriteDeadline(t time.Time) error { return nil }
+
+/*
+replayRecording runs the decoder the recording's protocol is probed
+with over it, then walks the classic protocol packets that follow the
+greeting. It prints what was decoded and returns the decoding error.
+With diff, classic greetings are also compared with go-sql-driver/mysql's
+reading of them, and divergences count as errors.
+*/
+func replayRecording(path string, diff bool) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	conn := &replayConn{Reader: bytes.NewReader(data), path: path}
+	render.separator(os.Stdout)
+	fmt.Printf("%s (%d bytes)\n", path, len(data))
+
+	if strings.HasSuffix(path, "-"+ProtocolMySQLX+recordingExt) {
+		capabilities := &XCapabilities{}
+		if err := capabilities.Decode(conn); err != nil {
+			return err
+		}
+		fmt.Println(capabilities)
+		return nil
+	}
+
+	packet := &InitialHandshakePacket{}
+	err = packet.Decode(conn)
+	if diff && len(data) >= 4 {
+		greeting := data
+		if length := 4 + int(binary.LittleEndian.Uint32([]byte{data[0], data[1], data[2], 0})); length < len(data) {
+			greeting = data[:length]
+		}
+		if divergences := diffDecode(greeting, packet, err); len(divergences) > 0 {
+			for _, d := range divergences {
+				fmt.Printf("Divergence: %s\n", d)
+			}
+			return errors.New("Diverges from go-sql-driver/mysql")
+		}
+		fmt.Println("go-sql-driver/mysql agrees")
+	}
+	if err != nil {
+		return err
+	}
+	fmt.Println(packet.Info())
+	for conn.Len() > 0 {
+		// A TLS handshake record after an SSLRequest, the rest is encrypted
+		if rest := data[len(data)-conn.Len():]; len(rest) > 1 && rest[0] == 0x16 && rest[1] == 0x03 {
+			fmt.Printf("TLS from here on, %d bytes not decoded\n", len(rest))
+			return nil
+		}
+		seq, payload, err := readPacket(conn)
+		if err != nil {
+			return fmt.Errorf("After the greeting: %s", err.Error())
+		}
+		fmt.Printf("Packet seq %d %s length %d\n", seq, classicPacketType(false, seq, payload), len(payload))
+	}
+	return nil
+}
+
+/*
+scanRecording scans the recording's greeting as if a server sent it,
+on an in-memory network and with a fake clock, and prints what the
+passive checks find. Recordings are numbered, each gets the address
+replay:n.
+*/
+func scanRecording(path string, n int) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	greeting := data
+	if len(data) >= 4 {
+		if length := 4 + int(binary.LittleEndian.Uint32([]byte{data[0], data[1], data[2], 0})); length < len(data) {
+			greeting = data[:length]
+		}
+	}
+
+	network := NewPipeNetwork()
+	target := Target{Host: "replay", Port: n, Protocol: ProtocolMySQL}
+	network.Serve(target.Address(), serveGreeting(greeting))
+	targetDialer = network
+
+	result := scanTarget(target, ScanOptions{Tier: TierPassive})
+	if result.Failed() {
+		return result.Err
+	}
+	for _, finding := range result.Findings {
+		fmt.Printf("Finding: %s\n", finding)
+	}
+	return nil
+}
+
+/*
+runReplay implements the replay subcommand, decoding every recording
+given or found under the given directories
+*/
+func runReplay(args []string) int {
+	flags := flag.NewFlagSet("replay", flag.ExitOnError)
+	diff := flags.Bool("diff", false, "compare each greeting with go-sql-driver/mysql's reading of it and report divergences")
+	scan := flags.Bool("scan", false, "also scan each classic greeting with the passive checks, without sockets or sleeps")
+	flags.Parse(args)
+	if flags.NArg() == 0 {
+		fmt.Println("Usage: ./bin/rajath_go_assessment replay [-diff] [-scan] recording.bin | dir...")
+		return 2
+	}
+	if *scan {
+		clock = NewFakeClock(time.Now())
+	}
+
+	var paths []string
+	for _, arg := range flags.Args() {
+		err := filepath.WalkDir(arg, func(path string, entry fs.DirEntry, err error) error {
+			if err != nil {
+				return err
+			}
+			if !entry.IsDir() && (path == arg || strings.HasSuffix(path, recordingExt)) {
+				paths = append(paths, path)
+			}
+			return nil
+		})
+		if err != nil {
+			fmt.Println(err.Error())
+			return 1
+		}
+	}
+
+	failed := 0
+	for i, path := range paths {
+		err := replayRecording(path, *diff)
+		if err == nil && *scan && !strings.HasSuffix(path, "-"+ProtocolMySQLX+recordingExt) {
+			err = scanRecording(path, i+1)
+		}
+		if err != nil {
+			if errors.Is(err, io.ErrUnexpectedEOF) || errors.Is(err, io.EOF) {
+				err = fmt.Errorf("Recording ends mid-packet: %w", err)
+			}
+			fmt.Printf("Failed: %s\n", err.Error())
+			failed++
+		}
+	}
+	fmt.Printf("%d of %d recordings decoded cleanly\n", len(paths)-failed, len(paths))
+	if failed > 0 {
+		return 1
+	}
+	return 0
+}
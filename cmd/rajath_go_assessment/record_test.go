@@ -0,0 +1,190 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestReplayRecordings(t *testing.T) {
+	var code int
+	out := captureStdout(t, func() {
+		code = runReplay([]string{"-scan", "testdata/recordings"})
+	})
+	if code != 0 {
+		t.Fatalf("replay exited with %d:\n%s", code, out)
+	}
+	if !strings.Contains(out, "2 of 2 recordings decoded cleanly") {
+		t.Errorf("unexpected replay output:\n%s", out)
+	}
+}
+
+func TestReplayTruncatedRecording(t *testing.T) {
+	dir := t.TempDir()
+	greeting := greetingBytes(t)
+	if err := os.WriteFile(filepath.Join(dir, "001-mysql.bin"), greeting[:40], 0600); err != nil {
+		t.Fatal(err)
+	}
+	var code int
+	out := captureStdout(t, func() {
+		code = runReplay([]string{dir})
+	})
+	if code != 1 || !strings.Contains(out, "Failed: Recording ends mid-packet") {
+		t.Errorf("replay exited with %d:\n%s", code, out)
+	}
+}
+
+/*
+useRecorder records to a temporary directory for the test, with the
+given redactor
+*/
+func useRecorder(t *testing.T, r *Redactor) string {
+	t.Helper()
+	dir := t.TempDir()
+	previous, previousRedactor := recorder, redactor
+	recorder, redactor = &streamRecorder{dir: dir, count: make(map[string]int)}, r
+	t.Cleanup(func() {
+		recorder, redactor = previous, previousRedactor
+	})
+	return dir
+}
+
+/*
+recordGreeting scans target on the pipe network, which greets with the
+golden greeting, and returns the recording it left
+*/
+func recordGreeting(t *testing.T, target Target) (path string, data []byte) {
+	t.Helper()
+	_, network := useFakes(t)
+	network.Serve(target.Address(), serveGreeting(greetingBytes(t)))
+	if _, _, err := fetchHandshake(target); err != nil {
+		t.Fatal(err)
+	}
+	paths, _ := filepath.Glob(filepath.Join(recorder.dir, "*", "*"+recordingExt))
+	if len(paths) != 1 {
+		t.Fatalf("got recordings %v, want one", paths)
+	}
+	data, err := os.ReadFile(paths[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	return paths[0], data
+}
+
+func TestRecordingIsPrivate(t *testing.T) {
+	dir := useRecorder(t, nil)
+	path, data := recordGreeting(t, Target{Host: "db1", Port: 3306, Protocol: ProtocolMySQL})
+
+	if want := filepath.Join(dir, "db1_3306", "001-mysql"+recordingExt); path != want {
+		t.Errorf("recorded to %s, want %s", path, want)
+	}
+	if !bytes.Equal(data, greetingBytes(t)) {
+		t.Errorf("recorded %x", data)
+	}
+	for name, mode := range map[string]os.FileMode{path: 0600, filepath.Dir(path): 0700} {
+		info, err := os.Stat(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if info.Mode().Perm()&^mode != 0 {
+			t.Errorf("%s has mode %s, want at most %s", name, info.Mode().Perm(), mode)
+		}
+	}
+}
+
+func TestRecordingIsRedacted(t *testing.T) {
+	r, err := newRedactor("salt,ips", ScanOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir := useRecorder(t, r)
+	target := Target{Host: "10.0.0.5", Port: 3306, Protocol: ProtocolMySQL}
+	path, data := recordGreeting(t, target)
+
+	if name := filepath.Base(filepath.Dir(path)); strings.Contains(name, "10.0.0.5") || !strings.HasPrefix(name, "10.0.0.x_3306") {
+		t.Errorf("recorded under %s", name)
+	}
+	if filepath.Dir(filepath.Dir(path)) != dir {
+		t.Errorf("recorded outside %s: %s", dir, path)
+	}
+
+	packet := &InitialHandshakePacket{}
+	if err := packet.decodePacket(data); err != nil {
+		t.Fatalf("the masked recording doesn't decode: %v", err)
+	}
+	if string(packet.ServerVersion) != "8.0.32" || string(packet.AuthPluginName) != nativePasswordPlugin {
+		t.Errorf("masked more than the salt: %s %s", packet.ServerVersion, packet.AuthPluginName)
+	}
+	if !bytes.Equal(packet.AuthPluginData, make([]byte, 21)) {
+		t.Errorf("salt %x not masked", packet.AuthPluginData)
+	}
+}
+
+/*
+addrConn is a connection with the remote address of the test's choosing
+*/
+type addrConn struct {
+	net.Conn
+	remote net.Addr
+}
+
+func (c addrConn) RemoteAddr() net.Addr { return c.remote }
+
+/*
+traceLogin traces a greeting from 10.0.0.5 and the answer of the user
+scanner with r, returning the log and the greeting's salt
+*/
+func traceLogin(t *testing.T, r *Redactor) (string, []byte) {
+	t.Helper()
+	previous, previousRedactor := *tracer, redactor
+	tracer.enabled, redactor = true, r
+	var logged bytes.Buffer
+	log.SetOutput(&logged)
+	defer func() {
+		*tracer, redactor = previous, previousRedactor
+		log.SetOutput(os.Stderr)
+	}()
+
+	client, server := net.Pipe()
+	defer server.Close()
+	defer client.Close()
+	conn := addrConn{Conn: client, remote: &net.TCPAddr{IP: net.ParseIP("10.0.0.5"), Port: 3306}}
+
+	greeting := greetingBytes(t)
+	tracer.packet(conn, false, 0, len(greeting)-4, "Handshake", greeting[4:])
+	packet := &InitialHandshakePacket{}
+	if err := packet.decodePacket(greeting); err != nil {
+		t.Fatal(err)
+	}
+	response := handshakeResponse(packet, "scanner", scrambleNativePassword(packet.AuthPluginData, "pw"), nativePasswordPlugin)
+	tracer.packet(conn, true, 1, len(response), "HandshakeResponse", response)
+	return logged.String(), packet.AuthPluginData
+}
+
+func TestTraceIsRedacted(t *testing.T) {
+	r, err := newRedactor("salt,ips,users", ScanOptions{User: "scanner"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	plain, salt := traceLogin(t, nil)
+	out, _ := traceLogin(t, r)
+
+	// The payload's second dump line starts with bytes 4 to 7 of the salt
+	saltLine := fmt.Sprintf("00000010  %02x %02x %02x %02x", salt[4], salt[5], salt[6], salt[7])
+	for _, leak := range []string{"10.0.0.5", saltLine, "scanner"} {
+		if !strings.Contains(plain, leak) {
+			t.Fatalf("the unredacted trace doesn't show %q:\n%s", leak, plain)
+		}
+		if strings.Contains(out, leak) {
+			t.Errorf("%q not masked:\n%s", leak, out)
+		}
+	}
+	if !strings.Contains(out, "10.0.0.x:3306") {
+		t.Errorf("peer not masked:\n%s", out)
+	}
+}
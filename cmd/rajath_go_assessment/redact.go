@@ -0,0 +1,402 @@
+package main
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+/*
+Redaction rules selected with -redact
+*/
+const (
+	// Auth-plugin-data (salts) from the handshakes
+	RedactSalt = "salt"
+	// User names, of the scan's credentials and of audited accounts
+	RedactUsers = "users"
+	// Passwords of the scan's credentials
+	RedactPasswords = "passwords"
+	// Last octet of IPv4 addresses, last 64 bits of IPv6 addresses
+	RedactIPs = "ips"
+)
+
+var redactionRules = map[string]bool{
+	RedactSalt:      true,
+	RedactUsers:     true,
+	RedactPasswords: true,
+	RedactIPs:       true,
+}
+
+const redacted = "<redacted>"
+
+var (
+	ipv4Pattern = regexp.MustCompile(`\b(\d{1,3}\.\d{1,3}\.\d{1,3})\.\d{1,3}\b`)
+	// Candidates only, what net.ParseIP doesn't take, like times, stays
+	ipv6Pattern    = regexp.MustCompile(`[0-9A-Fa-f]{0,4}(?::[0-9A-Fa-f]{0,4}){2,7}`)
+	accountPattern = regexp.MustCompile(`'[^']*'@'`)
+)
+
+/*
+Redactor removes sensitive data from results before they reach any
+output: the text report, -output reports, the result store, the TUI and
+results sent to a coordinator. A nil Redactor changes nothing.
+*/
+type Redactor struct {
+	rules map[string]bool
+	// Known user names and passwords, matched as whole words
+	secrets *regexp.Regexp
+	// HMAC key target IDs and store keys are derived with when IPs are
+	// masked, so they can't be matched against the hashes of the few
+	// addresses a masked one can be
+	idKey []byte
+}
+
+/*
+Environment variable holding the key of the target IDs of -redact ips.
+Without it every run gets a random key, and its IDs can't be joined
+with other runs' or agents'.
+*/
+const redactKeyEnv = "RAJATH_REDACT_KEY"
+
+var redactor *Redactor
+
+/*
+newRedactor parses the comma separated rules given to -redact. The
+users and passwords rules also hide the given credentials wherever
+they appear in free text.
+*/
+func newRedactor(list string, opts ScanOptions) (*Redactor, error) {
+	r := &Redactor{rules: make(map[string]bool)}
+	for _, name := range strings.Split(list, ",") {
+		name = strings.ToLower(strings.TrimSpace(name))
+		if name == "" {
+			continue
+		}
+		if !redactionRules[name] {
+			return nil, fmt.Errorf("Unknown redaction rule %q", name)
+		}
+		r.rules[name] = true
+	}
+	if len(r.rules) == 0 {
+		return nil, nil
+	}
+	if r.rules[RedactIPs] {
+		if key := os.Getenv(redactKeyEnv); key != "" {
+			r.idKey = []byte(key)
+		} else {
+			r.idKey = make([]byte, 32)
+			if _, err := rand.Read(r.idKey); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	credentials := append([]Credential{{User: opts.User, Password: opts.Password}}, opts.Credentials...)
+	for _, password := range opts.FactorPasswords {
+		credentials = append(credentials, Credential{Password: password})
+	}
+	credentials = append(credentials, defaultCredentials...)
+	seen := make(map[string]bool)
+	var words []string
+	for _, c := range credentials {
+		for _, word := range []string{r.pick(RedactUsers, c.User), r.pick(RedactPasswords, c.Password)} {
+			if word != "" && !seen[word] {
+				seen[word] = true
+				words = append(words, word)
+			}
+		}
+	}
+	if len(words) > 0 {
+		// Longest first, so a secret containing another is replaced whole
+		sort.Slice(words, func(i, j int) bool { return len(words[i]) > len(words[j]) })
+		for i, word := range words {
+			words[i] = secretPattern(word)
+		}
+		r.secrets = regexp.MustCompile(strings.Join(words, "|"))
+	}
+	return r, nil
+}
+
+/*
+secretPattern matches the secret as a whole word. Only an end that is a
+word character is held to a word boundary: \b next to punctuation
+would need a word character before "#Passw0rd" or after "s3cr3t!".
+*/
+func secretPattern(secret string) string {
+	pattern := regexp.QuoteMeta(secret)
+	if isWordByte(secret[0]) {
+		pattern = `\b` + pattern
+	}
+	if isWordByte(secret[len(secret)-1]) {
+		pattern += `\b`
+	}
+	return pattern
+}
+
+func isWordByte(b byte) bool {
+	return b == '_' || '0' <= b && b <= '9' || 'a' <= b && b <= 'z' || 'A' <= b && b <= 'Z'
+}
+
+func (r *Redactor) pick(rule, value string) string {
+	if r.rules[rule] {
+		return value
+	}
+	return ""
+}
+
+/*
+text redacts a free text string
+*/
+func (r *Redactor) text(s string) string {
+	if r == nil {
+		return s
+	}
+	if r.rules[RedactIPs] {
+		s = ipv4Pattern.ReplaceAllString(s, "${1}.x")
+		var masked strings.Builder
+		last := 0
+		for _, match := range ipv6Addresses([]byte(s)) {
+			masked.WriteString(s[last:match[0]])
+			masked.WriteString(r.host(s[match[0]:match[1]]))
+			last = match[1]
+		}
+		s = masked.String() + s[last:]
+	}
+	if r.rules[RedactUsers] {
+		s = accountPattern.ReplaceAllString(s, "'"+redacted+"'@'")
+	}
+	if r.secrets != nil {
+		s = r.secrets.ReplaceAllString(s, redacted)
+	}
+	return s
+}
+
+/*
+raw masks the sensitive bytes of what a server sent, a stream starting
+with the greeting, header included: the salts of the greeting, the last
+octet of IPv4 addresses and known user names and passwords. Every byte
+keeps its place, so a masked stream still decodes.
+*/
+func (r *Redactor) raw(data []byte) []byte {
+	if r == nil {
+		return data
+	}
+	masked := append([]byte(nil), data...)
+	if r.rules[RedactSalt] {
+		// The fields found before any decoding error are masked all the same
+		packet := &InitialHandshakePacket{}
+		packet.decodePacket(masked)
+		masked = packet.withoutSalt().raw
+	}
+	return r.maskBytes(masked)
+}
+
+/*
+maskBytes masks the IPv4 addresses, accounts and known secrets in data
+in place, each byte with another
+*/
+func (r *Redactor) maskBytes(masked []byte) []byte {
+	if r.rules[RedactIPs] {
+		for _, match := range ipv4Pattern.FindAllSubmatchIndex(masked, -1) {
+			// The last octet, after the three the pattern keeps
+			fill(masked[match[3]+1:match[1]], 'x')
+		}
+		for _, match := range ipv6Addresses(masked) {
+			maskIPv6(masked[match[0]:match[1]])
+		}
+	}
+	if r.rules[RedactUsers] {
+		for _, match := range accountPattern.FindAllIndex(masked, -1) {
+			fill(masked[match[0]+1:match[1]-3], '*')
+		}
+	}
+	if r.secrets != nil {
+		for _, match := range r.secrets.FindAllIndex(masked, -1) {
+			fill(masked[match[0]:match[1]], '*')
+		}
+	}
+	return masked
+}
+
+/*
+ipv6Addresses returns where the IPv6 addresses in data start and end.
+IPv4-mapped ones are left to the IPv4 pattern.
+*/
+func ipv6Addresses(data []byte) [][2]int {
+	var addresses [][2]int
+	for _, match := range ipv6Pattern.FindAllIndex(data, -1) {
+		// A colon right after the address, as in an error message, isn't part of it
+		end := match[0] + len(bytes.TrimRight(data[match[0]:match[1]], ":"))
+		if end < len(data) && data[end] == '.' {
+			continue
+		}
+		if ip := net.ParseIP(string(data[match[0]:end])); ip != nil && ip.To4() == nil {
+			addresses = append(addresses, [2]int{match[0], end})
+		}
+	}
+	return addresses
+}
+
+/*
+maskIPv6 masks the digits of a textual IPv6 address from its fifth
+group, or from the groups after "::" when it comes first, which may
+mask more than the last 64 bits but never less
+*/
+func maskIPv6(address []byte) {
+	from := len(address)
+	if i := bytes.Index(address, []byte("::")); i >= 0 {
+		from = i + 2
+	}
+	colons := 0
+	for i, c := range address {
+		if c == ':' {
+			colons++
+			if colons == 4 && i+1 < from {
+				from = i + 1
+			}
+		}
+	}
+	for i := from; i < len(address); i++ {
+		if address[i] != ':' {
+			address[i] = 'x'
+		}
+	}
+}
+
+func fill(b []byte, c byte) {
+	for i := range b {
+		b[i] = c
+	}
+}
+
+/*
+host masks an IP address host. Names are left alone.
+*/
+func (r *Redactor) host(host string) string {
+	if r == nil || !r.rules[RedactIPs] {
+		return host
+	}
+	ip := net.ParseIP(host)
+	switch {
+	case ip == nil:
+		return host
+	case ip.To4() != nil:
+		return r.text(host)
+	default:
+		return ip.Mask(net.CIDRMask(64, 128)).String() + "x"
+	}
+}
+
+/*
+target returns the target with its host masked
+*/
+func (r *Redactor) target(t Target) Target {
+	t.Host = r.host(t.Host)
+	return t
+}
+
+/*
+keyed returns the keyed hash of s that stands for it with IPs redacted
+*/
+func (r *Redactor) keyed(s string) string {
+	mac := hmac.New(sha256.New, r.idKey)
+	mac.Write([]byte(s))
+	return hex.EncodeToString(mac.Sum(nil)[:8])
+}
+
+/*
+targetID is the ID of the target in redacted results: with IPs masked
+a keyed hash, as the plain one is easily reversed by hashing every
+address the masked one can be
+*/
+func (r *Redactor) targetID(t Target) string {
+	if r == nil || !r.rules[RedactIPs] {
+		return t.ID()
+	}
+	return r.keyed(t.canonical())
+}
+
+/*
+storeKey is the key a target is kept under in the result store. With
+IPs redacted it is a keyed hash of the target, so hosts that only
+differ in the masked part still get their own entries. The store only
+matches across runs given the same $RAJATH_REDACT_KEY.
+*/
+func (r *Redactor) storeKey(t Target) string {
+	if r == nil || !r.rules[RedactIPs] {
+		return t.Label()
+	}
+	return r.target(t).Label() + " hmac:" + r.keyed(t.Label())
+}
+
+/*
+apply returns a redacted copy of the result, leaving the original as is
+*/
+func (r *Redactor) apply(result *ScanResult) *ScanResult {
+	if r == nil {
+		return result
+	}
+
+	copied := *result
+	// IDs set before, by the agent that scanned the target, stay
+	copied.targetID = result.targetID
+	if copied.targetID == "" {
+		copied.targetID = r.targetID(result.Target)
+	}
+	copied.Target = r.target(result.Target)
+	if result.Err != nil {
+		copied.Err = errors.New(r.text(result.Err.Error()))
+	}
+
+	copied.Findings = make([]Finding, len(result.Findings))
+	for i, f := range result.Findings {
+		f.Title = r.text(f.Title)
+		f.Detail = r.text(f.Detail)
+		copied.Findings[i] = f
+	}
+
+	if result.Processlist != nil {
+		copied.Processlist = make([]ConnectedSource, len(result.Processlist))
+		for i, source := range result.Processlist {
+			source.Address = r.host(source.Address)
+			if r.rules[RedactUsers] {
+				source.Users = []string{redacted}
+			}
+			copied.Processlist[i] = source
+		}
+	}
+
+	if result.Server != nil {
+		server := *result.Server
+		server.Hostname = r.host(server.Hostname)
+		server.Sessions = make([]ProcessEntry, len(result.Server.Sessions))
+		for i, session := range result.Server.Sessions {
+			session.Host = r.text(session.Host)
+			server.Sessions[i] = session
+		}
+		copied.Server = &server
+	}
+
+	if r.rules[RedactSalt] {
+		if result.Handshake != nil {
+			copied.Handshake = result.Handshake.withoutSalt()
+		}
+		copied.Probes = make([]ProbeSample, len(result.Probes))
+		for i, p := range result.Probes {
+			if p.Packet != nil {
+				p.Packet = p.Packet.withoutSalt()
+			}
+			copied.Probes[i] = p
+		}
+	}
+	return &copied
+}
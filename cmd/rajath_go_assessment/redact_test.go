@@ -0,0 +1,127 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestRedactedTargetIDIsKeyed(t *testing.T) {
+	t.Setenv(redactKeyEnv, "")
+	r, err := newRedactor(RedactIPs, ScanOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	target := Target{Host: "10.0.0.5", Port: 3306, Protocol: ProtocolMySQL}
+	result := r.apply(&ScanResult{Target: target})
+
+	// Every address the masked one can be must hash to something else
+	for last := 0; last < 256; last++ {
+		candidate := Target{Host: fmt.Sprintf("10.0.0.%d", last), Port: 3306, Protocol: ProtocolMySQL}
+		if candidate.ID() == result.TargetID() {
+			t.Fatalf("redacted ID %s is the plain ID of %s", result.TargetID(), candidate.Host)
+		}
+	}
+	if result.Target.Host != "10.0.0.x" {
+		t.Errorf("host %q not masked", result.Target.Host)
+	}
+	if again := r.apply(result); again.TargetID() != result.TargetID() {
+		t.Errorf("redacting twice changed the ID from %s to %s", result.TargetID(), again.TargetID())
+	}
+}
+
+func TestRedactedTargetIDWithKey(t *testing.T) {
+	t.Setenv(redactKeyEnv, "shared key")
+	first, _ := newRedactor(RedactIPs, ScanOptions{})
+	second, _ := newRedactor(RedactIPs, ScanOptions{})
+	target := Target{Host: "10.0.0.5", Port: 3306, Protocol: ProtocolMySQL}
+	if first.targetID(target) != second.targetID(target) {
+		t.Error("the same key gave different IDs")
+	}
+	if other := (Target{Host: "10.0.0.6", Port: 3306, Protocol: ProtocolMySQL}); first.targetID(other) == first.targetID(target) {
+		t.Error("targets that only differ in the masked octet got the same ID")
+	}
+	if first.storeKey(target) == first.storeKey(Target{Host: "10.0.0.6", Port: 3306, Protocol: ProtocolMySQL}) {
+		t.Error("targets that only differ in the masked octet got the same store key")
+	}
+}
+
+func TestUnredactedTargetIDIsPlain(t *testing.T) {
+	target := Target{Host: "db1.example.com", Port: 3306, Protocol: ProtocolMySQL}
+	var r *Redactor
+	if got := r.apply(&ScanResult{Target: target}).TargetID(); got != target.ID() {
+		t.Errorf("got %s, want %s", got, target.ID())
+	}
+}
+
+func TestRedactTextIPs(t *testing.T) {
+	r, err := newRedactor(RedactIPs, ScanOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		in, want string
+	}{
+		{"dial tcp 10.0.0.5:3306: connect: connection refused", "dial tcp 10.0.0.x:3306: connect: connection refused"},
+		{"dial tcp [2001:db8:1:2:3:4:5:6]:3306: i/o timeout", "dial tcp [2001:db8:1:2::x]:3306: i/o timeout"},
+		{"connected from 2001:db8::5", "connected from 2001:db8::x"},
+		{"fe80::1: no route to host", "fe80::x: no route to host"},
+		{"::1 is the loopback", "::x is the loopback"},
+		{"mapped ::ffff:10.0.0.5", "mapped ::ffff:10.0.0.x"},
+		// Not addresses
+		{"took 12:34:56", "took 12:34:56"},
+		{"mac 00:1a:2b:3c:4d:5e", "mac 00:1a:2b:3c:4d:5e"},
+		{"8.0.32-log", "8.0.32-log"},
+	}
+	for _, test := range tests {
+		if got := r.text(test.in); got != test.want {
+			t.Errorf("text(%q) = %q, want %q", test.in, got, test.want)
+		}
+	}
+}
+
+func TestRedactRawIPs(t *testing.T) {
+	r, err := newRedactor(RedactIPs, ScanOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		in, want string
+	}{
+		{"\x00host 10.0.0.5\x00", "\x00host 10.0.0.x\x00"},
+		{"\x00host 2001:db8:1:2:3:4:5:6\x00", "\x00host 2001:db8:1:2:x:x:x:x\x00"},
+		{"\x002001:db8::abcd:5]", "\x002001:db8::xxxx:x]"},
+		{"\x00fe80::1:", "\x00fe80::x:"},
+		{"12:34:56", "12:34:56"},
+	}
+	for _, test := range tests {
+		got := r.raw([]byte(test.in))
+		if string(got) != test.want {
+			t.Errorf("raw(%q) = %q, want %q", test.in, got, test.want)
+		}
+		if len(got) != len(test.in) {
+			t.Errorf("raw(%q) changed the length to %d", test.in, len(got))
+		}
+	}
+}
+
+func TestRedactPunctuatedPasswords(t *testing.T) {
+	opts := ScanOptions{Credentials: []Credential{{User: "app", Password: "s3cr3t!"}, {User: "ops", Password: "#Passw0rd"}, {User: "x", Password: "!!!"}}}
+	r, err := newRedactor(RedactPasswords, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, password := range []string{"s3cr3t!", "#Passw0rd", "!!!"} {
+		in := "denied with '" + password + "' here"
+		if got := r.text(in); strings.Contains(got, password) {
+			t.Errorf("text(%q) = %q", in, got)
+		}
+		if got := r.raw([]byte("\x00" + password + "\x00")); strings.Contains(string(got), password) {
+			t.Errorf("raw kept %q: %q", password, got)
+		}
+	}
+	// Word ends are still held to word boundaries
+	if got := r.text("as3cr3t!"); got != "as3cr3t!" {
+		t.Errorf("masked inside a word: %q", got)
+	}
+}
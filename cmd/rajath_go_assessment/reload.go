@@ -0,0 +1,96 @@
+package main
+
+import (
+	"log"
+	"os"
+	"reflect"
+	"time"
+)
+
+/*
+fileStamp is what tells a file changed between two looks at it
+*/
+type fileStamp struct {
+	modTime time.Time
+	size    int64
+}
+
+func stampFile(path string) fileStamp {
+	info, err := os.Stat(path)
+	if err != nil {
+		// A missing file is a change too, which the reload then reports
+		return fileStamp{}
+	}
+	return fileStamp{modTime: info.ModTime(), size: info.Size()}
+}
+
+/*
+reloader re-reads the configuration of a -watch scan, on SIGHUP or when
+one of its files changes: the targets, the -expect inventory, the
+-credentials, -custom-checks and -enrich. It is applied between rounds
+only, so probes in flight finish with the configuration they started with.
+*/
+type reloader struct {
+	stamps map[string]fileStamp
+	// Reads the configuration again, from scratch
+	load func() ([]Target, ScanOptions, error)
+}
+
+/*
+newReloader watches the given files, skipping unset ones and stdin
+*/
+func newReloader(paths []string, load func() ([]Target, ScanOptions, error)) *reloader {
+	r := &reloader{stamps: make(map[string]fileStamp), load: load}
+	for _, path := range paths {
+		if path != "" && path != "-" {
+			r.stamps[path] = stampFile(path)
+		}
+	}
+	return r
+}
+
+/*
+changed tells whether a file changed since the last look
+*/
+func (r *reloader) changed() bool {
+	changed := false
+	for path, stamp := range r.stamps {
+		if current := stampFile(path); current != stamp {
+			r.stamps[path] = current
+			changed = true
+		}
+	}
+	return changed
+}
+
+/*
+reload returns the configuration read again, keeping what the rounds
+carry over: the session pool and the restart and SLO trackers. Pooled
+sessions are closed when the credentials changed, so the checks log in
+again and a revoked password shows. When the configuration can't be
+read, a file being half written say, the current one is kept.
+*/
+func (r *reloader) reload(targets []Target, opts ScanOptions) ([]Target, ScanOptions) {
+	r.changed()
+	next, nextOpts, err := r.load()
+	if err != nil {
+		log.Printf("Keeping the previous configuration: %s\n", err.Error())
+		return targets, opts
+	}
+	nextOpts.Pool = opts.Pool
+	if nextOpts.Pool != nil && !sameCredentials(opts, nextOpts) {
+		nextOpts.Pool.closeAll()
+	}
+	nextOpts.Restarts = opts.Restarts
+	nextOpts.LatencySLO = opts.LatencySLO
+	log.Printf("Reloaded the configuration, %d targets\n", len(next))
+	return next, nextOpts
+}
+
+/*
+sameCredentials tells whether the scans log in with the same accounts
+*/
+func sameCredentials(a, b ScanOptions) bool {
+	return a.User == b.User && a.Password == b.Password &&
+		reflect.DeepEqual(a.FactorPasswords, b.FactorPasswords) && reflect.DeepEqual(a.Credentials, b.Credentials)
+}
@@ -0,0 +1,36 @@
+package main
+
+import "testing"
+
+func TestReloadClosesSessionsOfOldCredentials(t *testing.T) {
+	_, network := useFakes(t)
+	target := Target{Host: "reload-pool", Port: 3306, Protocol: ProtocolMySQL}
+	server := &mockAuthServer{greeting: greetingBytes(t), replies: [][]byte{okPayload()}}
+	network.Serve(target.Address(), server.serve)
+
+	pool := newSessionPool(defaultPoolMaxIdle, defaultPoolIdleTimeout)
+	session, err := pool.get(target, "auditor", "old")
+	if err != nil {
+		t.Fatal(err)
+	}
+	pool.put(session)
+	opts := ScanOptions{User: "auditor", Password: "old", Pool: pool}
+
+	for _, password := range []string{"old", "rotated"} {
+		r := newReloader(nil, func() ([]Target, ScanOptions, error) {
+			next := opts
+			next.Pool, next.Password = nil, password
+			return []Target{target}, next, nil
+		})
+		_, nextOpts := r.reload([]Target{target}, opts)
+		if nextOpts.Pool != pool {
+			t.Fatal("the pool wasn't carried over")
+		}
+		pool.mu.Lock()
+		idle := len(pool.idle[poolKey{target: target, user: "auditor"}])
+		pool.mu.Unlock()
+		if want := map[string]int{"old": 1, "rotated": 0}[password]; idle != want {
+			t.Errorf("reloading with password %q left %d idle sessions, want %d", password, idle, want)
+		}
+	}
+}
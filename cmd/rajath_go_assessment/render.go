@@ -0,0 +1,53 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"strings"
+)
+
+/*
+Escape sequence moving the cursor home and clearing the terminal
+*/
+const clearScreen = "\033[H\033[2J"
+
+/*
+renderer prints the decoration around the text output: the rules
+between targets and the interactive mode redrawing its table in place.
+With -plain there is none of it, for screen readers, braille displays
+and logs: a blank line separates targets and the interactive mode
+prints each result as a line once it is scanned.
+*/
+type renderer struct {
+	plain bool
+}
+
+var render = &renderer{}
+
+/*
+separator ends the previous block of output and separates the next one
+*/
+func (r *renderer) separator(w io.Writer) {
+	if r.plain {
+		fmt.Fprint(w, "\n\n")
+		return
+	}
+	fmt.Fprintln(w, strings.Repeat("-", 70))
+}
+
+/*
+clear starts a redraw of the whole screen, or nothing in plain mode
+*/
+func (r *renderer) clear(w io.Writer) {
+	if !r.plain {
+		fmt.Fprint(w, clearScreen)
+	}
+}
+
+/*
+animated tells whether screens are redrawn as results come in, rather
+than results added as lines
+*/
+func (r *renderer) animated() bool {
+	return !r.plain
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+)
+
+/*
+Uptime is reported in whole seconds and read a moment after the
+handshake, so it may lag what we expect by this much without a restart
+*/
+const uptimeSlack = 2 * time.Second
+
+/*
+serverLife is what watch mode remembers about a target between rounds
+*/
+type serverLife struct {
+	// Last round the server answered
+	upAt         time.Time
+	connectionID uint32
+	uptime       time.Duration
+	uptimeKnown  bool
+	// First round it didn't answer since then, zero if none
+	downAt time.Time
+}
+
+/*
+restartTracker notices servers restarting between watch rounds, from
+their connection IDs starting over or, when -statistics collects it,
+their uptime
+*/
+type restartTracker struct {
+	mu    sync.Mutex
+	lives map[string]*serverLife
+}
+
+func newRestartTracker() *restartTracker {
+	return &restartTracker{lives: make(map[string]*serverLife)}
+}
+
+/*
+observe records the result and returns a finding when the server
+restarted since the previous round
+*/
+func (t *restartTracker) observe(result *ScanResult) []Finding {
+	if t == nil || result.Target.Protocol != ProtocolMySQL {
+		return nil
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	now := clock.Now()
+	address := result.Target.Address()
+	life, known := t.lives[address]
+	if result.Handshake == nil {
+		if known {
+			if life.downAt.IsZero() {
+				life.downAt = now
+			}
+		}
+		return nil
+	}
+
+	current := &serverLife{upAt: now, connectionID: result.Handshake.ConnectionId}
+	if result.Statistics != nil {
+		current.uptime, current.uptimeKnown = result.Statistics.Uptime, true
+	}
+	t.lives[address] = current
+	if !known {
+		return nil
+	}
+
+	var reasons []string
+	if current.connectionID < life.connectionID {
+		reasons = append(reasons, fmt.Sprintf("connection ID went from %d to %d", life.connectionID, current.connectionID))
+	}
+	since := now.Sub(life.upAt)
+	if current.uptimeKnown && current.uptime+uptimeSlack < since {
+		reasons = append(reasons, fmt.Sprintf("uptime is %s", current.uptime))
+	}
+	if len(reasons) == 0 {
+		return nil
+	}
+
+	return []Finding{{
+		RuleID:   "MYSQL-SERVER-RESTARTED",
+		Severity: SeverityMedium,
+		Title:    "Server restarted since the previous round",
+		Detail:   strings.Join(reasons, ", ") + "; " + life.downtime(current, since),
+	}}
+}
+
+/*
+downtime estimates how long the server was gone. The uptime tells when
+it came back, failed rounds tell how long it was seen down.
+*/
+func (l *serverLife) downtime(current *serverLife, since time.Duration) string {
+	upper := since
+	if current.uptimeKnown {
+		upper -= current.uptime
+	}
+	estimate := fmt.Sprintf("down for at most %s since last seen up at %s", upper.Round(time.Second), l.upAt.Format(time.RFC3339))
+	if !l.downAt.IsZero() {
+		estimate += fmt.Sprintf(", unreachable since %s", l.downAt.Format(time.RFC3339))
+	}
+	return estimate
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"errors"
+	"net"
+	"time"
+)
+
+/*
+Roles a port can play on a MySQL 8 host
+*/
+const (
+	RoleClient           = ""
+	RoleAdmin            = "admin"
+	RoleGroupReplication = "group_replication"
+)
+
+/*
+Group replication (XCom) peers wait for the connecting side to speak
+first, so a port that stays silent this long is treated as XCom
+*/
+const xcomBannerWait = 2 * time.Second
+
+/*
+StatusXCom is reported for an open port that sent no greeting, as
+expected from the group replication communication engine
+*/
+const StatusXCom = "xcom"
+
+/*
+withRolePorts adds the admin and group replication ports of every
+distinct host in targets, when those ports are given
+*/
+func withRolePorts(targets []Target, adminPort, groupReplicationPort int) []Target {
+	seen := make(map[string]bool)
+	var extra []Target
+
+	for _, t := range targets {
+		if t.Pipe != "" || seen[t.Host] {
+			continue
+		}
+		seen[t.Host] = true
+
+		if adminPort > 0 {
+			extra = append(extra, Target{Host: t.Host, Port: adminPort, Protocol: ProtocolMySQL, Role: RoleAdmin})
+		}
+		if groupReplicationPort > 0 {
+			extra = append(extra, Target{Host: t.Host, Port: groupReplicationPort, Protocol: ProtocolMySQL, Role: RoleGroupReplication})
+		}
+	}
+
+	return append(targets, extra...)
+}
+
+/*
+scanGroupReplication classifies a group replication port. XCom stays
+silent after accept, while a classic handshake means the port is
+really a MySQL client listener.
+*/
+func scanGroupReplication(target Target) *ScanResult {
+	result := &ScanResult{Target: target, Status: StatusScanning}
+	if err := saturation.allow(target.Host); err != nil {
+		result.Status = StatusError
+		result.Err = err
+		return result
+	}
+
+	start := clock.Now()
+	conn, err := dialTarget(target)
+	if err != nil {
+		result.Status = StatusClosed
+		result.Err = err
+		return result
+	}
+	defer conn.Close()
+
+	packet := &InitialHandshakePacket{}
+	err = packet.decodeWithin(conn, xcomBannerWait, xcomBannerWait)
+	result.Latency = clock.Now().Sub(start)
+
+	var netErr net.Error
+	switch {
+	case err == nil:
+		result.Status = StatusMySQL
+		result.Handshake = packet
+	case errors.As(err, &netErr) && netErr.Timeout():
+		result.Status = StatusXCom
+	default:
+		result.Status = StatusError
+		result.Err = err
+	}
+
+	result.Findings = roleFindings(result)
+	return result
+}
+
+/*
+roleFindings reports admin and group replication ports reachable from
+the scanner, as both are meant to be reachable only from trusted hosts
+*/
+func roleFindings(result *ScanResult) []Finding {
+	if result.Failed() {
+		return nil
+	}
+
+	switch result.Target.Role {
+	case RoleAdmin:
+		return []Finding{{
+			RuleID:   "MYSQL-ADMIN-PORT-EXPOSED",
+			Severity: SeverityMedium,
+			Title:    "Administrative connection interface is reachable",
+			Detail:   "admin_port accepts connections even when max_connections is reached",
+		}}
+	case RoleGroupReplication:
+		return []Finding{{
+			RuleID:   "MYSQL-GR-PORT-EXPOSED",
+			Severity: SeverityMedium,
+			Title:    "Group replication port is reachable",
+			Detail:   "port answered as " + result.Status,
+		}}
+	}
+	return nil
+}
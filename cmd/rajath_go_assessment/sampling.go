@@ -0,0 +1,205 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"math/rand"
+	"sort"
+	"strings"
+	"time"
+)
+
+/*
+Jitter applied to the sampling interval, as a fraction of the interval,
+so repeated probes don't line up with a balancer's round-robin period
+*/
+const sampleJitter = 0.2
+
+/*
+ProbeSample is the outcome of a single probe of a target
+*/
+type ProbeSample struct {
+	At      time.Time
+	Latency time.Duration
+	Packet  *InitialHandshakePacket
+	DialErr bool
+	Err     error
+	// SHA-256 of the server certificate, when TLS was probed
+	CertSHA256 string
+}
+
+/*
+Stability summarises repeated probes of the same target
+*/
+type Stability struct {
+	Attempts      int
+	Successes     int
+	Versions      []string
+	LatencyMean   time.Duration
+	LatencyStdDev time.Duration
+}
+
+/*
+SuccessRate returns the fraction of probes that decoded a handshake
+*/
+func (s Stability) SuccessRate() float64 {
+	if s.Attempts == 0 {
+		return 0
+	}
+	return float64(s.Successes) / float64(s.Attempts)
+}
+
+func (s Stability) String() string {
+	return fmt.Sprintf("%d/%d probes succeeded, versions: %s, latency mean %s stddev %s",
+		s.Successes, s.Attempts, strings.Join(s.Versions, ", "),
+		s.LatencyMean.Round(time.Microsecond), s.LatencyStdDev.Round(time.Microsecond))
+}
+
+/*
+probe makes a single connection to the target and records the outcome
+*/
+func probe(target Target, opts ScanOptions) ProbeSample {
+	start := clock.Now()
+	conn, packet, dialErr, err := openHandshake(target)
+	sample := ProbeSample{
+		At:      start,
+		Latency: clock.Now().Sub(start),
+		Packet:  packet,
+		DialErr: dialErr,
+		Err:     err,
+	}
+	if err != nil {
+		return sample
+	}
+	defer conn.Close()
+
+	switch {
+	case target.TLS == TLSRequired:
+		tlsConn, err := upgradeTLS(conn, target, packet)
+		if err != nil {
+			sample.Err = fmt.Errorf("TLS is required for the target: %s", err.Error())
+			return sample
+		}
+		sample.CertSHA256 = certFingerprint(tlsConn)
+	case target.TLS == TLSDisabled:
+	case (opts.TLSCert || target.TLS == TLSPreferred) && packet.CapabilitiesFlags.Has(clientSSL):
+		// A failed upgrade doesn't make the handshake itself any less valid
+		if tlsConn, err := upgradeTLS(conn, target, packet); err == nil {
+			sample.CertSHA256 = certFingerprint(tlsConn)
+		}
+	}
+	return sample
+}
+
+/*
+jittered returns interval adjusted by up to sampleJitter in either direction
+*/
+func jittered(interval time.Duration) time.Duration {
+	if interval <= 0 {
+		return 0
+	}
+	spread := float64(interval) * sampleJitter
+	return interval + time.Duration((rand.Float64()*2-1)*spread)
+}
+
+/*
+probeRepeatedly probes the target opts.Samples times, or once when
+sampling is disabled
+*/
+func probeRepeatedly(target Target, opts ScanOptions) []ProbeSample {
+	count := opts.Samples
+	if count < 1 {
+		count = 1
+	}
+
+	probes := make([]ProbeSample, 0, count)
+	for i := 0; i < count; i++ {
+		if i > 0 {
+			clock.Sleep(jittered(opts.SampleInterval))
+		}
+		p := probe(target, opts)
+		probes = append(probes, p)
+		if errors.Is(p.Err, errHostPaused) {
+			break
+		}
+	}
+	return probes
+}
+
+/*
+stability computes statistics over the probes
+*/
+func stability(probes []ProbeSample) Stability {
+	s := Stability{Attempts: len(probes)}
+
+	versions := make(map[string]bool)
+	var latencies []float64
+	for _, p := range probes {
+		if p.Err != nil {
+			continue
+		}
+		s.Successes++
+		versions[string(p.Packet.ServerVersion)] = true
+		latencies = append(latencies, float64(p.Latency))
+	}
+
+	for version := range versions {
+		s.Versions = append(s.Versions, version)
+	}
+	sort.Strings(s.Versions)
+
+	if len(latencies) == 0 {
+		return s
+	}
+
+	mean := 0.0
+	for _, l := range latencies {
+		mean += l
+	}
+	mean /= float64(len(latencies))
+
+	variance := 0.0
+	for _, l := range latencies {
+		variance += (l - mean) * (l - mean)
+	}
+	variance /= float64(len(latencies))
+
+	s.LatencyMean = time.Duration(mean)
+	s.LatencyStdDev = time.Duration(math.Sqrt(variance))
+	return s
+}
+
+func init() {
+	registerCheck(Check{
+		ID:          "MYSQL-FLAPPING",
+		Description: "Flags targets whose repeated probes fail intermittently or return different versions",
+		Tier:        TierPassive,
+		Run:         checkFlapping,
+	})
+}
+
+func checkFlapping(ctx *CheckContext) []Finding {
+	if len(ctx.Probes) < 2 {
+		return nil
+	}
+
+	s := stability(ctx.Probes)
+	var reasons []string
+	if s.Successes < s.Attempts {
+		reasons = append(reasons, fmt.Sprintf("%d of %d probes failed", s.Attempts-s.Successes, s.Attempts))
+	}
+	if len(s.Versions) > 1 {
+		reasons = append(reasons, fmt.Sprintf("versions changed between probes: %s", strings.Join(s.Versions, ", ")))
+	}
+	if len(reasons) == 0 {
+		return nil
+	}
+
+	return []Finding{{
+		RuleID:   "MYSQL-FLAPPING",
+		Severity: SeverityLow,
+		Title:    "Target is unstable across repeated probes",
+		Detail:   strings.Join(reasons, "; "),
+	}}
+}
@@ -0,0 +1,228 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"time"
+)
+
+const (
+	sarifSchema  = "https://json.schemastore.org/sarif-2.1.0.json"
+	sarifVersion = "2.1.0"
+	toolName     = "rajath_go_assessment"
+	toolURI      = "https://github.com/AvRajath/rajath-go-assessment"
+)
+
+/*
+SARIF only knows error, warning and note. security-severity is the
+CVSS-like score GitHub code scanning uses to rank alerts.
+*/
+var sarifLevels = map[Severity]string{
+	SeverityInfo:     "note",
+	SeverityLow:      "note",
+	SeverityMedium:   "warning",
+	SeverityHigh:     "error",
+	SeverityCritical: "error",
+}
+
+var sarifSecuritySeverity = map[Severity]string{
+	SeverityInfo:     "0.0",
+	SeverityLow:      "3.0",
+	SeverityMedium:   "5.5",
+	SeverityHigh:     "8.0",
+	SeverityCritical: "9.5",
+}
+
+type sarifLog struct {
+	Schema  string     `json:"$schema"`
+	Version string     `json:"version"`
+	Runs    []sarifRun `json:"runs"`
+}
+
+type sarifRun struct {
+	Tool              sarifTool              `json:"tool"`
+	AutomationDetails sarifAutomationDetails `json:"automationDetails"`
+	Invocations       []sarifInvocation      `json:"invocations"`
+	Results           []sarifResult          `json:"results"`
+}
+
+/*
+Identifies the run as tool/run ID, the run ID of the JSON records
+*/
+type sarifAutomationDetails struct {
+	ID string `json:"id"`
+}
+
+type sarifTool struct {
+	Driver sarifDriver `json:"driver"`
+}
+
+type sarifDriver struct {
+	Name           string      `json:"name"`
+	Version        string      `json:"version"`
+	InformationURI string      `json:"informationUri"`
+	Rules          []sarifRule `json:"rules"`
+}
+
+type sarifRule struct {
+	ID                   string              `json:"id"`
+	ShortDescription     sarifMessage        `json:"shortDescription"`
+	DefaultConfiguration sarifConfiguration  `json:"defaultConfiguration"`
+	Properties           sarifRuleProperties `json:"properties"`
+}
+
+type sarifConfiguration struct {
+	Level string `json:"level"`
+}
+
+type sarifRuleProperties struct {
+	SecuritySeverity string   `json:"security-severity"`
+	Tags             []string `json:"tags"`
+}
+
+type sarifInvocation struct {
+	ExecutionSuccessful        bool                `json:"executionSuccessful"`
+	StartTimeUTC               *time.Time          `json:"startTimeUtc,omitempty"`
+	EndTimeUTC                 *time.Time          `json:"endTimeUtc,omitempty"`
+	ToolExecutionNotifications []sarifNotification `json:"toolExecutionNotifications,omitempty"`
+}
+
+type sarifNotification struct {
+	Level     string          `json:"level"`
+	Message   sarifMessage    `json:"message"`
+	Locations []sarifLocation `json:"locations"`
+}
+
+type sarifResult struct {
+	RuleID     string                 `json:"ruleId"`
+	RuleIndex  int                    `json:"ruleIndex"`
+	Level      string                 `json:"level"`
+	Message    sarifMessage           `json:"message"`
+	Locations  []sarifLocation        `json:"locations"`
+	Properties *sarifResultProperties `json:"properties,omitempty"`
+}
+
+type sarifResultProperties struct {
+	TargetID string `json:"targetId"`
+	ScanTags Tags   `json:"scanTags,omitempty"`
+}
+
+type sarifMessage struct {
+	Text string `json:"text"`
+}
+
+type sarifLocation struct {
+	PhysicalLocation sarifPhysicalLocation  `json:"physicalLocation"`
+	LogicalLocations []sarifLogicalLocation `json:"logicalLocations"`
+}
+
+type sarifPhysicalLocation struct {
+	ArtifactLocation sarifArtifactLocation `json:"artifactLocation"`
+}
+
+type sarifArtifactLocation struct {
+	URI string `json:"uri"`
+}
+
+type sarifLogicalLocation struct {
+	Name               string `json:"name"`
+	FullyQualifiedName string `json:"fullyQualifiedName"`
+	Kind               string `json:"kind"`
+}
+
+/*
+sarifTargetLocation locates a finding on a network endpoint rather than
+a file, as a URI like mysql://db1:3306
+*/
+func sarifTargetLocation(target Target) sarifLocation {
+	return sarifLocation{
+		PhysicalLocation: sarifPhysicalLocation{
+			ArtifactLocation: sarifArtifactLocation{URI: target.Protocol + "://" + target.Address()},
+		},
+		LogicalLocations: []sarifLogicalLocation{{
+			Name:               target.Address(),
+			FullyQualifiedName: target.Label(),
+			Kind:               "resource",
+		}},
+	}
+}
+
+/*
+writeSARIF writes the findings as a SARIF 2.1.0 log with one run. Rules
+are made from the findings themselves, using the first title seen for
+each rule ID. Targets that couldn't be scanned also become notifications.
+*/
+func writeSARIF(w io.Writer, results []*ScanResult) error {
+	run := sarifRun{
+		Tool: sarifTool{Driver: sarifDriver{
+			Name:           toolName,
+			Version:        version,
+			InformationURI: toolURI,
+			Rules:          []sarifRule{},
+		}},
+		AutomationDetails: sarifAutomationDetails{ID: toolName + "/" + runID},
+		Results:           []sarifResult{},
+	}
+	invocation := sarifInvocation{ExecutionSuccessful: true}
+
+	ruleIndex := make(map[string]int)
+	for _, result := range results {
+		// The invocation spans the scans of all the targets
+		if started := result.StartedAt; !started.IsZero() && (invocation.StartTimeUTC == nil || started.Before(*invocation.StartTimeUTC)) {
+			invocation.StartTimeUTC = &started
+		}
+		if ended := result.EndedAt; !ended.IsZero() && (invocation.EndTimeUTC == nil || ended.After(*invocation.EndTimeUTC)) {
+			invocation.EndTimeUTC = &ended
+		}
+		if result.Failed() {
+			invocation.ToolExecutionNotifications = append(invocation.ToolExecutionNotifications, sarifNotification{
+				Level:     "error",
+				Message:   sarifMessage{Text: fmt.Sprintf("%s %s: %v", result.Target, result.Status, result.Err)},
+				Locations: []sarifLocation{sarifTargetLocation(result.Target)},
+			})
+		}
+
+		for _, finding := range result.Findings {
+			index, ok := ruleIndex[finding.RuleID]
+			if !ok {
+				index = len(run.Tool.Driver.Rules)
+				ruleIndex[finding.RuleID] = index
+				run.Tool.Driver.Rules = append(run.Tool.Driver.Rules, sarifRule{
+					ID:                   finding.RuleID,
+					ShortDescription:     sarifMessage{Text: finding.Title},
+					DefaultConfiguration: sarifConfiguration{Level: sarifLevels[finding.Severity]},
+					Properties: sarifRuleProperties{
+						SecuritySeverity: sarifSecuritySeverity[finding.Severity],
+						Tags:             []string{"security", "mysql"},
+					},
+				})
+			}
+
+			message := finding.Title
+			if finding.Detail != "" {
+				message += ": " + finding.Detail
+			}
+			run.Results = append(run.Results, sarifResult{
+				RuleID:     finding.RuleID,
+				RuleIndex:  index,
+				Level:      sarifLevels[finding.Severity],
+				Message:    sarifMessage{Text: fmt.Sprintf("%s on %s", message, result.Target.Label())},
+				Locations:  []sarifLocation{sarifTargetLocation(result.Target)},
+				Properties: &sarifResultProperties{TargetID: result.TargetID(), ScanTags: result.Tags},
+			})
+		}
+	}
+
+	// Keep the output stable whatever order the targets finished in
+	sort.SliceStable(run.Results, func(i, j int) bool {
+		return run.Results[i].Locations[0].PhysicalLocation.ArtifactLocation.URI <
+			run.Results[j].Locations[0].PhysicalLocation.ArtifactLocation.URI
+	})
+	run.Invocations = []sarifInvocation{invocation}
+
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(sarifLog{Schema: sarifSchema, Version: sarifVersion, Runs: []sarifRun{run}})
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+/*
+ER_CON_COUNT_ERROR, sent instead of the greeting when the server has
+no connection slots left
+*/
+const erConCountError = 1040
+
+/*
+How long a host that reported 1040 is left alone by default
+*/
+const defaultSaturationPause = time.Minute
+
+/*
+errHostPaused is returned instead of connecting to a paused host
+*/
+var errHostPaused = errors.New("Probing paused, host is at its connection limit")
+
+/*
+saturationGuard stops the scanner from connecting to hosts that told us
+they are out of connections, so we never take a slot a real client needs
+*/
+type saturationGuard struct {
+	mu          sync.Mutex
+	pause       time.Duration
+	pausedUntil map[string]time.Time
+}
+
+var saturation = &saturationGuard{
+	pause:       defaultSaturationPause,
+	pausedUntil: make(map[string]time.Time),
+}
+
+/*
+allow returns errHostPaused while the host is paused
+*/
+func (g *saturationGuard) allow(host string) error {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	until, ok := g.pausedUntil[host]
+	if !ok {
+		return nil
+	}
+	if clock.Now().After(until) {
+		delete(g.pausedUntil, host)
+		return nil
+	}
+	return fmt.Errorf("%w until %s", errHostPaused, until.Format(time.RFC3339))
+}
+
+/*
+observe pauses the host when err says it ran out of connections
+*/
+func (g *saturationGuard) observe(host string, err error) {
+	var serverErr *ServerError
+	if !errors.As(err, &serverErr) || serverErr.Code != erConCountError {
+		return
+	}
+
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.pausedUntil[host] = clock.Now().Add(g.pause)
+}
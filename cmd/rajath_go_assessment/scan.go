@@ -0,0 +1,571 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+/*
+Protocols a target can be probed with
+*/
+const (
+	ProtocolMySQL  = "mysql"
+	ProtocolMySQLX = "mysqlx"
+)
+
+var protocols = map[string]bool{
+	ProtocolMySQL:  true,
+	ProtocolMySQLX: true,
+}
+
+/*
+Target represents a single host and port to be scanned, or a local
+named pipe
+*/
+type Target struct {
+	Host     string
+	Port     int
+	Protocol string
+	// What the port is expected to be used for, empty for the client port
+	Role string
+	// Named pipe path, used instead of Host and Port when set
+	Pipe string
+	// TLS mode from the targets file, empty to follow -tls-cert
+	TLS string
+}
+
+/*
+Address returns the dialable host:port form of the target, or its
+named pipe path
+*/
+func (t Target) Address() string {
+	if t.Pipe != "" {
+		return t.Pipe
+	}
+	return net.JoinHostPort(t.Host, strconv.Itoa(t.Port))
+}
+
+/*
+Label returns the address, followed by the role of the port when it
+isn't the client port
+*/
+func (t Target) Label() string {
+	if t.Role == RoleClient {
+		return t.Address()
+	}
+	return fmt.Sprintf("%s (%s)", t.Address(), t.Role)
+}
+
+func (t Target) String() string {
+	return t.Address()
+}
+
+/*
+ID identifies the target across runs, for results to be joined on: a
+hash of its protocol and normalized address, so that the case of a
+host name, a trailing dot or the spelling of an IPv6 address don't
+change it, nor what the name resolves to
+*/
+func (t Target) ID() string {
+	sum := sha256.Sum256([]byte(t.canonical()))
+	return hex.EncodeToString(sum[:8])
+}
+
+/*
+normalizeHost lowercases a host name and drops its trailing dot, and
+spells IP addresses the one way
+*/
+func normalizeHost(host string) string {
+	host = strings.TrimSuffix(strings.ToLower(host), ".")
+	if ip := net.ParseIP(host); ip != nil {
+		host = ip.String()
+	}
+	return host
+}
+
+/*
+canonical is the normalized protocol and address the ID is a hash of
+*/
+func (t Target) canonical() string {
+	address := strings.ToLower(t.Pipe)
+	if t.Pipe == "" {
+		address = net.JoinHostPort(normalizeHost(t.Host), strconv.Itoa(t.Port))
+	}
+	protocol := t.Protocol
+	if protocol == "" {
+		protocol = ProtocolMySQL
+	}
+	return protocol + "://" + address
+}
+
+/*
+Scan statuses reported for a target
+*/
+const (
+	StatusPending  = "pending"
+	StatusScanning = "scanning"
+	StatusMySQL    = "mysql"
+	StatusMySQLX   = "mysqlx"
+	StatusClosed   = "closed"
+	StatusError    = "error"
+	// Accepted the connection but never finished a greeting
+	StatusTarpit = "tarpit"
+	// Accepted by a SYN proxy in front of a closed port
+	StatusFiltered = "filtered"
+)
+
+/*
+ScanResult holds everything learnt about a single target
+*/
+type ScanResult struct {
+	Target        Target
+	Status        string
+	Handshake     *InitialHandshakePacket
+	XCapabilities *XCapabilities
+	Err           error
+	Latency       time.Duration
+	Findings      []Finding
+	Probes        []ProbeSample
+	// Collected with credentials when asked for
+	Statistics *ServerStatistics
+	ClockSkew  *ClockSkew
+	Schemas    []SchemaInventory
+	// Other ports of the server and its clone donors, from its variables
+	Listeners []Listener
+	// Addresses sessions were connected from, sampled with -processlist
+	Processlist []ConnectedSource
+	// Server the session's statements ran on, a proxy's backend
+	Server *SessionServer
+	// IDs of the checks run against the target, in order
+	ChecksRun []string
+	// Tags given to the scan
+	Tags Tags
+	// Bytes exchanged with the target during the scan
+	Traffic *Traffic
+	// When the target was actually probed, for results served from the banner cache
+	CachedAt time.Time
+	// When the scan of the target started and ended, in UTC
+	StartedAt time.Time
+	EndedAt   time.Time
+
+	// ID of the target, kept when redaction masks its host
+	targetID string
+}
+
+/*
+TargetID returns the stable ID of the scanned target
+*/
+func (r *ScanResult) TargetID() string {
+	if r.targetID != "" {
+		return r.targetID
+	}
+	return r.Target.ID()
+}
+
+/*
+Failed tells whether the scan got nothing to check from the target
+*/
+func (r *ScanResult) Failed() bool {
+	return r.Status == StatusClosed || r.Status == StatusError || r.Status == StatusTarpit || r.Status == StatusFiltered
+}
+
+/*
+Version returns the advertised server version, or an empty string when
+no handshake was decoded
+*/
+func (r *ScanResult) Version() string {
+	if r.Handshake == nil {
+		return ""
+	}
+	return string(r.Handshake.ServerVersion)
+}
+
+/*
+parseTargets accepts either the legacy "hostname port_number" pair or
+a list of host:port or named pipe (\\.\pipe\MySQL, pipe:MySQL)
+arguments, all probed with the given protocol
+*/
+func parseTargets(args []string, protocol string) ([]Target, error) {
+	if !protocols[protocol] {
+		return nil, fmt.Errorf("Unknown protocol %q", protocol)
+	}
+
+	if _, pipe := parsePipe(args[0]); len(args) == 2 && !pipe && !strings.Contains(args[1], ":") {
+		port, err := strconv.Atoi(args[1])
+		if err != nil {
+			return nil, fmt.Errorf("Invalid port number %q", args[1])
+		}
+		return []Target{{Host: args[0], Port: port, Protocol: protocol}}, nil
+	}
+
+	if len(args) == 0 {
+		return nil, errors.New("No targets given")
+	}
+
+	var targets []Target
+	for _, arg := range args {
+		if pipe, ok := parsePipe(arg); ok {
+			targets = append(targets, Target{Host: ".", Pipe: pipe, Protocol: protocol})
+			continue
+		}
+		host, p, err := net.SplitHostPort(arg)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid target %q: %s", arg, err.Error())
+		}
+		port, err := strconv.Atoi(p)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid port number in target %q", arg)
+		}
+		targets = append(targets, Target{Host: host, Port: port, Protocol: protocol})
+	}
+
+	return targets, nil
+}
+
+/*
+ScanOptions controls how targets are probed
+*/
+type ScanOptions struct {
+	// Number of extra handshakes collected for checks comparing connections
+	HandshakeSamples int
+	// Pause before each extra handshake
+	HandshakeInterval time.Duration
+	// Number of times each target is probed for stability statistics
+	Samples int
+	// Pause between probes, jittered
+	SampleInterval time.Duration
+	// Upgrade to TLS when offered and record the certificate fingerprint
+	TLSCert bool
+	// Highest tier of checks allowed to run
+	Tier Tier
+	// Credentials tried by the default credentials check, nil for the built-in list
+	Credentials []Credential
+	// Pause between login attempts against the same target
+	LoginDelay time.Duration
+	// Failed logins made to test for throttling, 0 disables the check
+	ThrottleAttempts int
+	// Credentials used by credentialed checks, which are skipped without a user
+	User     string
+	Password string
+	// Passwords of the second and third factors of a multi-factor account
+	FactorPasswords []string
+	// How credentialed checks run statements: raw or sql
+	QueryDriver string
+	// Keeps sessions between watch rounds, nil outside of watch mode
+	Pool *sessionPool
+	// Notices restarts between watch rounds, nil outside of watch mode
+	Restarts *restartTracker
+	// Reports handshake latency over its SLO, nil outside of watch mode
+	LatencySLO *sloTracker
+	// Collect COM_STATISTICS with the session
+	Statistics bool
+	// Clock skew tolerated before it is reported
+	MaxClockSkew time.Duration
+	// Collect database names, table counts and engines with the session
+	SchemaInventory bool
+	// Sample the processlist for the addresses connected, with the session
+	Processlist bool
+	// Scan the listeners found in the server variables too
+	FollowListeners bool
+	// Check packs selected with -pack
+	Packs map[string]bool
+	// Servers expected to be live, nil unless -expect is given
+	Inventory Inventory
+	// Targets scanned at once, within the per-host and per-subnet caps
+	Workers int
+	// Copied into every result
+	Tags Tags
+	// Ownership tags added to matching targets, nil unless -enrich is given
+	Enrichment Enrichment
+	// Recent results reused instead of probing again, nil unless -cache-ttl is given
+	Banners *bannerCache
+}
+
+/*
+openHandshake connects to the target and decodes the initial
+handshake, leaving the connection open for further exchanges.
+dialErr reports whether the failure happened while connecting rather
+than decoding.
+*/
+func openHandshake(target Target) (conn net.Conn, packet *InitialHandshakePacket, dialErr bool, err error) {
+	if err = saturation.allow(target.Host); err != nil {
+		return nil, nil, false, err
+	}
+
+	conn, err = dialTarget(target)
+	if err != nil {
+		return nil, nil, true, err
+	}
+
+	packet = &InitialHandshakePacket{}
+	read, total := tarpits.timeouts(target.Host)
+	if err = packet.decodeWithin(conn, read, total); err != nil {
+		conn.Close()
+		saturation.observe(target.Host, err)
+		tarpits.observe(target.Host, err)
+		return nil, nil, false, err
+	}
+	return conn, packet, false, nil
+}
+
+/*
+fetchHandshake opens a fresh connection to the target and decodes the
+initial handshake
+*/
+func fetchHandshake(target Target) (packet *InitialHandshakePacket, dialErr bool, err error) {
+	conn, packet, dialErr, err := openHandshake(target)
+	if err != nil {
+		return nil, dialErr, err
+	}
+	conn.Close()
+	return packet, false, nil
+}
+
+/*
+Stability returns statistics over the repeated probes, or false when
+the target was only probed once
+*/
+func (r *ScanResult) Stability() (Stability, bool) {
+	if len(r.Probes) < 2 {
+		return Stability{}, false
+	}
+	return stability(r.Probes), true
+}
+
+/*
+scanTarget probes the target and enriches its result, the two stages
+scanAll runs apart done in a row
+*/
+func scanTarget(target Target, opts ScanOptions) *ScanResult {
+	return enrichResult(probeTarget(target, opts), opts)
+}
+
+/*
+probeTarget scans the target, or takes it from the banner cache, reconciles
+it with the expected inventory when there is one and notes restarts and
+latency SLO breaches in watch mode
+*/
+func probeTarget(target Target, opts ScanOptions) *ScanResult {
+	defer health.start()()
+	started := clock.Now().UTC()
+	traffic.start(target)
+	evidence.start(target)
+	result, cached := opts.Banners.get(target)
+	if !cached {
+		result = scanEndpoint(target, opts)
+		opts.Banners.put(result)
+	}
+	result.Findings = append(result.Findings, opts.Inventory.reconcile(result)...)
+	result.Traffic = traffic.of(target)
+	traffic.finish(target)
+	result.StartedAt = started
+	// Nothing was measured for a cached result, so nothing to track over time
+	if !cached {
+		result.Findings = append(result.Findings, opts.Restarts.observe(result)...)
+		result.Findings = append(result.Findings, opts.LatencySLO.observe(result)...)
+	}
+	result.EndedAt = clock.Now().UTC()
+	if err := evidence.finish(result); err != nil {
+		log.Printf("Failed to save the evidence of %s: %s\n", target, err.Error())
+	}
+	return result
+}
+
+/*
+enrichResult tags the result, with its enrichment when there is any,
+and adds it to the latency histograms, which may be split by those tags
+*/
+func enrichResult(result *ScanResult, opts ScanOptions) *ScanResult {
+	result.Tags = opts.Enrichment.tagsFor(result.Target, opts.Tags)
+	if result.FirewallSuspected() {
+		result.Tags = result.Tags.with(dbFirewallTag, "suspected")
+	}
+	// Nothing was measured for a cached result
+	if result.CachedAt.IsZero() {
+		latencies.observe(result)
+	}
+	return result
+}
+
+/*
+runID identifies this run of the scanner in every result, for
+pipelines ingesting several runs to tell their results apart
+*/
+var runID = newRunID()
+
+func newRunID() string {
+	buf := make([]byte, 16)
+	rand.Read(buf)
+	return hex.EncodeToString(buf)
+}
+
+/*
+scanEndpoint connects to the target, decodes the initial handshake and
+runs the registered checks against it
+*/
+func scanEndpoint(target Target, opts ScanOptions) *ScanResult {
+	if target.Protocol == ProtocolMySQLX {
+		return scanMySQLX(target)
+	}
+	if target.Role == RoleGroupReplication {
+		return scanGroupReplication(target)
+	}
+
+	result := &ScanResult{Target: target, Status: StatusScanning}
+	result.Probes = probeRepeatedly(target, opts)
+
+	// Report the first successful probe, or the first failure if none succeeded
+	chosen := result.Probes[0]
+	for _, p := range result.Probes {
+		if p.Err == nil {
+			chosen = p
+			break
+		}
+	}
+
+	result.Latency = chosen.Latency
+	if chosen.Err != nil {
+		result.Status = StatusError
+		switch {
+		case chosen.DialErr:
+			result.Status = StatusClosed
+		case opts.Tier >= TierActive && silentConnection(chosen.Err) && synProxied(target):
+			result.Status = StatusFiltered
+		case tarpitKind(chosen.Err) != "":
+			result.Status = StatusTarpit
+		}
+		result.Err = chosen.Err
+		return result
+	}
+
+	result.Status = StatusMySQL
+	result.Handshake = chosen.Packet
+	result.Findings = runChecks(&CheckContext{
+		Target:     target,
+		Handshake:  chosen.Packet,
+		ReceivedAt: chosen.At.Add(chosen.Latency),
+		Probes:     result.Probes,
+		Options:    opts,
+		Result:     result,
+	})
+	result.Findings = append(result.Findings, roleFindings(result)...)
+	return result
+}
+
+/*
+scanMySQLX connects to an X Protocol target and asks for its capabilities
+*/
+func scanMySQLX(target Target) *ScanResult {
+	result := &ScanResult{Target: target, Status: StatusScanning}
+
+	if err := saturation.allow(target.Host); err != nil {
+		result.Status = StatusError
+		result.Err = err
+		return result
+	}
+
+	start := clock.Now()
+	conn, err := dialTarget(target)
+	if err != nil {
+		result.Status = StatusClosed
+		result.Err = err
+		return result
+	}
+	defer conn.Close()
+
+	capabilities := &XCapabilities{}
+	err = capabilities.Decode(conn)
+	result.Latency = clock.Now().Sub(start)
+	if err != nil {
+		result.Status = StatusError
+		result.Err = err
+		return result
+	}
+
+	result.Status = StatusMySQLX
+	result.XCapabilities = capabilities
+	return result
+}
+
+/*
+scanAll scans every target in a pipeline of stages, each with its own
+workers: resolving host names, probing within the per-host and
+per-subnet caps, enriching and passing the -script, and reporting. A
+slow resolver or sink holds up its own stage, not the probes, until the
+buffers between the stages fill up. Dialing, reading and decoding a
+target stay in the probe stage rather than stages of their own: the
+checks read and write on the connection the handshake came in on, and
+a pooled session carries it over to the next scan of the host. report
+is called from a single goroutine, in the order results come out of the
+pipeline.
+*/
+func scanAll(targets []Target, opts ScanOptions, workers int, report func(*ScanResult)) {
+	workers = openFiles.workers("scan", workers)
+	unresolved := make(chan Target, pipelineBuffer)
+	jobs := make(chan Target, pipelineBuffer)
+	probed := make(chan *ScanResult, pipelineBuffer)
+	enriched := make(chan *ScanResult, pipelineBuffer)
+	// Targets queued and not probed yet, which listeners found add to
+	var pending sync.WaitGroup
+	pending.Add(len(targets))
+	follow := newListenerQueue(targets)
+
+	resolving := runStage(stages.workers(stages.resolve, workers), func(int) {
+		for target := range unresolved {
+			resolver.resolve(target)
+			jobs <- target
+		}
+	})
+	probing := runStage(workers, func(i int) {
+		pause := jitter.worker(i)
+		for target := range jobs {
+			pause()
+			release := parallelism.acquire(target)
+			result := probeTarget(target, opts)
+			release()
+			resolver.forget(target)
+			if opts.FollowListeners {
+				for _, listener := range follow.add(result) {
+					pending.Add(1)
+					go func(target Target) { unresolved <- target }(listener)
+				}
+			}
+			probed <- result
+			pending.Done()
+		}
+	})
+	enriching := runStage(stages.workers(stages.enrich, workers), func(int) {
+		for result := range probed {
+			if result = script.apply(enrichResult(result, opts)); result != nil {
+				enriched <- result
+			}
+		}
+	})
+	reporting := runStage(1, func(int) {
+		for result := range enriched {
+			report(result)
+		}
+	})
+
+	for _, target := range targets {
+		unresolved <- target
+	}
+	pending.Wait()
+	close(unresolved)
+	resolving.Wait()
+	close(jobs)
+	probing.Wait()
+	close(probed)
+	enriching.Wait()
+	close(enriched)
+	reporting.Wait()
+}
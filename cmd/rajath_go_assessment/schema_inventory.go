@@ -0,0 +1,101 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+/*
+Schemas every server has, left out of the inventory
+*/
+var systemSchemas = map[string]bool{
+	"information_schema": true,
+	"mysql":              true,
+	"performance_schema": true,
+	"sys":                true,
+}
+
+/*
+Only metadata from information_schema is read, never row data
+*/
+const schemaInventoryQuery = "SELECT s.SCHEMA_NAME, t.ENGINE, COUNT(t.TABLE_NAME)" +
+	" FROM information_schema.SCHEMATA s" +
+	" LEFT JOIN information_schema.TABLES t" +
+	" ON t.TABLE_SCHEMA = s.SCHEMA_NAME AND t.TABLE_TYPE = 'BASE TABLE'" +
+	" GROUP BY s.SCHEMA_NAME, t.ENGINE"
+
+/*
+SchemaInventory describes one database: how many tables it has and
+which storage engines they use
+*/
+type SchemaInventory struct {
+	Name    string
+	Tables  int
+	Engines map[string]int
+}
+
+func (s SchemaInventory) String() string {
+	if s.Tables == 0 {
+		return s.Name + " (no tables)"
+	}
+
+	engines := make([]string, 0, len(s.Engines))
+	for engine := range s.Engines {
+		engines = append(engines, engine)
+	}
+	sort.Strings(engines)
+	for i, engine := range engines {
+		engines[i] = fmt.Sprintf("%s %d", engine, s.Engines[engine])
+	}
+	return fmt.Sprintf("%s (%d tables: %s)", s.Name, s.Tables, strings.Join(engines, ", "))
+}
+
+/*
+querySchemaInventory lists the databases visible to the account,
+leaving out the system ones
+*/
+func querySchemaInventory(s QueryRunner) ([]SchemaInventory, error) {
+	_, rows, err := s.Query(schemaInventoryQuery)
+	if err != nil {
+		return nil, err
+	}
+
+	byName := make(map[string]*SchemaInventory)
+	var names []string
+	for _, row := range rows {
+		if len(row) != 3 {
+			return nil, fmt.Errorf("Unexpected schema inventory row with %d columns", len(row))
+		}
+		name := string(row[0])
+		if systemSchemas[name] {
+			continue
+		}
+
+		schema, ok := byName[name]
+		if !ok {
+			schema = &SchemaInventory{Name: name, Engines: make(map[string]int)}
+			byName[name] = schema
+			names = append(names, name)
+		}
+
+		count, err := strconv.Atoi(string(row[2]))
+		if err != nil || count == 0 {
+			continue
+		}
+		engine := string(row[1])
+		if row[1] == nil {
+			engine = "unknown"
+		}
+		schema.Engines[engine] += count
+		schema.Tables += count
+	}
+
+	sort.Strings(names)
+	inventory := make([]SchemaInventory, 0, len(names))
+	for _, name := range names {
+		inventory = append(inventory, *byName[name])
+	}
+	return inventory, nil
+}
@@ -0,0 +1,139 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	starlarkjson "go.starlark.net/lib/json"
+	"go.starlark.net/starlark"
+)
+
+/*
+resultScript runs a Starlark script given with -script over every
+result before it is reported. The script defines process(result),
+called with the result as its JSON record in a dict, and returns the
+dict, possibly with other findings and tags, or None to drop the
+result. Changes to anything but findings and tags are ignored.
+*/
+type resultScript struct {
+	path    string
+	process starlark.Value
+}
+
+var script = &resultScript{}
+
+/*
+Limits on a run of the script, so one looping forever can't hold the
+scan up: the steps it may take, and the time. A cancelled script stops
+at its next step, once the builtin it is in returns.
+*/
+var scriptLimits = struct {
+	steps   uint64
+	timeout time.Duration
+}{10000000, 10 * time.Second}
+
+/*
+scriptThread returns a thread for a run of the script, cancelled past the
+limits. done stops the timer once the run is over.
+*/
+func scriptThread(name string) (thread *starlark.Thread, done func()) {
+	thread = &starlark.Thread{Name: name, Print: scriptPrint}
+	thread.SetMaxExecutionSteps(scriptLimits.steps)
+	timeout := scriptLimits.timeout
+	timer := time.AfterFunc(timeout, func() {
+		thread.Cancel(fmt.Sprintf("took more than %s", timeout))
+	})
+	return thread, func() { timer.Stop() }
+}
+
+/*
+load runs the script once, to define process and whatever it uses
+*/
+func (s *resultScript) load(path string) error {
+	thread, done := scriptThread(path)
+	defer done()
+	globals, err := starlark.ExecFile(thread, path, nil, starlark.StringDict{"json": starlarkjson.Module})
+	if err != nil {
+		return err
+	}
+	process, ok := globals["process"].(starlark.Callable)
+	if !ok {
+		return fmt.Errorf("%s: the script must define process(result)", path)
+	}
+	s.path, s.process = path, process
+	return nil
+}
+
+func scriptPrint(thread *starlark.Thread, msg string) {
+	log.Printf("%s: %s\n", thread.Name, msg)
+}
+
+/*
+apply returns the result as the script left it, or nil when dropped.
+A script failing on a result is logged and the result kept as it was.
+*/
+func (s *resultScript) apply(result *ScanResult) *ScanResult {
+	if s.process == nil {
+		return result
+	}
+	processed, err := s.run(result)
+	if err != nil {
+		log.Printf("Script failed on %s: %s\n", result.Target, err.Error())
+		return result
+	}
+	return processed
+}
+
+func (s *resultScript) run(result *ScanResult) (*ScanResult, error) {
+	encoded, err := json.Marshal(newJSONRecord(result))
+	if err != nil {
+		return nil, err
+	}
+	thread, done := scriptThread(s.path)
+	defer done()
+	record, err := starlark.Call(thread, starlarkjson.Module.Members["decode"], starlark.Tuple{starlark.String(encoded)}, nil)
+	if err != nil {
+		return nil, err
+	}
+	returned, err := starlark.Call(thread, s.process, starlark.Tuple{record}, nil)
+	if err != nil {
+		return nil, err
+	}
+	if returned == starlark.None {
+		return nil, nil
+	}
+	if _, ok := returned.(*starlark.Dict); !ok {
+		return nil, fmt.Errorf("process returned a %s, not the result or None", returned.Type())
+	}
+
+	encodedBack, err := starlark.Call(thread, starlarkjson.Module.Members["encode"], starlark.Tuple{returned}, nil)
+	if err != nil {
+		return nil, err
+	}
+	var changed struct {
+		Findings []JSONFinding `json:"findings"`
+		Tags     Tags          `json:"tags"`
+	}
+	if err := json.Unmarshal([]byte(encodedBack.(starlark.String)), &changed); err != nil {
+		return nil, err
+	}
+
+	findings := make([]Finding, 0, len(changed.Findings))
+	for _, f := range changed.Findings {
+		if f.RuleID == "" || f.Title == "" {
+			return nil, errors.New("Findings need a rule_id and a title")
+		}
+		severity, err := parseSeverity(f.Severity)
+		if err != nil {
+			return nil, err
+		}
+		findings = append(findings, Finding{RuleID: f.RuleID, Severity: severity, Title: f.Title, Detail: f.Detail, Check: f.Check})
+	}
+	processed := *result
+	processed.Findings = findings
+	processed.Tags = changed.Tags
+	return &processed, nil
+}
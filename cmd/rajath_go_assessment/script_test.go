@@ -0,0 +1,76 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+/*
+useScript loads source as the -script for the test
+*/
+func useScript(t *testing.T, source string) {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "script.star")
+	if err := os.WriteFile(path, []byte(source), 0600); err != nil {
+		t.Fatal(err)
+	}
+	previous := script
+	script = &resultScript{}
+	t.Cleanup(func() { script = previous })
+	if err := script.load(path); err != nil {
+		t.Fatal(err)
+	}
+}
+
+var scriptResult = &ScanResult{Target: Target{Host: "db1", Port: 3306}, Status: StatusMySQL, Findings: []Finding{evidenceFinding}}
+
+func TestScriptChangesFindingsAndTags(t *testing.T) {
+	useScript(t, `
+def process(result):
+    result["tags"] = {"owner": "dba"}
+    result["findings"] = [f for f in result["findings"] if f["rule_id"] != "MYSQL-TEST"]
+    return result
+`)
+	processed, err := script.run(scriptResult)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(processed.Findings) != 0 || processed.Tags["owner"] != "dba" {
+		t.Errorf("got findings %v and tags %v", processed.Findings, processed.Tags)
+	}
+}
+
+func TestScriptLimits(t *testing.T) {
+	limits := scriptLimits
+	defer func() { scriptLimits = limits }()
+
+	tests := []struct {
+		name   string
+		steps  uint64
+		source string
+		err    string
+	}{
+		{"endless loop", 100000, "def process(result):\n    for i in range(1 << 62):\n        pass\n", "too many steps"},
+		{"out of time", 1 << 62, "def process(result):\n    for i in range(1 << 62):\n        pass\n", "took more than"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			scriptLimits.steps, scriptLimits.timeout = test.steps, 50*time.Millisecond
+			useScript(t, test.source)
+			start := time.Now()
+			if _, err := script.run(scriptResult); err == nil || !strings.Contains(err.Error(), test.err) {
+				t.Errorf("got %v, want %q", err, test.err)
+			}
+			if took := time.Since(start); took > 5*time.Second {
+				t.Errorf("the script ran for %s", took)
+			}
+			// The result is reported as it was
+			if script.apply(scriptResult) != scriptResult {
+				t.Error("the result of a failed script changed")
+			}
+		})
+	}
+}
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"errors"
+)
+
+/*
+Categories of server errors, told apart by error code so that localized
+servers, whose messages are translated, are classified all the same
+*/
+const (
+	ErrorHostNotAllowed     = "host-not-allowed"
+	ErrorHostBlocked        = "host-blocked"
+	ErrorTooManyConnections = "too-many-connections"
+	ErrorResourceLimit      = "resource-limit"
+	ErrorAccessDenied       = "access-denied"
+	ErrorAccountLocked      = "account-locked"
+	ErrorPasswordExpired    = "password-expired"
+	ErrorAuthUnsupported    = "auth-unsupported"
+	ErrorTLSRequired        = "tls-required"
+	ErrorNetwork            = "network"
+	ErrorShuttingDown       = "shutting-down"
+	ErrorOffline            = "offline"
+	ErrorOutOfResources     = "out-of-resources"
+	ErrorUnknownDatabase    = "unknown-database"
+)
+
+/*
+Server error codes classified besides the ones the checks already name
+*/
+const (
+	erOutOfResources          = 1041
+	erHandshakeError          = 1043
+	erDBAccessDenied          = 1044
+	erAccessDenied            = 1045
+	erBadDB                   = 1049
+	erServerShutdown          = 1053
+	erHostNotPrivileged       = 1130
+	erCantCreateThread        = 1135
+	erTableAccessDenied       = 1142
+	erNetPacketTooLarge       = 1153
+	erNetReadError            = 1158
+	erNetReadInterrupted      = 1159
+	erNetErrorOnWrite         = 1160
+	erNetWriteInterrupted     = 1161
+	erTooManyUserConnections  = 1203
+	erUserLimitReached        = 1226
+	erSpecificAccessDenied    = 1227
+	erNotSupportedAuthMode    = 1251
+	erPluginIsNotLoaded       = 1524
+	erAccessDeniedNoPassword  = 1698
+	erAccessDeniedChangeUser  = 1873
+	erServerOfflineMode       = 3032
+	erSecureTransportRequired = 3159
+)
+
+var errorCategories = map[uint16]string{
+	erHostNotPrivileged:         ErrorHostNotAllowed,
+	erHostIsBlocked:             ErrorHostBlocked,
+	erConCountError:             ErrorTooManyConnections,
+	erTooManyUserConnections:    ErrorTooManyConnections,
+	erUserLimitReached:          ErrorResourceLimit,
+	erAccessDenied:              ErrorAccessDenied,
+	erDBAccessDenied:            ErrorAccessDenied,
+	erTableAccessDenied:         ErrorAccessDenied,
+	erSpecificAccessDenied:      ErrorAccessDenied,
+	erAccessDeniedNoPassword:    ErrorAccessDenied,
+	erAccessDeniedChangeUser:    ErrorAccessDenied,
+	erAccountHasBeenLocked:      ErrorAccountLocked,
+	erAccountBlockedByPassLock:  ErrorAccountLocked,
+	erAccountBlockedByPassLock2: ErrorAccountLocked,
+	erMustChangePassword:        ErrorPasswordExpired,
+	erMustChangePasswordLogin:   ErrorPasswordExpired,
+	erNotSupportedAuthMode:      ErrorAuthUnsupported,
+	erPluginIsNotLoaded:         ErrorAuthUnsupported,
+	erSecureTransportRequired:   ErrorTLSRequired,
+	erHandshakeError:            ErrorNetwork,
+	erNetPacketTooLarge:         ErrorNetwork,
+	erNetReadError:              ErrorNetwork,
+	erNetReadInterrupted:        ErrorNetwork,
+	erNetErrorOnWrite:           ErrorNetwork,
+	erNetWriteInterrupted:       ErrorNetwork,
+	erServerShutdown:            ErrorShuttingDown,
+	erServerOfflineMode:         ErrorOffline,
+	erOutOfResources:            ErrorOutOfResources,
+	erCantCreateThread:          ErrorOutOfResources,
+	erBadDB:                     ErrorUnknownDatabase,
+}
+
+/*
+Category returns the category of the error code, or an empty string for
+codes that aren't classified
+*/
+func (e *ServerError) Category() string {
+	return errorCategories[e.Code]
+}
+
+/*
+errorCategory returns the category of a server error anywhere in err's
+chain, or an empty string
+*/
+func errorCategory(err error) string {
+	var categorized interface{ Category() string }
+	if errors.As(err, &categorized) {
+		return categorized.Category()
+	}
+	return ""
+}
+
+/*
+remoteError is an error reported by an agent, which only sends its text
+and category
+*/
+type remoteError struct {
+	message  string
+	category string
+}
+
+func (e *remoteError) Error() string    { return e.message }
+func (e *remoteError) Category() string { return e.category }
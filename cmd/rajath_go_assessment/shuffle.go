@@ -0,0 +1,42 @@
+package main
+
+import (
+	"math/rand"
+)
+
+/*
+shuffleTargets puts the targets in a random order given by seed, then
+deals them out one subnet at a time so no subnet is probed twice in a
+row while another still has targets waiting
+*/
+func shuffleTargets(targets []Target, seed int64) []Target {
+	shuffled := append([]Target(nil), targets...)
+	rand.New(rand.NewSource(seed)).Shuffle(len(shuffled), func(i, j int) {
+		shuffled[i], shuffled[j] = shuffled[j], shuffled[i]
+	})
+
+	// Subnets in the order they first appear, names and pipes by host
+	var order []string
+	groups := make(map[string][]Target)
+	for _, target := range shuffled {
+		key := subnet(target.Host)
+		if key == "" {
+			key = target.Host
+		}
+		if _, ok := groups[key]; !ok {
+			order = append(order, key)
+		}
+		groups[key] = append(groups[key], target)
+	}
+
+	dealt := make([]Target, 0, len(shuffled))
+	for len(dealt) < len(shuffled) {
+		for _, key := range order {
+			if group := groups[key]; len(group) > 0 {
+				dealt = append(dealt, group[0])
+				groups[key] = group[1:]
+			}
+		}
+	}
+	return dealt
+}
@@ -0,0 +1,143 @@
+package main
+
+import (
+	"crypto/ed25519"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+)
+
+/*
+Default file the detached report signature is written to
+*/
+const defaultSignaturePath = "report.sig"
+
+/*
+reportSigner signs reports written with -output, so evidence can't be
+changed after the scan without it showing. Set from -sign-key.
+*/
+type reportSigner struct {
+	key           ed25519.PrivateKey
+	signaturePath string
+}
+
+var signer *reportSigner
+
+/*
+readPEM returns the DER bytes of the first PEM block in the file
+*/
+func readPEM(path string) ([]byte, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, fmt.Errorf("%s: no PEM data", path)
+	}
+	return block.Bytes, nil
+}
+
+/*
+loadSigningKey reads a PKCS #8 PEM Ed25519 private key, as made by
+openssl genpkey -algorithm ed25519
+*/
+func loadSigningKey(path string) (ed25519.PrivateKey, error) {
+	der, err := readPEM(path)
+	if err != nil {
+		return nil, err
+	}
+	key, err := x509.ParsePKCS8PrivateKey(der)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %s", path, err.Error())
+	}
+	private, ok := key.(ed25519.PrivateKey)
+	if !ok {
+		return nil, fmt.Errorf("%s: not an Ed25519 private key", path)
+	}
+	return private, nil
+}
+
+/*
+loadVerifyKey reads a PKIX PEM Ed25519 public key, as made by
+openssl pkey -pubout
+*/
+func loadVerifyKey(path string) (ed25519.PublicKey, error) {
+	der, err := readPEM(path)
+	if err != nil {
+		return nil, err
+	}
+	key, err := x509.ParsePKIXPublicKey(der)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %s", path, err.Error())
+	}
+	public, ok := key.(ed25519.PublicKey)
+	if !ok {
+		return nil, fmt.Errorf("%s: not an Ed25519 public key", path)
+	}
+	return public, nil
+}
+
+/*
+sign writes the base64 signature of the report to the signature file
+*/
+func (s *reportSigner) sign(report []byte) error {
+	signature := ed25519.Sign(s.key, report)
+	return os.WriteFile(s.signaturePath, []byte(base64.StdEncoding.EncodeToString(signature)+"\n"), 0644)
+}
+
+/*
+verifyReport checks a detached signature made by -sign-key
+*/
+func verifyReport(reportPath, signaturePath string, key ed25519.PublicKey) error {
+	report, err := os.ReadFile(reportPath)
+	if err != nil {
+		return err
+	}
+	encoded, err := os.ReadFile(signaturePath)
+	if err != nil {
+		return err
+	}
+	signature, err := base64.StdEncoding.DecodeString(strings.TrimSpace(string(encoded)))
+	if err != nil {
+		return fmt.Errorf("%s: %s", signaturePath, err.Error())
+	}
+	if !ed25519.Verify(key, report, signature) {
+		return errors.New("Signature does not match the report")
+	}
+	return nil
+}
+
+/*
+runVerify implements the verify subcommand:
+
+	./bin/rajath_go_assessment verify -key public.pem [-signature report.sig] report.sarif
+*/
+func runVerify(args []string) int {
+	flags := flag.NewFlagSet("verify", flag.ExitOnError)
+	keyPath := flags.String("key", "", "PEM Ed25519 public key of the signer")
+	signaturePath := flags.String("signature", defaultSignaturePath, "detached signature of the report")
+	flags.Parse(args)
+
+	if *keyPath == "" || flags.NArg() != 1 {
+		fmt.Println("Usage: ./bin/rajath_go_assessment verify -key public.pem [-signature report.sig] report")
+		return 2
+	}
+
+	key, err := loadVerifyKey(*keyPath)
+	if err != nil {
+		fmt.Println(err.Error())
+		return 1
+	}
+	if err := verifyReport(flags.Arg(0), *signaturePath, key); err != nil {
+		fmt.Println(err.Error())
+		return 1
+	}
+	fmt.Println("Signature OK")
+	return 0
+}
@@ -0,0 +1,321 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+)
+
+/*
+What a sink queue does with a result when it is full
+*/
+const (
+	// Wait for room, slowing the scan down to the sink's pace
+	OverflowBlock = "block"
+	// Drop the oldest queued result to make room, counting what was lost
+	OverflowDropOldest = "drop-oldest"
+	// Write results to a file on disk and send them once the queue drains
+	OverflowSpill = "spill"
+)
+
+var overflowPolicies = map[string]bool{
+	OverflowBlock:      true,
+	OverflowDropOldest: true,
+	OverflowSpill:      true,
+}
+
+/*
+Sink defaults. A result that can't be delivered is retried with a
+growing pause before it is given up on.
+*/
+const (
+	defaultSinkQueueSize = 1000
+	sinkDeliveryAttempts = 3
+	sinkRetryPause       = time.Second
+	webhookTimeout       = 10 * time.Second
+)
+
+/*
+resultSink delivers JSON records somewhere outside the scanner
+*/
+type resultSink interface {
+	deliver(record []byte) error
+}
+
+/*
+webhookSink POSTs each record to a URL
+*/
+type webhookSink struct {
+	url    string
+	client *http.Client
+}
+
+func newWebhookSink(url string) *webhookSink {
+	return &webhookSink{url: url, client: &http.Client{Timeout: webhookTimeout}}
+}
+
+func (s *webhookSink) deliver(record []byte) error {
+	resp, err := s.client.Post(s.url, "application/json", bytes.NewReader(record))
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("Webhook answered %s", resp.Status)
+	}
+	return nil
+}
+
+/*
+sinkQueue sits between the scan and a sink, so a slow sink doesn't
+stall the scan and results are never lost without saying so. Records
+are kept in a bounded queue and delivered in order by a single worker;
+what happens when the queue is full depends on the overflow policy.
+*/
+type sinkQueue struct {
+	mu       sync.Mutex
+	cond     *sync.Cond
+	sink     resultSink
+	queue    [][]byte
+	size     int
+	overflow string
+	closed   bool
+	done     chan struct{}
+
+	// Records written to disk with the spill policy, sent after the queue
+	spillPath    string
+	spillWriter  *os.File
+	spillFile    *os.File
+	spillReader  *bufio.Reader
+	spillPending int
+
+	dropped int
+	failed  int
+	// Error of the last delivery, nil once one succeeds
+	lastErr error
+}
+
+/*
+Queue results are sent to, nil when no sink is configured
+*/
+var sink *sinkQueue
+
+func newSinkQueue(s resultSink, size int, overflow, spillPath string) (*sinkQueue, error) {
+	if size <= 0 {
+		return nil, errors.New("The sink queue needs room for at least one result")
+	}
+	if !overflowPolicies[overflow] {
+		return nil, fmt.Errorf("Unknown overflow policy %q", overflow)
+	}
+
+	q := &sinkQueue{sink: s, size: size, overflow: overflow, done: make(chan struct{})}
+	q.cond = sync.NewCond(&q.mu)
+
+	if overflow == OverflowSpill {
+		var err error
+		// Only readable by the owner, like the store
+		if spillPath == "" {
+			q.spillWriter, err = os.CreateTemp("", "rajath-spill-*.jsonl")
+		} else {
+			q.spillWriter, err = os.OpenFile(spillPath, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0600)
+		}
+		if err != nil {
+			return nil, err
+		}
+		q.spillPath = q.spillWriter.Name()
+		if q.spillFile, err = os.Open(q.spillPath); err != nil {
+			q.spillWriter.Close()
+			os.Remove(q.spillPath)
+			return nil, err
+		}
+		q.spillReader = bufio.NewReader(q.spillFile)
+	}
+
+	go q.run()
+	return q, nil
+}
+
+/*
+send queues the redacted result for delivery. A nil queue does nothing.
+*/
+func (q *sinkQueue) send(result *ScanResult) {
+	if q == nil {
+		return
+	}
+	record, err := json.Marshal(newJSONRecord(redactor.apply(result)))
+	if err != nil {
+		log.Printf("Can't encode result for %s: %s\n", result.Target.Label(), err.Error())
+		return
+	}
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	for len(q.queue) >= q.size {
+		switch q.overflow {
+		case OverflowBlock:
+			q.cond.Wait()
+			continue
+		case OverflowDropOldest:
+			q.queue = q.queue[1:]
+			q.dropped++
+		case OverflowSpill:
+			if err := q.spill(record); err != nil {
+				log.Printf("Can't spill result for %s, dropping it: %s\n", result.Target.Label(), err.Error())
+				q.dropped++
+			}
+			return
+		}
+	}
+	// Once anything was spilled, newer records go to disk too so order is kept
+	if q.spillPending > 0 {
+		if err := q.spill(record); err == nil {
+			return
+		}
+	}
+	q.queue = append(q.queue, record)
+	q.cond.Broadcast()
+}
+
+func (q *sinkQueue) spill(record []byte) error {
+	if _, err := q.spillWriter.Write(append(record, '\n')); err != nil {
+		return err
+	}
+	q.spillPending++
+	q.cond.Broadcast()
+	return nil
+}
+
+/*
+next waits for the next record, from the queue first and then from the
+spill file. It returns nil once the queue is closed and empty.
+*/
+func (q *sinkQueue) next() []byte {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	for {
+		if len(q.queue) > 0 {
+			record := q.queue[0]
+			q.queue = q.queue[1:]
+			q.cond.Broadcast()
+			return record
+		}
+		if q.spillPending > 0 {
+			line, err := q.spillReader.ReadBytes('\n')
+			if err != nil {
+				log.Printf("Can't read spilled results back, %d are lost: %s\n", q.spillPending, err.Error())
+				q.failed += q.spillPending
+				q.spillPending = 0
+				continue
+			}
+			q.spillPending--
+			return bytes.TrimSuffix(line, []byte("\n"))
+		}
+		if q.closed {
+			return nil
+		}
+		q.cond.Wait()
+	}
+}
+
+func (q *sinkQueue) run() {
+	defer close(q.done)
+	for record := q.next(); record != nil; record = q.next() {
+		var err error
+		for attempt := 1; attempt <= sinkDeliveryAttempts; attempt++ {
+			if err = q.sink.deliver(record); err == nil {
+				break
+			}
+			if attempt < sinkDeliveryAttempts {
+				clock.Sleep(time.Duration(attempt) * sinkRetryPause)
+			}
+		}
+		q.mu.Lock()
+		q.lastErr = err
+		if err != nil {
+			log.Printf("Failed to deliver a result to the sink: %s\n", err.Error())
+			q.failed++
+		}
+		q.mu.Unlock()
+	}
+}
+
+/*
+SinkState is what the health endpoints tell of the sink queue
+*/
+type SinkState struct {
+	Queued    int    `json:"queued"`
+	Spilled   int    `json:"spilled"`
+	Dropped   int    `json:"dropped"`
+	Failed    int    `json:"failed"`
+	LastError string `json:"last_error,omitempty"`
+	// The queue is full and holds the scan up
+	Blocking bool `json:"blocking"`
+}
+
+/*
+state returns the queue's state, nil without a sink
+*/
+func (q *sinkQueue) state() *SinkState {
+	if q == nil {
+		return nil
+	}
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	state := &SinkState{
+		Queued:   len(q.queue),
+		Spilled:  q.spillPending,
+		Dropped:  q.dropped,
+		Failed:   q.failed,
+		Blocking: q.overflow == OverflowBlock && len(q.queue) >= q.size,
+	}
+	if q.lastErr != nil {
+		state.LastError = q.lastErr.Error()
+	}
+	return state
+}
+
+/*
+close waits for every queued and spilled result to be delivered, then
+reports what was lost. A nil queue does nothing.
+*/
+func (q *sinkQueue) close() {
+	if q == nil {
+		return
+	}
+
+	q.mu.Lock()
+	q.closed = true
+	q.cond.Broadcast()
+	q.mu.Unlock()
+	<-q.done
+
+	if q.spillWriter != nil {
+		q.spillWriter.Close()
+		q.spillFile.Close()
+		os.Remove(q.spillPath)
+	}
+	if q.dropped > 0 {
+		log.Printf("Sink queue was full, %d results were dropped\n", q.dropped)
+	}
+	if q.failed > 0 {
+		log.Printf("%d results could not be delivered to the sink\n", q.failed)
+	}
+}
+
+/*
+exit closes the sink before exiting with code, as deferred calls don't
+run on os.Exit and queued or spilled results would be lost
+*/
+func exit(code int) {
+	sink.close()
+	os.Exit(code)
+}
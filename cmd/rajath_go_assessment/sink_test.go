@@ -0,0 +1,60 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+)
+
+/*
+gatedSink holds every delivery until it is opened
+*/
+type gatedSink struct {
+	open chan struct{}
+
+	mu        sync.Mutex
+	delivered int
+}
+
+func (s *gatedSink) deliver(record []byte) error {
+	<-s.open
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.delivered++
+	return nil
+}
+
+func TestSpilledResultsAreDeliveredOnClose(t *testing.T) {
+	for _, spillPath := range []string{"", filepath.Join(t.TempDir(), "spill.jsonl")} {
+		sink := &gatedSink{open: make(chan struct{})}
+		q, err := newSinkQueue(sink, 1, OverflowSpill, spillPath)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for i := 0; i < 5; i++ {
+			q.send(&ScanResult{Target: Target{Host: "sink-spill", Port: 3306 + i}, Status: StatusClosed, Err: errors.New("refused")})
+		}
+		if q.state().Spilled == 0 {
+			t.Fatalf("nothing spilled with a full queue: %+v", q.state())
+		}
+
+		info, err := os.Stat(q.spillPath)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if info.Mode().Perm()&^0600 != 0 {
+			t.Errorf("spill file %s has mode %s, want at most 0600", q.spillPath, info.Mode().Perm())
+		}
+
+		close(sink.open)
+		q.close()
+		if sink.delivered != 5 {
+			t.Errorf("delivered %d of 5 results", sink.delivered)
+		}
+		if _, err := os.Stat(q.spillPath); !os.IsNotExist(err) {
+			t.Errorf("spill file %s left behind", q.spillPath)
+		}
+	}
+}
@@ -0,0 +1,352 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+	"golang.org/x/crypto/ssh/knownhosts"
+	"golang.org/x/net/proxy"
+)
+
+/*
+Environment variable holding the passphrase of protected SSH keys, kept
+off the command line so it doesn't show in the process list
+*/
+const sshPassphraseEnv = "RAJATH_SSH_PASSPHRASE"
+
+/*
+Policies for jump host keys, after OpenSSH's StrictHostKeyChecking
+*/
+const (
+	// Only hosts already in known_hosts are connected to
+	HostKeysStrict = "strict"
+	// Unknown hosts are added to known_hosts, changed keys are refused
+	HostKeysAcceptNew = "accept-new"
+	// Any key is accepted and only logged
+	HostKeysInsecure = "insecure"
+)
+
+/*
+Keys tried from ~/.ssh when -ssh-key isn't given
+*/
+var defaultSSHKeys = []string{"id_ed25519", "id_ecdsa", "id_rsa"}
+
+/*
+sshTunnel dials targets from a jump host, over direct-tcpip channels
+of a single SSH connection, for databases only reachable from there
+*/
+type sshTunnel struct {
+	keyPath    string
+	agent      string
+	knownHosts string
+	hostKeys   string
+
+	client *ssh.Client
+	// Connection to the SSH agent, only needed while logging in
+	agentConn net.Conn
+}
+
+/*
+Settings of the -ssh-* flags, used by every SSH hop not giving its own
+*/
+var sshDefaults = sshTunnel{hostKeys: HostKeysStrict}
+
+/*
+parseSSHDestination splits user@host[:port], the user defaulting to the
+local one and the port to 22
+*/
+func parseSSHDestination(dest string) (string, string, error) {
+	user, host, ok := strings.Cut(dest, "@")
+	if !ok {
+		host = dest
+		user = os.Getenv("USER")
+		if user == "" {
+			user = os.Getenv("USERNAME")
+		}
+	}
+	if user == "" || host == "" {
+		return "", "", fmt.Errorf("Invalid -ssh destination %q, expected user@host[:port]", dest)
+	}
+	if _, _, err := net.SplitHostPort(host); err != nil {
+		host = net.JoinHostPort(strings.Trim(host, "[]"), "22")
+	}
+	return user, host, nil
+}
+
+/*
+dialAgent connects to the SSH agent: a Unix socket, such as the one an
+agent forwarded with ssh -A listens on, or a named pipe on Windows
+*/
+func dialAgent(socket string) (net.Conn, error) {
+	if pipe, ok := parsePipe(socket); ok {
+		return dialPipe(pipe)
+	}
+	return net.Dial("unix", socket)
+}
+
+/*
+auth returns the key given with -ssh-key, or the SSH agent's keys and
+the default keys in ~/.ssh
+*/
+func (t *sshTunnel) auth() ([]ssh.AuthMethod, error) {
+	if t.keyPath != "" {
+		signer, err := loadSSHKey(t.keyPath)
+		if err != nil {
+			return nil, err
+		}
+		return []ssh.AuthMethod{ssh.PublicKeys(signer)}, nil
+	}
+
+	var methods []ssh.AuthMethod
+	socket := t.agent
+	if socket == "" {
+		socket = os.Getenv("SSH_AUTH_SOCK")
+	}
+	if socket == "" {
+		socket = defaultSSHAgent
+	}
+	if socket != "" {
+		conn, err := dialAgent(socket)
+		if err == nil {
+			t.agentConn = conn
+			methods = append(methods, ssh.PublicKeysCallback(agent.NewClient(conn).Signers))
+		} else if t.agent != "" {
+			return nil, fmt.Errorf("Can't reach the SSH agent: %s", err.Error())
+		}
+	}
+	var signers []ssh.Signer
+	if home, err := os.UserHomeDir(); err == nil {
+		for _, name := range defaultSSHKeys {
+			// Missing keys, and protected ones without a passphrase, are left to the agent
+			if signer, err := loadSSHKey(filepath.Join(home, ".ssh", name)); err == nil {
+				signers = append(signers, signer)
+			}
+		}
+	}
+	if len(signers) > 0 {
+		methods = append(methods, ssh.PublicKeys(signers...))
+	}
+	if len(methods) == 0 {
+		return nil, errors.New("No SSH key to log in with, start an SSH agent or give -ssh-key")
+	}
+	return methods, nil
+}
+
+/*
+loadSSHKey reads a private key, decrypting it with the passphrase in
+$RAJATH_SSH_PASSPHRASE when it is protected
+*/
+func loadSSHKey(path string) (ssh.Signer, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	signer, err := ssh.ParsePrivateKey(data)
+	if _, ok := err.(*ssh.PassphraseMissingError); ok {
+		passphrase := os.Getenv(sshPassphraseEnv)
+		if passphrase == "" {
+			return nil, fmt.Errorf("SSH key %s is passphrase protected, set %s or add it to an SSH agent", path, sshPassphraseEnv)
+		}
+		signer, err = ssh.ParsePrivateKeyWithPassphrase(data, []byte(passphrase))
+		if err != nil {
+			return nil, fmt.Errorf("Can't decrypt SSH key %s: %s", path, err.Error())
+		}
+	}
+	return signer, err
+}
+
+/*
+hostKeyCallback checks the jump host key against the known_hosts file
+as the -ssh-host-keys policy says
+*/
+func (t *sshTunnel) hostKeyCallback() (ssh.HostKeyCallback, error) {
+	if t.hostKeys == HostKeysInsecure {
+		return func(hostname string, remote net.Addr, key ssh.PublicKey) error {
+			log.Printf("Not checking the %s key of jump host %s: %s\n", key.Type(), hostname, ssh.FingerprintSHA256(key))
+			return nil
+		}, nil
+	}
+	if t.hostKeys != HostKeysStrict && t.hostKeys != HostKeysAcceptNew {
+		return nil, fmt.Errorf("Unknown -ssh-host-keys policy %q", t.hostKeys)
+	}
+
+	path := t.knownHosts
+	if path == "" {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return nil, err
+		}
+		path = filepath.Join(home, ".ssh", "known_hosts")
+	}
+	if t.hostKeys == HostKeysAcceptNew {
+		// Start the file on first use, as ssh does
+		if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+			return nil, err
+		}
+		file, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE, 0600)
+		if err != nil {
+			return nil, err
+		}
+		file.Close()
+	}
+	known, err := knownhosts.New(path)
+	if err != nil {
+		return nil, fmt.Errorf("Can't read SSH known hosts: %s", err.Error())
+	}
+	if t.hostKeys == HostKeysStrict {
+		return known, nil
+	}
+
+	return func(hostname string, remote net.Addr, key ssh.PublicKey) error {
+		err := known(hostname, remote, key)
+		var keyErr *knownhosts.KeyError
+		// No Want means the host isn't known at all, rather than known with another key
+		if !errors.As(err, &keyErr) || len(keyErr.Want) > 0 {
+			return err
+		}
+		file, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0600)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+		if _, err := fmt.Fprintln(file, knownhosts.Line([]string{knownhosts.Normalize(hostname)}, key)); err != nil {
+			return err
+		}
+		log.Printf("Added the %s key of jump host %s to %s: %s\n", key.Type(), hostname, path, ssh.FingerprintSHA256(key))
+		return nil
+	}, nil
+}
+
+/*
+connect logs in to the jump host dest, reached through forward when it
+isn't nil
+*/
+func (t *sshTunnel) connect(dest string, forward proxy.Dialer) error {
+	user, addr, err := parseSSHDestination(dest)
+	if err != nil {
+		return err
+	}
+	auth, err := t.auth()
+	if err != nil {
+		return err
+	}
+	defer t.closeAgent()
+	hostKeys, err := t.hostKeyCallback()
+	if err != nil {
+		return err
+	}
+
+	var conn net.Conn
+	if forward == nil {
+		conn, err = net.DialTimeout("tcp", addr, hopConnectTimeout)
+	} else {
+		ctx, cancel := context.WithTimeout(context.Background(), hopConnectTimeout)
+		defer cancel()
+		conn, err = forward.(proxy.ContextDialer).DialContext(ctx, "tcp", addr)
+	}
+	if err != nil {
+		return fmt.Errorf("Can't connect to jump host %s: %s", addr, err.Error())
+	}
+	// Bound the login too, a jump host may accept and stall
+	conn.SetDeadline(time.Now().Add(hopConnectTimeout))
+	c, channels, requests, err := ssh.NewClientConn(conn, addr, &ssh.ClientConfig{
+		User:            user,
+		Auth:            auth,
+		HostKeyCallback: hostKeys,
+	})
+	if err != nil {
+		conn.Close()
+		return fmt.Errorf("Can't connect to jump host %s: %s", addr, err.Error())
+	}
+	conn.SetDeadline(time.Time{})
+	t.client = ssh.NewClient(c, channels, requests)
+	return nil
+}
+
+/*
+closeAgent hangs up on the SSH agent once logged in
+*/
+func (t *sshTunnel) closeAgent() {
+	if t.agentConn != nil {
+		t.agentConn.Close()
+		t.agentConn = nil
+	}
+}
+
+func (t *sshTunnel) Dial(network, address string) (net.Conn, error) {
+	return t.DialContext(context.Background(), network, address)
+}
+
+/*
+DialContext opens a connection to address from the jump host, giving up
+when ctx is done: a jump host may never answer the channel open. SSH
+channels have no deadlines, so the channel is served through an
+in-memory pipe that has them, for the decoders' timeouts to work as on
+TCP.
+*/
+func (t *sshTunnel) DialContext(ctx context.Context, network, address string) (net.Conn, error) {
+	type dialed struct {
+		conn net.Conn
+		err  error
+	}
+	done := make(chan dialed, 1)
+	go func() {
+		conn, err := t.client.Dial(network, address)
+		done <- dialed{conn, err}
+	}()
+	var channel net.Conn
+	select {
+	case d := <-done:
+		if d.err != nil {
+			return nil, d.err
+		}
+		channel = d.conn
+	case <-ctx.Done():
+		// Close the channel should it open after all
+		go func() {
+			if d := <-done; d.err == nil {
+				d.conn.Close()
+			}
+		}()
+		return nil, fmt.Errorf("Can't connect to %s from the jump host: %s", address, ctx.Err().Error())
+	}
+
+	local, remote := net.Pipe()
+	go func() {
+		io.Copy(channel, remote)
+		channel.Close()
+	}()
+	go func() {
+		io.Copy(remote, channel)
+		remote.Close()
+	}()
+	return &tunnelConn{Conn: local, remote: channel.RemoteAddr()}, nil
+}
+
+/*
+tunnelConn is the pipe end of a tunneled connection, reporting the
+target as its peer
+*/
+type tunnelConn struct {
+	net.Conn
+	remote net.Addr
+}
+
+func (c *tunnelConn) RemoteAddr() net.Addr { return c.remote }
+
+func (t *sshTunnel) close() {
+	t.closeAgent()
+	if t.client != nil {
+		t.client.Close()
+	}
+}
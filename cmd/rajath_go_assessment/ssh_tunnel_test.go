@@ -0,0 +1,119 @@
+package main
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"net"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+)
+
+/*
+stallingJumpHost is an SSH server that lets anyone in and never answers
+channel opens, like a jump host whose next hop stalls
+*/
+func stallingJumpHost(t *testing.T) string {
+	t.Helper()
+	_, key, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	hostKey, err := ssh.NewSignerFromKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	config := &ssh.ServerConfig{
+		PublicKeyCallback: func(ssh.ConnMetadata, ssh.PublicKey) (*ssh.Permissions, error) { return nil, nil },
+	}
+	config.AddHostKey(hostKey)
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { listener.Close() })
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				_, channels, requests, err := ssh.NewServerConn(conn, config)
+				if err != nil {
+					return
+				}
+				go ssh.DiscardRequests(requests)
+				// Channel opens are left unanswered
+				for range channels {
+				}
+			}()
+		}
+	}()
+	return listener.Addr().String()
+}
+
+/*
+useAgent serves a key from an SSH agent on a Unix socket, and returns
+the socket and a channel closed once the client hangs up
+*/
+func useAgent(t *testing.T) (string, chan struct{}) {
+	t.Helper()
+	_, key, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyring := agent.NewKeyring()
+	if err := keyring.Add(agent.AddedKey{PrivateKey: key}); err != nil {
+		t.Fatal(err)
+	}
+	socket := filepath.Join(t.TempDir(), "agent.sock")
+	listener, err := net.Listen("unix", socket)
+	if err != nil {
+		t.Skipf("no Unix sockets: %s", err.Error())
+	}
+	t.Cleanup(func() { listener.Close() })
+	hungUp := make(chan struct{})
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		agent.ServeAgent(keyring, conn)
+		close(hungUp)
+	}()
+	return socket, hungUp
+}
+
+func TestSSHTunnel(t *testing.T) {
+	addr := stallingJumpHost(t)
+	socket, hungUp := useAgent(t)
+	tunnel := &sshTunnel{agent: socket, hostKeys: HostKeysInsecure}
+	if err := tunnel.connect("scanner@"+addr, nil); err != nil {
+		t.Fatal(err)
+	}
+	defer tunnel.close()
+
+	select {
+	case <-hungUp:
+	case <-time.After(time.Second):
+		t.Error("the agent connection was kept open after logging in")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	_, err := tunnel.DialContext(ctx, "tcp", "db1:3306")
+	if err == nil || !strings.Contains(err.Error(), "deadline exceeded") {
+		t.Errorf("got %v, want the dial to time out", err)
+	}
+	if took := time.Since(start); took > time.Second {
+		t.Errorf("the dial gave up after %s", took)
+	}
+}
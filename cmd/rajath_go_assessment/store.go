@@ -0,0 +1,214 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+/*
+StoredResult is what the result store keeps about the last scan of a
+target
+*/
+type StoredResult struct {
+	Target      string    `json:"target"`
+	Status      string    `json:"status"`
+	Fingerprint string    `json:"fingerprint"`
+	ScannedAt   time.Time `json:"scanned_at"`
+	Tags        Tags      `json:"tags,omitempty"`
+}
+
+/*
+resultStore keeps the last result of every target in a JSON file
+between runs, keyed by target label (hashed when IPs are redacted)
+*/
+type resultStore struct {
+	mu      sync.Mutex
+	path    string
+	results map[string]StoredResult
+	// Encrypts the file when set
+	cipher *fileCipher
+}
+
+/*
+openStore loads the store at path. A missing file is an empty store.
+An unencrypted file is read even with a cipher, and encrypted when saved.
+*/
+func openStore(path string, cipher *fileCipher) (*resultStore, error) {
+	s := &resultStore{path: path, results: make(map[string]StoredResult), cipher: cipher}
+
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return s, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if isEncrypted(data) {
+		if data, err = cipher.open(data); err != nil {
+			return nil, fmt.Errorf("%s: %s", path, err.Error())
+		}
+	}
+	if data, err = decompressBytes(data); err != nil {
+		return nil, fmt.Errorf("%s: %s", path, err.Error())
+	}
+
+	var stored []StoredResult
+	if err := json.Unmarshal(data, &stored); err != nil {
+		return nil, fmt.Errorf("%s: %s", path, err.Error())
+	}
+	for _, r := range stored {
+		s.results[r.Target] = r
+	}
+	return s, nil
+}
+
+/*
+fingerprint summarises what a scan found about a target: its status,
+handshake and findings. Finding details are left out as some, like the
+clock skew, change on every scan.
+*/
+func fingerprint(result *ScanResult) string {
+	parts := []string{result.Status}
+	if h := result.Handshake; h != nil {
+		parts = append(parts, fmt.Sprintf("%s|%d|%d|%s",
+			h.ServerVersion, uint32(h.CapabilitiesFlags), h.CharacterSet, h.AuthPluginName))
+	}
+
+	findings := make([]string, 0, len(result.Findings))
+	for _, f := range result.Findings {
+		findings = append(findings, fmt.Sprintf("%s|%s|%s", f.RuleID, f.Severity, f.Title))
+	}
+	sort.Strings(findings)
+
+	hash := sha256.New()
+	for _, part := range append(parts, findings...) {
+		hash.Write([]byte(part))
+		hash.Write([]byte{0})
+	}
+	return hex.EncodeToString(hash.Sum(nil))
+}
+
+/*
+fresh tells whether the target was scanned less than window ago
+*/
+func (s *resultStore) fresh(target Target, window time.Duration) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	previous, ok := s.results[redactor.storeKey(target)]
+	return ok && window > 0 && clock.Now().Sub(previous.ScannedAt) < window
+}
+
+/*
+record stores the result and tells whether its fingerprint differs
+from the previous scan of the target. A target never seen before has
+changed.
+*/
+func (s *resultStore) record(result *ScanResult) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	key := redactor.storeKey(result.Target)
+	current := StoredResult{
+		Target:      key,
+		Status:      result.Status,
+		Fingerprint: fingerprint(result),
+		ScannedAt:   clock.Now().UTC(),
+		Tags:        result.Tags,
+	}
+	previous, ok := s.results[key]
+	s.results[key] = current
+	return !ok || previous.Fingerprint != current.Fingerprint
+}
+
+/*
+save writes the store, replacing the file only once it is fully written
+*/
+func (s *resultStore) save() error {
+	s.mu.Lock()
+	stored := make([]StoredResult, 0, len(s.results))
+	for _, r := range s.results {
+		stored = append(stored, r)
+	}
+	s.mu.Unlock()
+	sort.Slice(stored, func(i, j int) bool { return stored[i].Target < stored[j].Target })
+
+	data, err := json.MarshalIndent(stored, "", "  ")
+	if err != nil {
+		return err
+	}
+	// Compressed before it is encrypted, as ciphertext doesn't compress
+	if data, err = compressFor(s.path, data); err != nil {
+		return err
+	}
+	if s.cipher != nil {
+		if data, err = s.cipher.seal(data); err != nil {
+			return err
+		}
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(s.path), filepath.Base(s.path)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), s.path)
+}
+
+/*
+StoreOptions controls how the result store filters a scan
+*/
+type StoreOptions struct {
+	// Only report targets whose fingerprint changed since the last run
+	ChangedOnly bool
+	// Skip targets scanned more recently than this
+	Freshness time.Duration
+}
+
+/*
+scanWithStore scans the targets and reports the results, skipping fresh
+targets and, with ChangedOnly, unchanged ones. Without a store every
+target is scanned and reported. With more than one worker results come
+in the order scans finish, but report is never called concurrently.
+*/
+func scanWithStore(targets []Target, opts ScanOptions, store *resultStore, storeOpts StoreOptions, report func(*ScanResult)) {
+	var due []Target
+	for _, target := range targets {
+		if store == nil || !store.fresh(target, storeOpts.Freshness) {
+			due = append(due, target)
+		}
+	}
+
+	workers := opts.Workers
+	if workers < 1 {
+		workers = 1
+	}
+
+	var mu sync.Mutex
+	scanAll(due, opts, workers, func(result *ScanResult) {
+		mu.Lock()
+		defer mu.Unlock()
+		if store != nil {
+			if changed := store.record(result); !changed && storeOpts.ChangedOnly {
+				return
+			}
+		}
+		sink.send(result)
+		report(result)
+	})
+}
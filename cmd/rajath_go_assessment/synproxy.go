@@ -0,0 +1,61 @@
+package main
+
+import (
+	"errors"
+	"io"
+	"syscall"
+	"time"
+)
+
+/*
+How long a silent connection gets to reset after it is written to. A
+SYN proxy resets as soon as its own connection to the port behind it
+fails, well within this.
+*/
+const synProxyTimeout = time.Second
+
+/*
+Bytes written to a silent connection: a COM_QUIT packet, harmless to
+whatever listens there
+*/
+var synProxyProbe = []byte{0x01, 0x00, 0x00, 0x00, comQuit}
+
+/*
+silentConnection tells whether the handshake failed with the connection
+up and nothing received: it stayed silent, or was closed or reset
+before the first byte
+*/
+func silentConnection(err error) bool {
+	return tarpitKind(err) == TarpitSilent || errors.Is(err, io.EOF) || errors.Is(err, syscall.ECONNRESET)
+}
+
+/*
+resetOnWrite tells whether err is the peer resetting the connection for
+what was written. A plain close isn't one, a service may hang up on
+bytes it doesn't understand.
+*/
+func resetOnWrite(err error) bool {
+	return errors.Is(err, syscall.ECONNRESET) || errors.Is(err, syscall.EPIPE)
+}
+
+/*
+synProxied connects again and writes to the target, which only a SYN
+proxy answers with a reset: middleboxes that complete the TCP handshake
+on behalf of every port, closed ones included, give up on the client
+once the port behind them refuses. A server or tarpit keeps the
+connection, or sends its greeting.
+*/
+func synProxied(target Target) bool {
+	conn, err := dialTarget(target)
+	if err != nil {
+		return false
+	}
+	defer conn.Close()
+
+	conn.SetDeadline(time.Now().Add(synProxyTimeout))
+	if _, err := conn.Write(synProxyProbe); err != nil {
+		return resetOnWrite(err)
+	}
+	_, err = conn.Read(make([]byte, 1))
+	return resetOnWrite(err)
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+/*
+Tags are arbitrary key=value pairs attached to a scan with -tag and
+copied into every result, so downstream systems can slice results by
+campaign, environment or ticket
+*/
+type Tags map[string]string
+
+/*
+keys returns the tag keys, sorted
+*/
+func (t Tags) keys() []string {
+	keys := make([]string, 0, len(t))
+	for key := range t {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+/*
+with returns a copy of the tags with one more, leaving the scan's own,
+which results share, alone
+*/
+func (t Tags) with(key, value string) Tags {
+	tags := make(Tags, len(t)+1)
+	for k, v := range t {
+		tags[k] = v
+	}
+	tags[key] = value
+	return tags
+}
+
+/*
+String lists the tags sorted by key, as key=value pairs
+*/
+func (t Tags) String() string {
+	keys := t.keys()
+	pairs := make([]string, len(keys))
+	for i, key := range keys {
+		pairs[i] = key + "=" + t[key]
+	}
+	return strings.Join(pairs, ", ")
+}
+
+/*
+Set parses one -tag flag. Giving the same key twice keeps the last value.
+*/
+func (t Tags) Set(value string) error {
+	key, val, ok := strings.Cut(value, "=")
+	key = strings.TrimSpace(key)
+	if !ok || key == "" {
+		return fmt.Errorf("Expected key=value, got %q", value)
+	}
+	t[key] = val
+	return nil
+}
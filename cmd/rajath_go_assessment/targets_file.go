@@ -0,0 +1,243 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"math/bits"
+	"net"
+	"os"
+	"strings"
+)
+
+/*
+TLS modes a target can be given in the targets file
+*/
+const (
+	// Upgrade to TLS, failing the target when the server can't
+	TLSRequired = "required"
+	// Upgrade to TLS when offered, as -tls-cert does for every target
+	TLSPreferred = "preferred"
+	// Never upgrade, even with -tls-cert
+	TLSDisabled = "disabled"
+)
+
+var tlsModes = map[string]bool{
+	TLSRequired:  true,
+	TLSPreferred: true,
+	TLSDisabled:  true,
+}
+
+/*
+targetsReader reads a targets file one line at a time, one target per
+line, a host:port or named pipe followed by options overriding the
+command line for that target:
+
+	db1.example.com:3306 tls=required
+	db1.example.com:33060 protocol=mysqlx
+	# comments and blank lines are skipped
+
+Targets without a protocol option are probed with protocol.
+*/
+type targetsReader struct {
+	path     string
+	protocol string
+	file     io.ReadCloser
+	scanner  *bufio.Scanner
+	line     int
+	// The rest of a range being read a block at a time
+	pending *rangeBlocks
+}
+
+func openTargetsFile(path string, protocol string) (*targetsReader, error) {
+	var file io.ReadCloser
+	var err error
+	if path == "-" {
+		file, err = decompress(os.Stdin)
+	} else {
+		file, err = openCompressed(path)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &targetsReader{path: path, protocol: protocol, file: file, scanner: bufio.NewScanner(file)}, nil
+}
+
+/*
+loadTargetsFile reads all the targets of the file
+*/
+func loadTargetsFile(path string, protocol string) ([]Target, error) {
+	r, err := openTargetsFile(path, protocol)
+	if err != nil {
+		return nil, err
+	}
+	defer r.close()
+	return r.next(0)
+}
+
+/*
+next reads up to n more targets, all the rest when n is 0. No targets
+means the end of the file. A range counts for all of its addresses, and
+is split into blocks across as many calls as it takes.
+*/
+func (r *targetsReader) next(n int) ([]Target, error) {
+	var targets []Target
+	// Addresses in the targets read so far
+	count := 0
+	for n == 0 || count < n {
+		if r.pending != nil {
+			block, ok := r.pending.peek()
+			if !ok {
+				r.pending = nil
+				continue
+			}
+			if count > 0 && count+rangeSize(block) > n {
+				break
+			}
+			r.pending.advance()
+			targets = append(targets, block)
+			count += rangeSize(block)
+			continue
+		}
+		if !r.scanner.Scan() {
+			break
+		}
+		r.line++
+		fields := strings.Fields(r.scanner.Text())
+		if len(fields) == 0 || strings.HasPrefix(fields[0], "#") {
+			continue
+		}
+
+		targetProtocol, tls := r.protocol, ""
+		for _, option := range fields[1:] {
+			key, value, _ := strings.Cut(option, "=")
+			switch key {
+			case "protocol":
+				targetProtocol = value
+			case "tls":
+				if !tlsModes[value] {
+					return nil, fmt.Errorf("%s:%d: Unknown TLS mode %q, expected required, preferred or disabled", r.path, r.line, value)
+				}
+				tls = value
+			default:
+				return nil, fmt.Errorf("%s:%d: Unknown option %q", r.path, r.line, option)
+			}
+		}
+
+		parsed, err := parseTargets(fields[:1], targetProtocol)
+		if err != nil {
+			return nil, fmt.Errorf("%s:%d: %s", r.path, r.line, err.Error())
+		}
+		if tls != "" && targetProtocol != ProtocolMySQL {
+			return nil, fmt.Errorf("%s:%d: tls only applies to the %s protocol", r.path, r.line, ProtocolMySQL)
+		}
+		for _, target := range parsed {
+			target.TLS = tls
+			if n > 0 && isRange(target.Host) {
+				r.pending = splitRange(target, n)
+				continue
+			}
+			targets = append(targets, target)
+			count++
+		}
+	}
+	if err := r.scanner.Err(); err != nil {
+		return nil, fmt.Errorf("%s: %s", r.path, err.Error())
+	}
+	return targets, nil
+}
+
+/*
+each scans the file in chunks of size targets, starting with first, so
+lists too large to be held in memory can be scanned. Only a chunk of
+targets is read at a time.
+*/
+func (r *targetsReader) each(size int, first []Target, scan func([]Target)) error {
+	for chunk := first; ; chunk = nil {
+		more, err := r.next(size)
+		if err != nil {
+			return err
+		}
+		if chunk = append(chunk, more...); len(chunk) == 0 {
+			return nil
+		}
+		scan(chunk)
+	}
+}
+
+func (r *targetsReader) close() {
+	r.file.Close()
+}
+
+/*
+rangeBlocks walks a range target in blocks of a prefix length, so a
+range larger than a chunk is scanned over several
+*/
+type rangeBlocks struct {
+	target  Target
+	network *net.IPNet
+	next    net.IP
+	ones    int
+}
+
+/*
+splitRange splits the range target into blocks of at most n addresses
+*/
+func splitRange(target Target, n int) *rangeBlocks {
+	_, network, _ := net.ParseCIDR(target.Host)
+	ones, size := network.Mask.Size()
+	if blockOnes := size - (bits.Len(uint(n)) - 1); blockOnes > ones {
+		ones = blockOnes
+	}
+	return &rangeBlocks{target: target, network: network, next: network.IP, ones: ones}
+}
+
+/*
+peek returns the next block, false once the range is done
+*/
+func (b *rangeBlocks) peek() (Target, bool) {
+	if b.next == nil || !b.network.Contains(b.next) {
+		return Target{}, false
+	}
+	block := b.target
+	block.Host = (&net.IPNet{IP: b.next, Mask: net.CIDRMask(b.ones, len(b.next)*8)}).String()
+	return block, true
+}
+
+/*
+advance moves on to the block after the one peeked at
+*/
+func (b *rangeBlocks) advance() {
+	next := make(net.IP, len(b.next))
+	copy(next, b.next)
+	// Add one at the last bit of the prefix, carrying over
+	i, bit := (b.ones-1)/8, byte(1)<<(7-(b.ones-1)%8)
+	for ; i >= 0; i, bit = i-1, 1 {
+		sum := next[i] + bit
+		carry := sum < next[i]
+		next[i] = sum
+		if !carry {
+			b.next = next
+			return
+		}
+	}
+	// Past the last address
+	b.next = nil
+}
+
+/*
+rangeSize returns the number of addresses of a range target, 1 for
+other targets
+*/
+func rangeSize(target Target) int {
+	_, network, err := net.ParseCIDR(target.Host)
+	if err != nil {
+		return 1
+	}
+	ones, size := network.Mask.Size()
+	if size-ones >= bits.UintSize-2 {
+		return math.MaxInt
+	}
+	return 1 << (size - ones)
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestChunksSplitRanges(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "targets.txt")
+	lines := "db1:3306\n10.0.0.0/22:3306\n# IPv6 ranges are split as well\n[2001:db8::/126]:3306\ndb2:3306\n"
+	if err := os.WriteFile(path, []byte(lines), 0600); err != nil {
+		t.Fatal(err)
+	}
+	r, err := openTargetsFile(path, ProtocolMySQL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.close()
+
+	var chunks [][]string
+	err = r.each(300, nil, func(chunk []Target) {
+		var hosts []string
+		for _, target := range chunk {
+			hosts = append(hosts, target.Host)
+		}
+		chunks = append(chunks, hosts)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := [][]string{
+		{"db1", "10.0.0.0/24"},
+		{"10.0.1.0/24"},
+		{"10.0.2.0/24"},
+		{"10.0.3.0/24", "2001:db8::/126", "db2"},
+	}
+	if !reflect.DeepEqual(chunks, want) {
+		t.Errorf("got chunks %v, want %v", chunks, want)
+	}
+}
+
+func TestRangeBlocksEnd(t *testing.T) {
+	blocks := splitRange(Target{Host: "255.255.255.0/24", Port: 3306}, 128)
+	var hosts []string
+	for block, ok := blocks.peek(); ok; block, ok = blocks.peek() {
+		hosts = append(hosts, block.Host)
+		blocks.advance()
+	}
+	if want := []string{"255.255.255.0/25", "255.255.255.128/25"}; !reflect.DeepEqual(hosts, want) {
+		t.Errorf("got blocks %v, want %v", hosts, want)
+	}
+}
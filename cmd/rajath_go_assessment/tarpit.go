@@ -0,0 +1,101 @@
+package main
+
+import (
+	"errors"
+	"log"
+	"sync"
+	"time"
+)
+
+/*
+Kinds of tarpit: a port that accepts connections and never says a word,
+or one that drips its greeting too slowly to ever finish it
+*/
+const (
+	TarpitSilent = "silent"
+	TarpitDrip   = "drip"
+)
+
+/*
+Handshake deadlines used for the rest of a subnet once one of its
+targets turned out to be a tarpit, unless the configured ones are shorter
+*/
+const (
+	tarpitReadTimeout      = time.Second
+	tarpitHandshakeTimeout = 2 * time.Second
+)
+
+/*
+tarpitKind tells whether err is the handshake timing out the way a
+tarpit makes it, and how
+*/
+func tarpitKind(err error) string {
+	var slow errSlowRead
+	if !errors.As(err, &slow) {
+		return ""
+	}
+	switch {
+	case slow.received == 0:
+		return TarpitSilent
+	case slow.overall:
+		return TarpitDrip
+	}
+	// Stalled halfway through, more like a server in trouble
+	return ""
+}
+
+/*
+tarpitRegistry remembers subnets, or hosts for names, where a tarpit
+was found, so the scan doesn't keep waiting out full deadlines there
+*/
+type tarpitRegistry struct {
+	mu      sync.Mutex
+	subnets map[string]bool
+}
+
+var tarpits = &tarpitRegistry{subnets: make(map[string]bool)}
+
+func tarpitKey(host string) string {
+	if block := subnet(host); block != "" {
+		return block
+	}
+	return host
+}
+
+/*
+observe marks the host's subnet when err says the host is a tarpit
+*/
+func (r *tarpitRegistry) observe(host string, err error) {
+	kind := tarpitKind(err)
+	if kind == "" {
+		return
+	}
+
+	key := tarpitKey(host)
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if !r.subnets[key] {
+		r.subnets[key] = true
+		log.Printf("Tarpit (%s) found at %s, shortening handshake deadlines for %s\n", kind, host, key)
+	}
+}
+
+/*
+timeouts returns the handshake deadlines for the host: the configured
+ones, shortened in subnets known to hold a tarpit
+*/
+func (r *tarpitRegistry) timeouts(host string) (read, total time.Duration) {
+	read, total = decodeTimeouts.read, decodeTimeouts.total
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.subnets[tarpitKey(host)] {
+		if read > tarpitReadTimeout {
+			read = tarpitReadTimeout
+		}
+		if total > tarpitHandshakeTimeout {
+			total = tarpitHandshakeTimeout
+		}
+	}
+	return read, total
+}
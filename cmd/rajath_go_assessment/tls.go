@@ -0,0 +1,45 @@
+package main
+
+import (
+	"crypto/sha256"
+	"crypto/tls"
+	"encoding/hex"
+	"errors"
+	"net"
+)
+
+/*
+upgradeTLS sends an SSLRequest on a connection that has just received
+the initial handshake and performs the TLS handshake. The certificate
+is not verified, we only want to look at it.
+*/
+func upgradeTLS(conn net.Conn, target Target, packet *InitialHandshakePacket) (*tls.Conn, error) {
+	if !packet.CapabilitiesFlags.Has(clientSSL) {
+		return nil, errors.New("Server does not support TLS")
+	}
+
+	if err := writePacket(conn, packet.header.SequenceId+1, sslRequest(packet.CharacterSet)); err != nil {
+		return nil, err
+	}
+
+	tlsConn := tls.Client(conn, &tls.Config{
+		ServerName:         target.Host,
+		InsecureSkipVerify: true,
+	})
+	if err := tlsConn.Handshake(); err != nil {
+		return nil, err
+	}
+	return tlsConn, nil
+}
+
+/*
+certFingerprint returns the hex encoded SHA-256 of the leaf certificate
+*/
+func certFingerprint(conn *tls.Conn) string {
+	certs := conn.ConnectionState().PeerCertificates
+	if len(certs) == 0 {
+		return ""
+	}
+	sum := sha256.Sum256(certs[0].Raw)
+	return hex.EncodeToString(sum[:])
+}
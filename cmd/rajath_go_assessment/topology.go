@@ -0,0 +1,273 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const proxyBackendRuleID = "MYSQL-PROXY-BACKEND"
+
+func init() {
+	registerCheck(Check{
+		ID:          proxyBackendRuleID,
+		Description: "Asks the session which server runs its statements, telling a proxy such as ProxySQL, which greets with its own connection ID, from the backend it hands them to",
+		Tier:        TierActive,
+		Run:         checkProxyBackend,
+	})
+}
+
+/*
+SessionServer is the server that ran the shared session's statements,
+as it tells of itself. Through a proxy such as ProxySQL it is the
+backend the proxy handed them to, whose connection ID isn't the one
+the proxy greeted with.
+*/
+type SessionServer struct {
+	// Connection ID of the greeting, and CONNECTION_ID() as the statements saw it
+	HandshakeConnectionID uint32
+	ConnectionID          uint32
+	Hostname              string
+	Port                  int
+	// Empty before MySQL 5.6
+	ServerUUID string
+	// Sessions of the scan account, read from the processlist of servers
+	// scanned directly, where a proxy's connections to them show up
+	Sessions []ProcessEntry
+}
+
+/*
+ProcessEntry is one session of the processlist
+*/
+type ProcessEntry struct {
+	ID uint32
+	// host:port the session connected from
+	Host string
+}
+
+/*
+Proxied tells whether the statements ran on another connection than
+the one that greeted, which only a proxy in between does
+*/
+func (s *SessionServer) Proxied() bool {
+	return s.ConnectionID != s.HandshakeConnectionID
+}
+
+/*
+Address is the hostname and port the server reports for itself
+*/
+func (s *SessionServer) Address() string {
+	return fmt.Sprintf("%s:%d", s.Hostname, s.Port)
+}
+
+func (s *SessionServer) String() string {
+	str := fmt.Sprintf("connection %d on %s", s.ConnectionID, s.Address())
+	if s.ServerUUID != "" {
+		str += ", server_uuid " + s.ServerUUID
+	}
+	return str
+}
+
+/*
+Statement the session identifies its server with. The columns are
+aliased so ProxySQL, which answers a bare SELECT CONNECTION_ID() with
+its own session ID, passes it on to the backend. It is one statement
+so a proxy multiplexing its backend connections can't split it.
+*/
+const sessionServerQuery = "SELECT CONNECTION_ID() AS connection_id, @@hostname AS hostname, @@port AS port, @@server_uuid AS server_uuid"
+
+/*
+The same without server_uuid, for servers before 5.6
+*/
+const sessionServerQueryLegacy = "SELECT CONNECTION_ID() AS connection_id, @@hostname AS hostname, @@port AS port, '' AS server_uuid"
+
+/*
+Sessions of the scan account, the only ones it sees without PROCESS
+*/
+const accountSessionsQuery = "SELECT ID, HOST FROM information_schema.PROCESSLIST WHERE USER = SUBSTRING_INDEX(CURRENT_USER(), '@', 1)"
+
+/*
+identifySessionServer asks the session for the server running its
+statements
+*/
+func identifySessionServer(session *Session) (*SessionServer, error) {
+	_, rows, err := session.Query(sessionServerQuery)
+	var serverErr *ServerError
+	if errors.As(err, &serverErr) {
+		_, rows, err = session.Query(sessionServerQueryLegacy)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if len(rows) != 1 || len(rows[0]) != 4 {
+		return nil, fmt.Errorf("Unexpected reply to CONNECTION_ID()")
+	}
+	id, err := strconv.ParseUint(string(rows[0][0]), 10, 32)
+	if err != nil {
+		return nil, err
+	}
+	port, _ := strconv.Atoi(string(rows[0][2]))
+	return &SessionServer{
+		HandshakeConnectionID: session.Handshake.ConnectionId,
+		ConnectionID:          uint32(id),
+		Hostname:              string(rows[0][1]),
+		Port:                  port,
+		ServerUUID:            string(rows[0][3]),
+	}, nil
+}
+
+/*
+accountSessions reads the scan account's sessions from the processlist
+*/
+func accountSessions(session *Session) []ProcessEntry {
+	_, rows, err := session.Query(accountSessionsQuery)
+	if err != nil {
+		return nil
+	}
+	var sessions []ProcessEntry
+	for _, row := range rows {
+		if len(row) != 2 {
+			continue
+		}
+		if id, err := strconv.ParseUint(string(row[0]), 10, 32); err == nil {
+			sessions = append(sessions, ProcessEntry{ID: uint32(id), Host: string(row[1])})
+		}
+	}
+	return sessions
+}
+
+/*
+checkProxyBackend records the server the statements ran on, for the
+topology to map proxies to the backends scanned with them, and reports
+a proxy. It needs the built-in protocol, the greeting of a database/sql
+connection isn't known.
+*/
+func checkProxyBackend(ctx *CheckContext) []Finding {
+	if ctx.Result == nil || ctx.Options.QueryDriver == QueryDriverSQL {
+		return nil
+	}
+	session, err := ctx.Session()
+	if err != nil {
+		return nil
+	}
+	server, err := identifySessionServer(session)
+	if err != nil {
+		return nil
+	}
+	ctx.Result.Server = server
+	if !server.Proxied() {
+		server.Sessions = accountSessions(session)
+		return nil
+	}
+
+	return []Finding{{
+		RuleID:   proxyBackendRuleID,
+		Severity: SeverityInfo,
+		Title:    "Statements are run by a backend behind a proxy",
+		Detail:   fmt.Sprintf("greeted as connection %d, statements ran as %s", server.HandshakeConnectionID, server),
+	}}
+}
+
+/*
+TopologyLink maps a proxied target to the backend that ran its scan's
+statements, when that backend was scanned too
+*/
+type TopologyLink struct {
+	Proxy  *ScanResult
+	Server *SessionServer
+	// Nil when the backend wasn't among the targets
+	Backend *ScanResult
+	// What tied the backend to the proxy: server_uuid, hostname and port, processlist
+	MatchedBy []string
+	// The proxy's connection in the backend's processlist
+	Session *ProcessEntry
+}
+
+func (l TopologyLink) String() string {
+	s := fmt.Sprintf("%s -> ", l.Proxy.Target.Label())
+	if l.Backend == nil {
+		return s + fmt.Sprintf("%s, not scanned", l.Server)
+	}
+	s += fmt.Sprintf("%s, %s, matched by %s", l.Backend.Target.Label(), l.Server, strings.Join(l.MatchedBy, ", "))
+	if l.Session != nil {
+		s += fmt.Sprintf(", connected from %s", l.Session.Host)
+	}
+	return s
+}
+
+/*
+topology keeps the results the session identified a server for, to map
+the proxies among them to their backends once the scan is done
+*/
+type topology struct {
+	results []*ScanResult
+}
+
+func (t *topology) add(result *ScanResult) {
+	if result.Server != nil {
+		t.results = append(t.results, result)
+	}
+}
+
+/*
+links maps every proxied target to a backend scanned directly. The
+backend is the one reporting the same server_uuid, or the same hostname
+and port; the proxy's connection ID in its processlist confirms it and
+tells where the proxy connects from. Links are in the order of the
+proxies' labels.
+*/
+func (t *topology) links() []TopologyLink {
+	var links []TopologyLink
+	for _, proxy := range t.results {
+		if !proxy.Server.Proxied() {
+			continue
+		}
+		link := TopologyLink{Proxy: proxy, Server: proxy.Server}
+		for _, backend := range t.results {
+			server := backend.Server
+			if server.Proxied() {
+				continue
+			}
+			var matchedBy []string
+			if server.ServerUUID != "" && server.ServerUUID == proxy.Server.ServerUUID {
+				matchedBy = append(matchedBy, "server_uuid")
+			}
+			if server.Hostname != "" && server.Address() == proxy.Server.Address() {
+				matchedBy = append(matchedBy, "hostname and port")
+			}
+			if matchedBy == nil {
+				continue
+			}
+			link.Backend, link.MatchedBy = backend, matchedBy
+			for i, session := range server.Sessions {
+				if session.ID == proxy.Server.ConnectionID {
+					link.MatchedBy = append(link.MatchedBy, "processlist")
+					link.Session = &server.Sessions[i]
+				}
+			}
+			break
+		}
+		links = append(links, link)
+	}
+	sort.SliceStable(links, func(i, j int) bool {
+		return links[i].Proxy.Target.Label() < links[j].Proxy.Target.Label()
+	})
+	return links
+}
+
+/*
+lines returns the topology output, empty when no target was proxied
+*/
+func (t *topology) lines() []string {
+	links := t.links()
+	if len(links) == 0 {
+		return nil
+	}
+	lines := []string{tr("Topology:")}
+	for _, link := range links {
+		lines = append(lines, "  "+link.String())
+	}
+	return lines
+}
@@ -0,0 +1,139 @@
+package main
+
+import (
+	"encoding/hex"
+	"fmt"
+	"log"
+	"net"
+	"strings"
+)
+
+/*
+Bytes of each packet shown by -trace by default
+*/
+const defaultTraceBytes = 64
+
+/*
+packetTracer logs every packet sent and received when -trace is given,
+with a hexdump of its first bytes. With -redact the peer and the bytes
+are masked as in results and recordings.
+*/
+type packetTracer struct {
+	enabled bool
+	// Bytes of the payload dumped, the rest is only counted
+	limit int
+}
+
+var tracer = &packetTracer{limit: defaultTraceBytes}
+
+/*
+Names of the commands the scanner sends
+*/
+var commandNames = map[byte]string{
+	comQuit:       "COM_QUIT",
+	comQuery:      "COM_QUERY",
+	comStatistics: "COM_STATISTICS",
+	comPing:       "COM_PING",
+}
+
+/*
+Names of the X Protocol messages exchanged during the greeting
+*/
+var xMessageNames = map[uint8]string{
+	mysqlxServerOk:               "Ok",
+	mysqlxServerError:            "Error",
+	mysqlxServerConnCapabilities: "Capabilities",
+	mysqlxServerNotice:           "Notice",
+}
+
+/*
+classicPacketType names a classic protocol packet from its direction,
+sequence id and first byte. Packets don't carry their type, so this is
+a best guess, like any dissector without the whole conversation.
+*/
+func classicPacketType(sent bool, seq uint8, payload []byte) string {
+	if sent {
+		switch {
+		case seq == 0 && len(payload) > 0:
+			if name, ok := commandNames[payload[0]]; ok {
+				return name
+			}
+			return fmt.Sprintf("command 0x%02x", payload[0])
+		case seq == 1 && len(payload) == 32:
+			return "SSLRequest"
+		case seq <= 2:
+			return "HandshakeResponse"
+		}
+		return "AuthResponse"
+	}
+
+	if len(payload) == 0 {
+		return "empty"
+	}
+	switch {
+	case payload[0] == 0x00:
+		return "OK"
+	case payload[0] == 0xff:
+		return "ERR"
+	case payload[0] == 0xfe && len(payload) < 9:
+		return "EOF"
+	case payload[0] == 0xfe:
+		return "AuthSwitchRequest"
+	case payload[0] == 0x01 && len(payload) > 1:
+		return "AuthMoreData"
+	}
+	return "data"
+}
+
+/*
+packet logs a packet of length bytes announced in its header, of which
+data arrived. seq is left out when negative, as X Protocol frames have none.
+*/
+func (t *packetTracer) packet(conn interface{}, sent bool, seq int, length int, kind string, data []byte) {
+	if !t.enabled {
+		return
+	}
+
+	peer := "?"
+	if c, ok := conn.(net.Conn); ok && c.RemoteAddr() != nil {
+		peer = c.RemoteAddr().String()
+		if host, port, err := net.SplitHostPort(peer); err == nil {
+			peer = net.JoinHostPort(redactor.host(host), port)
+		}
+	}
+	direction := "<-"
+	if sent {
+		direction = "->"
+	}
+
+	header := fmt.Sprintf("%s %s %s length %d", peer, direction, kind, length)
+	if seq >= 0 {
+		header = fmt.Sprintf("%s %s seq %d %s length %d", peer, direction, seq, kind, length)
+	}
+	if len(data) < length {
+		header += fmt.Sprintf(", only %d bytes arrived", len(data))
+	}
+
+	dump := data
+	switch {
+	case redactor == nil:
+	case kind == "Handshake" && !sent:
+		// Masked as the stream it starts, header included
+		framed := append([]byte{byte(length), byte(length >> 8), byte(length >> 16), byte(seq)}, data...)
+		dump = redactor.raw(framed)[4:]
+	default:
+		dump = redactor.maskBytes(append([]byte(nil), data...))
+	}
+	if len(dump) > t.limit {
+		dump = dump[:t.limit]
+	}
+	lines := strings.TrimRight(hex.Dump(dump), "\n")
+	if len(dump) < len(data) {
+		lines += fmt.Sprintf("\n... %d more bytes", len(data)-len(dump))
+	}
+	if lines == "" {
+		log.Printf("Trace %s\n", header)
+		return
+	}
+	log.Printf("Trace %s\n%s\n", header, lines)
+}
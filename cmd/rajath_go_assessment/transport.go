@@ -0,0 +1,56 @@
+package main
+
+import (
+	"errors"
+	"net"
+	"strings"
+	"time"
+)
+
+/*
+How long dialing a named pipe waits for a free pipe instance
+*/
+const pipeDialTimeout = 5 * time.Second
+
+/*
+errPipeUnsupported is returned when dialing a named pipe anywhere but
+on Windows
+*/
+var errPipeUnsupported = errors.New("Named pipes are only supported on Windows")
+
+/*
+parsePipe recognises a named pipe target, given either as a full path
+like \\.\pipe\MySQL or as pipe:MySQL for the local machine
+*/
+func parsePipe(arg string) (string, bool) {
+	if strings.HasPrefix(arg, `\\`) {
+		return arg, true
+	}
+	if name, ok := strings.CutPrefix(arg, "pipe:"); ok && name != "" {
+		return `\\.\pipe\` + name, true
+	}
+	return "", false
+}
+
+/*
+dialTarget opens the transport to the target: its named pipe when it
+has one, TCP otherwise, through the -ssh or proxy_chain hops if any.
+Everything that connects to a target goes through here, so all traffic
+is metered.
+*/
+func dialTarget(target Target) (net.Conn, error) {
+	var conn net.Conn
+	var err error
+	if target.Pipe != "" {
+		conn, err = dialPipe(target.Pipe)
+	} else if chain.dialer != nil {
+		conn, err = chain.dial("tcp", target.Address())
+	} else {
+		conn, err = resolver.dial(target)
+	}
+	if err != nil {
+		openFiles.exhausted(err)
+		return nil, err
+	}
+	return traffic.wrap(target, evidence.wrap(target, recorder.wrap(target, conn))), nil
+}
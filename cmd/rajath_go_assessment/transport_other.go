@@ -0,0 +1,30 @@
+//go:build !windows
+
+package main
+
+import (
+	"errors"
+	"net"
+	"syscall"
+)
+
+func dialPipe(path string) (net.Conn, error) {
+	return nil, errPipeUnsupported
+}
+
+/*
+connRefused tells whether the dial failed on the host refusing the connection
+*/
+func connRefused(err error) bool {
+	return errors.Is(err, syscall.ECONNREFUSED)
+}
+
+/*
+SSH agent used without $SSH_AUTH_SOCK, only Windows has a default one
+*/
+const defaultSSHAgent = ""
+
+/*
+Named pipe targets are only compiled into Windows binaries
+*/
+const namedPipes = false
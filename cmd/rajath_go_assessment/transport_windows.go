@@ -0,0 +1,43 @@
+//go:build windows
+
+package main
+
+import (
+	"errors"
+	"net"
+	"syscall"
+
+	"github.com/Microsoft/go-winio"
+)
+
+/*
+dialPipe connects to a local MySQL server listening on a named pipe
+(enable-named-pipe), for audits of hosts where TCP is disabled
+*/
+func dialPipe(path string) (net.Conn, error) {
+	timeout := pipeDialTimeout
+	return winio.DialPipe(path, &timeout)
+}
+
+/*
+WSAECONNREFUSED, which package syscall has no name for
+*/
+const wsaConnRefused = syscall.Errno(10061)
+
+/*
+connRefused tells whether the dial failed on the host refusing the connection
+*/
+func connRefused(err error) bool {
+	return errors.Is(err, wsaConnRefused)
+}
+
+/*
+SSH agent used without $SSH_AUTH_SOCK: the one shipped with Windows'
+OpenSSH, which listens on a named pipe
+*/
+const defaultSSHAgent = `\\.\pipe\openssh-ssh-agent`
+
+/*
+Named pipe targets are only compiled into Windows binaries
+*/
+const namedPipes = true
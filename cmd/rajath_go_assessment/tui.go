@@ -0,0 +1,186 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"sync"
+	"text/tabwriter"
+)
+
+const tuiWorkers = 8
+
+/*
+tui renders a live-updating table of scan results and accepts simple
+keyboard commands for filtering and sorting
+*/
+type tui struct {
+	mu      sync.Mutex
+	targets []Target
+	opts    ScanOptions
+	results map[Target]*ScanResult
+	filter  string
+	sortBy  string
+	out     io.Writer
+}
+
+var tuiSortKeys = map[string]func(a, b *ScanResult) bool{
+	"target": func(a, b *ScanResult) bool {
+		return a.Target.Address() < b.Target.Address()
+	},
+	"status": func(a, b *ScanResult) bool {
+		return a.Status < b.Status
+	},
+	"version": func(a, b *ScanResult) bool {
+		return a.Version() < b.Version()
+	},
+	"latency": func(a, b *ScanResult) bool {
+		return a.Latency < b.Latency
+	},
+	"findings": func(a, b *ScanResult) bool {
+		return len(a.Findings) > len(b.Findings)
+	},
+	"anomaly": func(a, b *ScanResult) bool {
+		return a.Anomaly().Score > b.Anomaly().Score
+	},
+}
+
+func newTUI(targets []Target, opts ScanOptions, out io.Writer) *tui {
+	return &tui{
+		targets: targets,
+		opts:    opts,
+		results: make(map[Target]*ScanResult),
+		sortBy:  "target",
+		out:     out,
+	}
+}
+
+/*
+run starts scanning in the background and processes commands read from
+in until the user quits or the input is closed
+*/
+func (t *tui) run(in io.Reader) {
+	go t.scan()
+
+	scanner := bufio.NewScanner(in)
+	t.redraw()
+	for scanner.Scan() {
+		if !t.command(strings.TrimSpace(scanner.Text())) {
+			return
+		}
+		t.redraw()
+	}
+}
+
+func (t *tui) scan() {
+	t.mu.Lock()
+	for _, target := range t.targets {
+		t.results[target] = &ScanResult{Target: target, Status: StatusPending}
+	}
+	t.mu.Unlock()
+	if render.animated() {
+		t.redraw()
+	}
+
+	scanAll(t.targets, t.opts, tuiWorkers, func(result *ScanResult) {
+		t.mu.Lock()
+		t.results[result.Target] = result
+		t.mu.Unlock()
+		if render.animated() {
+			t.redraw()
+			return
+		}
+		t.mu.Lock()
+		defer t.mu.Unlock()
+		var fields []string
+		for _, field := range strings.Split(tuiRow(result), "\t") {
+			if field != "" {
+				fields = append(fields, field)
+			}
+		}
+		fmt.Fprintln(t.out, strings.Join(fields, ", "))
+	})
+}
+
+/*
+command applies a single user command, returning false when the user
+asked to quit
+*/
+func (t *tui) command(cmd string) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	switch {
+	case cmd == "q":
+		return false
+	case cmd == "r":
+		go t.scan()
+	case strings.HasPrefix(cmd, "/"):
+		t.filter = strings.TrimPrefix(cmd, "/")
+	case strings.HasPrefix(cmd, "s "):
+		key := strings.TrimSpace(strings.TrimPrefix(cmd, "s "))
+		if _, ok := tuiSortKeys[key]; ok {
+			t.sortBy = key
+		}
+	}
+	return true
+}
+
+/*
+visible returns the results matching the current filter, in the current
+sort order
+*/
+func (t *tui) visible() []*ScanResult {
+	var rows []*ScanResult
+	for _, target := range t.targets {
+		result, ok := t.results[target]
+		if !ok {
+			continue
+		}
+		if t.filter != "" && !strings.Contains(tuiRow(result), t.filter) {
+			continue
+		}
+		rows = append(rows, result)
+	}
+
+	less := tuiSortKeys[t.sortBy]
+	sort.SliceStable(rows, func(i, j int) bool {
+		return less(rows[i], rows[j])
+	})
+	return rows
+}
+
+func tuiRow(r *ScanResult) string {
+	r = redactor.apply(r)
+	latency := ""
+	if r.Latency > 0 {
+		latency = r.Latency.String()
+	}
+
+	findings := ""
+	if max, ok := maxSeverity(r.Findings); ok {
+		findings = fmt.Sprintf("%d (%s)", len(r.Findings), max)
+	}
+
+	return strings.Join([]string{r.Target.Label(), r.Status, r.Version(), latency, findings}, "\t")
+}
+
+func (t *tui) redraw() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	render.clear(t.out)
+
+	w := tabwriter.NewWriter(t.out, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "TARGET\tSTATUS\tVERSION\tLATENCY\tFINDINGS")
+	for _, row := range t.visible() {
+		fmt.Fprintln(w, tuiRow(row))
+	}
+	w.Flush()
+
+	fmt.Fprintf(t.out, "\nfilter: %q  sort: %s\n", t.filter, t.sortBy)
+	fmt.Fprintln(t.out, "commands: /text filter, s target|status|version|latency|findings|anomaly sort, r rescan, q quit")
+	fmt.Fprint(t.out, "> ")
+}
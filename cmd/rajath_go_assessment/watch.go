@@ -0,0 +1,54 @@
+package main
+
+import (
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+)
+
+/*
+watch rescans the targets every interval until interrupted, keeping
+authenticated sessions in a pool between rounds and reporting servers
+that restarted in between. The store and metrics file are saved after every round.
+The configuration is reloaded on SIGHUP, and before a round when its files changed.
+*/
+func watch(targets []Target, opts ScanOptions, store *resultStore, storeOpts StoreOptions, interval time.Duration, reload *reloader) {
+	pool := newSessionPool(defaultPoolMaxIdle, defaultPoolIdleTimeout)
+	defer pool.closeAll()
+	opts.Pool = pool
+	opts.Restarts = newRestartTracker()
+
+	stop := make(chan os.Signal, 1)
+	signal.Notify(stop, os.Interrupt, syscall.SIGTERM)
+	defer signal.Stop(stop)
+	// Signals coming in during a round wait for it to end
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	defer signal.Stop(hup)
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		scanWithStore(targets, opts, store, storeOpts, printResult)
+		saveStore(store)
+		saveMetrics()
+		pool.reap()
+
+	wait:
+		for {
+			select {
+			case <-stop:
+				return
+			case <-hup:
+				targets, opts = reload.reload(targets, opts)
+			case <-ticker.C:
+				if reload.changed() {
+					targets, opts = reload.reload(targets, opts)
+				}
+				break wait
+			}
+		}
+	}
+}
@@ -1,3 +1,25 @@
 module github.com/avrajath/rajath_go_assessment
 
-go 1.20
+go 1.22
+
+require (
+	github.com/Microsoft/go-winio v0.6.1
+	github.com/go-sql-driver/mysql v1.7.1
+	github.com/klauspost/compress v1.18.0
+	github.com/santhosh-tekuri/jsonschema/v5 v5.3.1
+	go.starlark.net v0.0.0-20230525235612-a134d8f9ddca
+	golang.org/x/crypto v0.12.0
+	golang.org/x/net v0.12.0
+	google.golang.org/grpc v1.58.3
+	gopkg.in/yaml.v3 v3.0.1
+)
+
+require (
+	github.com/golang/protobuf v1.5.3 // indirect
+	golang.org/x/mod v0.8.0 // indirect
+	golang.org/x/sys v0.11.0 // indirect
+	golang.org/x/text v0.12.0 // indirect
+	golang.org/x/tools v0.6.0 // indirect
+	google.golang.org/genproto/googleapis/rpc v0.0.0-20230711160842-782d3b101e98 // indirect
+	google.golang.org/protobuf v1.31.0 // indirect
+)